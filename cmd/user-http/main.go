@@ -2,18 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/cache"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/config"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/db"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/events"
 	httptransport "github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/handlers"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/logging"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/mail"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/rbac"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/sms"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
 )
 
@@ -31,11 +40,7 @@ func main() {
 	}
 	defer dbConn.Close()
 
-	if cfg.JWTPrivateKeyPath == "" || cfg.JWTPublicKeyPath == "" {
-		log.Fatal("JWT_PRIVATE_KEY_PATH and JWT_PUBLIC_KEY_PATH must be set")
-	}
-
-	issuer, err := auth.LoadIssuerFromFiles(cfg.JWTPrivateKeyPath, cfg.JWTPublicKeyPath, "svc-user", []string{"users"})
+	issuer, err := loadIssuer(cfg)
 	if err != nil {
 		log.Fatalf("failed to load jwt keys: %v", err)
 	}
@@ -47,13 +52,135 @@ func main() {
 	}
 
 	tokenBlacklist := auth.NewRedisTokenBlacklist(redisClient)
+	tokenGenerations := auth.NewRedisTokenGenerations(redisClient)
+	sessionRevocations := auth.NewRedisSessionRevocations(redisClient)
 
 	userRepo := users.NewSQLRepository(dbConn)
 	rbacService := rbac.NewService(dbConn)
-	userService := users.NewService(userRepo, issuer, rbacService, tokenBlacklist)
+	refreshTokenRepo := users.NewSQLRefreshTokenRepository(dbConn)
+	apiKeyRepo := users.NewSQLAPIKeyRepository(dbConn)
+	sessionRepo := users.NewSQLSessionRepository(dbConn)
+	userTokenRepo := users.NewSQLUserTokenRepository(dbConn)
+	passwordHasher, err := auth.NewPasswordHasher(auth.Argon2Params{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Threads),
+		SaltLength:  auth.DefaultArgon2Params.SaltLength,
+		KeyLength:   auth.DefaultArgon2Params.KeyLength,
+	})
+	if err != nil {
+		log.Fatalf("invalid password hashing parameters: %v", err)
+	}
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		log.Fatalf("invalid password policy: %v", err)
+	}
+	serviceOpts := []users.Option{
+		users.WithRefreshTokens(refreshTokenRepo),
+		users.WithAPIKeys(apiKeyRepo),
+		users.WithTokenGenerations(tokenGenerations),
+		users.WithSessions(sessionRepo, sessionRevocations),
+		users.WithUserTokens(userTokenRepo, auth.NewRedisRateLimiter(redisClient)),
+		users.WithVerifiedEmailRequired(cfg.VerifiedEmailOnly),
+		users.WithEmailChanges(users.NewSQLEmailChangeRepository(dbConn)),
+		users.WithMagicLinks(cfg.MagicLinkLogin),
+		users.WithPasswordHasher(passwordHasher),
+		users.WithPasswordPolicy(passwordPolicy),
+		users.WithPasswordHistory(users.NewSQLPasswordHistoryRepository(dbConn), cfg.PasswordHistory),
+		users.WithLoginLockout(auth.NewRedisLoginAttempts(redisClient), users.LoginLockoutPolicy{
+			FreeAttempts: cfg.LoginFreeAttempts,
+			Threshold:    cfg.LoginLockoutAfter,
+			IPThreshold:  cfg.LoginIPLockout,
+			Cooldown:     cfg.LoginCooldown,
+		}),
+	}
+	if cfg.MFASecretKey != "" {
+		mfaSecrets, err := newSecretBox(cfg.MFASecretKey)
+		if err != nil {
+			log.Fatalf("invalid MFA_SECRET_KEY: %v", err)
+		}
+		mfaRepo := users.NewSQLMFARepository(dbConn, mfaSecrets)
+		if n, err := mfaRepo.EncryptPlaintextSecrets(context.Background()); err != nil {
+			log.Fatalf("failed to encrypt mfa secrets: %v", err)
+		} else if n > 0 {
+			log.Printf("encrypted %d plaintext mfa secrets", n)
+		}
+		serviceOpts = append(serviceOpts,
+			users.WithMFA(mfaRepo, auth.NewRedisMFAAttempts(redisClient), cfg.MFAIssuer),
+			users.WithMFARequiredRoles(cfg.MFARequiredRoles),
+		)
+	} else if enrolled, err := users.NewSQLMFARepository(dbConn, nil).HasEnrollments(context.Background()); err != nil {
+		log.Fatalf("failed to check mfa enrollments: %v", err)
+	} else if enrolled {
+		log.Fatalf("MFA_SECRET_KEY must be set: users are enrolled in mfa")
+	}
+	if cfg.WebAuthnRPID != "" {
+		relyingParty, err := auth.NewWebAuthn(cfg.WebAuthnRPID, cfg.WebAuthnRPName, cfg.WebAuthnOrigins)
+		if err != nil {
+			log.Fatalf("failed to configure passkeys: %v", err)
+		}
+		serviceOpts = append(serviceOpts, users.WithPasskeys(relyingParty, users.NewSQLPasskeyRepository(dbConn), auth.NewRedisWebAuthnCeremonies(redisClient)))
+	}
+	if cfg.SMSDriver != "" {
+		sender, err := newSMSSender(cfg, logger)
+		if err != nil {
+			log.Fatalf("failed to configure sms: %v", err)
+		}
+		serviceOpts = append(serviceOpts,
+			users.WithPhoneNumbers(sender, auth.NewRedisOneTimeCodes(redisClient)),
+			users.WithPhoneLogin(cfg.PhoneLogin),
+		)
+	}
+	if cfg.IdPConfigFile != "" {
+		providers, err := auth.LoadIdentityProvidersFile(cfg.IdPConfigFile)
+		if err != nil {
+			log.Fatalf("failed to configure identity providers: %v", err)
+		}
+		serviceOpts = append(serviceOpts, users.WithIdentityProviders(providers, users.NewSQLIdentityLinkRepository(dbConn), auth.NewRedisFederatedLoginStates(redisClient)))
+	}
+	var mailDispatcher *mail.Dispatcher
+	if cfg.MailDriver != "" {
+		mailer, err := newMailer(cfg)
+		if err != nil {
+			log.Fatalf("failed to configure mail: %v", err)
+		}
+		renderer, err := mail.NewRenderer()
+		if err != nil {
+			log.Fatalf("failed to load mail templates: %v", err)
+		}
+		mailSecrets, err := newSecretBox(cfg.MailSecretKey)
+		if err != nil {
+			log.Fatalf("invalid MAIL_SECRET_KEY: %v", err)
+		}
+		serviceOpts = append(serviceOpts, users.WithNotifier(mail.NewNotifier(mail.NewSQLOutbox(dbConn), renderer, mailSecrets, cfg.MailLinkBaseURL, cfg.MailLocale)))
+		mailDispatcher = mail.NewDispatcher(dbConn, mailSecrets, renderer, mailer, logger)
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		producer := events.NewProducer(cfg.KafkaBrokers, cfg.EventsTopic)
+		defer producer.Close()
+		serviceOpts = append(serviceOpts, users.WithEventPublisher(producer))
+	}
+	userService := users.NewService(userRepo, issuer, rbacService, tokenBlacklist, serviceOpts...)
 	userHandler := handlers.NewUserHandler(userService)
 
-	srv, err := httptransport.NewServer(cfg, logger, issuer, tokenBlacklist, userHandler)
+	var clients oauth.ClientStore = oauth.NewSQLClientStore(dbConn)
+	if cfg.OAuthClientsFile != "" {
+		clients, err = oauth.LoadClientsFile(cfg.OAuthClientsFile)
+		if err != nil {
+			log.Fatalf("failed to load oauth clients: %v", err)
+		}
+	}
+	codes := oauth.NewRedisCodeStore(redisClient)
+	oauthHandler := handlers.NewOAuthHandler(oauth.NewService(clients, issuer, tokenBlacklist, userService, codes,
+		oauth.WithTokenGenerations(tokenGenerations),
+		oauth.WithSessionRevocations(sessionRevocations),
+	))
+
+	srv, err := httptransport.NewServer(cfg, logger, issuer, tokenBlacklist, userHandler, oauthHandler,
+		middleware.WithAPIKeys(userService),
+		middleware.WithTokenGenerations(tokenGenerations),
+		middleware.WithSessionRevocations(sessionRevocations),
+	)
 	if err != nil {
 		log.Fatalf("failed to create http server: %v", err)
 	}
@@ -61,6 +188,10 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
+	if mailDispatcher != nil {
+		go mailDispatcher.Run(ctx, 5*time.Second)
+	}
+
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Fatalf("http server error: %v", err)
@@ -77,3 +208,72 @@ func main() {
 
 	log.Println("http server shut down cleanly")
 }
+
+func loadIssuer(cfg *config.Config) (*auth.TokenIssuer, error) {
+	if cfg.JWTKeysDir != "" {
+		ring, err := auth.LoadKeyRingFromDir(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewTokenIssuerWithKeyRing(ring, cfg.OIDCIssuerURL, []string{"users"})
+	}
+
+	if cfg.JWTPrivateKeyPath == "" || cfg.JWTPublicKeyPath == "" {
+		return nil, errors.New("JWT_KEYS_DIR or JWT_PRIVATE_KEY_PATH and JWT_PUBLIC_KEY_PATH must be set")
+	}
+	return auth.LoadIssuerFromFiles(cfg.JWTPrivateKeyPath, cfg.JWTPublicKeyPath, cfg.OIDCIssuerURL, []string{"users"})
+}
+
+func newMailer(cfg *config.Config) (mail.Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		})
+	case "file":
+		return mail.NewFileMailer(cfg.MailFileDir, cfg.MailFrom)
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", cfg.MailDriver)
+	}
+}
+
+func newSMSSender(cfg *config.Config, logger *slog.Logger) (users.SMSSender, error) {
+	switch cfg.SMSDriver {
+	case "log":
+		return sms.NewLogSender(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_DRIVER %q", cfg.SMSDriver)
+	}
+}
+
+func newSecretBox(encodedKey string) (*auth.SecretBox, error) {
+	key, err := auth.DecodeSecretBoxKey(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewSecretBox(key)
+}
+
+func newPasswordPolicy(cfg *config.Config) (*auth.PasswordPolicy, error) {
+	rules := auth.PasswordRules{
+		MinLength:          cfg.PasswordMinLength,
+		MaxLength:          cfg.PasswordMaxLength,
+		RejectPersonalInfo: cfg.PasswordPersonal,
+	}
+	for _, class := range cfg.PasswordClasses {
+		rules.RequiredClasses = append(rules.RequiredClasses, auth.CharacterClass(class))
+	}
+	var blocklist *auth.PasswordBlocklist
+	if cfg.PasswordBlocklist != "" {
+		var err error
+		blocklist, err = auth.LoadPasswordBlocklist(cfg.PasswordBlocklist)
+		if err != nil {
+			return nil, fmt.Errorf("load password blocklist: %w", err)
+		}
+	}
+	return auth.NewPasswordPolicy(rules, blocklist)
+}
@@ -44,6 +44,11 @@ func (b *RedisTokenBlacklist) IsBlacklisted(ctx context.Context, token string) (
 }
 
 func (b *RedisTokenBlacklist) key(token string) string {
+	return b.prefix + ":" + HashToken(token)
+}
+
+// HashToken returns the hex encoded SHA-256 digest used to store tokens at rest.
+func HashToken(token string) string {
 	sum := sha256.Sum256([]byte(token))
-	return b.prefix + ":" + hex.EncodeToString(sum[:])
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FederatedLoginState is the server-side state of a sign-in with an external identity provider
+// between the redirect to the provider and the callback. UserID is set when a signed-in user
+// links a new identity instead of signing in.
+type FederatedLoginState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	UserID       string `json:"userId,omitempty"`
+}
+
+// FederatedLoginStates keeps federated sign-in state keyed by the OAuth state parameter. Each
+// state can be taken at most once.
+type FederatedLoginStates interface {
+	SaveState(ctx context.Context, state string, login FederatedLoginState, ttl time.Duration) error
+	TakeState(ctx context.Context, state string) (*FederatedLoginState, error)
+}
+
+var ErrFederatedStateNotFound = errors.New("federated login state not found")
+
+// RedisFederatedLoginStates stores federated sign-in state in Redis.
+type RedisFederatedLoginStates struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisFederatedLoginStates constructs a Redis-backed state store.
+func NewRedisFederatedLoginStates(client *redis.Client) *RedisFederatedLoginStates {
+	return &RedisFederatedLoginStates{client: client, prefix: "auth:federation"}
+}
+
+// SaveState stores the state with the given TTL.
+func (r *RedisFederatedLoginStates) SaveState(ctx context.Context, state string, login FederatedLoginState, ttl time.Duration) error {
+	body, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(state), body, ttl).Err()
+}
+
+// TakeState atomically fetches and deletes the state.
+func (r *RedisFederatedLoginStates) TakeState(ctx context.Context, state string) (*FederatedLoginState, error) {
+	body, err := r.client.GetDel(ctx, r.key(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrFederatedStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var login FederatedLoginState
+	if err := json.Unmarshal(body, &login); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func (r *RedisFederatedLoginStates) key(state string) string {
+	return r.prefix + ":" + state
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// identityKeysMaxAge bounds how long a provider's JWKS is trusted before it is fetched again.
+	identityKeysMaxAge = time.Hour
+	// identityKeysMinRefresh keeps tokens with unknown key IDs from hammering the JWKS endpoint.
+	identityKeysMinRefresh = time.Minute
+	// identityResponseLimit caps the size of discovery, JWKS and token responses.
+	identityResponseLimit = 1 << 20
+)
+
+// IdentityProviderConfig describes an external OpenID Connect provider, such as Google, that
+// users may sign in with. RedirectURL is the storefront page the provider sends the user back to.
+type IdentityProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectUrl"`
+	Scopes       []string `json:"scopes"`
+}
+
+// ExternalIdentity is the verified subject of a provider's ID token.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+var (
+	ErrIdentityTokenInvalid  = errors.New("invalid identity token")
+	ErrAuthorizationRejected = errors.New("authorization code rejected by identity provider")
+)
+
+// IdentityProvider is an OpenID Connect relying-party client for one provider. It runs the
+// authorization-code flow with PKCE and verifies ID tokens against the provider's JWKS. The
+// discovery document and keys are fetched on first use and cached.
+type IdentityProvider struct {
+	config IdentityProviderConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *providerMetadata
+	keys        *KeyRing
+	keysFetched time.Time
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewIdentityProvider validates the configuration. A nil client uses one with a 10 second timeout.
+func NewIdentityProvider(config IdentityProviderConfig, client *http.Client) (*IdentityProvider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("identity provider %q: name, issuer, clientId and redirectUrl are required", config.Name)
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &IdentityProvider{config: config, client: client}, nil
+}
+
+// LoadIdentityProvidersFile reads a JSON array of provider configurations.
+func LoadIdentityProvidersFile(path string) ([]*IdentityProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []IdentityProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse identity providers file: %w", err)
+	}
+	providers := make([]*IdentityProvider, 0, len(configs))
+	for _, config := range configs {
+		provider, err := NewIdentityProvider(config, nil)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// Name identifies the provider in routes and identity links.
+func (p *IdentityProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the provider URL that starts a sign-in. state and nonce come back in the
+// redirect and the ID token; codeVerifier is the PKCE secret later passed to Exchange.
+func (p *IdentityProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {b64(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns the identity in the
+// ID token, which must carry nonce.
+func (p *IdentityProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*ExternalIdentity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("identity provider %q: token request: %w", p.config.Name, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, identityResponseLimit)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("identity provider %q: token response: %w", p.config.Name, err)
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: %s %s", ErrAuthorizationRejected, body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider %q: token endpoint answered %d", p.config.Name, resp.StatusCode)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrIdentityTokenInvalid)
+	}
+	return p.VerifyIDToken(ctx, body.IDToken, nonce)
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's JWKS along with its
+// issuer, audience, expiry and nonce.
+func (p *IdentityProvider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (*ExternalIdentity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		return p.verificationKey(ctx, token)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentityTokenInvalid, err)
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, ErrIdentityTokenInvalid
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrIdentityTokenInvalid)
+	}
+	// A token addressed to several clients must name this one as its authorized party.
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+			return nil, fmt.Errorf("%w: unexpected authorized party", ErrIdentityTokenInvalid)
+		}
+	}
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrIdentityTokenInvalid)
+	}
+
+	identity := &ExternalIdentity{Provider: p.config.Name, Subject: subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.GivenName, _ = claims["given_name"].(string)
+	identity.FamilyName, _ = claims["family_name"].(string)
+	// Some providers send email_verified as the string "true".
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	return identity, nil
+}
+
+// verificationKey resolves the token's key from the provider's JWKS, fetching the set again
+// when it is stale or does not know the key ID, as happens after the provider rotates keys.
+func (p *IdentityProvider) verificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		age := time.Since(p.keysFetched)
+		if p.keys == nil || age > identityKeysMaxAge || (attempt > 0 && age > identityKeysMinRefresh) {
+			if err := p.fetchKeys(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		if kid == "" {
+			set := jwt.VerificationKeySet{}
+			for _, key := range p.keys.VerificationKeys() {
+				if key.Method.Alg() == alg {
+					set.Keys = append(set.Keys, key.PublicKey)
+				}
+			}
+			if len(set.Keys) > 0 {
+				return set, nil
+			}
+		} else if key, ok := p.keys.Lookup(kid); ok {
+			if key.Method.Alg() != alg {
+				return nil, fmt.Errorf("unexpected signing method %s for key %q", alg, kid)
+			}
+			return key.PublicKey, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// fetchKeys replaces the cached JWKS. Keys of unsupported types are skipped. The caller holds p.mu.
+func (p *IdentityProvider) fetchKeys(ctx context.Context) error {
+	var set JSONWebKeySet
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return err
+	}
+	ring := NewKeyRing()
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		key, err := NewVerificationKey(jwk.KeyID, pub)
+		if err != nil {
+			continue
+		}
+		if err := ring.Add(key); err != nil {
+			continue
+		}
+	}
+	p.keys = ring
+	p.keysFetched = time.Now()
+	return nil
+}
+
+// discover fetches and caches the provider's discovery document. Its issuer must match the
+// configured one exactly, as OpenID Connect Discovery requires.
+func (p *IdentityProvider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata providerMetadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.config.Issuer, "/")+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("identity provider %q: discovery issuer %q does not match %q", p.config.Name, metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("identity provider %q: incomplete discovery document", p.config.Name)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+func (p *IdentityProvider) getJSON(ctx context.Context, target string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("identity provider %q: %w", p.config.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("identity provider %q: GET %s answered %d", p.config.Name, target, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, identityResponseLimit)).Decode(out); err != nil {
+		return fmt.Errorf("identity provider %q: decode %s: %w", p.config.Name, target, err)
+	}
+	return nil
+}
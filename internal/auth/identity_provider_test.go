@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth/oidctest"
+)
+
+func newTestIdentityProvider(t *testing.T) (*oidctest.Provider, *auth.IdentityProvider) {
+	t.Helper()
+	stand := oidctest.NewProvider(t, "shop", "secret")
+	provider, err := auth.NewIdentityProvider(stand.Config("example", "https://shop.example.com/oidc/callback"), nil)
+	if err != nil {
+		t.Fatalf("new identity provider: %v", err)
+	}
+	return stand, provider
+}
+
+func TestIdentityProviderAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	stand, provider := newTestIdentityProvider(t)
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("auth code url: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if parsed.Query().Get("scope") != "openid email profile" || parsed.Query().Get("code_challenge") == "verifier-1" {
+		t.Fatalf("unexpected authorization url %s", authURL)
+	}
+
+	code, state := stand.Authorize(t, authURL, oidctest.Identity{Subject: "sub-1", Email: "ana@example.com", EmailVerified: true, GivenName: "Ana"})
+	if state != "state-1" {
+		t.Fatalf("expected the state to round-trip, got %q", state)
+	}
+	identity, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if identity.Provider != "example" || identity.Subject != "sub-1" || identity.Email != "ana@example.com" || !identity.EmailVerified || identity.GivenName != "Ana" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	if _, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1"); !errors.Is(err, auth.ErrAuthorizationRejected) {
+		t.Fatalf("expected a spent code to be rejected, got %v", err)
+	}
+	code, _ = stand.Authorize(t, authURL, oidctest.Identity{Subject: "sub-1"})
+	if _, err := provider.Exchange(ctx, code, "other-verifier", "nonce-1"); !errors.Is(err, auth.ErrAuthorizationRejected) {
+		t.Fatalf("expected a wrong PKCE verifier to be rejected, got %v", err)
+	}
+	code, _ = stand.Authorize(t, authURL, oidctest.Identity{Subject: "sub-1"})
+	if _, err := provider.Exchange(ctx, code, "verifier-1", "other-nonce"); !errors.Is(err, auth.ErrIdentityTokenInvalid) {
+		t.Fatalf("expected a nonce mismatch to be rejected, got %v", err)
+	}
+}
+
+func TestIdentityProviderVerifyIDToken(t *testing.T) {
+	ctx := context.Background()
+	stand, provider := newTestIdentityProvider(t)
+	identity := oidctest.Identity{Subject: "sub-1", Email: "ana@example.com"}
+
+	token := stand.IDToken(t, identity, "nonce-1", map[string]any{"email_verified": "true"})
+	verified, err := provider.VerifyIDToken(ctx, token, "nonce-1")
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !verified.EmailVerified {
+		t.Fatalf("expected a string email_verified claim to be honoured")
+	}
+
+	cases := map[string]string{
+		"wrong audience":  stand.IDToken(t, identity, "nonce-1", map[string]any{"aud": "other-client"}),
+		"wrong issuer":    stand.IDToken(t, identity, "nonce-1", map[string]any{"iss": "https://evil.example.com"}),
+		"expired":         stand.IDToken(t, identity, "nonce-1", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"missing subject": stand.IDToken(t, oidctest.Identity{}, "nonce-1", nil),
+		"foreign azp":     stand.IDToken(t, identity, "nonce-1", map[string]any{"aud": []string{"shop", "other-client"}, "azp": "other-client"}),
+		"tampered":        token[:len(token)-4] + "AAAA",
+	}
+	for name, raw := range cases {
+		if _, err := provider.VerifyIDToken(ctx, raw, "nonce-1"); !errors.Is(err, auth.ErrIdentityTokenInvalid) {
+			t.Fatalf("%s: expected ErrIdentityTokenInvalid, got %v", name, err)
+		}
+	}
+}
+
+func TestIdentityProviderKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	stand, provider := newTestIdentityProvider(t)
+	identity := oidctest.Identity{Subject: "sub-1"}
+
+	old := stand.IDToken(t, identity, "nonce-1", nil)
+	stand.RotateKey(t)
+	current := stand.IDToken(t, identity, "nonce-1", nil)
+
+	for _, raw := range []string{current, old} {
+		if _, err := provider.VerifyIDToken(ctx, raw, "nonce-1"); err != nil {
+			t.Fatalf("expected tokens of every published key to verify: %v", err)
+		}
+	}
+}
+
+func TestIdentityProviderRequiresMatchingIssuer(t *testing.T) {
+	stand := oidctest.NewProvider(t, "shop", "secret")
+	config := stand.Config("example", "https://shop.example.com/oidc/callback")
+	config.Issuer += "/"
+	provider, err := auth.NewIdentityProvider(config, nil)
+	if err != nil {
+		t.Fatalf("new identity provider: %v", err)
+	}
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatalf("expected a discovery document for another issuer to be rejected")
+	}
+
+	if _, err := auth.NewIdentityProvider(auth.IdentityProviderConfig{Name: "example"}, nil); err == nil {
+		t.Fatalf("expected an incomplete configuration to be rejected")
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JSONWebKey is the public JWK representation of a verification key (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document served from the JWKS endpoint.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS publishes every key that is still accepted for verification.
+func (r *KeyRing) JWKS() JSONWebKeySet {
+	keys := r.VerificationKeys()
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	return set
+}
+
+func (k *SigningKey) jwk() JSONWebKey {
+	out := JSONWebKey{Use: "sig", KeyID: k.ID, Algorithm: k.Method.Alg()}
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		out.KeyType = "RSA"
+		out.N = b64(pub.N.Bytes())
+		out.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		out.KeyType = "EC"
+		out.Curve = pub.Curve.Params().Name
+		out.X = b64(pub.X.FillBytes(make([]byte, size)))
+		out.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		out.KeyType = "OKP"
+		out.Curve = "Ed25519"
+		out.X = b64(pub)
+	}
+	return out
+}
+
+// PublicKey decodes the key material of an RSA, P-256 EC or Ed25519 JWK.
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: decode n: %w", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: decode e: %w", k.KeyID, err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("jwk %q: invalid RSA key", k.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("jwk %q: invalid EC coordinates", k.KeyID)
+		}
+		// Parsing the uncompressed point rejects coordinates that are not on the curve.
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", k.KeyID, err)
+		}
+		return pub, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Curve != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwk %q: invalid OKP key", k.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("jwk %q: unsupported key type %q", k.KeyID, k.KeyType)
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint from the required members in lexical order.
+func thumbprint(jwk JSONWebKey) string {
+	var canonical string
+	switch jwk.KeyType {
+	case "RSA":
+		canonical = `{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`
+	case "EC":
+		canonical = `{"crv":"` + jwk.Curve + `","kty":"EC","x":"` + jwk.X + `","y":"` + jwk.Y + `"}`
+	case "OKP":
+		canonical = `{"crv":"` + jwk.Curve + `","kty":"OKP","x":"` + jwk.X + `"}`
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
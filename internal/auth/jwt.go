@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"crypto/rsa"
 	"fmt"
 	"os"
 	"time"
@@ -9,30 +8,60 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token types stamped into the typ claim.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	TokenTypeID      = "id"
+	TokenTypeMFA     = "mfa"
+)
+
+// mfaChallengeTTL bounds the time between the password step and the second factor.
+const mfaChallengeTTL = 5 * time.Minute
+
 // TokenIssuer issues and validates JWT access and refresh tokens.
 type TokenIssuer struct {
-	signingKey *rsa.PrivateKey
-	verifyKey  *rsa.PublicKey
+	keys       *KeyRing
 	accessTTL  time.Duration
 	refreshTTL time.Duration
 	issuer     string
 	audience   []string
 }
 
-// NewTokenIssuer constructs an issuer from PEM encoded key pairs.
+// NewTokenIssuer constructs an issuer from PEM encoded key pairs. The signing algorithm is
+// detected from the key type (RSA, ECDSA P-256 or Ed25519).
 func NewTokenIssuer(privateKeyPEM, publicKeyPEM []byte, issuer string, audience []string) (*TokenIssuer, error) {
-	priv, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
+	key, err := ParseSigningKeyPEM("", privateKeyPEM)
 	if err != nil {
-		return nil, fmt.Errorf("parse private key: %w", err)
+		return nil, err
+	}
+	if !key.CanSign() {
+		return nil, fmt.Errorf("parse private key: PEM does not contain a private key")
 	}
-	pub, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM)
+	pub, err := ParseSigningKeyPEM("", publicKeyPEM)
 	if err != nil {
-		return nil, fmt.Errorf("parse public key: %w", err)
+		return nil, err
+	}
+	if pub.ID != key.ID {
+		return nil, fmt.Errorf("public key does not match private key")
+	}
+
+	ring := NewKeyRing()
+	if err := ring.Add(key); err != nil {
+		return nil, err
+	}
+	return NewTokenIssuerWithKeyRing(ring, issuer, audience)
+}
+
+// NewTokenIssuerWithKeyRing constructs an issuer that signs with the ring's active key and
+// verifies against every key in the ring.
+func NewTokenIssuerWithKeyRing(ring *KeyRing, issuer string, audience []string) (*TokenIssuer, error) {
+	if _, err := ring.Active(); err != nil {
+		return nil, err
 	}
 
 	return &TokenIssuer{
-		signingKey: priv,
-		verifyKey:  pub,
+		keys:       ring,
 		accessTTL:  15 * time.Minute,
 		refreshTTL: 7 * 24 * time.Hour,
 		issuer:     issuer,
@@ -55,17 +84,43 @@ func LoadIssuerFromFiles(privatePath, publicPath, issuer string, audience []stri
 
 // GenerateAccessToken issues a signed JWT for the supplied claims.
 func (t *TokenIssuer) GenerateAccessToken(subject string, claims map[string]any) (string, error) {
-	return t.generateToken(subject, claims, t.accessTTL, "access")
+	return t.generateToken(subject, claims, t.accessTTL, TokenTypeAccess)
 }
 
 // GenerateRefreshToken issues a signed JWT refresh token for the supplied subject.
 func (t *TokenIssuer) GenerateRefreshToken(subject string, claims map[string]any) (string, error) {
-	return t.generateToken(subject, claims, t.refreshTTL, "refresh")
+	return t.generateToken(subject, claims, t.refreshTTL, TokenTypeRefresh)
+}
+
+// GenerateIDToken issues an OpenID Connect ID token addressed to the relying party clientID.
+func (t *TokenIssuer) GenerateIDToken(subject, clientID string, claims map[string]any) (string, error) {
+	merged := make(map[string]any, len(claims)+1)
+	for k, v := range claims {
+		merged[k] = v
+	}
+	merged["aud"] = clientID
+	return t.generateToken(subject, merged, t.accessTTL, TokenTypeID)
+}
+
+// GenerateMFAToken issues the short-lived challenge that proves the password step of a login
+// succeeded. It is only accepted by the second-factor step, never as an access token.
+func (t *TokenIssuer) GenerateMFAToken(subject string, claims map[string]any) (string, error) {
+	return t.generateToken(subject, claims, mfaChallengeTTL, TokenTypeMFA)
+}
+
+// MFATokenTTL exposes the lifetime of MFA challenge tokens.
+func (t *TokenIssuer) MFATokenTTL() time.Duration {
+	return mfaChallengeTTL
 }
 
 func (t *TokenIssuer) generateToken(subject string, claims map[string]any, ttl time.Duration, tokenType string) (string, error) {
+	key, err := t.keys.Active()
+	if err != nil {
+		return "", err
+	}
+
 	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+	token := jwt.NewWithClaims(key.Method, jwt.MapClaims{
 		"sub": subject,
 		"iss": t.issuer,
 		"aud": t.audience,
@@ -77,17 +132,73 @@ func (t *TokenIssuer) generateToken(subject string, claims map[string]any, ttl t
 	for k, v := range claims {
 		token.Claims.(jwt.MapClaims)[k] = v
 	}
-	return token.SignedString(t.signingKey)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
 }
 
-// ParseAndValidate validates an incoming token string and returns the claims.
-func (t *TokenIssuer) ParseAndValidate(tokenString string) (jwt.MapClaims, error) {
-	parsed, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return t.verifyKey, nil
-	})
+// ValidateOption customises the checks performed by ParseAndValidate.
+type ValidateOption func(*validateOptions)
+
+type validateOptions struct {
+	tokenType string
+	issuer    string
+	audience  string
+	leeway    time.Duration
+}
+
+// WithTokenType requires the typ claim to equal the supplied token type.
+func WithTokenType(tokenType string) ValidateOption {
+	return func(o *validateOptions) {
+		o.tokenType = tokenType
+	}
+}
+
+// WithIssuer overrides the expected iss claim. It defaults to the issuer's own name.
+func WithIssuer(issuer string) ValidateOption {
+	return func(o *validateOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithAudience overrides the audience that must appear in the aud claim. It defaults to the
+// first configured audience.
+func WithAudience(audience string) ValidateOption {
+	return func(o *validateOptions) {
+		o.audience = audience
+	}
+}
+
+// WithLeeway tolerates clock skew when checking exp, nbf and iat.
+func WithLeeway(leeway time.Duration) ValidateOption {
+	return func(o *validateOptions) {
+		o.leeway = leeway
+	}
+}
+
+// ParseAndValidate validates an incoming token string and returns the claims. Signature,
+// expiry, issuer and audience are always checked; the token type only when requested.
+func (t *TokenIssuer) ParseAndValidate(tokenString string, opts ...ValidateOption) (jwt.MapClaims, error) {
+	options := validateOptions{issuer: t.issuer}
+	if len(t.audience) > 0 {
+		options.audience = t.audience[0]
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(t.keys.Algorithms()),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(options.leeway),
+	}
+	if options.issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(options.issuer))
+	}
+	if options.audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(options.audience))
+	}
+
+	parsed, err := jwt.Parse(tokenString, t.verificationKey, parserOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -97,9 +208,66 @@ func (t *TokenIssuer) ParseAndValidate(tokenString string) (jwt.MapClaims, error
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	if options.tokenType != "" {
+		if typ, _ := claims["typ"].(string); typ != options.tokenType {
+			return nil, fmt.Errorf("unexpected token type %q", typ)
+		}
+	}
+
 	return claims, nil
 }
 
+// verificationKey resolves the key named by the kid header and insists the token uses that
+// key's algorithm. Tokens minted before kids were stamped are checked against every accepted
+// key of the token's algorithm.
+func (t *TokenIssuer) verificationKey(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		set := jwt.VerificationKeySet{}
+		for _, key := range t.keys.VerificationKeys() {
+			if key.Method.Alg() == alg {
+				set.Keys = append(set.Keys, key.PublicKey)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("unexpected signing method %s", alg)
+		}
+		return set, nil
+	}
+
+	key, ok := t.keys.Lookup(kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.Method.Alg() != alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", alg, kid)
+	}
+	return key.PublicKey, nil
+}
+
+// Rotate makes key the active signing key. The previous key keeps verifying for the refresh
+// token lifetime so every token it signed can still be used until it expires.
+func (t *TokenIssuer) Rotate(key *SigningKey) error {
+	return t.keys.Rotate(key, t.refreshTTL)
+}
+
+// KeyRing exposes the issuer's keys.
+func (t *TokenIssuer) KeyRing() *KeyRing {
+	return t.keys
+}
+
+// JWKS returns the public keys accepted for verification.
+func (t *TokenIssuer) JWKS() JSONWebKeySet {
+	return t.keys.JWKS()
+}
+
+// Issuer returns the value stamped into the iss claim.
+func (t *TokenIssuer) Issuer() string {
+	return t.issuer
+}
+
 // AccessTokenTTL exposes the configured TTL for access tokens.
 func (t *TokenIssuer) AccessTokenTTL() time.Duration {
 	return t.accessTTL
@@ -111,8 +279,8 @@ func (t *TokenIssuer) RefreshTokenTTL() time.Duration {
 }
 
 // SubjectFromToken extracts the authenticated subject identifier from a token string.
-func (t *TokenIssuer) SubjectFromToken(tokenString string) (string, error) {
-	claims, err := t.ParseAndValidate(tokenString)
+func (t *TokenIssuer) SubjectFromToken(tokenString string, opts ...ValidateOption) (string, error) {
+	claims, err := t.ParseAndValidate(tokenString, opts...)
 	if err != nil {
 		return "", err
 	}
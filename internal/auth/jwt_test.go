@@ -1,11 +1,19 @@
 package auth_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 )
@@ -43,6 +51,275 @@ func TestTokenIssuer(t *testing.T) {
 	}
 }
 
+func TestParseAndValidateOptions(t *testing.T) {
+	privPEM, pubPEM := generateKeyPair(t)
+
+	issuer, err := auth.NewTokenIssuer(privPEM, pubPEM, "svc-user", []string{"users"})
+	if err != nil {
+		t.Fatalf("new token issuer: %v", err)
+	}
+
+	access, err := issuer.GenerateAccessToken("user-123", nil)
+	if err != nil {
+		t.Fatalf("generate access token: %v", err)
+	}
+	refresh, err := issuer.GenerateRefreshToken("user-123", nil)
+	if err != nil {
+		t.Fatalf("generate refresh token: %v", err)
+	}
+
+	if _, err := issuer.ParseAndValidate(access, auth.WithTokenType(auth.TokenTypeAccess)); err != nil {
+		t.Fatalf("expected access token to validate: %v", err)
+	}
+	if _, err := issuer.ParseAndValidate(refresh, auth.WithTokenType(auth.TokenTypeAccess)); err == nil {
+		t.Fatal("expected refresh token to be rejected as access token")
+	}
+	if _, err := issuer.ParseAndValidate(access, auth.WithTokenType(auth.TokenTypeRefresh)); err == nil {
+		t.Fatal("expected access token to be rejected as refresh token")
+	}
+	if _, err := issuer.ParseAndValidate(access, auth.WithAudience("orders")); err == nil {
+		t.Fatal("expected audience mismatch to be rejected")
+	}
+	if _, err := issuer.ParseAndValidate(access, auth.WithIssuer("someone-else")); err == nil {
+		t.Fatal("expected issuer mismatch to be rejected")
+	}
+
+	foreign, err := auth.NewTokenIssuer(privPEM, pubPEM, "other-issuer", []string{"users"})
+	if err != nil {
+		t.Fatalf("new foreign issuer: %v", err)
+	}
+	foreignToken, err := foreign.GenerateAccessToken("user-123", nil)
+	if err != nil {
+		t.Fatalf("generate foreign token: %v", err)
+	}
+	if _, err := issuer.ParseAndValidate(foreignToken); err == nil {
+		t.Fatal("expected token from another issuer to be rejected")
+	}
+}
+
+func TestTokenIssuerKeyRotation(t *testing.T) {
+	privPEM, _ := generateKeyPair(t)
+	first, err := auth.ParseSigningKeyPEM("2024-01", privPEM)
+	if err != nil {
+		t.Fatalf("parse first key: %v", err)
+	}
+
+	ring := auth.NewKeyRing()
+	if err := ring.Add(first); err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+	issuer, err := auth.NewTokenIssuerWithKeyRing(ring, "svc-user", []string{"users"})
+	if err != nil {
+		t.Fatalf("new token issuer: %v", err)
+	}
+
+	before, err := issuer.GenerateAccessToken("user-123", nil)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if kid := tokenKeyID(t, before); kid != "2024-01" {
+		t.Fatalf("expected kid 2024-01 got %q", kid)
+	}
+
+	nextPEM, _ := generateKeyPair(t)
+	next, err := auth.ParseSigningKeyPEM("2024-02", nextPEM)
+	if err != nil {
+		t.Fatalf("parse next key: %v", err)
+	}
+	if err := issuer.Rotate(next); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	after, err := issuer.GenerateAccessToken("user-123", nil)
+	if err != nil {
+		t.Fatalf("generate token after rotation: %v", err)
+	}
+	if kid := tokenKeyID(t, after); kid != "2024-02" {
+		t.Fatalf("expected kid 2024-02 got %q", kid)
+	}
+
+	for name, token := range map[string]string{"before": before, "after": after} {
+		if _, err := issuer.ParseAndValidate(token); err != nil {
+			t.Fatalf("expected %s-rotation token to validate: %v", name, err)
+		}
+	}
+	if keys := issuer.JWKS().Keys; len(keys) != 2 {
+		t.Fatalf("expected both keys in JWKS, got %d", len(keys))
+	}
+
+	if err := ring.Retire("2024-01", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("retire: %v", err)
+	}
+	if _, err := issuer.ParseAndValidate(before); err == nil {
+		t.Fatal("expected token signed by retired key to be rejected")
+	}
+	if keys := issuer.JWKS().Keys; len(keys) != 1 || keys[0].KeyID != "2024-02" {
+		t.Fatalf("expected only the active key in JWKS, got %+v", keys)
+	}
+}
+
+func TestLoadKeyRingFromDir(t *testing.T) {
+	dir := t.TempDir()
+	_, oldPub := generateKeyPair(t)
+	newPriv, _ := generateKeyPair(t)
+
+	writeFile(t, filepath.Join(dir, "a-old.pem"), oldPub)
+	writeFile(t, filepath.Join(dir, "b-new.pem"), newPriv)
+
+	ring, err := auth.LoadKeyRingFromDir(dir, "")
+	if err != nil {
+		t.Fatalf("load key ring: %v", err)
+	}
+	active, err := ring.Active()
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	if active.ID != "b-new" {
+		t.Fatalf("expected b-new to be active, got %s", active.ID)
+	}
+	if len(ring.VerificationKeys()) != 2 {
+		t.Fatalf("expected public key to be kept for verification")
+	}
+	if _, err := auth.LoadKeyRingFromDir(dir, "a-old"); err == nil {
+		t.Fatal("expected activating a public-only key to fail")
+	}
+}
+
+func TestTokenIssuerAlgorithms(t *testing.T) {
+	cases := map[string]struct {
+		generate func(t *testing.T) ([]byte, []byte)
+		alg      string
+		kty      string
+	}{
+		"rsa":     {generate: generateKeyPair, alg: "RS256", kty: "RSA"},
+		"ecdsa":   {generate: generateECDSAKeyPair, alg: "ES256", kty: "EC"},
+		"ed25519": {generate: generateEd25519KeyPair, alg: "EdDSA", kty: "OKP"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			privPEM, pubPEM := tc.generate(t)
+			issuer, err := auth.NewTokenIssuer(privPEM, pubPEM, "svc-user", []string{"users"})
+			if err != nil {
+				t.Fatalf("new token issuer: %v", err)
+			}
+
+			token, err := issuer.GenerateAccessToken("user-123", nil)
+			if err != nil {
+				t.Fatalf("generate token: %v", err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("parse unverified: %v", err)
+			}
+			if parsed.Method.Alg() != tc.alg {
+				t.Fatalf("expected alg %s got %s", tc.alg, parsed.Method.Alg())
+			}
+
+			if sub, err := issuer.SubjectFromToken(token); err != nil || sub != "user-123" {
+				t.Fatalf("validate token: sub=%q err=%v", sub, err)
+			}
+
+			keys := issuer.JWKS().Keys
+			if len(keys) != 1 || keys[0].KeyType != tc.kty || keys[0].Algorithm != tc.alg {
+				t.Fatalf("unexpected jwks: %+v", keys)
+			}
+		})
+	}
+}
+
+func TestTokenIssuerRejectsUnexpectedAlgorithm(t *testing.T) {
+	ecPriv, _ := generateECDSAKeyPair(t)
+	rsaPriv, _ := generateKeyPair(t)
+
+	ecKey, err := auth.ParseSigningKeyPEM("shared", ecPriv)
+	if err != nil {
+		t.Fatalf("parse ec key: %v", err)
+	}
+	rsaKey, err := auth.ParseSigningKeyPEM("shared", rsaPriv)
+	if err != nil {
+		t.Fatalf("parse rsa key: %v", err)
+	}
+
+	verifierRing := auth.NewKeyRing()
+	if err := verifierRing.Add(ecKey); err != nil {
+		t.Fatalf("add ec key: %v", err)
+	}
+	verifier, err := auth.NewTokenIssuerWithKeyRing(verifierRing, "svc-user", []string{"users"})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+
+	attackerRing := auth.NewKeyRing()
+	if err := attackerRing.Add(rsaKey); err != nil {
+		t.Fatalf("add rsa key: %v", err)
+	}
+	attacker, err := auth.NewTokenIssuerWithKeyRing(attackerRing, "svc-user", []string{"users"})
+	if err != nil {
+		t.Fatalf("new attacker issuer: %v", err)
+	}
+
+	forged, err := attacker.GenerateAccessToken("user-123", nil)
+	if err != nil {
+		t.Fatalf("generate forged token: %v", err)
+	}
+	if _, err := verifier.ParseAndValidate(forged); err == nil {
+		t.Fatal("expected RS256 token to be rejected by an ES256-only issuer")
+	}
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("parse unverified: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func generateECDSAKeyPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return marshalPKCS8KeyPair(t, priv, &priv.PublicKey)
+}
+
+func generateEd25519KeyPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return marshalPKCS8KeyPair(t, priv, pub)
+}
+
+func marshalPKCS8KeyPair(t *testing.T, priv, pub any) ([]byte, []byte) {
+	t.Helper()
+
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshal private key: %v", err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+}
+
 func generateKeyPair(t *testing.T) ([]byte, []byte) {
 	t.Helper()
 
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoActiveKey = errors.New("no active signing key")
+	ErrUnknownKey  = errors.New("unknown key id")
+)
+
+// SigningKey is a JWT key identified by its kid. The signing algorithm is derived from the key
+// type: RSA keys sign RS256, P-256 ECDSA keys ES256 and Ed25519 keys EdDSA. Keys without a
+// private half can only verify.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+
+	verifyUntil time.Time
+}
+
+// NewSigningKey wraps a private key. An empty kid is replaced by the RFC 7638 thumbprint.
+func NewSigningKey(kid string, priv crypto.Signer) (*SigningKey, error) {
+	key, err := NewVerificationKey(kid, priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.PrivateKey = priv
+	return key, nil
+}
+
+// NewVerificationKey wraps a public key that may only verify tokens.
+func NewVerificationKey(kid string, pub crypto.PublicKey) (*SigningKey, error) {
+	method, err := signingMethodFor(pub)
+	if err != nil {
+		return nil, err
+	}
+	key := &SigningKey{ID: kid, Method: method, PublicKey: pub}
+	if key.ID == "" {
+		key.ID = thumbprint(key.jwk())
+	}
+	return key, nil
+}
+
+// ParseSigningKeyPEM decodes a private or public PEM key. Public keys become verification-only keys.
+func ParseSigningKeyPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q: no PEM block found", kid)
+	}
+
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		priv, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key %q: %w", kid, err)
+		}
+		return NewSigningKey(kid, priv)
+	}
+
+	pub, err := parsePublicKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %q: %w", kid, err)
+	}
+	return NewVerificationKey(kid, pub)
+}
+
+// CanSign reports whether the key holds private material.
+func (k *SigningKey) CanSign() bool {
+	return k.PrivateKey != nil
+}
+
+func (k *SigningKey) usable(now time.Time) bool {
+	return k.verifyUntil.IsZero() || now.Before(k.verifyUntil)
+}
+
+// KeyRing holds the verification keys accepted by the issuer and the single key used for signing.
+type KeyRing struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	order  []string
+	active string
+}
+
+// NewKeyRing constructs an empty key ring.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]*SigningKey)}
+}
+
+// Add registers a key for verification. The first signing-capable key added becomes active.
+func (r *KeyRing) Add(key *SigningKey) error {
+	if key == nil || key.ID == "" || key.PublicKey == nil {
+		return fmt.Errorf("key id and public key are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key id %q", key.ID)
+	}
+	r.keys[key.ID] = key
+	r.order = append(r.order, key.ID)
+	if r.active == "" && key.CanSign() {
+		r.active = key.ID
+	}
+	return nil
+}
+
+// Activate selects the key used to sign new tokens.
+func (r *KeyRing) Activate(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("key %q has no private key", kid)
+	}
+	key.verifyUntil = time.Time{}
+	r.active = kid
+	return nil
+}
+
+// Rotate adds a new signing key and activates it. The previously active key keeps verifying
+// for the grace period so tokens it signed remain valid until they expire.
+func (r *KeyRing) Rotate(key *SigningKey, grace time.Duration) error {
+	if !key.CanSign() {
+		return fmt.Errorf("key %q has no private key", key.ID)
+	}
+	if err := r.Add(key); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if previous, ok := r.keys[r.active]; ok && previous.ID != key.ID {
+		previous.verifyUntil = time.Now().Add(grace)
+	}
+	r.active = key.ID
+	return nil
+}
+
+// Retire stops accepting tokens signed by the key after the given time. The active key cannot be retired.
+func (r *KeyRing) Retire(kid string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if kid == r.active {
+		return fmt.Errorf("cannot retire the active key %q", kid)
+	}
+	key.verifyUntil = until
+	return nil
+}
+
+// Active returns the key used for signing.
+func (r *KeyRing) Active() (*SigningKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[r.active]
+	if !ok {
+		return nil, ErrNoActiveKey
+	}
+	return key, nil
+}
+
+// Lookup returns a key that is still valid for verification.
+func (r *KeyRing) Lookup(kid string) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[kid]
+	if !ok || !key.usable(time.Now()) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Algorithms lists the JWS algorithms of the keys currently accepted for verification.
+func (r *KeyRing) Algorithms() []string {
+	seen := make(map[string]struct{})
+	var algs []string
+	for _, key := range r.VerificationKeys() {
+		alg := key.Method.Alg()
+		if _, ok := seen[alg]; ok {
+			continue
+		}
+		seen[alg] = struct{}{}
+		algs = append(algs, alg)
+	}
+	return algs
+}
+
+// VerificationKeys lists the keys currently accepted for verification in insertion order.
+func (r *KeyRing) VerificationKeys() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	out := make([]*SigningKey, 0, len(r.order))
+	for _, kid := range r.order {
+		if key := r.keys[kid]; key.usable(now) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// LoadKeyRingFromDir loads every *.pem file in dir, using the file name without extension as
+// the kid. Private keys can sign; public keys are kept for verification only. When activeKID is
+// empty the lexicographically last private key is activated.
+func LoadKeyRingFromDir(dir, activeKID string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ring := NewKeyRing()
+	latest := ""
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := ParseSigningKeyPEM(kid, data)
+		if err != nil {
+			return nil, err
+		}
+		if err := ring.Add(key); err != nil {
+			return nil, err
+		}
+		if key.CanSign() {
+			latest = kid
+		}
+	}
+
+	if activeKID == "" {
+		activeKID = latest
+	}
+	if activeKID == "" {
+		return nil, fmt.Errorf("no private key found in %s", dir)
+	}
+	if err := ring.Activate(activeKID); err != nil {
+		return nil, fmt.Errorf("activate key %q: %w", activeKID, err)
+	}
+	return ring, nil
+}
+
+func signingMethodFor(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+		return jwt.SigningMethodES256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unrecognised private key encoding")
+}
+
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unrecognised public key encoding")
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginAttempts tracks failed sign-ins per key, such as an account or a client address, and
+// keeps keys blocked for a while.
+type LoginAttempts interface {
+	// Fail counts a failed attempt and returns the number of failures so far. The count expires
+	// once ttl passes without another failure.
+	Fail(ctx context.Context, key string, ttl time.Duration) (int, error)
+	// Block rejects attempts for key during d.
+	Block(ctx context.Context, key string, d time.Duration) error
+	// Blocked returns how long key stays blocked, or zero.
+	Blocked(ctx context.Context, key string) (time.Duration, error)
+	// Clear forgets the failures of key and lifts its block.
+	Clear(ctx context.Context, key string) error
+}
+
+// RedisLoginAttempts keeps failure counters and blocks in Redis.
+type RedisLoginAttempts struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisLoginAttempts constructs a Redis-backed login attempt tracker.
+func NewRedisLoginAttempts(client *redis.Client) *RedisLoginAttempts {
+	return &RedisLoginAttempts{client: client, prefix: "auth:login"}
+}
+
+// Fail increments the counter and pushes its expiry back.
+func (a *RedisLoginAttempts) Fail(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	counter := a.prefix + ":failures:" + key
+	pipe := a.client.TxPipeline()
+	count := pipe.Incr(ctx, counter)
+	pipe.PExpire(ctx, counter, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// Block sets a marker that expires after d.
+func (a *RedisLoginAttempts) Block(ctx context.Context, key string, d time.Duration) error {
+	return a.client.Set(ctx, a.prefix+":blocked:"+key, "1", d).Err()
+}
+
+// Blocked reads the remaining lifetime of the block marker.
+func (a *RedisLoginAttempts) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := a.client.PTTL(ctx, a.prefix+":blocked:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Clear deletes the counter and the block marker.
+func (a *RedisLoginAttempts) Clear(ctx context.Context, key string) error {
+	return a.client.Del(ctx, a.prefix+":failures:"+key, a.prefix+":blocked:"+key).Err()
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MFAAttempts counts second-factor attempts per key, such as a user or an MFA challenge, in
+// windows that start with the first attempt.
+type MFAAttempts interface {
+	// Attempt records an attempt and returns how many were made in the current window and how
+	// long the window has left.
+	Attempt(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+	// Exhaust uses up every attempt of key for window, so that further ones exceed any limit.
+	Exhaust(ctx context.Context, key string, window time.Duration) error
+	// Reset forgets the attempts of key.
+	Reset(ctx context.Context, key string) error
+}
+
+// RedisMFAAttempts keeps second-factor attempt counters in Redis.
+type RedisMFAAttempts struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisMFAAttempts constructs a Redis-backed second-factor attempt counter.
+func NewRedisMFAAttempts(client *redis.Client) *RedisMFAAttempts {
+	return &RedisMFAAttempts{client: client, prefix: "auth:mfa"}
+}
+
+// Attempt increments the key's counter, starting the window on the first attempt.
+func (a *RedisMFAAttempts) Attempt(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	key = a.prefix + ":" + key
+	pipe := a.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	remaining := ttl.Val()
+	if remaining <= 0 {
+		remaining = window
+	}
+	return int(count.Val()), remaining, nil
+}
+
+// Exhaust sets the counter beyond any limit until window passes.
+func (a *RedisMFAAttempts) Exhaust(ctx context.Context, key string, window time.Duration) error {
+	return a.client.Set(ctx, a.prefix+":"+key, math.MaxInt32, window).Err()
+}
+
+// Reset deletes the counter.
+func (a *RedisMFAAttempts) Reset(ctx context.Context, key string) error {
+	return a.client.Del(ctx, a.prefix+":"+key).Err()
+}
@@ -0,0 +1,231 @@
+// Package oidctest runs a minimal OpenID Connect provider on httptest for exercising
+// auth.IdentityProvider and the federated login flows built on it.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// Identity is the user the provider vouches for.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+type grant struct {
+	identity    Identity
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// Provider serves discovery, JWKS and token endpoints for one registered client. Users never
+// see an authorization page: Authorize stands in for the consent screen and the redirect.
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+
+	server *httptest.Server
+	issuer *auth.TokenIssuer
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// NewProvider starts a provider that is shut down when the test ends.
+func NewProvider(t testing.TB, clientID, clientSecret string) *Provider {
+	t.Helper()
+	p := &Provider{ClientID: clientID, ClientSecret: clientSecret, codes: make(map[string]grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("POST /token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	ring := auth.NewKeyRing()
+	if err := ring.Add(newKey(t)); err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+	issuer, err := auth.NewTokenIssuerWithKeyRing(ring, p.server.URL, nil)
+	if err != nil {
+		t.Fatalf("new issuer: %v", err)
+	}
+	p.issuer = issuer
+	return p
+}
+
+// Issuer is the provider's issuer URL.
+func (p *Provider) Issuer() string {
+	return p.server.URL
+}
+
+// Config returns a relying-party configuration for the provider under name.
+func (p *Provider) Config(name, redirectURL string) auth.IdentityProviderConfig {
+	return auth.IdentityProviderConfig{
+		Name:         name,
+		Issuer:       p.server.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+// Authorize plays the user consenting at the authorization URL built by the relying party and
+// returns the code and state the provider would redirect back with.
+func (p *Provider) Authorize(t testing.TB, authorizationURL string, identity Identity) (code, state string) {
+	t.Helper()
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authorizationURL)
+	}
+
+	code = randomString()
+	p.mu.Lock()
+	p.codes[code] = grant{
+		identity:    identity,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+	return code, query.Get("state")
+}
+
+// IDToken signs an ID token for the identity. extra claims override the defaults, which allows
+// tests to forge expired or misaddressed tokens.
+func (p *Provider) IDToken(t testing.TB, identity Identity, nonce string, extra map[string]any) string {
+	t.Helper()
+	claims := map[string]any{
+		"aud":            p.ClientID,
+		"typ":            auth.TokenTypeID,
+		"nonce":          nonce,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"given_name":     identity.GivenName,
+		"family_name":    identity.FamilyName,
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	// GenerateIDToken always addresses the token to the client; the generic path lets extra
+	// override the audience too.
+	token, err := p.issuer.GenerateAccessToken(identity.Subject, claims)
+	if err != nil {
+		t.Fatalf("sign id token: %v", err)
+	}
+	return token
+}
+
+// RotateKey signs new tokens with a fresh key. The previous key stays in the JWKS.
+func (p *Provider) RotateKey(t testing.TB) {
+	t.Helper()
+	if err := p.issuer.Rotate(newKey(t)); err != nil {
+		t.Fatalf("rotate key: %v", err)
+	}
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.server.URL,
+		"authorization_endpoint": p.server.URL + "/authorize",
+		"token_endpoint":         p.server.URL + "/token",
+		"jwks_uri":               p.server.URL + "/jwks",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, p.issuer.JWKS())
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if clientID, err := url.QueryUnescape(id); !ok || err != nil || clientID != p.ClientID {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if clientSecret, err := url.QueryUnescape(secret); err != nil || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !found || g.redirectURI != r.PostForm.Get("redirect_uri") || base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"given_name":     g.identity.GivenName,
+		"family_name":    g.identity.FamilyName,
+	}
+	idToken, err := p.issuer.GenerateIDToken(g.identity.Subject, p.ClientID, claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func newKey(t testing.TB) *auth.SigningKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key, err := auth.NewSigningKey("", priv)
+	if err != nil {
+		t.Fatalf("new signing key: %v", err)
+	}
+	return key
+}
+
+func randomString() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OneTimeCode is a short code sent out of band, such as by SMS, together with the value it
+// proves control of. Only the hash of the code is kept.
+type OneTimeCode struct {
+	CodeHash string
+	Target   string
+}
+
+// OneTimeCodes keeps pending one-time codes. A key holds at most one code; saving a new one
+// replaces it.
+type OneTimeCodes interface {
+	SaveCode(ctx context.Context, key string, code OneTimeCode, ttl time.Duration) error
+	// CheckCode redeems the code stored under key if codeHash matches it. Each mismatch counts as
+	// an attempt and the code is discarded after maxAttempts of them.
+	CheckCode(ctx context.Context, key, codeHash string, maxAttempts int) (*OneTimeCode, error)
+}
+
+var (
+	ErrCodeNotFound = errors.New("one-time code not found")
+	ErrCodeMismatch = errors.New("one-time code mismatch")
+)
+
+// GenerateNumericCode returns a uniformly random code of the given number of decimal digits.
+func GenerateNumericCode(digits int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// checkCodeScript compares and redeems a code in one step so that concurrent guesses cannot
+// exceed the attempt limit. It returns the target on a match, 0 on a mismatch and nil when no
+// code is stored.
+var checkCodeScript = redis.NewScript(`
+local stored = redis.call('HGET', KEYS[1], 'hash')
+if not stored then
+  return nil
+end
+if stored == ARGV[1] then
+  local target = redis.call('HGET', KEYS[1], 'target')
+  redis.call('DEL', KEYS[1])
+  return target
+end
+if redis.call('HINCRBY', KEYS[1], 'attempts', 1) >= tonumber(ARGV[2]) then
+  redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// RedisOneTimeCodes stores one-time codes in Redis hashes.
+type RedisOneTimeCodes struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisOneTimeCodes constructs a Redis-backed one-time code store.
+func NewRedisOneTimeCodes(client *redis.Client) *RedisOneTimeCodes {
+	return &RedisOneTimeCodes{client: client, prefix: "auth:otp"}
+}
+
+// SaveCode stores the code with the given TTL, resetting the attempt counter.
+func (r *RedisOneTimeCodes) SaveCode(ctx context.Context, key string, code OneTimeCode, ttl time.Duration) error {
+	key = r.key(key)
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "hash", code.CodeHash, "target", code.Target, "attempts", 0)
+	pipe.PExpire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// CheckCode redeems the code if codeHash matches it.
+func (r *RedisOneTimeCodes) CheckCode(ctx context.Context, key, codeHash string, maxAttempts int) (*OneTimeCode, error) {
+	res, err := checkCodeScript.Run(ctx, r.client, []string{r.key(key)}, codeHash, maxAttempts).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	target, ok := res.(string)
+	if !ok {
+		return nil, ErrCodeMismatch
+	}
+	return &OneTimeCode{CodeHash: codeHash, Target: target}, nil
+}
+
+func (r *RedisOneTimeCodes) key(key string) string {
+	return r.prefix + ":" + key
+}
@@ -10,57 +10,153 @@ import (
 	"golang.org/x/crypto/argon2"
 )
 
+// Upper bounds for Argon2id parameters, both configured and read from stored hashes, which
+// may have been imported. Memory is in KiB and matches the scrypt cap of 512 MiB.
 const (
-	saltLength  = 16
-	hashLength  = 32
-	iterations  = 3
-	memory      = 64 * 1024
-	parallelism = 2
+	maxArgon2Memory     = 512 << 10
+	maxArgon2Iterations = 64
 )
 
-// HashPassword derives an Argon2id hash for the supplied password.
-func HashPassword(password string) (string, error) {
-	salt := make([]byte, saltLength)
+// Argon2Params are the Argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params are used when no other parameters are configured: 64 MiB, three passes
+// and two lanes.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// PasswordHasher derives Argon2id password hashes with configurable parameters and tells which
+// stored hashes fall short of them.
+type PasswordHasher struct {
+	params Argon2Params
+}
+
+var defaultHasher = &PasswordHasher{params: DefaultArgon2Params}
+
+// NewPasswordHasher validates the parameters.
+func NewPasswordHasher(params Argon2Params) (*PasswordHasher, error) {
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return nil, fmt.Errorf("argon2 iterations and parallelism must be at least 1")
+	}
+	if params.Memory < 8*uint32(params.Parallelism) {
+		return nil, fmt.Errorf("argon2 memory must be at least 8 KiB per lane")
+	}
+	if params.Memory > maxArgon2Memory || params.Iterations > maxArgon2Iterations {
+		return nil, fmt.Errorf("argon2 memory and iterations must be at most %d KiB and %d", maxArgon2Memory, maxArgon2Iterations)
+	}
+	if params.SaltLength < 16 || params.KeyLength < 16 {
+		return nil, fmt.Errorf("argon2 salt and key must be at least 16 bytes")
+	}
+	return &PasswordHasher{params: params}, nil
+}
+
+// Params returns the parameters new hashes are derived with.
+func (h *PasswordHasher) Params() Argon2Params {
+	return h.params
+}
+
+// Hash derives an Argon2id hash for the supplied password.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
 	if _, err := rand.Read(salt); err != nil {
 		return "", fmt.Errorf("generate salt: %w", err)
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, hashLength)
+	hash := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
 
 	encodedSalt := base64.RawStdEncoding.EncodeToString(salt)
 	encodedHash := base64.RawStdEncoding.EncodeToString(hash)
 
-	return fmt.Sprintf("argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", memory, iterations, parallelism, encodedSalt, encodedHash), nil
+	return fmt.Sprintf("argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism, encodedSalt, encodedHash), nil
+}
+
+// NeedsRehash reports whether the encoded hash is weaker than the hasher's parameters: another
+// algorithm or Argon2 version, or less memory, fewer passes or lanes, or a shorter salt or key.
+// Hashes with stronger parameters are left alone.
+func (h *PasswordHasher) NeedsRehash(encodedHash string) bool {
+	decoded, err := decodeArgon2Hash(encodedHash)
+	if err != nil || decoded.version != argon2.Version {
+		return true
+	}
+	stored := decoded.params
+	return stored.Memory < h.params.Memory ||
+		stored.Iterations < h.params.Iterations ||
+		stored.Parallelism < h.params.Parallelism ||
+		stored.SaltLength < h.params.SaltLength ||
+		stored.KeyLength < h.params.KeyLength
+}
+
+// HashPassword derives an Argon2id hash for the supplied password with DefaultArgon2Params.
+func HashPassword(password string) (string, error) {
+	return defaultHasher.Hash(password)
 }
 
-// VerifyPassword compares a password with the encoded hash.
+// VerifyPassword compares a password with the encoded hash, using the algorithm and parameters
+// stored in it. Besides argon2id, hashes imported from older systems are accepted; see
+// parsePasswordHash for the formats.
 func VerifyPassword(password, encodedHash string) (bool, error) {
+	check, err := parsePasswordHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	return check(password)
+}
+
+type argon2Hash struct {
+	version int
+	params  Argon2Params
+	salt    []byte
+	hash    []byte
+}
+
+func (h *argon2Hash) check(password string) (bool, error) {
+	derived := argon2.IDKey([]byte(password), h.salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return subtle.ConstantTimeCompare(h.hash, derived) == 1, nil
+}
+
+// decodeArgon2Hash parses the PHC string format written by Hash.
+func decodeArgon2Hash(encodedHash string) (*argon2Hash, error) {
 	parts := strings.Split(encodedHash, "$")
-	if len(parts) != 5 {
-		return false, fmt.Errorf("invalid hash format")
+	if len(parts) != 5 || parts[0] != "argon2id" {
+		return nil, fmt.Errorf("invalid hash format")
 	}
 
-	params := parts[2]
-	var mem uint32
-	var iter uint32
-	var par uint8
-	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &mem, &iter, &par); err != nil {
-		return false, fmt.Errorf("invalid hash parameters")
+	decoded := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[1], "v=%d", &decoded.version); err != nil {
+		return nil, fmt.Errorf("invalid hash version")
+	}
+	params := &decoded.params
+	if _, err := fmt.Sscanf(parts[2], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, fmt.Errorf("invalid hash parameters")
+	}
+	if params.Iterations < 1 || params.Iterations > maxArgon2Iterations || params.Parallelism < 1 || params.Memory > maxArgon2Memory {
+		return nil, fmt.Errorf("invalid hash parameters")
 	}
 
-	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	var err error
+	decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[3])
 	if err != nil {
-		return false, fmt.Errorf("decode salt: %w", err)
+		return nil, fmt.Errorf("decode salt: %w", err)
 	}
-	hash, err := base64.RawStdEncoding.DecodeString(parts[4])
+	decoded.hash, err = base64.RawStdEncoding.DecodeString(parts[4])
 	if err != nil {
-		return false, fmt.Errorf("decode hash: %w", err)
+		return nil, fmt.Errorf("decode hash: %w", err)
 	}
-
-	derived := argon2.IDKey([]byte(password), salt, iter, mem, uint8(par), uint32(len(hash)))
-
-	if subtle.ConstantTimeCompare(hash, derived) == 1 {
-		return true, nil
+	if len(decoded.hash) == 0 {
+		return nil, fmt.Errorf("invalid hash format")
 	}
-	return false, nil
+	params.SaltLength = uint32(len(decoded.salt))
+	params.KeyLength = uint32(len(decoded.hash))
+	return decoded, nil
 }
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// PasswordBlocklist is an offline set of breached or common passwords. Only the first eight bytes
+// of each password's SHA-1 are kept, in a sorted slice, so a list of ten million passwords takes
+// 80 MB and a lookup is a binary search. The chance of a false match is negligible.
+type PasswordBlocklist struct {
+	prefixes []uint64
+}
+
+// LoadPasswordBlocklist reads a blocklist file with one entry per line: either a plain-text
+// password or its SHA-1 in hex, optionally followed by ":<count>" as in the Have I Been Pwned
+// downloads. Blank lines and lines starting with "#" are skipped.
+func LoadPasswordBlocklist(path string) (*PasswordBlocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPasswordBlocklist(f)
+}
+
+// ReadPasswordBlocklist reads a blocklist in the format described at LoadPasswordBlocklist.
+func ReadPasswordBlocklist(r io.Reader) (*PasswordBlocklist, error) {
+	var prefixes []uint64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		entry := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(entry) == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		prefixes = append(prefixes, blocklistEntryPrefix(entry))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(prefixes)
+	return &PasswordBlocklist{prefixes: slices.Clip(slices.Compact(prefixes))}, nil
+}
+
+// Len returns the number of distinct entries.
+func (b *PasswordBlocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.prefixes)
+}
+
+// Contains reports whether the password, or its lowercase form, is on the list. A nil list
+// contains nothing.
+func (b *PasswordBlocklist) Contains(password string) bool {
+	if b.Len() == 0 {
+		return false
+	}
+	if _, found := slices.BinarySearch(b.prefixes, passwordPrefix(password)); found {
+		return true
+	}
+	if lowered := strings.ToLower(password); lowered != password {
+		_, found := slices.BinarySearch(b.prefixes, passwordPrefix(lowered))
+		return found
+	}
+	return false
+}
+
+// blocklistEntryPrefix treats an entry as a digest when it starts with 40 hex digits, and as a
+// plain-text password otherwise.
+func blocklistEntryPrefix(entry string) uint64 {
+	digest, _, _ := strings.Cut(entry, ":")
+	if len(digest) == 2*sha1.Size {
+		if raw, err := hex.DecodeString(digest); err == nil {
+			return binary.BigEndian.Uint64(raw)
+		}
+	}
+	return passwordPrefix(entry)
+}
+
+func passwordPrefix(password string) uint64 {
+	sum := sha1.Sum([]byte(password))
+	return binary.BigEndian.Uint64(sum[:8])
+}
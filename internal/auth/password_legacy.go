@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Hashes imported from other systems carry their own cost parameters. These caps keep a single
+// login from tying up a CPU or gigabytes of memory.
+const (
+	maxBcryptCost       = 16
+	maxPBKDF2Iterations = 10_000_000
+	maxScryptMemory     = 512 << 20
+)
+
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash format")
+
+// passwordCheck compares a password with one parsed hash.
+type passwordCheck func(password string) (bool, error)
+
+// parsePasswordHash dispatches on the hash prefix. Besides the argon2id format written by
+// PasswordHasher it understands hashes imported from older systems:
+//
+//	$2a$, $2b$, $2y$                         bcrypt
+//	$pbkdf2-sha256$<iter>$<salt>$<hash>      PBKDF2 in passlib's format (also -sha512)
+//	pbkdf2_sha256$<iter>$<salt>$<hash>       PBKDF2 in Django's format
+//	$scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>  scrypt in passlib's format
+func parsePasswordHash(encodedHash string) (passwordCheck, error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "argon2id$"):
+		decoded, err := decodeArgon2Hash(encodedHash)
+		if err != nil {
+			return nil, err
+		}
+		return decoded.check, nil
+	case strings.HasPrefix(encodedHash, "$2a$"), strings.HasPrefix(encodedHash, "$2b$"), strings.HasPrefix(encodedHash, "$2y$"):
+		return parseBcryptHash(encodedHash)
+	case strings.HasPrefix(encodedHash, "$pbkdf2-"):
+		return parsePassLibPBKDF2Hash(encodedHash)
+	case strings.HasPrefix(encodedHash, "pbkdf2_"):
+		return parseDjangoPBKDF2Hash(encodedHash)
+	case strings.HasPrefix(encodedHash, "$scrypt$"):
+		return parseScryptHash(encodedHash)
+	}
+	return nil, ErrUnsupportedPasswordHash
+}
+
+// ValidatePasswordHash checks that an encoded hash, such as one being imported, is in a
+// supported format with acceptable cost parameters.
+func ValidatePasswordHash(encodedHash string) error {
+	_, err := parsePasswordHash(encodedHash)
+	return err
+}
+
+func parseBcryptHash(encodedHash string) (passwordCheck, error) {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if cost > maxBcryptCost {
+		return nil, fmt.Errorf("bcrypt cost %d exceeds %d", cost, maxBcryptCost)
+	}
+	return func(password string) (bool, error) {
+		err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword), errors.Is(err, bcrypt.ErrPasswordTooLong):
+			return false, nil
+		}
+		return false, err
+	}, nil
+}
+
+// parsePassLibPBKDF2Hash reads $pbkdf2-<digest>$<iterations>$<salt>$<hash> with salt and hash
+// in passlib's base64 variant.
+func parsePassLibPBKDF2Hash(encodedHash string) (passwordCheck, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid pbkdf2 hash format")
+	}
+	salt, errSalt := decodePassLibBase64(parts[3])
+	key, errKey := decodePassLibBase64(parts[4])
+	if errSalt != nil || errKey != nil {
+		return nil, fmt.Errorf("invalid pbkdf2 hash encoding")
+	}
+	return pbkdf2Check(strings.TrimPrefix(parts[1], "pbkdf2-"), parts[2], salt, key)
+}
+
+// parseDjangoPBKDF2Hash reads pbkdf2_<digest>$<iterations>$<salt>$<hash> with a plain-text salt
+// and a padded base64 hash.
+func parseDjangoPBKDF2Hash(encodedHash string) (passwordCheck, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid pbkdf2 hash format")
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pbkdf2 hash encoding")
+	}
+	return pbkdf2Check(strings.TrimPrefix(parts[0], "pbkdf2_"), parts[1], []byte(parts[2]), key)
+}
+
+func pbkdf2Check(digest, iterations string, salt, key []byte) (passwordCheck, error) {
+	var newHash func() hash.Hash
+	switch digest {
+	case "sha256":
+		newHash = sha256.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("%w: pbkdf2 with %s", ErrUnsupportedPasswordHash, digest)
+	}
+	iter, err := strconv.Atoi(iterations)
+	if err != nil || iter < 1 || iter > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("invalid pbkdf2 iteration count %q", iterations)
+	}
+	if len(salt) == 0 || len(key) < 16 {
+		return nil, fmt.Errorf("invalid pbkdf2 salt or key length")
+	}
+	return func(password string) (bool, error) {
+		derived, err := pbkdf2.Key(newHash, password, salt, iter, len(key))
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(key, derived) == 1, nil
+	}, nil
+}
+
+// parseScryptHash reads $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash> with salt and hash in
+// passlib's base64 variant.
+func parseScryptHash(encodedHash string) (passwordCheck, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid scrypt hash format")
+	}
+	var logN, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return nil, fmt.Errorf("invalid scrypt hash parameters")
+	}
+	if logN < 1 || logN > 30 || r < 1 || r > 64 || p < 1 || p > 16 || 128*r<<logN > maxScryptMemory {
+		return nil, fmt.Errorf("scrypt parameters ln=%d,r=%d,p=%d out of range", logN, r, p)
+	}
+	salt, errSalt := decodePassLibBase64(parts[3])
+	key, errKey := decodePassLibBase64(parts[4])
+	if errSalt != nil || errKey != nil || len(salt) == 0 || len(key) < 16 {
+		return nil, fmt.Errorf("invalid scrypt hash encoding")
+	}
+	return func(password string) (bool, error) {
+		derived, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(key))
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(key, derived) == 1, nil
+	}, nil
+}
+
+// decodePassLibBase64 decodes passlib's unpadded base64, which writes "." in place of "+".
+func decodePassLibBase64(value string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(strings.TrimRight(value, "="), ".", "+"))
+}
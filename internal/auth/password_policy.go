@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CharacterClass names a kind of character a password may be required to contain.
+type CharacterClass string
+
+const (
+	ClassLower  CharacterClass = "lower"
+	ClassUpper  CharacterClass = "upper"
+	ClassDigit  CharacterClass = "digit"
+	ClassSymbol CharacterClass = "symbol"
+)
+
+// Codes of password policy violations, stable for clients to map to their own messages.
+const (
+	ViolationTooShort     = "too_short"
+	ViolationTooLong      = "too_long"
+	ViolationMissingClass = "missing_class"
+	ViolationPersonalInfo = "contains_personal_info"
+	ViolationBreached     = "breached"
+	// ViolationReused is reported by callers that keep a password history.
+	ViolationReused = "reused"
+)
+
+// minPersonalTokenLength keeps short name or email fragments from rejecting most passwords.
+const minPersonalTokenLength = 3
+
+// PasswordRules configures a PasswordPolicy. Lengths count characters, not bytes.
+type PasswordRules struct {
+	MinLength          int
+	MaxLength          int
+	RequiredClasses    []CharacterClass
+	RejectPersonalInfo bool
+}
+
+// DefaultPasswordRules are used when no other rules are configured.
+var DefaultPasswordRules = PasswordRules{
+	MinLength:          8,
+	MaxLength:          128,
+	RejectPersonalInfo: true,
+}
+
+// PasswordViolation is one rule a password breaks. Class is set for ViolationMissingClass.
+type PasswordViolation struct {
+	Code    string
+	Message string
+	Class   CharacterClass
+}
+
+// PasswordPolicy checks new passwords against length and character class rules, the user's own
+// email and name, and an optional list of breached or common passwords.
+type PasswordPolicy struct {
+	rules     PasswordRules
+	blocklist *PasswordBlocklist
+}
+
+// NewPasswordPolicy validates the rules. blocklist may be nil.
+func NewPasswordPolicy(rules PasswordRules, blocklist *PasswordBlocklist) (*PasswordPolicy, error) {
+	if rules.MinLength < 1 {
+		return nil, fmt.Errorf("password minimum length must be at least 1")
+	}
+	if rules.MaxLength < rules.MinLength {
+		return nil, fmt.Errorf("password maximum length must not be below the minimum")
+	}
+	for _, class := range rules.RequiredClasses {
+		switch class {
+		case ClassLower, ClassUpper, ClassDigit, ClassSymbol:
+		default:
+			return nil, fmt.Errorf("unknown character class %q", class)
+		}
+	}
+	return &PasswordPolicy{rules: rules, blocklist: blocklist}, nil
+}
+
+// Rules returns the policy's rules.
+func (p *PasswordPolicy) Rules() PasswordRules {
+	return p.rules
+}
+
+// Check returns every rule the password breaks, or nil. personal holds the user's email and
+// names; the password may not contain any of them, nor the parts of the email's local part.
+func (p *PasswordPolicy) Check(password string, personal ...string) []PasswordViolation {
+	var violations []PasswordViolation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.rules.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters", p.rules.MinLength),
+		})
+	}
+	if length > p.rules.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    ViolationTooLong,
+			Message: fmt.Sprintf("password must be at most %d characters", p.rules.MaxLength),
+		})
+	}
+
+	for _, class := range p.rules.RequiredClasses {
+		if !strings.ContainsFunc(password, classMatcher(class)) {
+			violations = append(violations, PasswordViolation{
+				Code:    ViolationMissingClass,
+				Message: fmt.Sprintf("password must contain a %s character", classDescription(class)),
+				Class:   class,
+			})
+		}
+	}
+
+	if p.rules.RejectPersonalInfo && containsPersonalInfo(password, personal) {
+		violations = append(violations, PasswordViolation{
+			Code:    ViolationPersonalInfo,
+			Message: "password must not contain your email address or name",
+		})
+	}
+
+	if p.blocklist.Contains(password) {
+		violations = append(violations, PasswordViolation{
+			Code:    ViolationBreached,
+			Message: "password appears in a list of breached or common passwords",
+		})
+	}
+
+	return violations
+}
+
+func classMatcher(class CharacterClass) func(rune) bool {
+	switch class {
+	case ClassLower:
+		return unicode.IsLower
+	case ClassUpper:
+		return unicode.IsUpper
+	case ClassDigit:
+		return unicode.IsDigit
+	}
+	return func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	}
+}
+
+func classDescription(class CharacterClass) string {
+	switch class {
+	case ClassLower:
+		return "lowercase"
+	case ClassUpper:
+		return "uppercase"
+	case ClassDigit:
+		return "digit"
+	}
+	return "symbol"
+}
+
+// containsPersonalInfo compares case-insensitively. Emails are checked whole and by the parts of
+// their local part, so "ana.lim@example.com" rules out "ana", "lim" and "ana.lim".
+func containsPersonalInfo(password string, personal []string) bool {
+	lowered := strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		tokens := []string{value}
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			tokens = append(tokens, local)
+			tokens = append(tokens, strings.FieldsFunc(local, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})...)
+		} else {
+			tokens = append(tokens, strings.Fields(value)...)
+		}
+		for _, token := range tokens {
+			if utf8.RuneCountInString(token) >= minPersonalTokenLength && strings.Contains(lowered, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package auth_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+func violationCodes(violations []auth.PasswordViolation) string {
+	codes := make([]string, 0, len(violations))
+	for _, v := range violations {
+		code := v.Code
+		if v.Class != "" {
+			code += ":" + string(v.Class)
+		}
+		codes = append(codes, code)
+	}
+	return strings.Join(codes, ",")
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy, err := auth.NewPasswordPolicy(auth.PasswordRules{
+		MinLength:          10,
+		MaxLength:          20,
+		RequiredClasses:    []auth.CharacterClass{auth.ClassUpper, auth.ClassDigit, auth.ClassSymbol},
+		RejectPersonalInfo: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	personal := []string{"ana.lim@example.com", "Ana", "Lim"}
+
+	cases := map[string]string{
+		"Tr0ub4dor&3x":            "",
+		"short":                   "too_short,missing_class:upper,missing_class:digit,missing_class:symbol",
+		"Tr0ub4dor&3xTr0ub4dor&3": "too_long",
+		"trustno1-forever":        "missing_class:upper",
+		"ANALIM#2024xyz":          "contains_personal_info",
+		"Ana.Lim@example.com1":    "contains_personal_info",
+		"Ä-Größenwahn9":           "",
+	}
+	for password, want := range cases {
+		if got := violationCodes(policy.Check(password, personal...)); got != want {
+			t.Fatalf("%q: expected violations %q, got %q", password, want, got)
+		}
+	}
+
+	for _, rules := range []auth.PasswordRules{
+		{MinLength: 0, MaxLength: 10},
+		{MinLength: 12, MaxLength: 10},
+		{MinLength: 8, MaxLength: 64, RequiredClasses: []auth.CharacterClass{"emoji"}},
+	} {
+		if _, err := auth.NewPasswordPolicy(rules, nil); err == nil {
+			t.Fatalf("expected rules %+v to be rejected", rules)
+		}
+	}
+}
+
+func TestPasswordBlocklist(t *testing.T) {
+	digest := sha1.Sum([]byte("correcthorse"))
+	list := strings.Join([]string{
+		"# common passwords",
+		"password",
+		"123456",
+		"",
+		strings.ToUpper(hex.EncodeToString(digest[:])) + ":4211",
+		"password",
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatalf("write blocklist: %v", err)
+	}
+
+	blocklist, err := auth.LoadPasswordBlocklist(path)
+	if err != nil {
+		t.Fatalf("load blocklist: %v", err)
+	}
+	if blocklist.Len() != 3 {
+		t.Fatalf("expected 3 distinct entries, got %d", blocklist.Len())
+	}
+	for _, password := range []string{"password", "PassWord", "123456", "correcthorse"} {
+		if !blocklist.Contains(password) {
+			t.Fatalf("expected %q to be blocked", password)
+		}
+	}
+	for _, password := range []string{"password1", "# common passwords", ""} {
+		if blocklist.Contains(password) {
+			t.Fatalf("expected %q to be allowed", password)
+		}
+	}
+
+	policy, err := auth.NewPasswordPolicy(auth.DefaultPasswordRules, blocklist)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	if got := violationCodes(policy.Check("Password")); got != auth.ViolationBreached {
+		t.Fatalf("expected a breached violation, got %q", got)
+	}
+
+	var empty *auth.PasswordBlocklist
+	if empty.Contains("password") || empty.Len() != 0 {
+		t.Fatal("expected a nil blocklist to be empty")
+	}
+}
@@ -1,8 +1,12 @@
 package auth_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
+	"golang.org/x/crypto/bcrypt"
+
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 )
 
@@ -32,3 +36,102 @@ func TestHashAndVerifyPassword(t *testing.T) {
 		t.Fatal("expected mismatch to fail")
 	}
 }
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	weak, err := auth.NewPasswordHasher(auth.Argon2Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatalf("new weak hasher: %v", err)
+	}
+	current, err := auth.NewPasswordHasher(auth.DefaultArgon2Params)
+	if err != nil {
+		t.Fatalf("new hasher: %v", err)
+	}
+
+	weakHash, err := weak.Hash("Sup3rSecret!")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if ok, err := auth.VerifyPassword("Sup3rSecret!", weakHash); err != nil || !ok {
+		t.Fatalf("expected a hash with other parameters to verify: %v", err)
+	}
+	if !current.NeedsRehash(weakHash) {
+		t.Fatal("expected a weaker hash to need rehashing")
+	}
+	if weak.NeedsRehash(weakHash) {
+		t.Fatal("expected a hash matching the parameters to be kept")
+	}
+
+	strongHash, err := current.Hash("Sup3rSecret!")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if current.NeedsRehash(strongHash) || weak.NeedsRehash(strongHash) {
+		t.Fatal("expected hashes at least as strong as the parameters to be kept")
+	}
+
+	oldVersion := strings.Replace(strongHash, "$v=19$", "$v=16$", 1)
+	if !current.NeedsRehash(oldVersion) || !current.NeedsRehash("bcrypt-or-garbage") {
+		t.Fatal("expected other versions and formats to need rehashing")
+	}
+
+	if _, err := auth.NewPasswordHasher(auth.Argon2Params{Memory: 8, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}); err == nil {
+		t.Fatal("expected too little memory per lane to be rejected")
+	}
+	if _, err := auth.NewPasswordHasher(auth.Argon2Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 8, KeyLength: 32}); err == nil {
+		t.Fatal("expected a short salt to be rejected")
+	}
+}
+
+func TestVerifyLegacyPasswordHashes(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("Legacy#Pass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	hashes := map[string]string{
+		"bcrypt":                string(bcryptHash),
+		"bcrypt $2y$":           "$2y$" + string(bcryptHash[4:]),
+		"passlib pbkdf2-sha256": "$pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$3r7gWryFS9MrFVYkt5vWVCFbKEXyHhXhmH7rEAFQ2UA",
+		"passlib pbkdf2-sha512": "$pbkdf2-sha512$1000$MDEyMzQ1Njc4OWFiY2RlZg$AmVhWmyccZS3GsMZv2zISb27Bi4/5YmEj7n7v0p9bzRxjxMfJnfjk6fJxqgMskKNQJvzCU72lTkJKkYoibWU/A",
+		"django pbkdf2_sha256":  "pbkdf2_sha256$1000$monolithsalt$G+pbskLOSj8+NCcR7iQc4v9NmvMx7boCjlrKya4hLQM=",
+		"passlib scrypt":        "$scrypt$ln=10,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$/.xv01FXFXyXZgObMOKMylO/Q48iTDadi8cNN2cq8CQ",
+	}
+	current, err := auth.NewPasswordHasher(auth.DefaultArgon2Params)
+	if err != nil {
+		t.Fatalf("new hasher: %v", err)
+	}
+	for name, hash := range hashes {
+		if err := auth.ValidatePasswordHash(hash); err != nil {
+			t.Fatalf("%s: validate: %v", name, err)
+		}
+		if ok, err := auth.VerifyPassword("Legacy#Pass1", hash); err != nil || !ok {
+			t.Fatalf("%s: expected the password to verify: %v", name, err)
+		}
+		if ok, err := auth.VerifyPassword("legacy#pass1", hash); err != nil || ok {
+			t.Fatalf("%s: expected another password to fail: %v", name, err)
+		}
+		if !current.NeedsRehash(hash) {
+			t.Fatalf("%s: expected a legacy hash to need rehashing", name)
+		}
+	}
+
+	rejected := map[string]string{
+		"unknown algorithm":     "$1$md5crypt$abcdefghijklmnopqrstuv",
+		"pbkdf2 with sha1":      "$pbkdf2$1000$MDEyMzQ1Njc4OWFiY2RlZg$3r7gWryFS9MrFVYkt5vWVCFbKEXyHhXhmH7rEAFQ2UA",
+		"pbkdf2 too costly":     "pbkdf2_sha256$999999999$monolithsalt$G+pbskLOSj8+NCcR7iQc4v9NmvMx7boCjlrKya4hLQM=",
+		"scrypt too costly":     "$scrypt$ln=24,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$/.xv01FXFXyXZgObMOKMylO/Q48iTDadi8cNN2cq8CQ",
+		"bcrypt too costly":     "$2a$31$" + string(bcryptHash[7:]),
+		"argon2id too costly":   "argon2id$v=19$m=99999999,t=3,p=2$MDEyMzQ1Njc4OWFiY2RlZg$3r7gWryFS9MrFVYkt5vWVCFbKEXyHhXhmH7rEAFQ2UA",
+		"argon2id over 512 MiB": "argon2id$v=19$m=1048576,t=1,p=1$MDEyMzQ1Njc4OWFiY2RlZg$3r7gWryFS9MrFVYkt5vWVCFbKEXyHhXhmH7rEAFQ2UA",
+	}
+	for name, hash := range rejected {
+		if err := auth.ValidatePasswordHash(hash); err == nil {
+			t.Fatalf("%s: expected the hash to be rejected", name)
+		}
+		if _, err := auth.VerifyPassword("Legacy#Pass1", hash); err == nil {
+			t.Fatalf("%s: expected verification to fail", name)
+		}
+	}
+	if err := auth.ValidatePasswordHash("$1$md5crypt$abc"); !errors.Is(err, auth.ErrUnsupportedPasswordHash) {
+		t.Fatalf("expected ErrUnsupportedPasswordHash, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimiter counts attempts per key in fixed windows.
+type RateLimiter interface {
+	// Allow records an attempt and reports whether it is within limit attempts per window. When
+	// it is not, the returned duration tells how long until the window resets.
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error)
+}
+
+// RedisRateLimiter keeps attempt counters in Redis.
+type RedisRateLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRateLimiter constructs a Redis-backed rate limiter.
+func NewRedisRateLimiter(client *redis.Client) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client, prefix: "auth:ratelimit"}
+}
+
+// Allow increments the key's counter, starting the window on the first attempt.
+func (l *RedisRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	key = l.prefix + ":" + key
+	pipe := l.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, 0, err
+	}
+	if count.Val() <= int64(limit) {
+		return true, 0, nil
+	}
+	retryAfter := ttl.Val()
+	if retryAfter <= 0 {
+		retryAfter = window
+	}
+	return false, retryAfter, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks values produced by SecretBox.Seal and names the format version.
+const sealedPrefix = "v1:"
+
+var ErrSecretUnreadable = errors.New("sealed secret cannot be opened")
+
+// SecretBox encrypts secrets that must be stored in recoverable form, such as TOTP seeds, with
+// AES-256-GCM.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox takes a 32-byte key.
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret box key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// DecodeSecretBoxKey decodes a standard base64 key, as produced by `openssl rand -base64 32`.
+func DecodeSecretBoxKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode secret box key: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext. context is authenticated but not stored, typically the ID of the row
+// holding the value, so that a sealed value copied to another row no longer opens.
+func (b *SecretBox) Seal(plaintext, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal with the same context.
+func (b *SecretBox) Open(sealed, context string) (string, error) {
+	encoded, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return "", ErrSecretUnreadable
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return "", ErrSecretUnreadable
+	}
+	nonce, ciphertext := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", ErrSecretUnreadable
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value looks like the output of Seal, as opposed to a plaintext value
+// stored before encryption was introduced.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
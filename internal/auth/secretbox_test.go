@@ -0,0 +1,52 @@
+package auth_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+func TestSecretBoxRoundTrip(t *testing.T) {
+	box, err := auth.NewSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("new secret box: %v", err)
+	}
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP", "user-1")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !auth.IsSealed(sealed) || bytes.Contains([]byte(sealed), []byte("JBSWY3DPEHPK3PXP")) {
+		t.Fatalf("expected an opaque sealed value, got %q", sealed)
+	}
+	if again, _ := box.Seal("JBSWY3DPEHPK3PXP", "user-1"); again == sealed {
+		t.Fatal("expected a fresh nonce for every seal")
+	}
+	opened, err := box.Open(sealed, "user-1")
+	if err != nil || opened != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("open: %q %v", opened, err)
+	}
+
+	if _, err := box.Open(sealed, "user-2"); !errors.Is(err, auth.ErrSecretUnreadable) {
+		t.Fatalf("expected a value sealed for another row to be refused, got %v", err)
+	}
+	other, _ := auth.NewSecretBox(bytes.Repeat([]byte{8}, 32))
+	if _, err := other.Open(sealed, "user-1"); !errors.Is(err, auth.ErrSecretUnreadable) {
+		t.Fatalf("expected another key to be refused, got %v", err)
+	}
+	if _, err := box.Open("JBSWY3DPEHPK3PXP", "user-1"); !errors.Is(err, auth.ErrSecretUnreadable) {
+		t.Fatalf("expected a plaintext value to be refused, got %v", err)
+	}
+}
+
+func TestNewSecretBoxRejectsShortKeys(t *testing.T) {
+	key, err := auth.DecodeSecretBoxKey("c2hvcnQ=")
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if _, err := auth.NewSecretBox(key); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+}
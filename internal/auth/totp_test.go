@@ -0,0 +1,62 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// rfc6238Secret is the SHA-1 test key of RFC 6238 appendix B, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := auth.TOTPCode(rfc6238Secret, auth.TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("totp code: %v", err)
+		}
+		if got != want {
+			t.Fatalf("time %d: expected %s got %s", unix, want, got)
+		}
+	}
+}
+
+func TestVerifyTOTPWindowAndReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	previous, _ := auth.TOTPCode(rfc6238Secret, auth.TOTPStep(now)-1)
+	stale, _ := auth.TOTPCode(rfc6238Secret, auth.TOTPStep(now)-2)
+
+	step, ok := auth.VerifyTOTP(rfc6238Secret, previous, now, 0)
+	if !ok || step != auth.TOTPStep(now)-1 {
+		t.Fatalf("expected previous period to be accepted, got %d %v", step, ok)
+	}
+	if _, ok := auth.VerifyTOTP(rfc6238Secret, previous, now, step); ok {
+		t.Fatal("expected a used code to be rejected")
+	}
+	if _, ok := auth.VerifyTOTP(rfc6238Secret, stale, now, 0); ok {
+		t.Fatal("expected codes outside the window to be rejected")
+	}
+}
+
+func TestGenerateTOTPSecretAndURI(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("expected a 160-bit base32 secret, got %q", secret)
+	}
+	uri := auth.TOTPURI("Acme Shop", "ops@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Acme%20Shop:ops@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected otpauth uri %q", uri)
+	}
+}
@@ -0,0 +1,441 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// COSE algorithm identifiers accepted for passkeys, in order of preference.
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// Authenticator data flags.
+const (
+	authFlagUserPresent        = 0x01
+	authFlagUserVerified       = 0x04
+	authFlagAttestedCredential = 0x40
+)
+
+// webAuthnTimeout is the ceremony timeout suggested to the browser.
+const webAuthnTimeout = 5 * time.Minute
+
+var (
+	// ErrWebAuthnInvalid wraps every reason a registration or assertion is rejected.
+	ErrWebAuthnInvalid = errors.New("invalid webauthn response")
+	// ErrWebAuthnCounter is returned when the signature counter did not increase, which
+	// suggests the authenticator was cloned.
+	ErrWebAuthnCounter = errors.New("webauthn signature counter did not increase")
+)
+
+// WebAuthn is a WebAuthn relying party. Passkeys are used for passwordless login, so user
+// verification is required in both ceremonies. Attestation is not requested and attestation
+// statements are not verified.
+type WebAuthn struct {
+	rpID    string
+	rpName  string
+	origins []string
+}
+
+// NewWebAuthn constructs a relying party for rpID, the registrable domain passkeys are scoped to.
+// origins lists the exact origins, such as https://shop.example.com, allowed to run ceremonies.
+func NewWebAuthn(rpID, rpName string, origins []string) (*WebAuthn, error) {
+	if rpID == "" {
+		return nil, errors.New("webauthn relying party id required")
+	}
+	if len(origins) == 0 {
+		return nil, errors.New("webauthn origins required")
+	}
+	if rpName == "" {
+		rpName = rpID
+	}
+	return &WebAuthn{rpID: rpID, rpName: rpName, origins: origins}, nil
+}
+
+// Timeout is how long a ceremony may take.
+func (w *WebAuthn) Timeout() time.Duration {
+	return webAuthnTimeout
+}
+
+// WebAuthnCredential is a registered passkey. PublicKey is the COSE_Key from the authenticator.
+type WebAuthnCredential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// CredentialDescriptor names a credential in ceremony options.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CredentialParameter names an accepted public key algorithm.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialCreationOptions is the publicKey argument of navigator.credentials.create, with
+// binary fields base64url encoded.
+type CredentialCreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// CredentialRequestOptions is the publicKey argument of navigator.credentials.get. An empty
+// AllowCredentials lets the user pick any discoverable passkey for the relying party.
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned by create().
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by get().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// NewWebAuthnChallenge returns a random base64url challenge.
+func NewWebAuthnChallenge() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreationOptions builds the options for registering a passkey. Credentials the user already
+// registered are excluded so the same authenticator is not enrolled twice.
+func (w *WebAuthn) CreationOptions(challenge string, userHandle []byte, name, displayName string, exclude [][]byte) CredentialCreationOptions {
+	var opts CredentialCreationOptions
+	opts.RP.ID = w.rpID
+	opts.RP.Name = w.rpName
+	opts.User.ID = base64.RawURLEncoding.EncodeToString(userHandle)
+	opts.User.Name = name
+	opts.User.DisplayName = displayName
+	opts.Challenge = challenge
+	for _, alg := range []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256} {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	opts.Timeout = webAuthnTimeout.Milliseconds()
+	opts.ExcludeCredentials = credentialDescriptors(exclude)
+	opts.AuthenticatorSelection.ResidentKey = "required"
+	opts.AuthenticatorSelection.UserVerification = "required"
+	opts.Attestation = "none"
+	return opts
+}
+
+// RequestOptions builds the options for signing in with a passkey.
+func (w *WebAuthn) RequestOptions(challenge string, allow [][]byte) CredentialRequestOptions {
+	return CredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          webAuthnTimeout.Milliseconds(),
+		RPID:             w.rpID,
+		AllowCredentials: credentialDescriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// VerifyRegistration checks a create() response against the challenge issued for it and returns
+// the new credential.
+func (w *WebAuthn) VerifyRegistration(challenge string, resp RegistrationResponse) (*WebAuthnCredential, error) {
+	if resp.Type != "public-key" {
+		return nil, invalidWebAuthn("unexpected credential type %q", resp.Type)
+	}
+	if _, err := w.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeBase64URL(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, invalidWebAuthn("attestation object is not base64url")
+	}
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, invalidWebAuthn("attestation object: %v", err)
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, invalidWebAuthn("attestation object is not a map")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, invalidWebAuthn("attestation object has no authData")
+	}
+
+	parsed, err := w.parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.flags&authFlagAttestedCredential == 0 {
+		return nil, invalidWebAuthn("authenticator data has no attested credential")
+	}
+	if _, err := parseCOSEKey(parsed.publicKey); err != nil {
+		return nil, err
+	}
+	if id, err := decodeBase64URL(resp.ID); err != nil || !bytes.Equal(id, parsed.credentialID) {
+		return nil, invalidWebAuthn("credential id does not match authenticator data")
+	}
+
+	return &WebAuthnCredential{
+		ID:        parsed.credentialID,
+		PublicKey: parsed.publicKey,
+		SignCount: parsed.signCount,
+		AAGUID:    parsed.aaguid,
+	}, nil
+}
+
+// VerifyAssertion checks a get() response signed by credential and returns the authenticator's
+// new signature counter. Authenticators that do not implement a counter always report zero.
+func (w *WebAuthn) VerifyAssertion(challenge string, credential WebAuthnCredential, resp AssertionResponse) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, invalidWebAuthn("unexpected credential type %q", resp.Type)
+	}
+	clientData, err := w.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+	authData, err := decodeBase64URL(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, invalidWebAuthn("authenticator data is not base64url")
+	}
+	parsed, err := w.parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+	signature, err := decodeBase64URL(resp.Response.Signature)
+	if err != nil {
+		return 0, invalidWebAuthn("signature is not base64url")
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return 0, err
+	}
+
+	if (parsed.signCount != 0 || credential.SignCount != 0) && parsed.signCount <= credential.SignCount {
+		return 0, ErrWebAuthnCounter
+	}
+	return parsed.signCount, nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin and returns the raw JSON,
+// which is signed over in assertions.
+func (w *WebAuthn) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, invalidWebAuthn("client data is not base64url")
+	}
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, invalidWebAuthn("client data is not JSON")
+	}
+	if clientData.Type != ceremony {
+		return nil, invalidWebAuthn("unexpected ceremony %q", clientData.Type)
+	}
+	if challenge == "" || strings.TrimRight(clientData.Challenge, "=") != challenge {
+		return nil, invalidWebAuthn("challenge mismatch")
+	}
+	for _, origin := range w.origins {
+		if clientData.Origin == origin {
+			return raw, nil
+		}
+	}
+	return nil, invalidWebAuthn("origin %q not allowed", clientData.Origin)
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData decodes authenticator data and checks the relying party ID hash and
+// the user presence and verification flags.
+func (w *WebAuthn) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, invalidWebAuthn("authenticator data too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(w.rpID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, invalidWebAuthn("relying party id mismatch")
+	}
+	parsed := &authenticatorData{flags: data[32], signCount: binary.BigEndian.Uint32(data[33:37])}
+	if parsed.flags&authFlagUserPresent == 0 {
+		return nil, invalidWebAuthn("user not present")
+	}
+	if parsed.flags&authFlagUserVerified == 0 {
+		return nil, invalidWebAuthn("user not verified")
+	}
+
+	if parsed.flags&authFlagAttestedCredential != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, invalidWebAuthn("attested credential data too short")
+		}
+		parsed.aaguid = append([]byte(nil), rest[:16]...)
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, invalidWebAuthn("credential id truncated")
+		}
+		parsed.credentialID = append([]byte(nil), rest[:idLen]...)
+		rest = rest[idLen:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, invalidWebAuthn("credential public key: %v", err)
+		}
+		parsed.publicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+	}
+	return parsed, nil
+}
+
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey decodes an EC2 P-256, OKP Ed25519 or RSA COSE_Key.
+func parseCOSEKey(raw []byte) (*coseKey, error) {
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, invalidWebAuthn("public key: %v", err)
+	}
+	fields, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, invalidWebAuthn("public key is not a COSE key")
+	}
+	kty, _ := fields[int64(1)].(int64)
+	alg, _ := fields[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		y, _ := fields[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, invalidWebAuthn("unsupported EC2 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, invalidWebAuthn("EC2 point not on curve")
+		}
+		return &coseKey{alg: alg, key: pub}, nil
+	case kty == 1 && alg == COSEAlgEdDSA:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, invalidWebAuthn("unsupported OKP key")
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == COSEAlgRS256:
+		n, _ := fields[int64(-1)].([]byte)
+		e, _ := fields[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, invalidWebAuthn("unsupported RSA key")
+		}
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	default:
+		return nil, invalidWebAuthn("unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+func (k *coseKey) verify(message, signature []byte) error {
+	digest := sha256.Sum256(message)
+	switch pub := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(pub, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(pub, message, signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+	return invalidWebAuthn("signature mismatch")
+}
+
+func credentialDescriptors(ids [][]byte) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		descriptors = append(descriptors, CredentialDescriptor{Type: "public-key", ID: base64.RawURLEncoding.EncodeToString(id)})
+	}
+	return descriptors
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers differ.
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func invalidWebAuthn(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrWebAuthnInvalid, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WebAuthnCeremony is the server-side state of a registration or login in progress. UserID is
+// empty for logins that let the user pick a discoverable passkey.
+type WebAuthnCeremony struct {
+	Challenge string `json:"challenge"`
+	UserID    string `json:"userId,omitempty"`
+}
+
+// WebAuthnCeremonies keeps ceremony state between the begin and finish requests. Each ceremony
+// can be finished at most once.
+type WebAuthnCeremonies interface {
+	SaveCeremony(ctx context.Context, id string, ceremony WebAuthnCeremony, ttl time.Duration) error
+	TakeCeremony(ctx context.Context, id string) (*WebAuthnCeremony, error)
+}
+
+var ErrCeremonyNotFound = errors.New("webauthn ceremony not found")
+
+// RedisWebAuthnCeremonies stores ceremonies in Redis.
+type RedisWebAuthnCeremonies struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisWebAuthnCeremonies constructs a Redis-backed ceremony store.
+func NewRedisWebAuthnCeremonies(client *redis.Client) *RedisWebAuthnCeremonies {
+	return &RedisWebAuthnCeremonies{client: client, prefix: "auth:webauthn"}
+}
+
+// SaveCeremony stores the ceremony with the given TTL.
+func (r *RedisWebAuthnCeremonies) SaveCeremony(ctx context.Context, id string, ceremony WebAuthnCeremony, ttl time.Duration) error {
+	body, err := json.Marshal(ceremony)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.key(id), body, ttl).Err()
+}
+
+// TakeCeremony atomically fetches and deletes the ceremony.
+func (r *RedisWebAuthnCeremonies) TakeCeremony(ctx context.Context, id string) (*WebAuthnCeremony, error) {
+	body, err := r.client.GetDel(ctx, r.key(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCeremonyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ceremony WebAuthnCeremony
+	if err := json.Unmarshal(body, &ceremony); err != nil {
+		return nil, err
+	}
+	return &ceremony, nil
+}
+
+func (r *RedisWebAuthnCeremonies) key(id string) string {
+	return r.prefix + ":" + id
+}
@@ -0,0 +1,251 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+const (
+	testRPID   = "shop.example.com"
+	testOrigin = "https://shop.example.com"
+)
+
+func TestWebAuthnRegistrationAndAssertion(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	for name, authenticator := range map[string]*softAuthenticator{
+		"es256": newSoftAuthenticator(t, testOrigin, false),
+		"eddsa": newSoftAuthenticator(t, testOrigin, true),
+	} {
+		t.Run(name, func(t *testing.T) {
+			challenge := mustChallenge(t)
+			credential, err := rp.VerifyRegistration(challenge, authenticator.register(t, challenge, 0x45))
+			if err != nil {
+				t.Fatalf("verify registration: %v", err)
+			}
+
+			challenge = mustChallenge(t)
+			authenticator.counter = 7
+			counter, err := rp.VerifyAssertion(challenge, *credential, authenticator.assert(t, challenge, 0x05))
+			if err != nil {
+				t.Fatalf("verify assertion: %v", err)
+			}
+			if counter != 7 {
+				t.Fatalf("expected counter 7, got %d", counter)
+			}
+
+			credential.SignCount = counter
+			challenge = mustChallenge(t)
+			if _, err := rp.VerifyAssertion(challenge, *credential, authenticator.assert(t, challenge, 0x05)); !errors.Is(err, auth.ErrWebAuthnCounter) {
+				t.Fatalf("expected a repeated counter to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRejectsTamperedResponses(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftAuthenticator(t, testOrigin, false)
+	challenge := mustChallenge(t)
+	credential, err := rp.VerifyRegistration(challenge, authenticator.register(t, challenge, 0x45))
+	if err != nil {
+		t.Fatalf("verify registration: %v", err)
+	}
+
+	if _, err := rp.VerifyRegistration(mustChallenge(t), authenticator.register(t, challenge, 0x45)); !errors.Is(err, auth.ErrWebAuthnInvalid) {
+		t.Fatalf("expected challenge mismatch to be rejected, got %v", err)
+	}
+	if _, err := rp.VerifyRegistration(challenge, authenticator.register(t, challenge, 0x41)); !errors.Is(err, auth.ErrWebAuthnInvalid) {
+		t.Fatalf("expected missing user verification to be rejected, got %v", err)
+	}
+
+	phishing := newSoftAuthenticator(t, "https://shop.example.com.evil.test", false)
+	phishing.key, phishing.id = authenticator.key, authenticator.id
+	if _, err := rp.VerifyAssertion(challenge, *credential, phishing.assert(t, challenge, 0x05)); !errors.Is(err, auth.ErrWebAuthnInvalid) {
+		t.Fatalf("expected foreign origin to be rejected, got %v", err)
+	}
+
+	forged := authenticator.assert(t, challenge, 0x05)
+	forged.Response.Signature = base64.RawURLEncoding.EncodeToString([]byte("not a signature"))
+	if _, err := rp.VerifyAssertion(challenge, *credential, forged); !errors.Is(err, auth.ErrWebAuthnInvalid) {
+		t.Fatalf("expected forged signature to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnCreationOptions(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	opts := rp.CreationOptions("challenge", []byte{1, 2, 3}, "user@example.com", "User", [][]byte{{9}})
+	if opts.RP.ID != testRPID || opts.User.ID != "AQID" || opts.Attestation != "none" {
+		t.Fatalf("unexpected creation options %+v", opts)
+	}
+	if len(opts.ExcludeCredentials) != 1 || opts.ExcludeCredentials[0].ID != "CQ" {
+		t.Fatalf("expected existing credentials to be excluded, got %+v", opts.ExcludeCredentials)
+	}
+}
+
+func newTestRelyingParty(t *testing.T) *auth.WebAuthn {
+	t.Helper()
+	rp, err := auth.NewWebAuthn(testRPID, "Shop", []string{testOrigin})
+	if err != nil {
+		t.Fatalf("new relying party: %v", err)
+	}
+	return rp
+}
+
+func mustChallenge(t *testing.T) string {
+	t.Helper()
+	challenge, err := auth.NewWebAuthnChallenge()
+	if err != nil {
+		t.Fatalf("new challenge: %v", err)
+	}
+	return challenge
+}
+
+// softAuthenticator is a software passkey producing "none" attestations and signed assertions.
+type softAuthenticator struct {
+	origin  string
+	key     crypto.Signer
+	id      []byte
+	counter uint32
+}
+
+func newSoftAuthenticator(t *testing.T, origin string, eddsa bool) *softAuthenticator {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	if eddsa {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &softAuthenticator{origin: origin, key: key, id: id}
+}
+
+func (a *softAuthenticator) register(t *testing.T, challenge string, flags byte) auth.RegistrationResponse {
+	t.Helper()
+	authData := a.authData(flags)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, a.coseKey()...)
+
+	var resp auth.RegistrationResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(a.id)
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = a.clientData(t, "webauthn.create", challenge)
+	resp.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(cborEncode(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", authData},
+	}))
+	return resp
+}
+
+func (a *softAuthenticator) assert(t *testing.T, challenge string, flags byte) auth.AssertionResponse {
+	t.Helper()
+	authData := a.authData(flags)
+	clientData := a.clientData(t, "webauthn.get", challenge)
+	raw, _ := base64.RawURLEncoding.DecodeString(clientData)
+	hash := sha256.Sum256(raw)
+	signed := append(append([]byte(nil), authData...), hash[:]...)
+
+	var signature []byte
+	var err error
+	if _, ok := a.key.(ed25519.PrivateKey); ok {
+		signature, err = a.key.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, err = a.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+
+	var resp auth.AssertionResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(a.id)
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	resp.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	return resp
+}
+
+func (a *softAuthenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.counter)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) string {
+	t.Helper()
+	raw, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	if err != nil {
+		t.Fatalf("marshal client data: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	switch pub := a.key.Public().(type) {
+	case ed25519.PublicKey:
+		return cborEncode(cborMap{{1, 1}, {3, auth.COSEAlgEdDSA}, {-1, 6}, {-2, []byte(pub)}})
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return cborEncode(cborMap{{1, 2}, {3, auth.COSEAlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+	}
+	return nil
+}
+
+// cborMap keeps key order so encodings are deterministic.
+type cborMap [][2]any
+
+func cborEncode(value any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case cborMap:
+		out := head(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, cborEncode(pair[0])...)
+			out = append(out, cborEncode(pair[1])...)
+		}
+		return out
+	}
+	panic("cbor: unsupported test value")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,6 +17,46 @@ type Config struct {
 	RedisAddr         string
 	JWTPrivateKeyPath string
 	JWTPublicKeyPath  string
+	JWTKeysDir        string
+	JWTActiveKeyID    string
+	OAuthClientsFile  string
+	OIDCIssuerURL     string
+	MFAIssuer         string
+	MFARequiredRoles  []string
+	MFASecretKey      string
+	WebAuthnRPID      string
+	WebAuthnRPName    string
+	WebAuthnOrigins   []string
+	VerifiedEmailOnly bool
+	MailDriver        string
+	MailFrom          string
+	MailFileDir       string
+	MailLinkBaseURL   string
+	MailLocale        string
+	MailSecretKey     string
+	SMTPHost          string
+	SMTPPort          int
+	SMTPUsername      string
+	SMTPPassword      string
+	SMSDriver         string
+	PhoneLogin        bool
+	MagicLinkLogin    bool
+	IdPConfigFile     string
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Threads     int
+	PasswordMinLength int
+	PasswordMaxLength int
+	PasswordClasses   []string
+	PasswordPersonal  bool
+	PasswordBlocklist string
+	PasswordHistory   int
+	LoginFreeAttempts int
+	LoginLockoutAfter int
+	LoginIPLockout    int
+	LoginCooldown     time.Duration
+	KafkaBrokers      []string
+	EventsTopic       string
 }
 
 func Load() (*Config, error) {
@@ -25,6 +66,46 @@ func Load() (*Config, error) {
 		RedisAddr:         getEnv("REDIS_ADDR", "localhost:6379"),
 		JWTPrivateKeyPath: os.Getenv("JWT_PRIVATE_KEY_PATH"),
 		JWTPublicKeyPath:  os.Getenv("JWT_PUBLIC_KEY_PATH"),
+		JWTKeysDir:        os.Getenv("JWT_KEYS_DIR"),
+		JWTActiveKeyID:    os.Getenv("JWT_ACTIVE_KID"),
+		OAuthClientsFile:  os.Getenv("OAUTH_CLIENTS_FILE"),
+		OIDCIssuerURL:     getEnv("OIDC_ISSUER_URL", "svc-user"),
+		MFAIssuer:         getEnv("MFA_ISSUER", "Scalable Ecommerce"),
+		MFARequiredRoles:  getListEnv("MFA_REQUIRED_ROLES"),
+		MFASecretKey:      os.Getenv("MFA_SECRET_KEY"),
+		WebAuthnRPID:      os.Getenv("WEBAUTHN_RP_ID"),
+		WebAuthnRPName:    getEnv("WEBAUTHN_RP_NAME", "Scalable Ecommerce"),
+		WebAuthnOrigins:   getListEnv("WEBAUTHN_ORIGINS"),
+		VerifiedEmailOnly: getBoolEnv("REQUIRE_VERIFIED_EMAIL", false),
+		MailDriver:        os.Getenv("MAIL_DRIVER"),
+		MailFrom:          getEnv("MAIL_FROM", "Scalable Ecommerce <no-reply@localhost>"),
+		MailFileDir:       getEnv("MAIL_FILE_DIR", "mail-outbox"),
+		MailLinkBaseURL:   os.Getenv("MAIL_LINK_BASE_URL"),
+		MailLocale:        getEnv("MAIL_LOCALE", "en"),
+		MailSecretKey:     os.Getenv("MAIL_SECRET_KEY"),
+		SMTPHost:          os.Getenv("SMTP_HOST"),
+		SMTPPort:          getIntEnv("SMTP_PORT", 587),
+		SMTPUsername:      os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:      os.Getenv("SMTP_PASSWORD"),
+		SMSDriver:         os.Getenv("SMS_DRIVER"),
+		PhoneLogin:        getBoolEnv("PHONE_LOGIN", false),
+		MagicLinkLogin:    getBoolEnv("MAGIC_LINK_LOGIN", false),
+		IdPConfigFile:     os.Getenv("IDENTITY_PROVIDERS_FILE"),
+		Argon2Memory:      getIntEnv("ARGON2_MEMORY_KIB", 64*1024),
+		Argon2Iterations:  getIntEnv("ARGON2_ITERATIONS", 3),
+		Argon2Threads:     getIntEnv("ARGON2_PARALLELISM", 2),
+		PasswordMinLength: getIntEnv("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength: getIntEnv("PASSWORD_MAX_LENGTH", 128),
+		PasswordClasses:   getListEnv("PASSWORD_REQUIRED_CLASSES"),
+		PasswordPersonal:  getBoolEnv("PASSWORD_REJECT_PERSONAL_INFO", true),
+		PasswordBlocklist: os.Getenv("PASSWORD_BLOCKLIST_FILE"),
+		PasswordHistory:   getIntEnv("PASSWORD_HISTORY_SIZE", 4),
+		LoginFreeAttempts: getIntEnv("LOGIN_BACKOFF_AFTER", 3),
+		LoginLockoutAfter: getIntEnv("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LoginIPLockout:    getIntEnv("LOGIN_IP_LOCKOUT_THRESHOLD", 100),
+		LoginCooldown:     getDurationEnv("LOGIN_LOCKOUT_SECONDS", 15*time.Minute),
+		KafkaBrokers:      getListEnv("KAFKA_BROKERS"),
+		EventsTopic:       getEnv("KAFKA_EVENTS_TOPIC", "user.events"),
 		ReadTimeout:       getDurationEnv("HTTP_READ_TIMEOUT_SECONDS", 15*time.Second),
 		WriteTimeout:      getDurationEnv("HTTP_WRITE_TIMEOUT_SECONDS", 15*time.Second),
 		GracefulTimeout:   getDurationEnv("HTTP_GRACEFUL_TIMEOUT_SECONDS", 10*time.Second),
@@ -33,6 +114,22 @@ func Load() (*Config, error) {
 	if cfg.DatabaseURL == "" {
 		return nil, fmt.Errorf("DB_DSN environment variable must be set")
 	}
+	if len(cfg.MFARequiredRoles) > 0 && cfg.MFASecretKey == "" {
+		return nil, fmt.Errorf("MFA_SECRET_KEY must be set when MFA_REQUIRED_ROLES is")
+	}
+	if cfg.MailDriver != "" && cfg.MailSecretKey == "" {
+		return nil, fmt.Errorf("MAIL_SECRET_KEY must be set when MAIL_DRIVER is")
+	}
+	if cfg.Argon2Memory <= 0 || cfg.Argon2Iterations <= 0 || cfg.Argon2Threads <= 0 || cfg.Argon2Threads > 255 {
+		return nil, fmt.Errorf("ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM must be positive, with at most 255 lanes")
+	}
+
+	if cfg.PasswordHistory < 0 {
+		return nil, fmt.Errorf("PASSWORD_HISTORY_SIZE must not be negative")
+	}
+	if cfg.LoginFreeAttempts < 0 || cfg.LoginLockoutAfter <= cfg.LoginFreeAttempts || cfg.LoginIPLockout <= 0 || cfg.LoginCooldown <= 0 {
+		return nil, fmt.Errorf("LOGIN_LOCKOUT_THRESHOLD must exceed LOGIN_BACKOFF_AFTER, and LOGIN_IP_LOCKOUT_THRESHOLD and LOGIN_LOCKOUT_SECONDS must be positive")
+	}
 
 	return cfg, nil
 }
@@ -53,3 +150,33 @@ func getDurationEnv(key string, fallback time.Duration) time.Duration {
 	}
 	return fallback
 }
+
+func getIntEnv(key string, fallback int) int {
+	if val := os.Getenv(key); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+func getBoolEnv(key string, fallback bool) bool {
+	if val := os.Getenv(key); val != "" {
+		parsed, err := strconv.ParseBool(val)
+		if err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+func getListEnv(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
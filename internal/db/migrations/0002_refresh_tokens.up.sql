@@ -0,0 +1,2 @@
+CREATE UNIQUE INDEX idx_refresh_tokens_hash ON refresh_tokens (token_hash);
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens (user_id);
@@ -0,0 +1,9 @@
+ALTER TABLE refresh_tokens
+  ADD COLUMN family_id UUID,
+  ADD COLUMN parent_id UUID REFERENCES refresh_tokens(id) ON DELETE SET NULL;
+
+UPDATE refresh_tokens SET family_id = id WHERE family_id IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_id);
@@ -0,0 +1,10 @@
+CREATE TABLE oauth_clients (
+  id            TEXT PRIMARY KEY,
+  name          TEXT NOT NULL,
+  secret_hash   TEXT,
+  redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+  scopes        TEXT[] NOT NULL DEFAULT '{}',
+  grant_types   TEXT[] NOT NULL DEFAULT '{}',
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
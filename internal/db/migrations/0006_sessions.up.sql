@@ -0,0 +1,25 @@
+CREATE TABLE sessions (
+  id                UUID PRIMARY KEY,
+  user_id           UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  user_agent        TEXT,
+  ip                INET,
+  created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_refreshed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at        TIMESTAMPTZ NOT NULL,
+  revoked_at        TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+
+-- Every refresh token family that is still alive becomes a session.
+INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_refreshed_at, expires_at)
+SELECT family_id,
+       user_id,
+       (array_agg(user_agent ORDER BY issued_at))[1],
+       (array_agg(ip ORDER BY issued_at))[1],
+       min(issued_at),
+       max(issued_at),
+       max(expires_at)
+FROM refresh_tokens
+GROUP BY family_id, user_id
+HAVING bool_or(NOT revoked AND expires_at > now());
@@ -0,0 +1,16 @@
+CREATE TABLE user_mfa (
+  user_id        UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  totp_secret    TEXT NOT NULL,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  confirmed_at   TIMESTAMPTZ,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE mfa_recovery_codes (
+  id        UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id   UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  used_at   TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_mfa_recovery_codes_hash ON mfa_recovery_codes (user_id, code_hash);
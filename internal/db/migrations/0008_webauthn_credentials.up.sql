@@ -0,0 +1,12 @@
+CREATE TABLE webauthn_credentials (
+  id           BYTEA PRIMARY KEY,
+  user_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name         TEXT NOT NULL,
+  public_key   BYTEA NOT NULL,
+  sign_count   BIGINT NOT NULL DEFAULT 0,
+  aaguid       BYTEA,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_webauthn_credentials_user ON webauthn_credentials (user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE user_tokens (
+  id         UUID PRIMARY KEY,
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  purpose    TEXT NOT NULL,
+  token_hash TEXT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at    TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX idx_user_tokens_hash ON user_tokens (token_hash);
+CREATE INDEX idx_user_tokens_user ON user_tokens (user_id, purpose);
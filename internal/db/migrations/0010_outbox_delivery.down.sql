@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_outbox_pending;
+
+ALTER TABLE outbox
+  DROP COLUMN IF EXISTS available_at,
+  DROP COLUMN IF EXISTS last_error,
+  DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,6 @@
+ALTER TABLE outbox
+  ADD COLUMN attempts     INT NOT NULL DEFAULT 0,
+  ADD COLUMN last_error   TEXT,
+  ADD COLUMN available_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX idx_outbox_pending ON outbox (type, available_at) WHERE NOT processed;
@@ -0,0 +1,16 @@
+CREATE TABLE email_changes (
+  id                 UUID PRIMARY KEY,
+  user_id            UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  old_email          CITEXT NOT NULL,
+  new_email          CITEXT NOT NULL,
+  confirm_token_hash TEXT NOT NULL,
+  cancel_token_hash  TEXT NOT NULL,
+  expires_at         TIMESTAMPTZ NOT NULL,
+  confirmed_at       TIMESTAMPTZ,
+  cancelled_at       TIMESTAMPTZ,
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX idx_email_changes_confirm ON email_changes (confirm_token_hash);
+CREATE UNIQUE INDEX idx_email_changes_cancel ON email_changes (cancel_token_hash);
+CREATE INDEX idx_email_changes_user ON email_changes (user_id);
@@ -0,0 +1 @@
+ALTER TABLE user_tokens ADD COLUMN binding_hash TEXT;
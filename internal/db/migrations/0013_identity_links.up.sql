@@ -0,0 +1,12 @@
+CREATE TABLE identity_links (
+  id            UUID PRIMARY KEY,
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider      TEXT NOT NULL,
+  subject       TEXT NOT NULL,
+  email         CITEXT,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_login_at TIMESTAMPTZ,
+  UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_identity_links_user ON identity_links (user_id);
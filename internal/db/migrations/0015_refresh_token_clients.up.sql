@@ -0,0 +1,2 @@
+-- OAuth clients may only refresh the token families issued to them.
+ALTER TABLE refresh_tokens ADD COLUMN client_id TEXT;
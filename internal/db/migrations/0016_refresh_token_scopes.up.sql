@@ -0,0 +1,2 @@
+-- Refreshed OAuth access tokens keep the scope granted with the original code.
+ALTER TABLE refresh_tokens ADD COLUMN scope TEXT;
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Request}}
+<h1>Sign in to {{.ClientName}}</h1>
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label>
+<label>Password <input type="password" name="password" required></label>
+{{if .AskCode}}<label>Authentication code <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" required></label>
+{{end}}<button type="submit">Sign in</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// csrfCookie holds the token the sign-in form must echo back, so that another site cannot post
+// credentials to the form on a user's behalf.
+const (
+	csrfCookie    = "oauth_csrf"
+	csrfCookieTTL = time.Hour
+)
+
+type authorizePageData struct {
+	Request    *oauth.AuthorizationRequest
+	ClientName string
+	Email      string
+	Error      string
+	AskCode    bool
+	CSRFToken  string
+}
+
+// authorizeForm validates the authorization request and renders the sign-in form.
+func (h *OAuthHandler) authorizeForm(c *fiber.Ctx) error {
+	req := authorizationRequest(c.Query)
+	client, err := h.svc.ValidateAuthorization(c.Context(), req)
+	if err != nil {
+		return h.authorizeError(c, req, err)
+	}
+	return renderSignInForm(c, fiber.StatusOK, authorizePageData{Request: &req, ClientName: clientName(client)})
+}
+
+// authorize checks the submitted credentials and redirects back to the client with a code.
+func (h *OAuthHandler) authorize(c *fiber.Ctx) error {
+	req := authorizationRequest(c.FormValue)
+	email := strings.TrimSpace(c.FormValue("email"))
+	if !validCSRFToken(c) {
+		return h.renderSignIn(c, fiber.StatusForbidden, req, authorizePageData{Email: email, Error: "The sign-in form expired. Please try again."})
+	}
+
+	location, err := h.svc.Authorize(c.Context(), req, users.AuthenticateRequest{
+		Email:    email,
+		Password: c.FormValue("password"),
+		MFACode:  strings.TrimSpace(c.FormValue("code")),
+		Client:   clientInfo(c),
+	})
+	switch {
+	case err == nil:
+		return c.Redirect(location, fiber.StatusFound)
+	case errors.Is(err, users.ErrInvalidCredentials):
+		return h.retrySignIn(c, req, authorizePageData{Email: email, Error: "Invalid email or password."})
+	case errors.Is(err, users.ErrMFARequired):
+		return h.retrySignIn(c, req, authorizePageData{Email: email, Error: "Enter the code from your authenticator app.", AskCode: true})
+	case errors.Is(err, users.ErrMFAInvalidCode):
+		return h.retrySignIn(c, req, authorizePageData{Email: email, Error: "Invalid authentication code.", AskCode: true})
+	case errors.Is(err, users.ErrMFAEnrollmentRequired):
+		return c.Redirect(oauth.ErrorRedirect(req, &oauth.Error{Code: oauth.ErrorAccessDenied, Description: "two-factor authentication must be set up first"}), fiber.StatusFound)
+	case errors.Is(err, users.ErrUserDisabled):
+		return c.Redirect(oauth.ErrorRedirect(req, &oauth.Error{Code: oauth.ErrorAccessDenied, Description: "user is disabled"}), fiber.StatusFound)
+	case errors.Is(err, users.ErrEmailNotVerified):
+		return h.retrySignIn(c, req, authorizePageData{Email: email, Error: "Verify your email address before signing in."})
+	case errors.Is(err, users.ErrRateLimited):
+		setRetryAfter(c, err)
+		return h.renderSignIn(c, fiber.StatusTooManyRequests, req, authorizePageData{Email: email, Error: "Too many failed sign-in attempts. Try again later."})
+	default:
+		return h.authorizeError(c, req, err)
+	}
+}
+
+// retrySignIn renders the sign-in form again with an error for the user.
+func (h *OAuthHandler) retrySignIn(c *fiber.Ctx, req oauth.AuthorizationRequest, data authorizePageData) error {
+	return h.renderSignIn(c, fiber.StatusUnauthorized, req, data)
+}
+
+// renderSignIn renders the sign-in form for req with the given status.
+func (h *OAuthHandler) renderSignIn(c *fiber.Ctx, status int, req oauth.AuthorizationRequest, data authorizePageData) error {
+	client, err := h.svc.ValidateAuthorization(c.Context(), req)
+	if err != nil {
+		return h.authorizeError(c, req, err)
+	}
+	data.Request = &req
+	data.ClientName = clientName(client)
+	return renderSignInForm(c, status, data)
+}
+
+// renderSignInForm renders the sign-in form with the CSRF token of the browser, issuing one in a
+// cookie first if it has none.
+func renderSignInForm(c *fiber.Ctx, status int, data authorizePageData) error {
+	token := c.Cookies(csrfCookie)
+	if token == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		token = base64.RawURLEncoding.EncodeToString(buf)
+		c.Cookie(&fiber.Cookie{
+			Name:     csrfCookie,
+			Value:    token,
+			Path:     "/oauth/authorize",
+			MaxAge:   int(csrfCookieTTL.Seconds()),
+			Secure:   c.Protocol() == "https",
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+	data.CSRFToken = token
+	return renderAuthorizePage(c, status, data)
+}
+
+// validCSRFToken reports whether the posted form carries the token from the browser's cookie.
+func validCSRFToken(c *fiber.Ctx) bool {
+	cookie, form := c.Cookies(csrfCookie), c.FormValue("csrf_token")
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(form)) == 1
+}
+
+// authorizeError reports problems with the client or redirect URI to the user and everything
+// else to the client through its redirect URI, as required by RFC 6749 section 4.1.2.1.
+func (h *OAuthHandler) authorizeError(c *fiber.Ctx, req oauth.AuthorizationRequest, err error) error {
+	var protoErr *oauth.Error
+	switch {
+	case errors.As(err, &protoErr):
+		return c.Redirect(oauth.ErrorRedirect(req, protoErr), fiber.StatusFound)
+	case errors.Is(err, oauth.ErrInvalidClient):
+		return renderAuthorizePage(c, fiber.StatusBadRequest, authorizePageData{Error: "Unknown client."})
+	case errors.Is(err, oauth.ErrInvalidRedirectURI):
+		return renderAuthorizePage(c, fiber.StatusBadRequest, authorizePageData{Error: "The redirect URI is not registered for this client."})
+	default:
+		return renderAuthorizePage(c, fiber.StatusInternalServerError, authorizePageData{Error: "Sign-in is temporarily unavailable."})
+	}
+}
+
+func renderAuthorizePage(c *fiber.Ctx, status int, data authorizePageData) error {
+	var body strings.Builder
+	if err := authorizePage.Execute(&body, data); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Status(status).SendString(body.String())
+}
+
+func authorizationRequest(param func(key string, defaultValue ...string) string) oauth.AuthorizationRequest {
+	return oauth.AuthorizationRequest{
+		ResponseType:        param("response_type"),
+		ClientID:            param("client_id"),
+		RedirectURI:         param("redirect_uri"),
+		Scope:               param("scope"),
+		State:               param("state"),
+		Nonce:               param("nonce"),
+		CodeChallenge:       param("code_challenge"),
+		CodeChallengeMethod: param("code_challenge_method"),
+	}
+}
+
+func clientName(client *oauth.Client) string {
+	if client.Name != "" {
+		return client.Name
+	}
+	return client.ID
+}
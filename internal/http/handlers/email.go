@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type changeEmailRequest struct {
+	NewEmail        string `json:"newEmail"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type emailChangeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+func (h *UserHandler) requestEmailChange(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot change the email")
+	}
+
+	var req changeEmailRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	err := h.svc.RequestEmailChange(c.Context(), middleware.UserID(c), req.NewEmail, req.CurrentPassword)
+	switch {
+	case err == nil:
+		return response.Accepted(c, "confirm the change from the link sent to the new address", nil)
+	case errors.Is(err, users.ErrInvalidCredentials):
+		return response.Unauthorized(c, "current password incorrect")
+	case errors.Is(err, users.ErrEmailTaken):
+		return response.Conflict(c, "email already in use")
+	case errors.Is(err, users.ErrRateLimited):
+		return rateLimited(c, err)
+	case errors.Is(err, users.ErrNotFound):
+		return response.NotFound(c, "user not found")
+	default:
+		return response.BadRequest(c, err.Error())
+	}
+}
+
+func (h *UserHandler) confirmEmailChange(c *fiber.Ctx) error {
+	var req emailChangeTokenRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Token == "" {
+		return response.BadRequest(c, "token required")
+	}
+
+	if err := h.svc.ConfirmEmailChange(c.Context(), req.Token); err != nil {
+		if errors.Is(err, users.ErrEmailChangeTokenInvalid) {
+			return response.BadRequest(c, "invalid or expired token")
+		}
+		if errors.Is(err, users.ErrEmailTaken) {
+			return response.Conflict(c, "email already in use")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "email changed", nil)
+}
+
+func (h *UserHandler) cancelEmailChange(c *fiber.Ctx) error {
+	var req emailChangeTokenRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Token == "" {
+		return response.BadRequest(c, "token required")
+	}
+
+	if err := h.svc.CancelEmailChange(c.Context(), req.Token); err != nil {
+		if errors.Is(err, users.ErrEmailChangeTokenInvalid) {
+			return response.BadRequest(c, "invalid or expired token")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "email change cancelled", nil)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type finishFederatedLoginRequest struct {
+	State string `json:"state"`
+	Code  string `json:"code"`
+}
+
+func (h *UserHandler) beginFederatedLogin(c *fiber.Ctx) error {
+	login, err := h.svc.BeginFederatedLogin(c.Context(), c.Params("provider"))
+	if err != nil {
+		if errors.Is(err, users.ErrIdentityProviderNotFound) {
+			return response.NotFound(c, "identity provider not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+	return response.OK(c, "federated login started", fiber.Map{
+		"state":            login.State,
+		"authorizationUrl": login.AuthorizationURL,
+	})
+}
+
+func (h *UserHandler) finishFederatedLogin(c *fiber.Ctx) error {
+	var req finishFederatedLoginRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.State == "" || req.Code == "" {
+		return response.BadRequest(c, "state and code required")
+	}
+
+	res, err := h.svc.FinishFederatedLogin(c.Context(), c.Params("provider"), req.State, req.Code, clientInfo(c))
+	if err != nil {
+		return federationError(c, err)
+	}
+	return loginResponse(c, res)
+}
+
+func (h *UserHandler) beginIdentityLink(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage linked identities")
+	}
+
+	login, err := h.svc.BeginIdentityLink(c.Context(), middleware.UserID(c), c.Params("provider"))
+	if err != nil {
+		if errors.Is(err, users.ErrIdentityProviderNotFound) {
+			return response.NotFound(c, "identity provider not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+	return response.OK(c, "identity link started", fiber.Map{
+		"state":            login.State,
+		"authorizationUrl": login.AuthorizationURL,
+	})
+}
+
+func (h *UserHandler) finishIdentityLink(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage linked identities")
+	}
+
+	var req finishFederatedLoginRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.State == "" || req.Code == "" {
+		return response.BadRequest(c, "state and code required")
+	}
+
+	link, err := h.svc.FinishIdentityLink(c.Context(), middleware.UserID(c), c.Params("provider"), req.State, req.Code)
+	if err != nil {
+		return federationError(c, err)
+	}
+	return response.Created(c, "identity linked", identityLinkPayload(*link))
+}
+
+func (h *UserHandler) listIdentityLinks(c *fiber.Ctx) error {
+	links, err := h.svc.ListIdentityLinks(c.Context(), middleware.UserID(c))
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+
+	payload := make([]fiber.Map, 0, len(links))
+	for _, link := range links {
+		payload = append(payload, identityLinkPayload(link))
+	}
+	return response.OK(c, "linked identities retrieved", payload)
+}
+
+func (h *UserHandler) unlinkIdentity(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage linked identities")
+	}
+
+	if err := h.svc.UnlinkIdentity(c.Context(), middleware.UserID(c), c.Params("id")); err != nil {
+		if errors.Is(err, users.ErrIdentityLinkNotFound) {
+			return response.NotFound(c, "linked identity not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+	return response.OK(c, "identity unlinked", nil)
+}
+
+// federationError maps the outcomes of finishing a federated login or link.
+func federationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, users.ErrIdentityProviderNotFound):
+		return response.NotFound(c, "identity provider not found")
+	case errors.Is(err, users.ErrFederatedLoginInvalid):
+		return response.Unauthorized(c, "invalid or expired federated sign-in")
+	case errors.Is(err, users.ErrIdentityEmailConflict):
+		return response.Conflict(c, err.Error())
+	case errors.Is(err, users.ErrIdentityAlreadyLinked):
+		return response.Conflict(c, "identity already linked to an account")
+	case errors.Is(err, users.ErrIdentityEmailUnverified):
+		return response.Forbidden(c, "identity provider did not verify the email address")
+	case errors.Is(err, users.ErrUserDisabled):
+		return response.Forbidden(c, "user disabled")
+	case errors.Is(err, users.ErrEmailNotVerified):
+		return response.Forbidden(c, "email not verified")
+	}
+	return response.InternalError(c, err.Error())
+}
+
+func identityLinkPayload(link users.IdentityLink) fiber.Map {
+	payload := fiber.Map{
+		"id":          link.ID,
+		"provider":    link.Provider,
+		"email":       nil,
+		"createdAt":   link.CreatedAt.UTC().Format(time.RFC3339),
+		"lastLoginAt": nil,
+	}
+	if link.Email.Valid {
+		payload["email"] = link.Email.String
+	}
+	if link.LastLoginAt.Valid {
+		payload["lastLoginAt"] = link.LastLoginAt.Time.UTC().Format(time.RFC3339)
+	}
+	return payload
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type magicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+type redeemMagicLinkRequest struct {
+	Token       string `json:"token"`
+	DeviceToken string `json:"deviceToken"`
+}
+
+func (h *UserHandler) requestMagicLink(c *fiber.Ctx) error {
+	var req magicLinkRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Email == "" {
+		return response.BadRequest(c, "email required")
+	}
+
+	link, err := h.svc.RequestMagicLink(c.Context(), req.Email, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, users.ErrRateLimited) {
+			return rateLimited(c, err)
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.Accepted(c, "if the address belongs to an account, a sign-in link was sent", fiber.Map{
+		"deviceToken": link.DeviceToken,
+		"expiresIn":   link.ExpiresIn,
+	})
+}
+
+func (h *UserHandler) redeemMagicLink(c *fiber.Ctx) error {
+	var req redeemMagicLinkRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Token == "" || req.DeviceToken == "" {
+		return response.BadRequest(c, "token and deviceToken required")
+	}
+
+	res, err := h.svc.RedeemMagicLink(c.Context(), req.Token, req.DeviceToken, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, users.ErrMagicLinkInvalid) {
+			return response.Unauthorized(c, "invalid or expired sign-in link")
+		}
+		if errors.Is(err, users.ErrUserDisabled) {
+			return response.Forbidden(c, "user disabled")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return loginResponse(c, res)
+}
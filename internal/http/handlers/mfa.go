@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// loginMFA completes a login that Authenticate held back for a second factor.
+func (h *UserHandler) loginMFA(c *fiber.Ctx) error {
+	var req mfaLoginRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.MFAToken == "" || req.Code == "" {
+		return response.BadRequest(c, "mfa token and code required")
+	}
+
+	res, err := h.svc.VerifyMFA(c.Context(), users.MFALoginRequest{
+		Token:  req.MFAToken,
+		Code:   req.Code,
+		Client: clientInfo(c),
+	})
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	payload := fiber.Map{
+		"userId": res.UserID,
+		"tokens": tokenPair(res.Tokens),
+	}
+	if res.RecoveryCodes != nil {
+		payload["recoveryCodes"] = res.RecoveryCodes
+	}
+	return response.OK(c, "login successful", payload)
+}
+
+// loginMFAEnroll starts the enrollment a user's roles demand before they can sign in.
+func (h *UserHandler) loginMFAEnroll(c *fiber.Ctx) error {
+	var req mfaLoginRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.MFAToken == "" {
+		return response.BadRequest(c, "mfa token required")
+	}
+
+	enrollment, err := h.svc.EnrollMFAWithChallenge(c.Context(), req.MFAToken)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return response.OK(c, "mfa enrollment started", mfaEnrollmentPayload(enrollment))
+}
+
+func (h *UserHandler) enrollMFA(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage mfa")
+	}
+
+	enrollment, err := h.svc.EnrollMFA(c.Context(), middleware.UserID(c))
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return response.OK(c, "mfa enrollment started", mfaEnrollmentPayload(enrollment))
+}
+
+func (h *UserHandler) confirmMFA(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage mfa")
+	}
+
+	var req mfaCodeRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	codes, err := h.svc.ConfirmMFA(c.Context(), middleware.UserID(c), req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return response.OK(c, "mfa enabled", fiber.Map{"recoveryCodes": codes})
+}
+
+func (h *UserHandler) regenerateRecoveryCodes(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage mfa")
+	}
+
+	var req mfaCodeRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	codes, err := h.svc.RegenerateRecoveryCodes(c.Context(), middleware.UserID(c), req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return response.OK(c, "recovery codes regenerated", fiber.Map{"recoveryCodes": codes})
+}
+
+func (h *UserHandler) disableMFA(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage mfa")
+	}
+
+	var req mfaCodeRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	if err := h.svc.DisableMFA(c.Context(), middleware.UserID(c), req.Code); err != nil {
+		return mfaError(c, err)
+	}
+	return response.OK(c, "mfa disabled", nil)
+}
+
+func mfaError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, users.ErrTokenInvalid):
+		return response.Unauthorized(c, "invalid or expired mfa token")
+	case errors.Is(err, users.ErrMFAInvalidCode):
+		return response.Unauthorized(c, "invalid verification code")
+	case errors.Is(err, users.ErrRateLimited):
+		return rateLimited(c, err)
+	case errors.Is(err, users.ErrUserDisabled):
+		return response.Forbidden(c, "user disabled")
+	case errors.Is(err, users.ErrEmailNotVerified):
+		return response.Forbidden(c, "email not verified")
+	case errors.Is(err, users.ErrMFAMandatory):
+		return response.Forbidden(c, "mfa is mandatory for your roles")
+	case errors.Is(err, users.ErrMFAEnrollmentRequired):
+		return response.Forbidden(c, "mfa enrollment required")
+	case errors.Is(err, users.ErrMFANotEnrolled):
+		return response.BadRequest(c, "mfa is not enrolled")
+	case errors.Is(err, users.ErrMFAAlreadyEnabled):
+		return response.BadRequest(c, "mfa is already enabled")
+	default:
+		return response.InternalError(c, err.Error())
+	}
+}
+
+func mfaEnrollmentPayload(enrollment *users.MFAEnrollment) fiber.Map {
+	return fiber.Map{
+		"secret":     enrollment.Secret,
+		"otpauthUri": enrollment.URI,
+	}
+}
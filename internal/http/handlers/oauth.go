@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+// OAuthService defines the OAuth 2.0 interactions exposed over HTTP.
+type OAuthService interface {
+	AuthenticateClient(ctx context.Context, id, secret string) (*oauth.Client, error)
+	Introspect(ctx context.Context, token string) (*oauth.Introspection, error)
+	Revoke(ctx context.Context, client *oauth.Client, token, hint string) error
+	ValidateAuthorization(ctx context.Context, req oauth.AuthorizationRequest) (*oauth.Client, error)
+	Authorize(ctx context.Context, req oauth.AuthorizationRequest, credentials users.AuthenticateRequest) (string, error)
+	Exchange(ctx context.Context, req oauth.TokenRequest) (*oauth.TokenResponse, error)
+	Discovery(baseURL string) oauth.Discovery
+	UserInfo(ctx context.Context, userID, scope string) (map[string]any, error)
+}
+
+// OAuthHandler exposes the standards-based OAuth endpoints. Responses follow the RFC wire
+// formats instead of the base response envelope so off-the-shelf clients can consume them.
+type OAuthHandler struct {
+	svc OAuthService
+}
+
+// NewOAuthHandler constructs the handler.
+func NewOAuthHandler(svc OAuthService) *OAuthHandler {
+	return &OAuthHandler{svc: svc}
+}
+
+// RegisterOAuthRoutes binds the OAuth and OpenID Connect routes to the application. The
+// userinfo endpoint is protected by the supplied bearer token middleware.
+func RegisterOAuthRoutes(app fiber.Router, handler *OAuthHandler, auth fiber.Handler) {
+	app.Get("/.well-known/openid-configuration", handler.discovery)
+	app.Get("/userinfo", auth, middleware.RequireUser(), handler.userInfo)
+	app.Post("/userinfo", auth, middleware.RequireUser(), handler.userInfo)
+
+	group := app.Group("/oauth")
+	group.Get("/authorize", handler.authorizeForm)
+	group.Post("/authorize", handler.authorize)
+	group.Post("/token", handler.token)
+	group.Post("/introspect", handler.introspect)
+	group.Post("/revoke", handler.revoke)
+}
+
+func (h *OAuthHandler) discovery(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.svc.Discovery(c.BaseURL()))
+}
+
+func (h *OAuthHandler) token(c *fiber.Ctx) error {
+	req := oauth.TokenRequest{
+		GrantType:    c.FormValue("grant_type"),
+		Code:         c.FormValue("code"),
+		RedirectURI:  c.FormValue("redirect_uri"),
+		CodeVerifier: c.FormValue("code_verifier"),
+		RefreshToken: c.FormValue("refresh_token"),
+		Scope:        c.FormValue("scope"),
+		Client:       clientInfo(c),
+	}
+	var ok bool
+	req.ClientID, req.ClientSecret, ok = basicCredentials(c.Get(fiber.HeaderAuthorization))
+	if !ok {
+		req.ClientID, req.ClientSecret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+
+	res, err := h.svc.Exchange(c.Context(), req)
+	if err != nil {
+		var protoErr *oauth.Error
+		if errors.As(err, &protoErr) {
+			return oauthError(c, fiber.StatusBadRequest, protoErr.Code, protoErr.Description)
+		}
+		return h.clientError(c, err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+	return c.JSON(res)
+}
+
+func (h *OAuthHandler) userInfo(c *fiber.Ctx) error {
+	scope := strings.Join(middleware.Principal(c).Scopes, " ")
+	claims, err := h.svc.UserInfo(c.Context(), middleware.UserID(c), scope)
+	if err != nil {
+		if errors.Is(err, users.ErrNotFound) {
+			return oauthError(c, fiber.StatusUnauthorized, "invalid_token", "user no longer exists")
+		}
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", err.Error())
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(claims)
+}
+
+func (h *OAuthHandler) introspect(c *fiber.Ctx) error {
+	if _, err := h.authenticateClient(c); err != nil {
+		return h.clientError(c, err)
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	res, err := h.svc.Introspect(c.Context(), token)
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", err.Error())
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(res)
+}
+
+func (h *OAuthHandler) revoke(c *fiber.Ctx) error {
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		return h.clientError(c, err)
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	if err := h.svc.Revoke(c.Context(), client, token, c.FormValue("token_type_hint")); err != nil {
+		var protoErr *oauth.Error
+		if errors.As(err, &protoErr) {
+			return oauthError(c, fiber.StatusBadRequest, protoErr.Code, protoErr.Description)
+		}
+		return oauthError(c, fiber.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post credentials.
+func (h *OAuthHandler) authenticateClient(c *fiber.Ctx) (*oauth.Client, error) {
+	id, secret, ok := basicCredentials(c.Get(fiber.HeaderAuthorization))
+	if !ok {
+		id, secret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+	return h.svc.AuthenticateClient(c.Context(), id, secret)
+}
+
+func (h *OAuthHandler) clientError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, oauth.ErrInvalidClient) {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="svc-user"`)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	return oauthError(c, fiber.StatusInternalServerError, "server_error", err.Error())
+}
+
+func basicCredentials(header string) (string, string, bool) {
+	const prefix = "Basic "
+	if !strings.HasPrefix(header, prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(header, prefix)))
+	if err != nil {
+		return "", "", false
+	}
+	rawID, rawSecret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+	// RFC 6749 section 2.3.1 form-encodes the credentials before base64.
+	id, err := url.QueryUnescape(rawID)
+	if err != nil {
+		return "", "", false
+	}
+	secret, err := url.QueryUnescape(rawSecret)
+	if err != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func oauthError(c *fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error":             code,
+		"error_description": description,
+	})
+}
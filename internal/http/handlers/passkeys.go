@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type finishPasskeyRegistrationRequest struct {
+	CeremonyID string                    `json:"ceremonyId"`
+	Name       string                    `json:"name"`
+	Credential auth.RegistrationResponse `json:"credential"`
+}
+
+type finishPasskeyLoginRequest struct {
+	CeremonyID string                 `json:"ceremonyId"`
+	Credential auth.AssertionResponse `json:"credential"`
+}
+
+func (h *UserHandler) beginPasskeyLogin(c *fiber.Ctx) error {
+	login, err := h.svc.BeginPasskeyLogin(c.Context())
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+	return response.OK(c, "passkey login started", fiber.Map{
+		"ceremonyId": login.CeremonyID,
+		"publicKey":  login.Options,
+	})
+}
+
+func (h *UserHandler) finishPasskeyLogin(c *fiber.Ctx) error {
+	var req finishPasskeyLoginRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	res, err := h.svc.FinishPasskeyLogin(c.Context(), req.CeremonyID, req.Credential, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, users.ErrPasskeyInvalid) {
+			return response.Unauthorized(c, "passkey verification failed")
+		}
+		if errors.Is(err, users.ErrUserDisabled) {
+			return response.Forbidden(c, "user disabled")
+		}
+		if errors.Is(err, users.ErrEmailNotVerified) {
+			return response.Forbidden(c, "email not verified")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "login successful", map[string]any{
+		"userId": res.UserID,
+		"tokens": tokenPair(res.Tokens),
+	})
+}
+
+func (h *UserHandler) beginPasskeyRegistration(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage passkeys")
+	}
+
+	registration, err := h.svc.BeginPasskeyRegistration(c.Context(), middleware.UserID(c))
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+	return response.OK(c, "passkey registration started", fiber.Map{
+		"ceremonyId": registration.CeremonyID,
+		"publicKey":  registration.Options,
+	})
+}
+
+func (h *UserHandler) finishPasskeyRegistration(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage passkeys")
+	}
+
+	var req finishPasskeyRegistrationRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	passkey, err := h.svc.FinishPasskeyRegistration(c.Context(), middleware.UserID(c), req.CeremonyID, req.Name, req.Credential)
+	if err != nil {
+		if errors.Is(err, users.ErrPasskeyInvalid) {
+			return response.BadRequest(c, "passkey verification failed")
+		}
+		return response.InternalError(c, err.Error())
+	}
+	return response.Created(c, "passkey registered", passkeyPayload(*passkey))
+}
+
+func (h *UserHandler) listPasskeys(c *fiber.Ctx) error {
+	passkeys, err := h.svc.ListPasskeys(c.Context(), middleware.UserID(c))
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+
+	payload := make([]fiber.Map, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		payload = append(payload, passkeyPayload(passkey))
+	}
+	return response.OK(c, "passkeys retrieved", payload)
+}
+
+func (h *UserHandler) deletePasskey(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage passkeys")
+	}
+
+	if err := h.svc.DeletePasskey(c.Context(), middleware.UserID(c), c.Params("id")); err != nil {
+		if errors.Is(err, users.ErrPasskeyNotFound) {
+			return response.NotFound(c, "passkey not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+	return response.OK(c, "passkey deleted", nil)
+}
+
+func passkeyPayload(passkey users.Passkey) fiber.Map {
+	payload := fiber.Map{
+		"id":         users.PasskeyID(passkey.ID),
+		"name":       passkey.Name,
+		"createdAt":  passkey.CreatedAt.UTC().Format(time.RFC3339),
+		"lastUsedAt": nil,
+	}
+	if passkey.LastUsedAt.Valid {
+		payload["lastUsedAt"] = passkey.LastUsedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return payload
+}
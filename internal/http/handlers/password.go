@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+func (h *UserHandler) forgotPassword(c *fiber.Ctx) error {
+	var req forgotPasswordRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Email == "" {
+		return response.BadRequest(c, "email required")
+	}
+
+	if err := h.svc.ForgotPassword(c.Context(), req.Email); err != nil {
+		if errors.Is(err, users.ErrRateLimited) {
+			return rateLimited(c, err)
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.Accepted(c, "if the account exists, a password reset email is on its way", nil)
+}
+
+func (h *UserHandler) resetPassword(c *fiber.Ctx) error {
+	var req resetPasswordRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Token == "" {
+		return response.BadRequest(c, "token required")
+	}
+
+	if err := h.svc.ResetPassword(c.Context(), req.Token, req.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, users.ErrResetTokenInvalid):
+			return response.BadRequest(c, "invalid or expired token")
+		case errors.Is(err, users.ErrUserDisabled):
+			return response.Forbidden(c, "user disabled")
+		default:
+			return passwordRejected(c, err)
+		}
+	}
+
+	return response.OK(c, "password reset", nil)
+}
+
+// passwordRejected answers a new password refused by the password policy with the list of
+// violations, and any other error with its message.
+func passwordRejected(c *fiber.Ctx, err error) error {
+	var policyErr *users.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return response.BadRequest(c, err.Error())
+	}
+	violations := make([]fiber.Map, 0, len(policyErr.Violations))
+	for _, v := range policyErr.Violations {
+		violation := fiber.Map{"code": v.Code, "message": v.Message}
+		if v.Class != "" {
+			violation["class"] = v.Class
+		}
+		violations = append(violations, violation)
+	}
+	return response.JSON(c, fiber.StatusBadRequest, "password does not meet the policy", fiber.Map{"violations": violations})
+}
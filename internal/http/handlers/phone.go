@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type phoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+type phoneCodeRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+func (h *UserHandler) requestPhoneChange(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage phone numbers")
+	}
+	var req phoneRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	err := h.svc.RequestPhoneChange(c.Context(), middleware.UserID(c), req.Phone)
+	switch {
+	case err == nil:
+		return response.Accepted(c, "verification code sent", nil)
+	case errors.Is(err, users.ErrInvalidPhone), errors.Is(err, users.ErrPhoneUnchanged):
+		return response.BadRequest(c, err.Error())
+	case errors.Is(err, users.ErrPhoneTaken):
+		return response.Conflict(c, "phone number already in use")
+	case errors.Is(err, users.ErrRateLimited):
+		return rateLimited(c, err)
+	case errors.Is(err, users.ErrNotFound):
+		return response.NotFound(c, "user not found")
+	default:
+		return response.InternalError(c, err.Error())
+	}
+}
+
+func (h *UserHandler) confirmPhoneChange(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage phone numbers")
+	}
+	var req phoneCodeRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	if err := h.svc.ConfirmPhoneChange(c.Context(), middleware.UserID(c), req.Code); err != nil {
+		if errors.Is(err, users.ErrPhoneCodeInvalid) {
+			return response.BadRequest(c, "invalid or expired code")
+		}
+		if errors.Is(err, users.ErrPhoneTaken) {
+			return response.Conflict(c, "phone number already in use")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "phone number verified", nil)
+}
+
+func (h *UserHandler) beginPhoneLogin(c *fiber.Ctx) error {
+	var req phoneRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	if err := h.svc.SendPhoneLoginCode(c.Context(), req.Phone); err != nil {
+		if errors.Is(err, users.ErrInvalidPhone) {
+			return response.BadRequest(c, err.Error())
+		}
+		if errors.Is(err, users.ErrRateLimited) {
+			return rateLimited(c, err)
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.Accepted(c, "if the number belongs to an account, a sign-in code was sent", nil)
+}
+
+func (h *UserHandler) finishPhoneLogin(c *fiber.Ctx) error {
+	var req phoneCodeRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	res, err := h.svc.PhoneLogin(c.Context(), req.Phone, req.Code, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidCredentials) {
+			return response.Unauthorized(c, "invalid or expired code")
+		}
+		if errors.Is(err, users.ErrUserDisabled) {
+			return response.Forbidden(c, "user disabled")
+		}
+		if errors.Is(err, users.ErrEmailNotVerified) {
+			return response.Forbidden(c, "email not verified")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return loginResponse(c, res)
+}
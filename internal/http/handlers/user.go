@@ -7,6 +7,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
@@ -16,13 +17,53 @@ import (
 type UserService interface {
 	Register(ctx context.Context, req users.RegisterRequest) (*users.RegisterResult, error)
 	Authenticate(ctx context.Context, req users.AuthenticateRequest) (*users.AuthenticateResult, error)
+	Refresh(ctx context.Context, req users.RefreshRequest) (*users.AuthenticateResult, error)
 	GetProfile(ctx context.Context, userID string) (*users.Profile, error)
 	UpdateProfile(ctx context.Context, userID string, req users.UpdateProfileRequest) (*users.Profile, error)
 	ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error
 	Logout(ctx context.Context, token string) error
+	LogoutAll(ctx context.Context, userID string) error
 	AssignRole(ctx context.Context, userID, role string) error
 	Permissions(ctx context.Context, userID string) ([]string, error)
 	HasPermission(ctx context.Context, userID, permission string) (bool, error)
+	CreateAPIKey(ctx context.Context, userID string, req users.CreateAPIKeyRequest) (*users.CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]users.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+	ListSessions(ctx context.Context, userID string) ([]users.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	VerifyMFA(ctx context.Context, req users.MFALoginRequest) (*users.AuthenticateResult, error)
+	EnrollMFA(ctx context.Context, userID string) (*users.MFAEnrollment, error)
+	EnrollMFAWithChallenge(ctx context.Context, challenge string) (*users.MFAEnrollment, error)
+	ConfirmMFA(ctx context.Context, userID, code string) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error)
+	DisableMFA(ctx context.Context, userID, code string) error
+	BeginPasskeyRegistration(ctx context.Context, userID string) (*users.PasskeyRegistration, error)
+	FinishPasskeyRegistration(ctx context.Context, userID, ceremonyID, name string, resp auth.RegistrationResponse) (*users.Passkey, error)
+	BeginPasskeyLogin(ctx context.Context) (*users.PasskeyLogin, error)
+	FinishPasskeyLogin(ctx context.Context, ceremonyID string, resp auth.AssertionResponse, client users.ClientInfo) (*users.AuthenticateResult, error)
+	ListPasskeys(ctx context.Context, userID string) ([]users.Passkey, error)
+	DeletePasskey(ctx context.Context, userID, id string) error
+	VerifyEmail(ctx context.Context, token string) error
+	ResendEmailVerification(ctx context.Context, email string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
+	RequestEmailChange(ctx context.Context, userID, newEmail, currentPassword string) error
+	ConfirmEmailChange(ctx context.Context, token string) error
+	CancelEmailChange(ctx context.Context, token string) error
+	RequestPhoneChange(ctx context.Context, userID, phone string) error
+	ConfirmPhoneChange(ctx context.Context, userID, code string) error
+	SendPhoneLoginCode(ctx context.Context, phone string) error
+	PhoneLogin(ctx context.Context, phone, code string, client users.ClientInfo) (*users.AuthenticateResult, error)
+	RequestMagicLink(ctx context.Context, email string, client users.ClientInfo) (*users.MagicLink, error)
+	RedeemMagicLink(ctx context.Context, token, deviceToken string, client users.ClientInfo) (*users.AuthenticateResult, error)
+	BeginFederatedLogin(ctx context.Context, provider string) (*users.FederatedLogin, error)
+	FinishFederatedLogin(ctx context.Context, provider, state, code string, client users.ClientInfo) (*users.AuthenticateResult, error)
+	BeginIdentityLink(ctx context.Context, userID, provider string) (*users.FederatedLogin, error)
+	FinishIdentityLink(ctx context.Context, userID, provider, state, code string) (*users.IdentityLink, error)
+	ListIdentityLinks(ctx context.Context, userID string) ([]users.IdentityLink, error)
+	UnlinkIdentity(ctx context.Context, userID, linkID string) error
+	ImportUsers(ctx context.Context, batch []users.ImportUser) ([]users.ImportResult, error)
+	UnlockUser(ctx context.Context, userID string) error
 }
 
 // UserHandler exposes HTTP handlers for user operations.
@@ -39,19 +80,59 @@ func NewUserHandler(svc UserService) *UserHandler {
 func RegisterUserRoutes(app fiber.Router, handler *UserHandler, auth fiber.Handler) {
 	usersGroup := app.Group("/users")
 	usersGroup.Post("/register", handler.register)
+	usersGroup.Post("/verify-email", handler.verifyEmail)
+	usersGroup.Post("/verify-email/resend", handler.resendVerification)
+	usersGroup.Post("/forgot-password", handler.forgotPassword)
+	usersGroup.Post("/reset-password", handler.resetPassword)
+	usersGroup.Post("/email/confirm", handler.confirmEmailChange)
+	usersGroup.Post("/email/cancel", handler.cancelEmailChange)
 	usersGroup.Post("/login", handler.login)
+	usersGroup.Post("/login/mfa", handler.loginMFA)
+	usersGroup.Post("/login/mfa/enroll", handler.loginMFAEnroll)
+	usersGroup.Post("/login/passkey/begin", handler.beginPasskeyLogin)
+	usersGroup.Post("/login/passkey/finish", handler.finishPasskeyLogin)
+	usersGroup.Post("/login/phone/begin", handler.beginPhoneLogin)
+	usersGroup.Post("/login/phone/finish", handler.finishPhoneLogin)
+	usersGroup.Post("/login/magic-link", handler.requestMagicLink)
+	usersGroup.Post("/login/magic-link/verify", handler.redeemMagicLink)
+	usersGroup.Post("/login/oidc/:provider/begin", handler.beginFederatedLogin)
+	usersGroup.Post("/login/oidc/:provider/finish", handler.finishFederatedLogin)
+	usersGroup.Post("/token/refresh", handler.refresh)
 
 	authenticated := usersGroup.Group("")
-	authenticated.Use(auth)
+	authenticated.Use(auth, middleware.RequireUser())
 	authenticated.Get("/me", handler.profile)
 	authenticated.Patch("/me", handler.updateProfile)
 	authenticated.Post("/me/change-password", handler.changePassword)
+	authenticated.Post("/me/email", handler.requestEmailChange)
+	authenticated.Post("/me/phone", handler.requestPhoneChange)
+	authenticated.Post("/me/phone/verify", handler.confirmPhoneChange)
 	authenticated.Post("/logout", handler.logout)
+	authenticated.Post("/me/logout-all", handler.logoutAll)
+	authenticated.Get("/me/api-keys", handler.listAPIKeys)
+	authenticated.Post("/me/api-keys", handler.createAPIKey)
+	authenticated.Delete("/me/api-keys/:id", handler.revokeAPIKey)
+	authenticated.Get("/me/sessions", handler.listSessions)
+	authenticated.Delete("/me/sessions/:id", handler.revokeSession)
+	authenticated.Post("/me/mfa/enroll", handler.enrollMFA)
+	authenticated.Post("/me/mfa/confirm", handler.confirmMFA)
+	authenticated.Post("/me/mfa/recovery-codes", handler.regenerateRecoveryCodes)
+	authenticated.Post("/me/mfa/disable", handler.disableMFA)
+	authenticated.Get("/me/passkeys", handler.listPasskeys)
+	authenticated.Post("/me/passkeys/register/begin", handler.beginPasskeyRegistration)
+	authenticated.Post("/me/passkeys/register/finish", handler.finishPasskeyRegistration)
+	authenticated.Delete("/me/passkeys/:id", handler.deletePasskey)
+	authenticated.Get("/me/identities", handler.listIdentityLinks)
+	authenticated.Post("/me/identities/:provider/begin", handler.beginIdentityLink)
+	authenticated.Post("/me/identities/:provider/finish", handler.finishIdentityLink)
+	authenticated.Delete("/me/identities/:id", handler.unlinkIdentity)
 
 	admin := app.Group("/admin")
 	admin.Use(auth)
 	admin.Post("/users/:id/roles", handler.assignRole)
 	admin.Get("/users/:id/permissions", handler.permissions)
+	admin.Post("/users/import", handler.importUsers)
+	admin.Post("/users/:id/unlock", handler.unlockUser)
 }
 
 func (h *UserHandler) register(c *fiber.Ctx) error {
@@ -65,9 +146,20 @@ func (h *UserHandler) register(c *fiber.Ctx) error {
 		Password:  req.Password,
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
+		Client:    clientInfo(c),
 	})
 	if err != nil {
-		return response.BadRequest(c, err.Error())
+		if errors.Is(err, users.ErrEmailTaken) {
+			return response.Conflict(c, "email already exists")
+		}
+		return passwordRejected(c, err)
+	}
+
+	if result.EmailVerificationRequired {
+		return response.Accepted(c, "registration accepted, verify your email to sign in", map[string]any{
+			"userId":                    result.UserID,
+			"emailVerificationRequired": true,
+		})
 	}
 
 	return response.Accepted(c, "registration accepted", map[string]any{
@@ -85,23 +177,77 @@ func (h *UserHandler) login(c *fiber.Ctx) error {
 	res, err := h.svc.Authenticate(c.Context(), users.AuthenticateRequest{
 		Email:    req.Email,
 		Password: req.Password,
+		Client:   clientInfo(c),
 	})
 	if err != nil {
 		if errors.Is(err, users.ErrInvalidCredentials) {
 			return response.Unauthorized(c, "invalid credentials")
 		}
+		if errors.Is(err, users.ErrRateLimited) {
+			return rateLimited(c, err)
+		}
 		if errors.Is(err, users.ErrUserDisabled) {
 			return response.Forbidden(c, "user disabled")
 		}
+		if errors.Is(err, users.ErrEmailNotVerified) {
+			return response.Forbidden(c, "email not verified")
+		}
 		return response.InternalError(c, err.Error())
 	}
 
+	return loginResponse(c, res)
+}
+
+// loginResponse answers a first-factor sign-in with tokens, or with the MFA challenge to
+// complete at /users/login/mfa.
+func loginResponse(c *fiber.Ctx, res *users.AuthenticateResult) error {
+	if res.MFA != nil {
+		return response.Accepted(c, "mfa required", map[string]any{
+			"userId":                res.UserID,
+			"mfaToken":              res.MFA.Token,
+			"expiresIn":             res.MFA.ExpiresIn,
+			"mfaEnrollmentRequired": res.MFA.EnrollmentRequired,
+		})
+	}
+
 	return response.OK(c, "login successful", map[string]any{
 		"userId": res.UserID,
 		"tokens": tokenPair(res.Tokens),
 	})
 }
 
+func (h *UserHandler) refresh(c *fiber.Ctx) error {
+	var req refreshRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.RefreshToken == "" {
+		return response.BadRequest(c, "refresh token required")
+	}
+
+	res, err := h.svc.Refresh(c.Context(), users.RefreshRequest{
+		RefreshToken: req.RefreshToken,
+		Client:       clientInfo(c),
+	})
+	if err != nil {
+		if errors.Is(err, users.ErrTokenInvalid) || errors.Is(err, users.ErrTokenRevoked) {
+			return response.Unauthorized(c, "invalid or revoked refresh token")
+		}
+		if errors.Is(err, users.ErrUserDisabled) {
+			return response.Forbidden(c, "user disabled")
+		}
+		if errors.Is(err, users.ErrEmailNotVerified) {
+			return response.Forbidden(c, "email not verified")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "token refreshed", map[string]any{
+		"userId": res.UserID,
+		"tokens": tokenPair(res.Tokens),
+	})
+}
+
 func (h *UserHandler) profile(c *fiber.Ctx) error {
 	userID := middleware.UserID(c)
 	prof, err := h.svc.GetProfile(c.Context(), userID)
@@ -116,6 +262,10 @@ func (h *UserHandler) profile(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) updateProfile(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot update the profile")
+	}
+
 	userID := middleware.UserID(c)
 	var req updateProfileRequest
 	if err := parseJSON(c, &req); err != nil {
@@ -137,6 +287,10 @@ func (h *UserHandler) updateProfile(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) changePassword(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot change the password")
+	}
+
 	userID := middleware.UserID(c)
 	var req changePasswordRequest
 	if err := parseJSON(c, &req); err != nil {
@@ -144,10 +298,13 @@ func (h *UserHandler) changePassword(c *fiber.Ctx) error {
 	}
 
 	if err := h.svc.ChangePassword(c.Context(), userID, req.CurrentPassword, req.NewPassword); err != nil {
-		if errors.Is(err, users.ErrInvalidCredentials) {
+		switch {
+		case errors.Is(err, users.ErrInvalidCredentials):
 			return response.Unauthorized(c, "current password incorrect")
+		case errors.Is(err, users.ErrRateLimited):
+			return rateLimited(c, err)
 		}
-		return response.BadRequest(c, err.Error())
+		return passwordRejected(c, err)
 	}
 
 	return response.OK(c, "password changed", nil)
@@ -169,9 +326,20 @@ func (h *UserHandler) logout(c *fiber.Ctx) error {
 	return response.OK(c, "logout successful", nil)
 }
 
+func (h *UserHandler) logoutAll(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage sessions")
+	}
+
+	if err := h.svc.LogoutAll(c.Context(), middleware.UserID(c)); err != nil {
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "all sessions revoked", nil)
+}
+
 func (h *UserHandler) assignRole(c *fiber.Ctx) error {
-	actor := middleware.UserID(c)
-	has, err := h.svc.HasPermission(c.Context(), actor, "roles:assign")
+	has, err := h.authorize(c, "roles:assign")
 	if err != nil {
 		return response.InternalError(c, err.Error())
 	}
@@ -193,8 +361,7 @@ func (h *UserHandler) assignRole(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) permissions(c *fiber.Ctx) error {
-	actor := middleware.UserID(c)
-	has, err := h.svc.HasPermission(c.Context(), actor, "roles:view")
+	has, err := h.authorize(c, "roles:view")
 	if err != nil {
 		return response.InternalError(c, err.Error())
 	}
@@ -211,6 +378,178 @@ func (h *UserHandler) permissions(c *fiber.Ctx) error {
 	return response.OK(c, "permissions retrieved", fiber.Map{"userId": target, "permissions": perms})
 }
 
+func (h *UserHandler) unlockUser(c *fiber.Ctx) error {
+	has, err := h.authorize(c, "users:unlock")
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+	if !has {
+		return response.Forbidden(c, "insufficient permissions")
+	}
+
+	target := c.Params("id")
+	if err := h.svc.UnlockUser(c.Context(), target); err != nil {
+		if errors.Is(err, users.ErrNotFound) {
+			return response.NotFound(c, "user not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "user unlocked", fiber.Map{"userId": target})
+}
+
+func (h *UserHandler) importUsers(c *fiber.Ctx) error {
+	has, err := h.authorize(c, "users:import")
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+	if !has {
+		return response.Forbidden(c, "insufficient permissions")
+	}
+
+	var req importUsersRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	batch := make([]users.ImportUser, 0, len(req.Users))
+	for _, u := range req.Users {
+		batch = append(batch, users.ImportUser{
+			Email:         u.Email,
+			PasswordHash:  u.PasswordHash,
+			FirstName:     u.FirstName,
+			LastName:      u.LastName,
+			EmailVerified: u.EmailVerified,
+		})
+	}
+
+	results, err := h.svc.ImportUsers(c.Context(), batch)
+	if errors.Is(err, users.ErrImportBatchTooLarge) {
+		return response.BadRequest(c, err.Error())
+	}
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+
+	payload := make([]fiber.Map, 0, len(results))
+	created := 0
+	for _, result := range results {
+		if result.Status == users.ImportCreated {
+			created++
+		}
+		payload = append(payload, fiber.Map{
+			"email":  result.Email,
+			"userId": result.UserID,
+			"status": result.Status,
+			"error":  result.Error,
+		})
+	}
+	return response.OK(c, "users imported", fiber.Map{"created": created, "results": payload})
+}
+
+func (h *UserHandler) listAPIKeys(c *fiber.Ctx) error {
+	keys, err := h.svc.ListAPIKeys(c.Context(), middleware.UserID(c))
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+
+	payload := make([]fiber.Map, 0, len(keys))
+	for _, key := range keys {
+		payload = append(payload, apiKeyPayload(key))
+	}
+	return response.OK(c, "api keys retrieved", payload)
+}
+
+func (h *UserHandler) createAPIKey(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage api keys")
+	}
+
+	var req createAPIKeyRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	key, err := h.svc.CreateAPIKey(c.Context(), middleware.UserID(c), users.CreateAPIKeyRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	payload := apiKeyPayload(key.APIKey)
+	payload["key"] = key.Secret
+	return response.Created(c, "api key created", payload)
+}
+
+func (h *UserHandler) revokeAPIKey(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage api keys")
+	}
+
+	if err := h.svc.RevokeAPIKey(c.Context(), middleware.UserID(c), c.Params("id")); err != nil {
+		if errors.Is(err, users.ErrAPIKeyNotFound) {
+			return response.NotFound(c, "api key not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "api key revoked", nil)
+}
+
+func (h *UserHandler) listSessions(c *fiber.Ctx) error {
+	sessions, err := h.svc.ListSessions(c.Context(), middleware.UserID(c))
+	if err != nil {
+		return response.InternalError(c, err.Error())
+	}
+
+	current := middleware.Principal(c).SessionID
+	payload := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		item := sessionPayload(session)
+		item["current"] = current != "" && session.ID == current
+		payload = append(payload, item)
+	}
+	return response.OK(c, "sessions retrieved", payload)
+}
+
+func (h *UserHandler) revokeSession(c *fiber.Ctx) error {
+	if middleware.Principal(c).APIKeyID != "" {
+		return response.Forbidden(c, "api keys cannot manage sessions")
+	}
+
+	if err := h.svc.RevokeSession(c.Context(), middleware.UserID(c), c.Params("id")); err != nil {
+		if errors.Is(err, users.ErrSessionNotFound) {
+			return response.NotFound(c, "session not found")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "session revoked", nil)
+}
+
+// authorize checks an admin permission. Users are checked against their RBAC roles; service
+// principals must have been granted a scope of the same name. Scoped API keys additionally
+// need that scope on top of the owner's permission.
+func (h *UserHandler) authorize(c *fiber.Ctx, permission string) (bool, error) {
+	principal := middleware.Principal(c)
+	if principal.IsService() {
+		return principal.HasScope(permission), nil
+	}
+	if principal.APIKeyID != "" && len(principal.Scopes) > 0 && !principal.HasScope(permission) {
+		return false, nil
+	}
+	return h.svc.HasPermission(c.Context(), principal.Subject, permission)
+}
+
+func clientInfo(c *fiber.Ctx) users.ClientInfo {
+	return users.ClientInfo{
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}
+}
+
 func parseJSON(c *fiber.Ctx, out any) error {
 	if err := c.BodyParser(out); err != nil {
 		return err
@@ -230,6 +569,10 @@ type loginRequest struct {
 	Password string `json:"password"`
 }
 
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 type updateProfileRequest struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
@@ -244,6 +587,22 @@ type assignRoleRequest struct {
 	Role string `json:"role"`
 }
 
+type importUsersRequest struct {
+	Users []struct {
+		Email         string `json:"email"`
+		PasswordHash  string `json:"passwordHash"`
+		FirstName     string `json:"firstName"`
+		LastName      string `json:"lastName"`
+		EmailVerified bool   `json:"emailVerified"`
+	} `json:"users"`
+}
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
 type tokenResponse struct {
 	AccessToken      string `json:"accessToken"`
 	RefreshToken     string `json:"refreshToken"`
@@ -266,8 +625,54 @@ func profilePayload(profile *users.Profile) fiber.Map {
 		"email":       profile.Email,
 		"firstName":   profile.FirstName,
 		"lastName":    profile.LastName,
+		"phone":       profile.Phone,
 		"status":      profile.Status,
 		"roles":       profile.Roles,
 		"retrievedAt": time.Now().UTC().Format(time.RFC3339),
 	}
 }
+
+func apiKeyPayload(key users.APIKey) fiber.Map {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	payload := fiber.Map{
+		"id":         key.ID,
+		"name":       key.Name,
+		"prefix":     key.Prefix,
+		"scopes":     scopes,
+		"createdAt":  key.CreatedAt.UTC().Format(time.RFC3339),
+		"expiresAt":  nil,
+		"lastUsedAt": nil,
+		"revokedAt":  nil,
+	}
+	if key.ExpiresAt.Valid {
+		payload["expiresAt"] = key.ExpiresAt.Time.UTC().Format(time.RFC3339)
+	}
+	if key.LastUsedAt.Valid {
+		payload["lastUsedAt"] = key.LastUsedAt.Time.UTC().Format(time.RFC3339)
+	}
+	if key.RevokedAt.Valid {
+		payload["revokedAt"] = key.RevokedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return payload
+}
+
+func sessionPayload(session users.Session) fiber.Map {
+	payload := fiber.Map{
+		"id":              session.ID,
+		"userAgent":       nil,
+		"ip":              nil,
+		"createdAt":       session.CreatedAt.UTC().Format(time.RFC3339),
+		"lastRefreshedAt": session.LastRefreshedAt.UTC().Format(time.RFC3339),
+		"expiresAt":       session.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if session.UserAgent.Valid {
+		payload["userAgent"] = session.UserAgent.String
+	}
+	if session.IP.Valid {
+		payload["ip"] = session.IP.String
+	}
+	return payload
+}
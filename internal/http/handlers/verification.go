@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type resendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+func (h *UserHandler) verifyEmail(c *fiber.Ctx) error {
+	var req verifyEmailRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Token == "" {
+		return response.BadRequest(c, "token required")
+	}
+
+	if err := h.svc.VerifyEmail(c.Context(), req.Token); err != nil {
+		if errors.Is(err, users.ErrVerificationTokenInvalid) {
+			return response.BadRequest(c, "invalid or expired token")
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.OK(c, "email verified", nil)
+}
+
+func (h *UserHandler) resendVerification(c *fiber.Ctx) error {
+	var req resendVerificationRequest
+	if err := parseJSON(c, &req); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+	if req.Email == "" {
+		return response.BadRequest(c, "email required")
+	}
+
+	if err := h.svc.ResendEmailVerification(c.Context(), req.Email); err != nil {
+		if errors.Is(err, users.ErrRateLimited) {
+			return rateLimited(c, err)
+		}
+		return response.InternalError(c, err.Error())
+	}
+
+	return response.Accepted(c, "if the account exists and is unverified, a verification email is on its way", nil)
+}
+
+// rateLimited answers 429 with a Retry-After header taken from a users.RateLimitError.
+func rateLimited(c *fiber.Ctx, err error) error {
+	setRetryAfter(c, err)
+	return response.TooManyRequests(c, "too many requests, try again later")
+}
+
+// setRetryAfter sets the Retry-After header from a users.RateLimitError, in whole seconds.
+func setRetryAfter(c *fiber.Ctx, err error) {
+	var limited *users.RateLimitError
+	if errors.As(err, &limited) && limited.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+	}
+}
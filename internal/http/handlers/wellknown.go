@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// RegisterWellKnownRoutes binds the discovery documents consumed by token verifiers.
+// They are served as plain JSON rather than the base response envelope so standard
+// JWKS clients can read them.
+func RegisterWellKnownRoutes(app *fiber.App, issuer *auth.TokenIssuer) {
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(issuer.JWKS())
+	})
+}
@@ -1,22 +1,83 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/response"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
 )
 
 const (
-	userIDContextKey = "user_id"
-	tokenContextKey  = "auth_token"
+	userIDContextKey    = "user_id"
+	tokenContextKey     = "auth_token"
+	principalContextKey = "auth_principal"
 )
 
+// APIKeyAuthenticator resolves personal API keys to the principal that owns them.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*auth.Principal, error)
+}
+
+// AuthOption customises the Authenticated middleware.
+type AuthOption func(*authOptions)
+
+type authOptions struct {
+	apiKeys     APIKeyAuthenticator
+	generations auth.TokenGenerations
+	sessions    auth.SessionRevocations
+}
+
+// WithAPIKeys accepts API keys, sent in the X-API-Key header or as a bearer token starting with
+// the key prefix, as an alternative to JWTs.
+func WithAPIKeys(authenticator APIKeyAuthenticator) AuthOption {
+	return func(o *authOptions) {
+		o.apiKeys = authenticator
+	}
+}
+
+// WithTokenGenerations rejects user tokens issued before the user's sessions were revoked.
+func WithTokenGenerations(generations auth.TokenGenerations) AuthOption {
+	return func(o *authOptions) {
+		o.generations = generations
+	}
+}
+
+// WithSessionRevocations rejects access tokens whose login session the user has revoked.
+func WithSessionRevocations(revocations auth.SessionRevocations) AuthOption {
+	return func(o *authOptions) {
+		o.sessions = revocations
+	}
+}
+
 // Authenticated parses the Authorization header and injects the authenticated subject into the context.
-func Authenticated(issuer *auth.TokenIssuer, blacklist auth.TokenBlacklist) fiber.Handler {
+// Only access tokens are accepted; refresh tokens must go through the refresh endpoint.
+func Authenticated(issuer *auth.TokenIssuer, blacklist auth.TokenBlacklist, opts ...AuthOption) fiber.Handler {
+	var options authOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return func(c *fiber.Ctx) error {
+		if options.apiKeys != nil {
+			if key := apiKey(c); key != "" {
+				principal, err := options.apiKeys.AuthenticateAPIKey(c.Context(), key)
+				if err != nil {
+					if errors.Is(err, users.ErrAPIKeyInvalid) {
+						return response.Unauthorized(c, "invalid or revoked api key")
+					}
+					return response.InternalError(c, "failed to validate api key")
+				}
+				c.Locals(userIDContextKey, principal.Subject)
+				c.Locals(principalContextKey, principal)
+				return c.Next()
+			}
+		}
+
 		header := c.Get(fiber.HeaderAuthorization)
 		if header == "" {
 			return response.Unauthorized(c, "missing authorization header")
@@ -42,24 +103,78 @@ func Authenticated(issuer *auth.TokenIssuer, blacklist auth.TokenBlacklist) fibe
 			}
 		}
 
-		sub, err := issuer.SubjectFromToken(token)
+		claims, err := issuer.ParseAndValidate(token, auth.WithTokenType(auth.TokenTypeAccess))
+		if err != nil {
+			return response.Unauthorized(c, "invalid or expired token")
+		}
+		principal, err := auth.PrincipalFromClaims(claims)
 		if err != nil {
 			return response.Unauthorized(c, "invalid or expired token")
 		}
 
-		c.Locals(userIDContextKey, sub)
+		if options.generations != nil {
+			if err := auth.CheckGeneration(c.Context(), options.generations, claims); err != nil {
+				if errors.Is(err, auth.ErrTokenStale) {
+					return response.Unauthorized(c, "token revoked")
+				}
+				return response.InternalError(c, "failed to validate token")
+			}
+		}
+		if options.sessions != nil {
+			if err := auth.CheckSession(c.Context(), options.sessions, claims); err != nil {
+				if errors.Is(err, auth.ErrSessionRevoked) {
+					return response.Unauthorized(c, "session revoked")
+				}
+				return response.InternalError(c, "failed to validate token")
+			}
+		}
+
+		c.Locals(userIDContextKey, principal.Subject)
 		c.Locals(tokenContextKey, token)
+		c.Locals(principalContextKey, principal)
 		return c.Next()
 	}
 }
 
-// UserID extracts the authenticated subject from the context.
+func apiKey(c *fiber.Ctx) string {
+	if key := strings.TrimSpace(c.Get("X-API-Key")); key != "" {
+		return key
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+	if strings.HasPrefix(token, users.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// RequireUser rejects service principals on routes that act on the calling user's own account.
+// It must run after Authenticated.
+func RequireUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if Principal(c).IsService() {
+			return response.Forbidden(c, "user token required")
+		}
+		return c.Next()
+	}
+}
+
+// Principal returns the authenticated caller. Requests that did not pass through Authenticated
+// yield an empty user principal.
+func Principal(c *fiber.Ctx) auth.Principal {
+	if principal, ok := c.Locals(principalContextKey).(*auth.Principal); ok {
+		return *principal
+	}
+	return auth.Principal{Type: auth.PrincipalUser}
+}
+
+// UserID extracts the authenticated subject from the context. For service principals this is
+// the OAuth client ID.
 func UserID(c *fiber.Ctx) string {
 	userID, _ := c.Locals(userIDContextKey).(string)
 	return userID
 }
 
-// Token extracts the bearer token from the context.
+// Token extracts the bearer token from the context. It is empty for API key requests.
 func Token(c *fiber.Ctx) string {
 	token, _ := c.Locals(tokenContextKey).(string)
 	return token
@@ -57,6 +57,16 @@ func NotFound(c *fiber.Ctx, message string) error {
 	return JSON(c, fiber.StatusNotFound, message, nil)
 }
 
+// Conflict writes a 409 response.
+func Conflict(c *fiber.Ctx, message string) error {
+	return JSON(c, fiber.StatusConflict, message, nil)
+}
+
+// TooManyRequests writes a 429 response.
+func TooManyRequests(c *fiber.Ctx, message string) error {
+	return JSON(c, fiber.StatusTooManyRequests, message, nil)
+}
+
 // InternalError writes a 500 response.
 func InternalError(c *fiber.Ctx, message string) error {
 	return JSON(c, fiber.StatusInternalServerError, message, nil)
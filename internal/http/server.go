@@ -24,7 +24,9 @@ type Server struct {
 }
 
 // NewServer configures the HTTP server with middlewares and routes.
-func NewServer(cfg *config.Config, log *slog.Logger, issuer *auth.TokenIssuer, blacklist auth.TokenBlacklist, userHandler *handlers.UserHandler) (*Server, error) {
+// The OAuth handler is optional and its routes are only mounted when it is non-nil. authOpts
+// configure the bearer authentication middleware, e.g. to accept API keys.
+func NewServer(cfg *config.Config, log *slog.Logger, issuer *auth.TokenIssuer, blacklist auth.TokenBlacklist, userHandler *handlers.UserHandler, oauthHandler *handlers.OAuthHandler, authOpts ...middleware.AuthOption) (*Server, error) {
 	app := fiber.New(fiber.Config{
 		Prefork:               false,
 		DisableStartupMessage: true,
@@ -44,10 +46,16 @@ func NewServer(cfg *config.Config, log *slog.Logger, issuer *auth.TokenIssuer, b
 	app.Use(middleware.RequestID())
 	app.Use(middleware.Logger(log))
 
+	authenticated := middleware.Authenticated(issuer, blacklist, authOpts...)
+
 	handlers.RegisterHealthRoutes(app)
+	handlers.RegisterWellKnownRoutes(app, issuer)
+	if oauthHandler != nil {
+		handlers.RegisterOAuthRoutes(app, oauthHandler, authenticated)
+	}
 
 	api := app.Group("/api/v1")
-	handlers.RegisterUserRoutes(api, userHandler, middleware.Authenticated(issuer, blacklist))
+	handlers.RegisterUserRoutes(api, userHandler, authenticated)
 
 	return &Server{app: app, cfg: cfg}, nil
 }
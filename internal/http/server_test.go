@@ -10,6 +10,8 @@ import (
 	"encoding/pem"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,6 +21,8 @@ import (
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/config"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/handlers"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/http/middleware"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
 )
 
@@ -46,7 +50,7 @@ func TestServerRegisterAndProfileRoutes(t *testing.T) {
 	}
 
 	cfg := &config.Config{HTTPAddr: ":0"}
-	srv, err := NewServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc))
+	srv, err := NewServer(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
 	if err != nil {
 		t.Fatalf("new server: %v", err)
 	}
@@ -86,6 +90,921 @@ func TestServerRegisterAndProfileRoutes(t *testing.T) {
 	}
 }
 
+func TestRegisterReportsPasswordPolicyViolations(t *testing.T) {
+	svc := &stubUserService{
+		registerFn: func(context.Context, users.RegisterRequest) (*users.RegisterResult, error) {
+			return nil, &users.PasswordPolicyError{Violations: []auth.PasswordViolation{
+				{Code: auth.ViolationTooShort, Message: "password must be at least 8 characters"},
+				{Code: auth.ViolationMissingClass, Message: "password must contain a digit character", Class: auth.ClassDigit},
+			}}
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), testIssuer(t), noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	req := httptestNewRequest(http.MethodPost, "/api/v1/users/register", strings.NewReader(`{"email":"ana@example.com","password":"short"}`))
+	req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+	resp, err := srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("register request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400 got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Data struct {
+			Violations []struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+				Class   string `json:"class"`
+			} `json:"violations"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	violations := payload.Data.Violations
+	if len(violations) != 2 || violations[0].Code != "too_short" || violations[1].Class != "digit" || violations[1].Message == "" {
+		t.Fatalf("unexpected violations %+v", violations)
+	}
+}
+
+func TestLoginWithMFAChallenge(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		authenticateFn: func(context.Context, users.AuthenticateRequest) (*users.AuthenticateResult, error) {
+			return &users.AuthenticateResult{UserID: "user-1", MFA: &users.MFAChallenge{Token: "challenge", ExpiresIn: 300}}, nil
+		},
+		verifyMFAFn: func(_ context.Context, req users.MFALoginRequest) (*users.AuthenticateResult, error) {
+			if req.Token == "locked" {
+				return nil, &users.RateLimitError{RetryAfter: 10 * time.Minute}
+			}
+			if req.Token != "challenge" || req.Code != "123456" {
+				return nil, users.ErrMFAInvalidCode
+			}
+			return &users.AuthenticateResult{UserID: "user-1", Tokens: users.TokenPair{AccessToken: "access", RefreshToken: "refresh"}}, nil
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	post := func(path string, payload map[string]string) *http.Response {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := post("/api/v1/users/login", map[string]string{"email": "admin@example.com", "password": "secretpass"})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status 202 for mfa challenge got %d", resp.StatusCode)
+	}
+	var challenge struct {
+		Data struct {
+			MFAToken string          `json:"mfaToken"`
+			Tokens   json.RawMessage `json:"tokens"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatalf("decode challenge: %v", err)
+	}
+	if challenge.Data.MFAToken != "challenge" || challenge.Data.Tokens != nil {
+		t.Fatalf("expected only the challenge token, got %+v", challenge.Data)
+	}
+
+	if resp := post("/api/v1/users/login/mfa", map[string]string{"mfaToken": "challenge", "code": "000000"}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for a wrong code got %d", resp.StatusCode)
+	}
+	if resp := post("/api/v1/users/login/mfa", map[string]string{"mfaToken": "challenge", "code": "123456"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 for a valid code got %d", resp.StatusCode)
+	}
+
+	resp = post("/api/v1/users/login/mfa", map[string]string{"mfaToken": "locked", "code": "123456"})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 for a locked account got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "600" {
+		t.Fatalf("expected Retry-After 600 got %q", got)
+	}
+}
+
+func TestEmailVerificationRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	sent := 0
+	svc := &stubUserService{
+		resendFn: func(context.Context, string) error {
+			sent++
+			if sent > 1 {
+				return &users.RateLimitError{RetryAfter: 90 * time.Second}
+			}
+			return nil
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	post := func(path string, payload map[string]string) *http.Response {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		return resp
+	}
+
+	if resp := post("/api/v1/users/verify-email", map[string]string{"token": "stale"}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid token got %d", resp.StatusCode)
+	}
+	if resp := post("/api/v1/users/verify-email/resend", map[string]string{"email": "user@example.com"}); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status 202 for a resend got %d", resp.StatusCode)
+	}
+	resp := post("/api/v1/users/verify-email/resend", map[string]string{"email": "user@example.com"})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 for a throttled resend got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "90" {
+		t.Fatalf("expected Retry-After 90 got %q", got)
+	}
+}
+
+func TestPasswordResetRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		forgotFn: func(_ context.Context, email string) error {
+			if email == "busy@example.com" {
+				return &users.RateLimitError{RetryAfter: time.Minute}
+			}
+			return nil
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	post := func(path string, payload map[string]string) int {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := post("/api/v1/users/forgot-password", map[string]string{"email": "user@example.com"}); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 for forgot password got %d", status)
+	}
+	if status := post("/api/v1/users/forgot-password", map[string]string{"email": "busy@example.com"}); status != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 for a throttled address got %d", status)
+	}
+	if status := post("/api/v1/users/reset-password", map[string]string{"token": "stale", "newPassword": "Password!3"}); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid token got %d", status)
+	}
+	if status := post("/api/v1/users/reset-password", map[string]string{"token": "reset-token", "newPassword": "Password!3"}); status != http.StatusOK {
+		t.Fatalf("expected status 200 for a reset got %d", status)
+	}
+}
+
+func TestEmailChangeRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	token := mustIssueToken(t, issuer, "user-1")
+	post := func(path, bearer string, payload map[string]string) int {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := post("/api/v1/users/me/email", "", map[string]string{"newEmail": "new@example.com", "currentPassword": "secretpass"}); status != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without a token got %d", status)
+	}
+	if status := post("/api/v1/users/me/email", token, map[string]string{"newEmail": "new@example.com", "currentPassword": "secretpass"}); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 for an email change got %d", status)
+	}
+	if status := post("/api/v1/users/me/email", token, map[string]string{"newEmail": "taken@example.com", "currentPassword": "secretpass"}); status != http.StatusConflict {
+		t.Fatalf("expected status 409 for a taken address got %d", status)
+	}
+	if status := post("/api/v1/users/email/confirm", "", map[string]string{"token": "stale"}); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid token got %d", status)
+	}
+	if status := post("/api/v1/users/email/cancel", "", map[string]string{"token": "cancel-token"}); status != http.StatusOK {
+		t.Fatalf("expected status 200 for a cancellation got %d", status)
+	}
+}
+
+func TestPhoneRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	token := mustIssueToken(t, issuer, "user-1")
+	post := func(path, bearer string, payload map[string]string) int {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := post("/api/v1/users/me/phone", token, map[string]string{"phone": "+6281234567890"}); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 for a phone change got %d", status)
+	}
+	if status := post("/api/v1/users/me/phone", token, map[string]string{"phone": "081234567890"}); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a number not in E.164 format got %d", status)
+	}
+	if status := post("/api/v1/users/me/phone/verify", token, map[string]string{"code": "000000"}); status != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a wrong code got %d", status)
+	}
+	if status := post("/api/v1/users/login/phone/begin", "", map[string]string{"phone": "+6281234567890"}); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 for a login code got %d", status)
+	}
+	if status := post("/api/v1/users/login/phone/finish", "", map[string]string{"phone": "+6281234567890", "code": "000000"}); status != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for a wrong login code got %d", status)
+	}
+	if status := post("/api/v1/users/login/phone/finish", "", map[string]string{"phone": "+6281234567890", "code": "123456"}); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 with an mfa challenge got %d", status)
+	}
+}
+
+func TestMagicLinkRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	post := func(path string, payload map[string]string) *http.Response {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := post("/api/v1/users/login/magic-link", map[string]string{"email": "user@example.com"})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status 202 for a magic link got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Data struct {
+			DeviceToken string `json:"deviceToken"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil || payload.Data.DeviceToken != "device-token" {
+		t.Fatalf("expected the device token in the response, got %+v: %v", payload, err)
+	}
+	resp = post("/api/v1/users/login/magic-link", map[string]string{"email": "busy@example.com"})
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected status 429 with Retry-After got %d", resp.StatusCode)
+	}
+	if status := post("/api/v1/users/login/magic-link/verify", map[string]string{"token": "link-token", "deviceToken": "other-device"}).StatusCode; status != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 from another device got %d", status)
+	}
+	if status := post("/api/v1/users/login/magic-link/verify", map[string]string{"token": "link-token", "deviceToken": "device-token"}).StatusCode; status != http.StatusOK {
+		t.Fatalf("expected status 200 for a valid link got %d", status)
+	}
+}
+
+func TestFederatedLoginRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	token := mustIssueToken(t, issuer, "user-1")
+	send := func(method, path, bearer string, payload map[string]string) int {
+		body, _ := json.Marshal(payload)
+		req := httptestNewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := send(http.MethodPost, "/api/v1/users/login/oidc/example/begin", "", nil); status != http.StatusOK {
+		t.Fatalf("expected status 200 for a federated login got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/v1/users/login/oidc/unknown/begin", "", nil); status != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown provider got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/v1/users/login/oidc/example/finish", "", map[string]string{"state": "other", "code": "code"}); status != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for an unknown state got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/v1/users/login/oidc/example/finish", "", map[string]string{"state": "state", "code": "taken"}); status != http.StatusConflict {
+		t.Fatalf("expected status 409 for an email conflict got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/v1/users/login/oidc/example/finish", "", map[string]string{"state": "state", "code": "code"}); status != http.StatusOK {
+		t.Fatalf("expected status 200 for a federated login got %d", status)
+	}
+
+	if status := send(http.MethodPost, "/api/v1/users/me/identities/example/begin", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without a token got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/v1/users/me/identities/example/begin", token, nil); status != http.StatusOK {
+		t.Fatalf("expected status 200 for an identity link got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/v1/users/me/identities/example/finish", token, map[string]string{"state": "state", "code": "code"}); status != http.StatusCreated {
+		t.Fatalf("expected status 201 for a linked identity got %d", status)
+	}
+	if status := send(http.MethodGet, "/api/v1/users/me/identities", token, nil); status != http.StatusOK {
+		t.Fatalf("expected status 200 for linked identities got %d", status)
+	}
+	if status := send(http.MethodDelete, "/api/v1/users/me/identities/link-2", token, nil); status != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown link got %d", status)
+	}
+	if status := send(http.MethodDelete, "/api/v1/users/me/identities/link-1", token, nil); status != http.StatusOK {
+		t.Fatalf("expected status 200 for an unlinked identity got %d", status)
+	}
+}
+
+func TestAdminImportUsersRoute(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		hasPermissionFn: func(_ context.Context, userID, permission string) (bool, error) {
+			return userID == "admin-1" && permission == "users:import", nil
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	send := func(bearer, body string) *http.Response {
+		req := httptestNewRequest(http.MethodPost, "/api/v1/admin/users/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("import request: %v", err)
+		}
+		return resp
+	}
+	body := `{"users":[{"email":"ana@example.com","passwordHash":"$2a$10$hash"},{"email":"ben@example.com","passwordHash":"md5"}]}`
+
+	if resp := send(mustIssueToken(t, issuer, "user-1"), body); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status 403 without users:import got %d", resp.StatusCode)
+	}
+	resp := send(mustIssueToken(t, issuer, "admin-1"), body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Data struct {
+			Created int `json:"created"`
+			Results []struct {
+				Email  string `json:"email"`
+				Status string `json:"status"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.Data.Created != 1 || len(payload.Data.Results) != 2 || payload.Data.Results[1].Status != users.ImportFailed {
+		t.Fatalf("unexpected import response %+v", payload.Data)
+	}
+}
+
+func TestLoginLockedOut(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		authenticateFn: func(context.Context, users.AuthenticateRequest) (*users.AuthenticateResult, error) {
+			return nil, &users.RateLimitError{RetryAfter: 15 * time.Minute}
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	req := httptestNewRequest(http.MethodPost, "/api/v1/users/login", strings.NewReader(`{"email":"ana@example.com","password":"secretpass"}`))
+	req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+	resp, err := srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("login request: %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "900" {
+		t.Fatalf("expected Retry-After 900 got %q", got)
+	}
+}
+
+func TestAdminUnlockUserRoute(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		hasPermissionFn: func(_ context.Context, userID, permission string) (bool, error) {
+			return userID == "admin-1" && permission == "users:unlock", nil
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	send := func(bearer, target string) int {
+		req := httptestNewRequest(http.MethodPost, "/api/v1/admin/users/"+target+"/unlock", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("unlock request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := send(mustIssueToken(t, issuer, "user-1"), "user-2"); status != http.StatusForbidden {
+		t.Fatalf("expected status 403 without users:unlock got %d", status)
+	}
+	admin := mustIssueToken(t, issuer, "admin-1")
+	if status := send(admin, "user-2"); status != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", status)
+	}
+	if status := send(admin, "missing"); status != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown user got %d", status)
+	}
+}
+
+func TestAuthenticatedRejectsRefreshTokens(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		getProfileFn: func(ctx context.Context, userID string) (*users.Profile, error) {
+			return &users.Profile{ID: userID}, nil
+		},
+	}
+
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	refresh, err := issuer.GenerateRefreshToken("user-1", nil)
+	if err != nil {
+		t.Fatalf("issue refresh token: %v", err)
+	}
+
+	req := httptestNewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+refresh)
+	resp, err := srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("profile request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 got %d", resp.StatusCode)
+	}
+}
+
+func TestServicePrincipalRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		permissionsFn: func(_ context.Context, userID string) ([]string, error) {
+			return []string{"orders:read"}, nil
+		},
+		hasPermissionFn: func(context.Context, string, string) (bool, error) {
+			t.Fatal("service principals must not be checked against user roles")
+			return false, nil
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	token, err := issuer.GenerateServiceToken("orders", []string{"roles:view"})
+	if err != nil {
+		t.Fatalf("issue service token: %v", err)
+	}
+
+	req := httptestNewRequest(http.MethodGet, "/api/v1/admin/users/user-1/permissions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("permissions request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 for scoped service token got %d", resp.StatusCode)
+	}
+
+	req = httptestNewRequest(http.MethodPost, "/api/v1/admin/users/user-1/roles", strings.NewReader(`{"role":"admin"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("assign role request: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status 403 without roles:assign scope got %d", resp.StatusCode)
+	}
+
+	req = httptestNewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("profile request: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status 403 for service principal on /users/me got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		getProfileFn: func(_ context.Context, userID string) (*users.Profile, error) {
+			return &users.Profile{ID: userID, Email: "merchant@example.com"}, nil
+		},
+		createAPIKeyFn: func(context.Context, string, users.CreateAPIKeyRequest) (*users.CreatedAPIKey, error) {
+			t.Fatal("api keys must not be able to mint api keys")
+			return nil, nil
+		},
+	}
+	keys := stubAPIKeys{"sk_abcd1234_secret": {Subject: "user-1", Type: auth.PrincipalUser, APIKeyID: "key-1"}}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil, middleware.WithAPIKeys(keys))
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	req := httptestNewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("X-API-Key", "sk_abcd1234_secret")
+	resp, err := srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("profile request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 with api key header got %d", resp.StatusCode)
+	}
+
+	req = httptestNewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer sk_abcd1234_secret")
+	resp, err = srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("profile request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 with api key bearer got %d", resp.StatusCode)
+	}
+
+	req = httptestNewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("X-API-Key", "sk_revoked_secret")
+	resp, err = srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("profile request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for unknown api key got %d", resp.StatusCode)
+	}
+
+	req = httptestNewRequest(http.MethodPost, "/api/v1/users/me/api-keys", strings.NewReader(`{"name":"ci"}`))
+	req.Header.Set("X-API-Key", "sk_abcd1234_secret")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("create api key request: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status 403 when creating a key with an api key got %d", resp.StatusCode)
+	}
+
+	for _, route := range []struct{ method, path, body string }{
+		{http.MethodPatch, "/api/v1/users/me", `{"firstName":"Mallory"}`},
+		{http.MethodPost, "/api/v1/users/me/change-password", `{"currentPassword":"secretpass","newPassword":"newsecretpass"}`},
+		{http.MethodPost, "/api/v1/users/me/email", `{"newEmail":"mallory@example.com","currentPassword":"secretpass"}`},
+		{http.MethodPost, "/api/v1/users/me/logout-all", ""},
+		{http.MethodDelete, "/api/v1/users/me/sessions/session-1", ""},
+	} {
+		req = httptestNewRequest(route.method, route.path, strings.NewReader(route.body))
+		req.Header.Set("X-API-Key", "sk_abcd1234_secret")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s request: %v", route.method, route.path, err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected status 403 for %s %s with an api key got %d", route.method, route.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestAuthenticatedRejectsStaleGenerations(t *testing.T) {
+	issuer := testIssuer(t)
+	svc := &stubUserService{
+		getProfileFn: func(_ context.Context, userID string) (*users.Profile, error) {
+			return &users.Profile{ID: userID}, nil
+		},
+	}
+	generations := stubGenerations{}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil, middleware.WithTokenGenerations(generations))
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	token, err := issuer.GenerateAccessToken("user-1", map[string]any{auth.GenerationClaim: 0})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	profile := func() int {
+		req := httptestNewRequest(http.MethodGet, "/api/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("profile request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := profile(); status != http.StatusOK {
+		t.Fatalf("expected status 200 before revocation got %d", status)
+	}
+	generations.Bump(context.Background(), "user-1")
+	if status := profile(); status != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 after revocation got %d", status)
+	}
+}
+
+func TestSessionRoutes(t *testing.T) {
+	issuer := testIssuer(t)
+	revocations := stubSessionRevocations{}
+	now := time.Now()
+	svc := &stubUserService{
+		listSessionsFn: func(context.Context, string) ([]users.Session, error) {
+			return []users.Session{
+				{ID: "session-1", CreatedAt: now, LastRefreshedAt: now, ExpiresAt: now.Add(time.Hour)},
+				{ID: "session-2", CreatedAt: now, LastRefreshedAt: now, ExpiresAt: now.Add(time.Hour)},
+			}, nil
+		},
+		revokeSessionFn: func(ctx context.Context, _ string, sessionID string) error {
+			if sessionID != "session-2" {
+				return users.ErrSessionNotFound
+			}
+			return revocations.RevokeSession(ctx, sessionID, time.Minute)
+		},
+	}
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(svc), nil, middleware.WithSessionRevocations(revocations))
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	current, err := issuer.GenerateAccessToken("user-1", map[string]any{auth.SessionClaim: "session-1"})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	other, err := issuer.GenerateAccessToken("user-1", map[string]any{auth.SessionClaim: "session-2"})
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	send := func(method, path, token string) *http.Response {
+		req := httptestNewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp
+	}
+
+	resp := send(http.MethodGet, "/api/v1/users/me/sessions", current)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 listing sessions got %d", resp.StatusCode)
+	}
+	var listed struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Current bool   `json:"current"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode sessions: %v", err)
+	}
+	if len(listed.Data) != 2 || !listed.Data[0].Current || listed.Data[1].Current {
+		t.Fatalf("expected only session-1 to be current, got %+v", listed.Data)
+	}
+
+	if resp := send(http.MethodDelete, "/api/v1/users/me/sessions/unknown", current); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 for unknown session got %d", resp.StatusCode)
+	}
+	if resp := send(http.MethodDelete, "/api/v1/users/me/sessions/session-2", current); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 revoking session got %d", resp.StatusCode)
+	}
+	if resp := send(http.MethodGet, "/api/v1/users/me", other); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session token to be rejected got %d", resp.StatusCode)
+	}
+}
+
+func TestJWKSRoute(t *testing.T) {
+	issuer := testIssuer(t)
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), nil)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	resp, err := srv.app.Test(httptestNewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if err != nil {
+		t.Fatalf("jwks request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", resp.StatusCode)
+	}
+
+	var set auth.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("decode jwks: %v", err)
+	}
+	active, _ := issuer.KeyRing().Active()
+	if len(set.Keys) != 1 || set.Keys[0].KeyID != active.ID || set.Keys[0].N == "" {
+		t.Fatalf("unexpected jwks payload: %+v", set)
+	}
+}
+
+func TestOAuthIntrospectRoute(t *testing.T) {
+	issuer := testIssuer(t)
+	hash, err := auth.HashPassword("orders-secret")
+	if err != nil {
+		t.Fatalf("hash secret: %v", err)
+	}
+	clients := oauth.NewStaticClientStore([]oauth.Client{{ID: "orders", SecretHash: hash}})
+	oauthHandler := handlers.NewOAuthHandler(oauth.NewService(clients, issuer, noopBlacklist{}, &stubUserService{}, nil))
+
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), oauthHandler)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	token := mustIssueToken(t, issuer, "user-1")
+	form := url.Values{"token": {token}}
+
+	unauthenticated := httptestNewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+	unauthenticated.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+	resp, err := srv.app.Test(unauthenticated)
+	if err != nil {
+		t.Fatalf("introspect request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without client credentials got %d", resp.StatusCode)
+	}
+
+	req := httptestNewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+	req.SetBasicAuth("orders", "orders-secret")
+	resp, err = srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("introspect request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", resp.StatusCode)
+	}
+
+	var payload oauth.Introspection
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode introspection: %v", err)
+	}
+	if !payload.Active || payload.Subject != "user-1" {
+		t.Fatalf("unexpected introspection: %+v", payload)
+	}
+}
+
+func TestOpenIDConfigurationRoute(t *testing.T) {
+	issuer := testIssuer(t)
+	clients := oauth.NewStaticClientStore(nil)
+	oauthHandler := handlers.NewOAuthHandler(oauth.NewService(clients, issuer, noopBlacklist{}, &stubUserService{}, nil))
+
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), oauthHandler)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	req := httptestNewRequest(http.MethodGet, "http://users.example.com/.well-known/openid-configuration", nil)
+	resp, err := srv.app.Test(req)
+	if err != nil {
+		t.Fatalf("discovery request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", resp.StatusCode)
+	}
+
+	var doc oauth.Discovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode discovery: %v", err)
+	}
+	if doc.Issuer != "svc-user" || doc.TokenEndpoint != "http://users.example.com/oauth/token" {
+		t.Fatalf("unexpected discovery document: %+v", doc)
+	}
+	if len(doc.IDTokenSigningAlgValuesSupported) != 1 || doc.IDTokenSigningAlgValuesSupported[0] != "RS256" {
+		t.Fatalf("unexpected signing algorithms: %v", doc.IDTokenSigningAlgValuesSupported)
+	}
+
+	resp, err = srv.app.Test(httptestNewRequest(http.MethodGet, "/userinfo", nil))
+	if err != nil {
+		t.Fatalf("userinfo request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected userinfo to require a bearer token, got %d", resp.StatusCode)
+	}
+}
+
+func TestOAuthSignInFormRequiresCSRFToken(t *testing.T) {
+	issuer := testIssuer(t)
+	clients := oauth.NewStaticClientStore([]oauth.Client{{
+		ID:           "storefront",
+		RedirectURIs: []string{"https://shop.example.com/callback"},
+		GrantTypes:   []string{oauth.GrantAuthorizationCode},
+	}})
+	oauthHandler := handlers.NewOAuthHandler(oauth.NewService(clients, issuer, noopBlacklist{}, &stubUserService{}, nil))
+
+	srv, err := NewServer(&config.Config{HTTPAddr: ":0"}, slog.New(slog.NewTextHandler(io.Discard, nil)), issuer, noopBlacklist{}, handlers.NewUserHandler(&stubUserService{}), oauthHandler)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"storefront"},
+		"redirect_uri":          {"https://shop.example.com/callback"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	resp, err := srv.app.Test(httptestNewRequest(http.MethodGet, "/oauth/authorize?"+params.Encode(), nil))
+	if err != nil {
+		t.Fatalf("authorize form request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the sign-in form, got %d", resp.StatusCode)
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "oauth_csrf" {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly {
+		t.Fatalf("expected an http-only csrf cookie, got %v", resp.Cookies())
+	}
+	page, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), `name="csrf_token" value="`+cookie.Value+`"`) {
+		t.Fatal("expected the form to echo the csrf token")
+	}
+
+	signIn := func(token string, withCookie bool) int {
+		form := url.Values{"email": {"jane@example.com"}, "password": {"wrong"}, "csrf_token": {token}}
+		for key, values := range params {
+			form[key] = values
+		}
+		req := httptestNewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+		if withCookie {
+			req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+		resp, err := srv.app.Test(req)
+		if err != nil {
+			t.Fatalf("sign-in request: %v", err)
+		}
+		return resp.StatusCode
+	}
+	if status := signIn(cookie.Value, false); status != http.StatusForbidden {
+		t.Fatalf("expected a post without the cookie to be refused, got %d", status)
+	}
+	if status := signIn("forged", true); status != http.StatusForbidden {
+		t.Fatalf("expected a post with the wrong token to be refused, got %d", status)
+	}
+	if status := signIn(cookie.Value, true); status != http.StatusUnauthorized {
+		t.Fatalf("expected the credentials to be checked, got %d", status)
+	}
+}
+
 func httptestNewRequest(method, url string, body io.Reader) *http.Request {
 	if body == nil {
 		body = http.NoBody
@@ -131,6 +1050,7 @@ func generateKeyPair(t *testing.T) ([]byte, []byte) {
 type stubUserService struct {
 	registerFn       func(context.Context, users.RegisterRequest) (*users.RegisterResult, error)
 	authenticateFn   func(context.Context, users.AuthenticateRequest) (*users.AuthenticateResult, error)
+	refreshFn        func(context.Context, users.RefreshRequest) (*users.AuthenticateResult, error)
 	getProfileFn     func(context.Context, string) (*users.Profile, error)
 	updateProfileFn  func(context.Context, string, users.UpdateProfileRequest) (*users.Profile, error)
 	changePasswordFn func(context.Context, string, string, string) error
@@ -138,6 +1058,43 @@ type stubUserService struct {
 	assignRoleFn     func(context.Context, string, string) error
 	permissionsFn    func(context.Context, string) ([]string, error)
 	hasPermissionFn  func(context.Context, string, string) (bool, error)
+	createAPIKeyFn   func(context.Context, string, users.CreateAPIKeyRequest) (*users.CreatedAPIKey, error)
+	listSessionsFn   func(context.Context, string) ([]users.Session, error)
+	revokeSessionFn  func(context.Context, string, string) error
+	verifyMFAFn      func(context.Context, users.MFALoginRequest) (*users.AuthenticateResult, error)
+	resendFn         func(context.Context, string) error
+	forgotFn         func(context.Context, string) error
+}
+
+type stubGenerations map[string]int64
+
+func (s stubGenerations) Current(_ context.Context, userID string) (int64, error) {
+	return s[userID], nil
+}
+
+func (s stubGenerations) Bump(_ context.Context, userID string) (int64, error) {
+	s[userID]++
+	return s[userID], nil
+}
+
+type stubSessionRevocations map[string]bool
+
+func (s stubSessionRevocations) RevokeSession(_ context.Context, sessionID string, _ time.Duration) error {
+	s[sessionID] = true
+	return nil
+}
+
+func (s stubSessionRevocations) IsSessionRevoked(_ context.Context, sessionID string) (bool, error) {
+	return s[sessionID], nil
+}
+
+type stubAPIKeys map[string]*auth.Principal
+
+func (s stubAPIKeys) AuthenticateAPIKey(_ context.Context, key string) (*auth.Principal, error) {
+	if principal, ok := s[key]; ok {
+		return principal, nil
+	}
+	return nil, users.ErrAPIKeyInvalid
 }
 
 type noopBlacklist struct{}
@@ -153,6 +1110,21 @@ func (s *stubUserService) Authenticate(ctx context.Context, req users.Authentica
 	return s.authenticateFn(ctx, req)
 }
 
+func (s *stubUserService) VerifyCredentials(context.Context, users.AuthenticateRequest) (*users.User, error) {
+	return nil, users.ErrInvalidCredentials
+}
+
+func (s *stubUserService) IssueTokensForUser(context.Context, string, users.OAuthGrant, users.ClientInfo) (*users.TokenPair, error) {
+	return nil, users.ErrNotFound
+}
+
+func (s *stubUserService) Refresh(ctx context.Context, req users.RefreshRequest) (*users.AuthenticateResult, error) {
+	if s.refreshFn != nil {
+		return s.refreshFn(ctx, req)
+	}
+	return nil, users.ErrTokenInvalid
+}
+
 func (s *stubUserService) GetProfile(ctx context.Context, userID string) (*users.Profile, error) {
 	return s.getProfileFn(ctx, userID)
 }
@@ -172,6 +1144,18 @@ func (s *stubUserService) Logout(ctx context.Context, token string) error {
 	return nil
 }
 
+func (s *stubUserService) LogoutAll(context.Context, string) error {
+	return nil
+}
+
+func (s *stubUserService) RevokeRefreshToken(context.Context, string) error {
+	return nil
+}
+
+func (s *stubUserService) RefreshTokenActive(context.Context, string) (bool, error) {
+	return false, nil
+}
+
 func (s *stubUserService) AssignRole(ctx context.Context, userID, role string) error {
 	return s.assignRoleFn(ctx, userID, role)
 }
@@ -183,3 +1167,205 @@ func (s *stubUserService) Permissions(ctx context.Context, userID string) ([]str
 func (s *stubUserService) HasPermission(ctx context.Context, userID, permission string) (bool, error) {
 	return s.hasPermissionFn(ctx, userID, permission)
 }
+
+func (s *stubUserService) CreateAPIKey(ctx context.Context, userID string, req users.CreateAPIKeyRequest) (*users.CreatedAPIKey, error) {
+	return s.createAPIKeyFn(ctx, userID, req)
+}
+
+func (s *stubUserService) ListAPIKeys(context.Context, string) ([]users.APIKey, error) {
+	return nil, nil
+}
+
+func (s *stubUserService) RevokeAPIKey(context.Context, string, string) error {
+	return users.ErrAPIKeyNotFound
+}
+
+func (s *stubUserService) ListSessions(ctx context.Context, userID string) ([]users.Session, error) {
+	return s.listSessionsFn(ctx, userID)
+}
+
+func (s *stubUserService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return s.revokeSessionFn(ctx, userID, sessionID)
+}
+
+func (s *stubUserService) VerifyMFA(ctx context.Context, req users.MFALoginRequest) (*users.AuthenticateResult, error) {
+	return s.verifyMFAFn(ctx, req)
+}
+
+func (s *stubUserService) EnrollMFA(context.Context, string) (*users.MFAEnrollment, error) {
+	return nil, users.ErrMFAAlreadyEnabled
+}
+
+func (s *stubUserService) EnrollMFAWithChallenge(context.Context, string) (*users.MFAEnrollment, error) {
+	return nil, users.ErrTokenInvalid
+}
+
+func (s *stubUserService) ConfirmMFA(context.Context, string, string) ([]string, error) {
+	return nil, users.ErrMFANotEnrolled
+}
+
+func (s *stubUserService) RegenerateRecoveryCodes(context.Context, string, string) ([]string, error) {
+	return nil, users.ErrMFANotEnrolled
+}
+
+func (s *stubUserService) DisableMFA(context.Context, string, string) error {
+	return users.ErrMFANotEnrolled
+}
+
+func (s *stubUserService) BeginPasskeyRegistration(context.Context, string) (*users.PasskeyRegistration, error) {
+	return &users.PasskeyRegistration{CeremonyID: "ceremony"}, nil
+}
+
+func (s *stubUserService) FinishPasskeyRegistration(context.Context, string, string, string, auth.RegistrationResponse) (*users.Passkey, error) {
+	return nil, users.ErrPasskeyInvalid
+}
+
+func (s *stubUserService) BeginPasskeyLogin(context.Context) (*users.PasskeyLogin, error) {
+	return &users.PasskeyLogin{CeremonyID: "ceremony"}, nil
+}
+
+func (s *stubUserService) FinishPasskeyLogin(context.Context, string, auth.AssertionResponse, users.ClientInfo) (*users.AuthenticateResult, error) {
+	return nil, users.ErrPasskeyInvalid
+}
+
+func (s *stubUserService) ListPasskeys(context.Context, string) ([]users.Passkey, error) {
+	return nil, nil
+}
+
+func (s *stubUserService) DeletePasskey(context.Context, string, string) error {
+	return users.ErrPasskeyNotFound
+}
+
+func (s *stubUserService) VerifyEmail(context.Context, string) error {
+	return users.ErrVerificationTokenInvalid
+}
+
+func (s *stubUserService) ForgotPassword(ctx context.Context, email string) error {
+	if s.forgotFn != nil {
+		return s.forgotFn(ctx, email)
+	}
+	return nil
+}
+
+func (s *stubUserService) ResetPassword(_ context.Context, token, _ string) error {
+	if token != "reset-token" {
+		return users.ErrResetTokenInvalid
+	}
+	return nil
+}
+
+func (s *stubUserService) RequestEmailChange(_ context.Context, _, newEmail, _ string) error {
+	if newEmail == "taken@example.com" {
+		return users.ErrEmailTaken
+	}
+	return nil
+}
+
+func (s *stubUserService) ConfirmEmailChange(context.Context, string) error {
+	return users.ErrEmailChangeTokenInvalid
+}
+
+func (s *stubUserService) CancelEmailChange(context.Context, string) error {
+	return nil
+}
+
+func (s *stubUserService) RequestPhoneChange(_ context.Context, _, phone string) error {
+	if _, err := users.NormalizePhone(phone); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *stubUserService) ConfirmPhoneChange(context.Context, string, string) error {
+	return users.ErrPhoneCodeInvalid
+}
+
+func (s *stubUserService) SendPhoneLoginCode(context.Context, string) error {
+	return nil
+}
+
+func (s *stubUserService) PhoneLogin(_ context.Context, _, code string, _ users.ClientInfo) (*users.AuthenticateResult, error) {
+	if code != "123456" {
+		return nil, users.ErrInvalidCredentials
+	}
+	return &users.AuthenticateResult{UserID: "user-1", MFA: &users.MFAChallenge{Token: "mfa-token", ExpiresIn: 300}}, nil
+}
+
+func (s *stubUserService) RequestMagicLink(_ context.Context, email string, _ users.ClientInfo) (*users.MagicLink, error) {
+	if email == "busy@example.com" {
+		return nil, &users.RateLimitError{RetryAfter: time.Minute}
+	}
+	return &users.MagicLink{DeviceToken: "device-token", ExpiresIn: 900}, nil
+}
+
+func (s *stubUserService) RedeemMagicLink(_ context.Context, token, deviceToken string, _ users.ClientInfo) (*users.AuthenticateResult, error) {
+	if token != "link-token" || deviceToken != "device-token" {
+		return nil, users.ErrMagicLinkInvalid
+	}
+	return &users.AuthenticateResult{UserID: "user-1", Tokens: users.TokenPair{AccessToken: "access", RefreshToken: "refresh"}}, nil
+}
+
+func (s *stubUserService) BeginFederatedLogin(_ context.Context, provider string) (*users.FederatedLogin, error) {
+	if provider != "example" {
+		return nil, users.ErrIdentityProviderNotFound
+	}
+	return &users.FederatedLogin{State: "state", AuthorizationURL: "https://idp.example.com/authorize?state=state"}, nil
+}
+
+func (s *stubUserService) FinishFederatedLogin(_ context.Context, _, state, code string, _ users.ClientInfo) (*users.AuthenticateResult, error) {
+	if state != "state" {
+		return nil, users.ErrFederatedLoginInvalid
+	}
+	if code == "taken" {
+		return nil, users.ErrIdentityEmailConflict
+	}
+	return &users.AuthenticateResult{UserID: "user-1", Tokens: users.TokenPair{AccessToken: "access", RefreshToken: "refresh"}}, nil
+}
+
+func (s *stubUserService) BeginIdentityLink(ctx context.Context, _, provider string) (*users.FederatedLogin, error) {
+	return s.BeginFederatedLogin(ctx, provider)
+}
+
+func (s *stubUserService) FinishIdentityLink(_ context.Context, userID, provider, state, _ string) (*users.IdentityLink, error) {
+	if state != "state" {
+		return nil, users.ErrFederatedLoginInvalid
+	}
+	return &users.IdentityLink{ID: "link-1", UserID: userID, Provider: provider, Subject: "subject"}, nil
+}
+
+func (s *stubUserService) ListIdentityLinks(_ context.Context, userID string) ([]users.IdentityLink, error) {
+	return []users.IdentityLink{{ID: "link-1", UserID: userID, Provider: "example", Subject: "subject"}}, nil
+}
+
+func (s *stubUserService) UnlinkIdentity(_ context.Context, _, linkID string) error {
+	if linkID != "link-1" {
+		return users.ErrIdentityLinkNotFound
+	}
+	return nil
+}
+
+func (s *stubUserService) ImportUsers(_ context.Context, batch []users.ImportUser) ([]users.ImportResult, error) {
+	results := make([]users.ImportResult, 0, len(batch))
+	for _, u := range batch {
+		result := users.ImportResult{Email: u.Email, UserID: "user-" + u.Email, Status: users.ImportCreated}
+		if !strings.HasPrefix(u.PasswordHash, "$2a$") {
+			result = users.ImportResult{Email: u.Email, Status: users.ImportFailed, Error: "unsupported password hash format"}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *stubUserService) UnlockUser(_ context.Context, userID string) error {
+	if userID == "missing" {
+		return users.ErrNotFound
+	}
+	return nil
+}
+
+func (s *stubUserService) ResendEmailVerification(ctx context.Context, email string) error {
+	if s.resendFn != nil {
+		return s.resendFn(ctx, email)
+	}
+	return nil
+}
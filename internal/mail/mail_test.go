@@ -0,0 +1,243 @@
+package mail_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/mail"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestRendererLocalizesAndEscapes(t *testing.T) {
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		t.Fatalf("new renderer: %v", err)
+	}
+	version := renderer.Latest(users.NotificationEmailVerification)
+	if version == 0 {
+		t.Fatalf("expected an email verification template")
+	}
+	data := map[string]string{"Name": "<Ana>", "Email": "ana@example.com", "Link": "https://shop.example.com/verify-email?token=abc", "Expires": "1 Jan 2030 00:00 UTC"}
+
+	msg, err := renderer.Render(users.NotificationEmailVerification, version, "en", data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if msg.Subject != "Confirm your email address" {
+		t.Fatalf("unexpected subject %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Hi <Ana>,") || !strings.Contains(msg.Text, data["Link"]) {
+		t.Fatalf("unexpected text body %q", msg.Text)
+	}
+	if !strings.Contains(msg.HTML, "&lt;Ana&gt;") {
+		t.Fatalf("expected the HTML body to escape data, got %q", msg.HTML)
+	}
+
+	regional, err := renderer.Render(users.NotificationEmailVerification, version, "id_ID", data)
+	if err != nil {
+		t.Fatalf("render id_ID: %v", err)
+	}
+	if regional.Subject != "Konfirmasi alamat email Anda" {
+		t.Fatalf("expected the base language template, got %q", regional.Subject)
+	}
+	fallback, err := renderer.Render(users.NotificationEmailVerification, version, "fr", data)
+	if err != nil {
+		t.Fatalf("render fr: %v", err)
+	}
+	if fallback.Subject != msg.Subject {
+		t.Fatalf("expected the default locale, got %q", fallback.Subject)
+	}
+
+	if _, err := renderer.Render(users.NotificationEmailVerification, version+1, "en", data); !errors.Is(err, mail.ErrTemplateNotFound) {
+		t.Fatalf("expected ErrTemplateNotFound for an unknown version, got %v", err)
+	}
+}
+
+func TestTemplatesCoverEveryLocale(t *testing.T) {
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		t.Fatalf("new renderer: %v", err)
+	}
+	for _, kind := range []string{users.NotificationEmailVerification, users.NotificationPasswordReset, users.NotificationPasswordChanged, users.NotificationEmailChangeConfirm, users.NotificationEmailChangeRequested, users.NotificationMagicLink} {
+		for _, locale := range []string{"en", "id"} {
+			msg, err := renderer.Render(kind, renderer.Latest(kind), locale, map[string]string{"Email": "user@example.com"})
+			if err != nil {
+				t.Fatalf("render %s/%s: %v", kind, locale, err)
+			}
+			if msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+				t.Fatalf("expected %s/%s to have a subject, text and HTML", kind, locale)
+			}
+		}
+	}
+}
+
+type memoryOutbox struct {
+	emails []mail.Email
+}
+
+func (m *memoryOutbox) Enqueue(_ context.Context, _ string, email mail.Email) error {
+	m.emails = append(m.emails, email)
+	return nil
+}
+
+func TestNotifierQueuesLinks(t *testing.T) {
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		t.Fatalf("new renderer: %v", err)
+	}
+	outbox := &memoryOutbox{}
+	notifier := mail.NewNotifier(outbox, renderer, nil, "https://shop.example.com/", "")
+
+	err = notifier.Notify(context.Background(), users.Notification{
+		Kind:      users.NotificationPasswordReset,
+		UserID:    "user-1",
+		Email:     "ana@example.com",
+		Token:     "a+b",
+		ExpiresAt: time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if len(outbox.emails) != 1 {
+		t.Fatalf("expected one queued email, got %d", len(outbox.emails))
+	}
+	email := outbox.emails[0]
+	if email.To != "ana@example.com" || email.Template != users.NotificationPasswordReset || email.Version != renderer.Latest(users.NotificationPasswordReset) || email.Locale != mail.DefaultLocale {
+		t.Fatalf("unexpected email %+v", email)
+	}
+	if email.Data["Link"] != "https://shop.example.com/reset-password?token=a%2Bb" {
+		t.Fatalf("unexpected link %q", email.Data["Link"])
+	}
+	if email.Data["Expires"] != "1 Jan 2030 12:00 UTC" {
+		t.Fatalf("unexpected expiry %q", email.Data["Expires"])
+	}
+
+	if err := notifier.Notify(context.Background(), users.Notification{Kind: "unknown"}); !errors.Is(err, mail.ErrTemplateNotFound) {
+		t.Fatalf("expected ErrTemplateNotFound, got %v", err)
+	}
+}
+
+func TestNotifierSealsTemplateData(t *testing.T) {
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		t.Fatalf("new renderer: %v", err)
+	}
+	secrets, err := auth.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("new secret box: %v", err)
+	}
+	outbox := &memoryOutbox{}
+	notifier := mail.NewNotifier(outbox, renderer, secrets, "https://shop.example.com", "")
+
+	err = notifier.Notify(context.Background(), users.Notification{
+		Kind:   users.NotificationMagicLink,
+		UserID: "user-1",
+		Email:  "ana@example.com",
+		Token:  "secret-token",
+	})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	email := outbox.emails[0]
+	if email.Data != nil || email.SealedData == "" || strings.Contains(email.SealedData, "secret-token") {
+		t.Fatalf("expected the template data to be sealed, got %+v", email)
+	}
+
+	data, err := email.TemplateData(secrets)
+	if err != nil {
+		t.Fatalf("open template data: %v", err)
+	}
+	if data["Link"] != "https://shop.example.com/magic-link?token=secret-token" {
+		t.Fatalf("unexpected link %q", data["Link"])
+	}
+
+	email.To = "mallory@example.com"
+	if _, err := email.TemplateData(secrets); err == nil {
+		t.Fatal("expected sealed data not to open for another recipient")
+	}
+	if _, err := email.TemplateData(nil); err == nil {
+		t.Fatal("expected sealed data not to open without a secret box")
+	}
+}
+
+func TestFileMailerWritesMIME(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "Shop <no-reply@shop.example.com>")
+	if err != nil {
+		t.Fatalf("new file mailer: %v", err)
+	}
+	err = mailer.Send(context.Background(), mail.Message{
+		To:      "ana@example.com",
+		Subject: "Kata sandi Anda telah diubah ✓",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %d", len(files))
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	msg, err := netmail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Kata sandi Anda telah diubah ✓" {
+		t.Fatalf("unexpected subject %q: %v", subject, err)
+	}
+	if msg.Header.Get("To") != "ana@example.com" || msg.Header.Get("Message-Id") == "" {
+		t.Fatalf("unexpected headers %v", msg.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q: %v", mediaType, err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+string(body))
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[0], "plain body") || !strings.Contains(bodies[1], "<p>html body</p>") {
+		t.Fatalf("unexpected parts %q", bodies)
+	}
+}
+
+func TestNewSMTPMailerValidatesConfig(t *testing.T) {
+	if _, err := mail.NewSMTPMailer(mail.SMTPConfig{Host: "smtp.example.com"}); err == nil {
+		t.Fatalf("expected a missing sender to be rejected")
+	}
+	if _, err := mail.NewSMTPMailer(mail.SMTPConfig{Host: "smtp.example.com", From: "not an address"}); err == nil {
+		t.Fatalf("expected an invalid sender to be rejected")
+	}
+	if _, err := mail.NewSMTPMailer(mail.SMTPConfig{Host: "smtp.example.com", From: "Shop <no-reply@shop.example.com>"}); err != nil {
+		t.Fatalf("new smtp mailer: %v", err)
+	}
+}
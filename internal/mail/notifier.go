@@ -0,0 +1,92 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+// linkPaths maps notification kinds that carry a token to the page the link opens.
+var linkPaths = map[string]string{
+	users.NotificationEmailVerification:    "/verify-email",
+	users.NotificationPasswordReset:        "/reset-password",
+	users.NotificationEmailChangeConfirm:   "/confirm-email-change",
+	users.NotificationEmailChangeRequested: "/cancel-email-change",
+	users.NotificationMagicLink:            "/magic-link",
+}
+
+// Enqueuer queues emails for asynchronous delivery.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, userID string, email Email) error
+}
+
+// Notifier turns user notifications into queued emails. Each notification kind is rendered
+// with the template of the same name.
+type Notifier struct {
+	outbox   Enqueuer
+	renderer *Renderer
+	secrets  *auth.SecretBox
+	baseURL  string
+	locale   string
+}
+
+// NewNotifier returns a notifier linking to pages under baseURL and writing in locale. With
+// secrets, template data is queued sealed rather than in the clear.
+func NewNotifier(outbox Enqueuer, renderer *Renderer, secrets *auth.SecretBox, baseURL, locale string) *Notifier {
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	return &Notifier{outbox: outbox, renderer: renderer, secrets: secrets, baseURL: strings.TrimRight(baseURL, "/"), locale: locale}
+}
+
+// Notify queues the email for the notification.
+func (n *Notifier) Notify(ctx context.Context, note users.Notification) error {
+	version := n.renderer.Latest(note.Kind)
+	if version == 0 {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, note.Kind)
+	}
+
+	data := map[string]string{
+		"Name":  note.Name,
+		"Email": note.Email,
+	}
+	if note.NewEmail != "" {
+		data["NewEmail"] = note.NewEmail
+	}
+	if !note.ExpiresAt.IsZero() {
+		data["Expires"] = note.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST")
+	}
+	if note.Token != "" {
+		page, ok := linkPaths[note.Kind]
+		if !ok {
+			return fmt.Errorf("no link page for %s notifications", note.Kind)
+		}
+		data["Link"] = n.baseURL + page + "?token=" + url.QueryEscape(note.Token)
+	}
+
+	email := Email{
+		To:       note.Email,
+		Template: note.Kind,
+		Version:  version,
+		Locale:   n.locale,
+		Data:     data,
+	}
+	if n.secrets != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		// The recipient is the context, so sealed data moved to a message for someone else
+		// no longer opens.
+		if email.SealedData, err = n.secrets.Seal(string(raw), email.To); err != nil {
+			return err
+		}
+		email.Data = nil
+	}
+	return n.outbox.Enqueue(ctx, note.UserID, email)
+}
@@ -0,0 +1,192 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// OutboxType marks outbox rows that carry an email.
+const OutboxType = "email.send"
+
+// Email is the outbox payload of a queued message. The template is rendered when the message is
+// sent, with the version it was queued with. Template data, which may hold a link token, is
+// queued in SealedData, encrypted for the recipient, when the notifier has a secret box.
+type Email struct {
+	To         string            `json:"to"`
+	Template   string            `json:"template"`
+	Version    int               `json:"version"`
+	Locale     string            `json:"locale"`
+	Data       map[string]string `json:"data,omitempty"`
+	SealedData string            `json:"sealedData,omitempty"`
+}
+
+// TemplateData returns the data to render the email with, opening SealedData if it is set.
+func (e Email) TemplateData(secrets *auth.SecretBox) (map[string]string, error) {
+	if e.SealedData == "" {
+		return e.Data, nil
+	}
+	if secrets == nil {
+		return nil, errors.New("email data is sealed but no secret box is configured")
+	}
+	raw, err := secrets.Open(e.SealedData, e.To)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]string
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SQLOutbox queues emails in the outbox table.
+type SQLOutbox struct {
+	db *sql.DB
+}
+
+// NewSQLOutbox creates an outbox instance.
+func NewSQLOutbox(db *sql.DB) *SQLOutbox {
+	return &SQLOutbox{db: db}
+}
+
+// Enqueue stores the email for the dispatcher. userID identifies the outbox aggregate.
+func (o *SQLOutbox) Enqueue(ctx context.Context, userID string, email Email) error {
+	payload, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+	_, err = o.db.ExecContext(ctx, `INSERT INTO outbox (aggregate_type, aggregate_id, type, payload) VALUES ('user',$1,$2,$3)`, userID, OutboxType, payload)
+	return err
+}
+
+// claimLease is how long a dispatcher owns the rows it claimed. Rows it has not marked by then,
+// because it crashed mid-batch, are claimed again and sent once more.
+const claimLease = 5 * time.Minute
+
+// Dispatcher sends queued emails. A batch is claimed in one short statement that pushes the
+// rows' available_at past claimLease, so several instances can run side by side without holding
+// locks while talking to the mail server. Each row is then marked on its own once its send has
+// finished. Failed sends are retried with a growing delay; after maxAttempts the message is
+// dropped. Template data, which may hold a link token, is removed from the row once it is sent
+// or dropped.
+type Dispatcher struct {
+	db          *sql.DB
+	secrets     *auth.SecretBox
+	renderer    *Renderer
+	mailer      Mailer
+	logger      *slog.Logger
+	batchSize   int
+	maxAttempts int
+}
+
+// NewDispatcher constructs a dispatcher. secrets opens the template data sealed by the notifier.
+func NewDispatcher(db *sql.DB, secrets *auth.SecretBox, renderer *Renderer, mailer Mailer, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{db: db, secrets: secrets, renderer: renderer, mailer: mailer, logger: logger, batchSize: 20, maxAttempts: 8}
+}
+
+// Run dispatches queued emails every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			sent, err := d.DispatchOnce(ctx)
+			if err != nil {
+				d.logger.Error("dispatch emails", slog.String("error", err.Error()))
+			}
+			if err != nil || sent < d.batchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type queuedEmail struct {
+	id       int64
+	payload  []byte
+	attempts int
+}
+
+// DispatchOnce sends one batch of due emails and returns how many rows it handled.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	batch, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, q := range batch {
+		var email Email
+		err := json.Unmarshal(q.payload, &email)
+		if err == nil {
+			err = d.deliver(ctx, email)
+		}
+		if err == nil {
+			if _, err := d.db.ExecContext(ctx, `UPDATE outbox SET processed=true, payload=payload - 'data' - 'sealedData', last_error=NULL WHERE id=$1`, q.id); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		attempts := q.attempts + 1
+		d.logger.Warn("send email", slog.Int64("outboxId", q.id), slog.Int("attempt", attempts), slog.String("error", err.Error()))
+		if attempts >= d.maxAttempts {
+			_, err = d.db.ExecContext(ctx, `UPDATE outbox SET processed=true, payload=payload - 'data' - 'sealedData', attempts=$2, last_error=$3 WHERE id=$1`, q.id, attempts, err.Error())
+		} else {
+			_, err = d.db.ExecContext(ctx, `UPDATE outbox SET attempts=$2, last_error=$3, available_at=now() + $4 * interval '1 second' WHERE id=$1`, q.id, attempts, err.Error(), retryDelay(attempts).Seconds())
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), nil
+}
+
+// claim leases a batch of due emails to this dispatcher.
+func (d *Dispatcher) claim(ctx context.Context) ([]queuedEmail, error) {
+	rows, err := d.db.QueryContext(ctx, `UPDATE outbox SET available_at=now() + $3 * interval '1 second'
+WHERE id IN (SELECT id FROM outbox WHERE type=$1 AND NOT processed AND available_at <= now() ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED)
+RETURNING id, payload, attempts`, OutboxType, d.batchSize, claimLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []queuedEmail
+	for rows.Next() {
+		var q queuedEmail
+		if err := rows.Scan(&q.id, &q.payload, &q.attempts); err != nil {
+			return nil, err
+		}
+		batch = append(batch, q)
+	}
+	return batch, rows.Err()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, email Email) error {
+	data, err := email.TemplateData(d.secrets)
+	if err != nil {
+		return err
+	}
+	msg, err := d.renderer.Render(email.Template, email.Version, email.Locale, data)
+	if err != nil {
+		return err
+	}
+	msg.To = email.To
+	return d.mailer.Send(ctx, *msg)
+}
+
+// retryDelay grows quadratically from 30 seconds: 30s, 2m, 4.5m, 8m and so on.
+func retryDelay(attempts int) time.Duration {
+	return time.Duration(attempts*attempts) * 30 * time.Second
+}
@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used when a template has no variant for the requested locale.
+const DefaultLocale = "en"
+
+//go:embed templates
+var templateFS embed.FS
+
+var ErrTemplateNotFound = errors.New("mail template not found")
+
+// Renderer renders the embedded templates. Templates live in templates/<name>/v<version>/ with
+// <locale>.subject.tmpl, <locale>.txt.tmpl and <locale>.html.tmpl files. Older versions stay in
+// place so that messages queued before a deploy still render as they were written.
+type Renderer struct {
+	files  fs.FS
+	latest map[string]int
+}
+
+// NewRenderer loads the embedded templates.
+func NewRenderer() (*Renderer, error) {
+	files, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return newRenderer(files)
+}
+
+func newRenderer(files fs.FS) (*Renderer, error) {
+	names, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]int)
+	for _, name := range names {
+		if !name.IsDir() {
+			continue
+		}
+		versions, err := fs.ReadDir(files, name.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			n, err := strconv.Atoi(strings.TrimPrefix(version.Name(), "v"))
+			if err != nil || !version.IsDir() || !strings.HasPrefix(version.Name(), "v") {
+				return nil, fmt.Errorf("unexpected template directory %s/%s", name.Name(), version.Name())
+			}
+			if n > latest[name.Name()] {
+				latest[name.Name()] = n
+			}
+		}
+	}
+	return &Renderer{files: files, latest: latest}, nil
+}
+
+// Latest returns the newest version of the template, or zero when it does not exist.
+func (r *Renderer) Latest(name string) int {
+	return r.latest[name]
+}
+
+// Render renders a template version for the locale, falling back to the base language
+// ("pt-BR" to "pt") and then to DefaultLocale.
+func (r *Renderer) Render(name string, version int, locale string, data map[string]string) (*Message, error) {
+	dir := path.Join(name, "v"+strconv.Itoa(version))
+	locale, err := r.resolveLocale(dir, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := r.renderText(path.Join(dir, locale+".subject.tmpl"), data)
+	if err != nil {
+		return nil, err
+	}
+	text, err := r.renderText(path.Join(dir, locale+".txt.tmpl"), data)
+	if err != nil {
+		return nil, err
+	}
+	html, err := r.renderHTML(path.Join(dir, locale+".html.tmpl"), data)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Subject: strings.TrimSpace(subject), Text: text, HTML: html}, nil
+}
+
+func (r *Renderer) resolveLocale(dir, locale string) (string, error) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	candidates := []string{locale}
+	if base, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, DefaultLocale)
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if _, err := fs.Stat(r.files, path.Join(dir, candidate+".subject.tmpl")); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrTemplateNotFound, dir)
+}
+
+func (r *Renderer) renderText(file string, data map[string]string) (string, error) {
+	tmpl, err := texttemplate.New(path.Base(file)).Option("missingkey=zero").ParseFS(r.files, file)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (r *Renderer) renderHTML(file string, data map[string]string) (string, error) {
+	tmpl, err := htmltemplate.New(path.Base(file)).Option("missingkey=zero").ParseFS(r.files, file)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+const (
+	codeTTL             = 2 * time.Minute
+	codeChallengeMethod = "S256"
+)
+
+// Scopes understood by the OpenID Connect provider.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// ErrInvalidRedirectURI is returned when the redirect URI is not registered. The user agent must
+// not be redirected in that case.
+var ErrInvalidRedirectURI = errors.New("invalid redirect uri")
+
+// AuthorizationRequest captures the parameters of an authorization-code request.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// ValidateAuthorization checks an authorization request before the user is asked to sign in.
+// ErrInvalidClient and ErrInvalidRedirectURI must be shown to the user; *Error values can be
+// reported to the client through ErrorRedirect.
+func (s *Service) ValidateAuthorization(ctx context.Context, req AuthorizationRequest) (*Client, error) {
+	client, err := s.clients.FindClient(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return nil, protocolError(ErrorUnsupportedResponseType, "only the code response type is supported")
+	}
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		return nil, protocolError(ErrorUnauthorizedClient, "client may not use the authorization code grant")
+	}
+	if req.CodeChallenge == "" {
+		return nil, protocolError(ErrorInvalidRequest, "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != codeChallengeMethod {
+		return nil, protocolError(ErrorInvalidRequest, "code_challenge_method must be S256")
+	}
+	for _, scope := range strings.Fields(req.Scope) {
+		if !contains(supportedScopes, scope) && !contains(client.Scopes, scope) {
+			return nil, protocolError(ErrorInvalidScope, "scope %q is not allowed", scope)
+		}
+	}
+
+	return client, nil
+}
+
+// Authorize signs the user in with their credentials and returns the redirect URL carrying a
+// freshly issued authorization code.
+func (s *Service) Authorize(ctx context.Context, req AuthorizationRequest, credentials users.AuthenticateRequest) (string, error) {
+	client, err := s.ValidateAuthorization(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.users.VerifyCredentials(ctx, credentials)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := s.codes.SaveCode(ctx, code, AuthorizationCode{
+		UserID:        user.ID,
+		ClientID:      client.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      time.Now().UTC(),
+	}, codeTTL); err != nil {
+		return "", err
+	}
+
+	return redirectWith(req.RedirectURI, url.Values{"code": {code}}, req.State), nil
+}
+
+// ErrorRedirect builds the redirect URL reporting a protocol error to the client.
+func ErrorRedirect(req AuthorizationRequest, protoErr *Error) string {
+	return redirectWith(req.RedirectURI, url.Values{
+		"error":             {protoErr.Code},
+		"error_description": {protoErr.Description},
+	}, req.State)
+}
+
+func redirectWith(redirectURI string, params url.Values, state string) string {
+	if state != "" {
+		params.Set("state", state)
+	}
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := target.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// verifyPKCE checks an RFC 7636 S256 code verifier against the stored challenge.
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,223 @@
+package oauth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+const (
+	testRedirectURI = "https://shop.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	svc, issuer, _ := newTestService(t)
+	ctx := context.Background()
+
+	req := authorizationRequest()
+	location, err := svc.Authorize(ctx, req, users.AuthenticateRequest{Email: "jane@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	code := redirect.Query().Get("code")
+	if code == "" || redirect.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect: %s", location)
+	}
+
+	res, err := svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+		ClientID:     "storefront",
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" || res.TokenType != "Bearer" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+
+	claims, err := issuer.ParseAndValidate(res.IDToken, auth.WithTokenType(auth.TokenTypeID), auth.WithAudience("storefront"))
+	if err != nil {
+		t.Fatalf("validate id token: %v", err)
+	}
+	if claims["sub"] != "user-1" || claims["nonce"] != "n-0S6_WzA2Mj" || claims["email"] != "jane@example.com" || claims["given_name"] != "Jane" {
+		t.Fatalf("unexpected id token claims: %v", claims)
+	}
+	if _, ok := claims["auth_time"]; !ok {
+		t.Fatal("expected auth_time claim")
+	}
+
+	_, err = svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+		ClientID:     "storefront",
+	})
+	assertProtocolError(t, err, oauth.ErrorInvalidGrant)
+
+	refreshed, err := svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantRefreshToken,
+		RefreshToken: res.RefreshToken,
+		ClientID:     "storefront",
+	})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if refreshed.AccessToken == "" || refreshed.IDToken != "" {
+		t.Fatalf("unexpected refresh response: %+v", refreshed)
+	}
+}
+
+func TestRefreshTokenIsBoundToItsClient(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	location, err := svc.Authorize(ctx, authorizationRequest(), users.AuthenticateRequest{Email: "jane@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	redirect, _ := url.Parse(location)
+	res, err := svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		Code:         redirect.Query().Get("code"),
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+		ClientID:     "storefront",
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+
+	_, err = svc.Exchange(ctx, oauth.TokenRequest{GrantType: oauth.GrantRefreshToken, RefreshToken: res.RefreshToken, ClientID: "kiosk"})
+	assertProtocolError(t, err, oauth.ErrorInvalidGrant)
+
+	if _, err := svc.Exchange(ctx, oauth.TokenRequest{GrantType: oauth.GrantRefreshToken, RefreshToken: res.RefreshToken, ClientID: "storefront"}); err != nil {
+		t.Fatalf("expected the issuing client to refresh: %v", err)
+	}
+}
+
+func TestUserInfoReleasesOnlyGrantedClaims(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	claims, err := svc.UserInfo(ctx, "user-1", "openid email")
+	if err != nil {
+		t.Fatalf("userinfo: %v", err)
+	}
+	if claims["email"] != "jane@example.com" || claims["given_name"] != nil {
+		t.Fatalf("expected only email claims, got %v", claims)
+	}
+
+	claims, err = svc.UserInfo(ctx, "user-1", "openid profile")
+	if err != nil {
+		t.Fatalf("userinfo: %v", err)
+	}
+	if claims["given_name"] != "Jane" || claims["email"] != nil {
+		t.Fatalf("expected only profile claims, got %v", claims)
+	}
+
+	claims, err = svc.UserInfo(ctx, "user-1", "")
+	if err != nil {
+		t.Fatalf("userinfo: %v", err)
+	}
+	if len(claims) != 1 || claims["sub"] != "user-1" {
+		t.Fatalf("expected only the subject without a scope, got %v", claims)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	location, err := svc.Authorize(ctx, authorizationRequest(), users.AuthenticateRequest{Email: "jane@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	redirect, _ := url.Parse(location)
+
+	_, err = svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		Code:         redirect.Query().Get("code"),
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: "wrong-verifier-wrong-verifier-wrong-verifier",
+		ClientID:     "storefront",
+	})
+	assertProtocolError(t, err, oauth.ErrorInvalidGrant)
+}
+
+func TestValidateAuthorization(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	req := authorizationRequest()
+	req.RedirectURI = "https://evil.example.com/callback"
+	if _, err := svc.ValidateAuthorization(ctx, req); !errors.Is(err, oauth.ErrInvalidRedirectURI) {
+		t.Fatalf("expected invalid redirect uri, got %v", err)
+	}
+
+	req = authorizationRequest()
+	req.ClientID = "unknown"
+	if _, err := svc.ValidateAuthorization(ctx, req); !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected invalid client, got %v", err)
+	}
+
+	req = authorizationRequest()
+	req.CodeChallenge = ""
+	_, err := svc.ValidateAuthorization(ctx, req)
+	assertProtocolError(t, err, oauth.ErrorInvalidRequest)
+
+	req = authorizationRequest()
+	req.Scope = "openid admin"
+	_, err = svc.ValidateAuthorization(ctx, req)
+	assertProtocolError(t, err, oauth.ErrorInvalidScope)
+
+	if _, err := svc.Authorize(ctx, authorizationRequest(), users.AuthenticateRequest{Email: "jane@example.com", Password: "nope"}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestExchangeRequiresSecretForConfidentialClients(t *testing.T) {
+	svc, _, _ := newTestService(t)
+
+	_, err := svc.Exchange(context.Background(), oauth.TokenRequest{GrantType: oauth.GrantRefreshToken, ClientID: "orders"})
+	if !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected invalid client, got %v", err)
+	}
+}
+
+func authorizationRequest() oauth.AuthorizationRequest {
+	sum := sha256.Sum256([]byte(testVerifier))
+	return oauth.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            "storefront",
+		RedirectURI:         testRedirectURI,
+		Scope:               "openid profile email",
+		State:               "xyz",
+		Nonce:               "n-0S6_WzA2Mj",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+}
+
+func assertProtocolError(t *testing.T, err error, code string) {
+	t.Helper()
+	var protoErr *oauth.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != code {
+		t.Fatalf("expected %s error, got %v", code, err)
+	}
+}
@@ -0,0 +1,230 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// Grant types understood by the token endpoint.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Client is an OAuth client registered with the service. Clients without a secret are public
+// clients, such as SPAs, and must use PKCE.
+type Client struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	SecretHash   string   `json:"secretHash"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grantTypes"`
+}
+
+// Public reports whether the client has no secret.
+func (c *Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsGrant reports whether the client may use the grant type.
+func (c *Client) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsRedirect reports whether the redirect URI exactly matches a registered one.
+func (c *Client) AllowsRedirect(uri string) bool {
+	return uri != "" && contains(c.RedirectURIs, uri)
+}
+
+// ClientStore looks up registered clients.
+type ClientStore interface {
+	FindClient(ctx context.Context, id string) (*Client, error)
+}
+
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrInvalidClient  = errors.New("invalid client credentials")
+)
+
+// StaticClientStore serves clients defined in configuration.
+type StaticClientStore struct {
+	clients map[string]Client
+}
+
+// NewStaticClientStore constructs a store from the supplied clients.
+func NewStaticClientStore(clients []Client) *StaticClientStore {
+	store := &StaticClientStore{clients: make(map[string]Client, len(clients))}
+	for _, c := range clients {
+		store.clients[c.ID] = c
+	}
+	return store
+}
+
+// LoadClientsFile reads a JSON array of clients. Secrets are stored as Argon2id hashes.
+func LoadClientsFile(path string) (*StaticClientStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var clients []Client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, fmt.Errorf("parse clients file: %w", err)
+	}
+	return NewStaticClientStore(clients), nil
+}
+
+// FindClient returns a client by identifier.
+func (s *StaticClientStore) FindClient(_ context.Context, id string) (*Client, error) {
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	return &c, nil
+}
+
+// SQLClientStore reads clients from the oauth_clients table.
+type SQLClientStore struct {
+	db *sql.DB
+}
+
+// NewSQLClientStore constructs a store backed by database/sql.
+func NewSQLClientStore(db *sql.DB) *SQLClientStore {
+	return &SQLClientStore{db: db}
+}
+
+// FindClient returns a client by identifier.
+func (s *SQLClientStore) FindClient(ctx context.Context, id string) (*Client, error) {
+	query := `SELECT id, name, COALESCE(secret_hash, ''), array_to_json(redirect_uris), array_to_json(scopes), array_to_json(grant_types) FROM oauth_clients WHERE id=$1`
+	c := &Client{}
+	var redirectURIs, scopes, grantTypes []byte
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&c.ID, &c.Name, &c.SecretHash, &redirectURIs, &scopes, &grantTypes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range []struct {
+		raw []byte
+		out *[]string
+	}{{redirectURIs, &c.RedirectURIs}, {scopes, &c.Scopes}, {grantTypes, &c.GrantTypes}} {
+		if err := json.Unmarshal(field.raw, field.out); err != nil {
+			return nil, fmt.Errorf("decode client %q: %w", id, err)
+		}
+	}
+	return c, nil
+}
+
+// CreateClient registers a client. The secret must already be hashed with auth.HashPassword.
+func (s *SQLClientStore) CreateClient(ctx context.Context, c *Client) error {
+	query := `INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, scopes, grant_types) VALUES ($1,$2,NULLIF($3,''),$4,$5,$6)`
+	_, err := s.db.ExecContext(ctx, query, c.ID, c.Name, c.SecretHash, textArray(c.RedirectURIs), textArray(c.Scopes), textArray(c.GrantTypes))
+	return err
+}
+
+// AuthenticateClient verifies a confidential client's credentials.
+func AuthenticateClient(ctx context.Context, store ClientStore, id, secret string) (*Client, error) {
+	return authenticateClient(ctx, store, nil, id, secret)
+}
+
+func authenticateClient(ctx context.Context, store ClientStore, verified *secretCache, id, secret string) (*Client, error) {
+	if id == "" || secret == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := store.FindClient(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if client.SecretHash == "" {
+		return nil, ErrInvalidClient
+	}
+
+	if verified.contains(client, secret) {
+		return client, nil
+	}
+	match, err := auth.VerifyPassword(secret, client.SecretHash)
+	if err != nil || !match {
+		return nil, ErrInvalidClient
+	}
+	verified.add(client, secret)
+	return client, nil
+}
+
+// secretCacheTTL bounds how long a verified client secret is trusted without hashing it again.
+const secretCacheTTL = 5 * time.Minute
+
+// secretCache remembers client secrets that recently passed verification, so that clients
+// calling introspection or revocation on every request do not pay for Argon2id each time.
+// Entries are keyed by a digest of the client, its stored hash and the secret, so a rotated
+// secret is verified afresh; the secrets themselves are not kept.
+type secretCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]time.Time
+}
+
+func newSecretCache() *secretCache {
+	return &secretCache{entries: make(map[[sha256.Size]byte]time.Time)}
+}
+
+func (c *secretCache) contains(client *Client, secret string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.entries[secretKey(client, secret)]
+	return ok && time.Now().Before(expires)
+}
+
+func (c *secretCache) add(client *Client, secret string) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, expires := range c.entries {
+		if !now.Before(expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[secretKey(client, secret)] = now.Add(secretCacheTTL)
+}
+
+func secretKey(client *Client, secret string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(client.ID + "\x00" + client.SecretHash + "\x00" + secret))
+}
+
+// textArray renders a Postgres array literal so slices can be bound without a driver-specific type.
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
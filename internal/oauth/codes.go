@@ -0,0 +1,71 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// AuthorizationCode is the state bound to an issued authorization code.
+type AuthorizationCode struct {
+	UserID        string    `json:"userId"`
+	ClientID      string    `json:"clientId"`
+	RedirectURI   string    `json:"redirectUri"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce"`
+	CodeChallenge string    `json:"codeChallenge"`
+	AuthTime      time.Time `json:"authTime"`
+}
+
+// CodeStore persists authorization codes until they are redeemed once.
+type CodeStore interface {
+	SaveCode(ctx context.Context, code string, data AuthorizationCode, ttl time.Duration) error
+	ConsumeCode(ctx context.Context, code string) (*AuthorizationCode, error)
+}
+
+var ErrCodeNotFound = errors.New("authorization code not found")
+
+// RedisCodeStore keeps authorization codes in Redis keyed by their hash.
+type RedisCodeStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCodeStore constructs a Redis-backed code store.
+func NewRedisCodeStore(client *redis.Client) *RedisCodeStore {
+	return &RedisCodeStore{client: client, prefix: "oauth:code"}
+}
+
+// SaveCode stores the code state with the given TTL.
+func (s *RedisCodeStore) SaveCode(ctx context.Context, code string, data AuthorizationCode, ttl time.Duration) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.key(code), body, ttl).Err()
+}
+
+// ConsumeCode atomically fetches and deletes the code so it can only be redeemed once.
+func (s *RedisCodeStore) ConsumeCode(ctx context.Context, code string) (*AuthorizationCode, error) {
+	body, err := s.client.GetDel(ctx, s.key(code)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data AuthorizationCode
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *RedisCodeStore) key(code string) string {
+	return s.prefix + ":" + auth.HashToken(code)
+}
@@ -0,0 +1,28 @@
+package oauth
+
+import "fmt"
+
+// Error codes defined by RFC 6749 section 4.1.2.1 and 5.2.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorAccessDenied            = "access_denied"
+)
+
+// Error is an OAuth protocol error that is reported to the client using its RFC error code.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func protocolError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Description: fmt.Sprintf(format, args...)}
+}
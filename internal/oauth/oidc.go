@@ -0,0 +1,84 @@
+package oauth
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+// Discovery is the OpenID Provider metadata document (OpenID Connect Discovery 1.0 section 3).
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Discovery describes the provider. Endpoints are rooted at the issuer when it is a URL and at
+// baseURL, the externally visible origin of the request, otherwise.
+func (s *Service) Discovery(baseURL string) Discovery {
+	issuer := s.issuer.Issuer()
+	root := strings.TrimSuffix(baseURL, "/")
+	if u, err := url.Parse(issuer); err == nil && u.Scheme != "" && u.Host != "" {
+		root = strings.TrimSuffix(issuer, "/")
+	}
+
+	return Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             root + "/oauth/authorize",
+		TokenEndpoint:                     root + "/oauth/token",
+		UserInfoEndpoint:                  root + "/userinfo",
+		JWKSURI:                           root + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             root + "/oauth/introspect",
+		RevocationEndpoint:                root + "/oauth/revoke",
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  s.issuer.KeyRing().Algorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeMethod},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "given_name", "family_name"},
+	}
+}
+
+// UserInfo returns the standard claims for the authenticated user that scope, the scope of the
+// presented access token, grants.
+func (s *Service) UserInfo(ctx context.Context, userID, scope string) (map[string]any, error) {
+	profile, err := s.users.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return profileClaims(profile, scope), nil
+}
+
+// profileClaims maps a profile to the OpenID Connect standard claims granted by scope.
+func profileClaims(profile *users.Profile, scope string) map[string]any {
+	claims := map[string]any{"sub": profile.ID}
+	if hasScope(scope, ScopeEmail) {
+		claims["email"] = profile.Email
+		claims["email_verified"] = profile.EmailVerified
+	}
+	if hasScope(scope, ScopeProfile) {
+		if profile.FirstName != "" {
+			claims["given_name"] = profile.FirstName
+		}
+		if profile.LastName != "" {
+			claims["family_name"] = profile.LastName
+		}
+	}
+	return claims
+}
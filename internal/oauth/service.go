@@ -0,0 +1,183 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+// Token type hints accepted by the introspection and revocation endpoints (RFC 7009 section 2.1).
+const (
+	HintAccessToken  = "access_token"
+	HintRefreshToken = "refresh_token"
+)
+
+// UserService is the part of the user service the OAuth endpoints build on.
+type UserService interface {
+	VerifyCredentials(ctx context.Context, req users.AuthenticateRequest) (*users.User, error)
+	IssueTokensForUser(ctx context.Context, userID string, grant users.OAuthGrant, client users.ClientInfo) (*users.TokenPair, error)
+	Refresh(ctx context.Context, req users.RefreshRequest) (*users.AuthenticateResult, error)
+	GetProfile(ctx context.Context, userID string) (*users.Profile, error)
+	Logout(ctx context.Context, token string) error
+	RevokeRefreshToken(ctx context.Context, token string) error
+	RefreshTokenActive(ctx context.Context, token string) (bool, error)
+}
+
+// Service implements the OAuth 2.0 endpoints used by other services in the platform.
+type Service struct {
+	clients     ClientStore
+	issuer      *auth.TokenIssuer
+	blacklist   auth.TokenBlacklist
+	users       UserService
+	codes       CodeStore
+	generations auth.TokenGenerations
+	sessions    auth.SessionRevocations
+	verified    *secretCache
+}
+
+// Option configures optional service dependencies.
+type Option func(*Service)
+
+// WithTokenGenerations reports user tokens issued before a "log out everywhere" as inactive.
+func WithTokenGenerations(generations auth.TokenGenerations) Option {
+	return func(s *Service) {
+		s.generations = generations
+	}
+}
+
+// WithSessionRevocations reports access tokens of individually revoked sessions as inactive.
+func WithSessionRevocations(revocations auth.SessionRevocations) Option {
+	return func(s *Service) {
+		s.sessions = revocations
+	}
+}
+
+// NewService constructs the OAuth service.
+func NewService(clients ClientStore, issuer *auth.TokenIssuer, blacklist auth.TokenBlacklist, userService UserService, codes CodeStore, opts ...Option) *Service {
+	s := &Service{clients: clients, issuer: issuer, blacklist: blacklist, users: userService, codes: codes, verified: newSecretCache()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Introspection is the RFC 7662 introspection response.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Type      string `json:"typ,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	TokenID   string `json:"jti,omitempty"`
+}
+
+// AuthenticateClient verifies the credentials of a calling client. Secrets that passed
+// verification within the last few minutes are accepted without hashing them again.
+func (s *Service) AuthenticateClient(ctx context.Context, id, secret string) (*Client, error) {
+	return authenticateClient(ctx, s.clients, s.verified, id, secret)
+}
+
+// Introspect reports whether a token is currently active. Invalid, expired and revoked
+// tokens all yield an inactive response rather than an error. Only access and refresh tokens
+// can be active: ID tokens and MFA challenges do not authorize requests.
+func (s *Service) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	inactive := &Introspection{Active: false}
+
+	claims, err := s.issuer.ParseAndValidate(token)
+	if err != nil {
+		return inactive, nil
+	}
+
+	typ, _ := claims["typ"].(string)
+	switch typ {
+	case auth.TokenTypeRefresh:
+		active, err := s.users.RefreshTokenActive(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return inactive, nil
+		}
+	case auth.TokenTypeAccess:
+		if s.blacklist != nil {
+			revoked, err := s.blacklist.IsBlacklisted(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			if revoked {
+				return inactive, nil
+			}
+		}
+		if s.generations != nil {
+			if err := auth.CheckGeneration(ctx, s.generations, claims); err != nil {
+				if errors.Is(err, auth.ErrTokenStale) {
+					return inactive, nil
+				}
+				return nil, err
+			}
+		}
+		if s.sessions != nil {
+			if err := auth.CheckSession(ctx, s.sessions, claims); err != nil {
+				if errors.Is(err, auth.ErrSessionRevoked) {
+					return inactive, nil
+				}
+				return nil, err
+			}
+		}
+	default:
+		return inactive, nil
+	}
+
+	return &Introspection{
+		Active:    true,
+		Subject:   stringClaim(claims, "sub"),
+		ClientID:  stringClaim(claims, "client_id"),
+		Scope:     stringClaim(claims, "scope"),
+		Type:      typ,
+		ExpiresAt: numericClaim(claims, "exp"),
+		IssuedAt:  numericClaim(claims, "iat"),
+		Issuer:    stringClaim(claims, "iss"),
+		TokenID:   stringClaim(claims, "jti"),
+	}, nil
+}
+
+// Revoke invalidates an access or refresh token issued to client. Per RFC 7009, tokens that are
+// already invalid are not an error, but tokens issued to another client, or to first-party
+// logins, are refused (section 2.1).
+func (s *Service) Revoke(ctx context.Context, client *Client, token, hint string) error {
+	claims, err := s.issuer.ParseAndValidate(token)
+	if err != nil {
+		return nil
+	}
+	if stringClaim(claims, "client_id") != client.ID {
+		return protocolError(ErrorUnauthorizedClient, "token was not issued to this client")
+	}
+
+	typ, _ := claims["typ"].(string)
+	if typ == auth.TokenTypeRefresh || (typ == "" && hint == HintRefreshToken) {
+		err = s.users.RevokeRefreshToken(ctx, token)
+	} else {
+		err = s.users.Logout(ctx, token)
+	}
+	if err != nil && !errors.Is(err, users.ErrTokenInvalid) {
+		return err
+	}
+	return nil
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+func numericClaim(claims jwt.MapClaims, name string) int64 {
+	value, _ := claims[name].(float64)
+	return int64(value)
+}
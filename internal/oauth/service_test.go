@@ -0,0 +1,345 @@
+package oauth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestIntrospectAndRevokeAccessToken(t *testing.T) {
+	svc, issuer, tokens := newTestService(t)
+	ctx := context.Background()
+
+	access, err := issuer.GenerateAccessToken("user-1", map[string]any{"scope": "profile", "client_id": "orders"})
+	if err != nil {
+		t.Fatalf("generate access token: %v", err)
+	}
+
+	res, err := svc.Introspect(ctx, access)
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	if !res.Active || res.Subject != "user-1" || res.Type != auth.TokenTypeAccess || res.Scope != "profile" || res.ExpiresAt == 0 {
+		t.Fatalf("unexpected introspection: %+v", res)
+	}
+
+	assertProtocolError(t, svc.Revoke(ctx, &oauth.Client{ID: "storefront"}, access, ""), oauth.ErrorUnauthorizedClient)
+	if tokens.loggedOut[access] {
+		t.Fatal("expected another client's revocation to be refused")
+	}
+
+	if err := svc.Revoke(ctx, &oauth.Client{ID: "orders"}, access, ""); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if !tokens.loggedOut[access] {
+		t.Fatal("expected access token to be logged out")
+	}
+
+	res, err = svc.Introspect(ctx, access)
+	if err != nil {
+		t.Fatalf("introspect after revoke: %v", err)
+	}
+	if res.Active {
+		t.Fatal("expected revoked token to be inactive")
+	}
+}
+
+func TestIntrospectRefreshToken(t *testing.T) {
+	svc, issuer, tokens := newTestService(t)
+	ctx := context.Background()
+
+	refresh, err := issuer.GenerateRefreshToken("user-1", map[string]any{"client_id": "storefront"})
+	if err != nil {
+		t.Fatalf("generate refresh token: %v", err)
+	}
+	tokens.activeRefresh[refresh] = true
+
+	res, err := svc.Introspect(ctx, refresh)
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	if !res.Active || res.Type != auth.TokenTypeRefresh {
+		t.Fatalf("expected active refresh token: %+v", res)
+	}
+
+	assertProtocolError(t, svc.Revoke(ctx, &oauth.Client{ID: "orders"}, refresh, oauth.HintRefreshToken), oauth.ErrorUnauthorizedClient)
+	if !tokens.activeRefresh[refresh] {
+		t.Fatal("expected another client's revocation to be refused")
+	}
+
+	if err := svc.Revoke(ctx, &oauth.Client{ID: "storefront"}, refresh, oauth.HintRefreshToken); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	res, err = svc.Introspect(ctx, refresh)
+	if err != nil {
+		t.Fatalf("introspect after revoke: %v", err)
+	}
+	if res.Active {
+		t.Fatal("expected revoked refresh token to be inactive")
+	}
+}
+
+func TestIntrospectMFAChallengeIsInactive(t *testing.T) {
+	svc, issuer, _ := newTestService(t)
+
+	challenge, err := issuer.GenerateMFAToken("user-1", nil)
+	if err != nil {
+		t.Fatalf("generate mfa token: %v", err)
+	}
+	res, err := svc.Introspect(context.Background(), challenge)
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	if res.Active {
+		t.Fatalf("expected an mfa challenge to be inactive, got %+v", res)
+	}
+}
+
+func TestIntrospectInvalidToken(t *testing.T) {
+	svc, _, _ := newTestService(t)
+
+	res, err := svc.Introspect(context.Background(), "not-a-jwt")
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	if res.Active {
+		t.Fatal("expected garbage token to be inactive")
+	}
+	if err := svc.Revoke(context.Background(), &oauth.Client{ID: "orders"}, "not-a-jwt", ""); err != nil {
+		t.Fatalf("expected revoking an invalid token to succeed, got %v", err)
+	}
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	if _, err := svc.AuthenticateClient(ctx, "orders", "orders-secret"); err != nil {
+		t.Fatalf("authenticate client: %v", err)
+	}
+	if _, err := svc.AuthenticateClient(ctx, "orders", "wrong"); !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected invalid client for wrong secret, got %v", err)
+	}
+	if _, err := svc.AuthenticateClient(ctx, "unknown", "orders-secret"); !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected invalid client for unknown id, got %v", err)
+	}
+}
+
+func TestAuthenticateClientAfterSecretRotation(t *testing.T) {
+	hash := func(secret string) string {
+		h, err := auth.HashPassword(secret)
+		if err != nil {
+			t.Fatalf("hash secret: %v", err)
+		}
+		return h
+	}
+	store := &rotatingClients{client: oauth.Client{ID: "orders", SecretHash: hash("old-secret")}}
+	svc := oauth.NewService(store, nil, nil, nil, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.AuthenticateClient(ctx, "orders", "old-secret"); err != nil {
+			t.Fatalf("authenticate client: %v", err)
+		}
+	}
+	if _, err := svc.AuthenticateClient(ctx, "orders", "wrong"); !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected invalid client for wrong secret, got %v", err)
+	}
+
+	store.client.SecretHash = hash("new-secret")
+	if _, err := svc.AuthenticateClient(ctx, "orders", "old-secret"); !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected the rotated secret to be refused, got %v", err)
+	}
+	if _, err := svc.AuthenticateClient(ctx, "orders", "new-secret"); err != nil {
+		t.Fatalf("authenticate with new secret: %v", err)
+	}
+}
+
+type rotatingClients struct {
+	client oauth.Client
+}
+
+func (r *rotatingClients) FindClient(_ context.Context, id string) (*oauth.Client, error) {
+	if id != r.client.ID {
+		return nil, oauth.ErrClientNotFound
+	}
+	client := r.client
+	return &client, nil
+}
+
+func newTestService(t *testing.T) (*oauth.Service, *auth.TokenIssuer, *stubUsers) {
+	t.Helper()
+
+	priv, pub := generateKeyPair(t)
+	issuer, err := auth.NewTokenIssuer(priv, pub, "svc-user", []string{"users"})
+	if err != nil {
+		t.Fatalf("new token issuer: %v", err)
+	}
+
+	hash, err := auth.HashPassword("orders-secret")
+	if err != nil {
+		t.Fatalf("hash secret: %v", err)
+	}
+	clients := oauth.NewStaticClientStore([]oauth.Client{
+		{ID: "orders", SecretHash: hash, Scopes: []string{"roles:view", "users:read"}, GrantTypes: []string{oauth.GrantClientCredentials}},
+		{
+			ID:           "storefront",
+			Name:         "Storefront",
+			RedirectURIs: []string{"https://shop.example.com/callback"},
+			GrantTypes:   []string{oauth.GrantAuthorizationCode, oauth.GrantRefreshToken},
+		},
+		{
+			ID:           "kiosk",
+			RedirectURIs: []string{"https://kiosk.example.com/callback"},
+			GrantTypes:   []string{oauth.GrantAuthorizationCode, oauth.GrantRefreshToken},
+		},
+	})
+
+	blacklist := &memoryBlacklist{tokens: make(map[string]struct{})}
+	tokens := &stubUsers{
+		issuer:        issuer,
+		blacklist:     blacklist,
+		loggedOut:     make(map[string]bool),
+		activeRefresh: make(map[string]bool),
+		refreshClient: make(map[string]string),
+	}
+	return oauth.NewService(clients, issuer, blacklist, tokens, newMemoryCodes()), issuer, tokens
+}
+
+type stubUsers struct {
+	issuer        *auth.TokenIssuer
+	blacklist     *memoryBlacklist
+	loggedOut     map[string]bool
+	activeRefresh map[string]bool
+	refreshClient map[string]string
+}
+
+func (s *stubUsers) VerifyCredentials(_ context.Context, req users.AuthenticateRequest) (*users.User, error) {
+	if req.Email != "jane@example.com" || req.Password != "correct horse" {
+		return nil, users.ErrInvalidCredentials
+	}
+	return &users.User{ID: "user-1", Email: req.Email}, nil
+}
+
+func (s *stubUsers) IssueTokensForUser(_ context.Context, userID string, grant users.OAuthGrant, _ users.ClientInfo) (*users.TokenPair, error) {
+	access, err := s.issuer.GenerateAccessToken(userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := s.issuer.GenerateRefreshToken(userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.activeRefresh[refresh] = true
+	s.refreshClient[refresh] = grant.ClientID
+	return &users.TokenPair{AccessToken: access, RefreshToken: refresh, AccessExpiresIn: 900}, nil
+}
+
+func (s *stubUsers) Refresh(ctx context.Context, req users.RefreshRequest) (*users.AuthenticateResult, error) {
+	if s.refreshClient[req.RefreshToken] != req.ClientID {
+		return nil, users.ErrTokenInvalid
+	}
+	if !s.activeRefresh[req.RefreshToken] {
+		return nil, users.ErrTokenRevoked
+	}
+	s.activeRefresh[req.RefreshToken] = false
+	tokens, err := s.IssueTokensForUser(ctx, "user-1", users.OAuthGrant{ClientID: req.ClientID}, req.Client)
+	if err != nil {
+		return nil, err
+	}
+	return &users.AuthenticateResult{UserID: "user-1", Tokens: *tokens}, nil
+}
+
+func (s *stubUsers) GetProfile(_ context.Context, userID string) (*users.Profile, error) {
+	return &users.Profile{ID: userID, Email: "jane@example.com", FirstName: "Jane", EmailVerified: true}, nil
+}
+
+func (s *stubUsers) Logout(ctx context.Context, token string) error {
+	s.loggedOut[token] = true
+	return s.blacklist.Revoke(ctx, token, time.Minute)
+}
+
+func (s *stubUsers) RevokeRefreshToken(_ context.Context, token string) error {
+	if !s.activeRefresh[token] {
+		return users.ErrTokenInvalid
+	}
+	s.activeRefresh[token] = false
+	return nil
+}
+
+func (s *stubUsers) RefreshTokenActive(_ context.Context, token string) (bool, error) {
+	return s.activeRefresh[token], nil
+}
+
+type memoryCodes struct {
+	mu    sync.Mutex
+	codes map[string]oauth.AuthorizationCode
+}
+
+func newMemoryCodes() *memoryCodes {
+	return &memoryCodes{codes: make(map[string]oauth.AuthorizationCode)}
+}
+
+func (m *memoryCodes) SaveCode(_ context.Context, code string, data oauth.AuthorizationCode, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code] = data
+	return nil
+}
+
+func (m *memoryCodes) ConsumeCode(_ context.Context, code string) (*oauth.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.codes[code]
+	if !ok {
+		return nil, oauth.ErrCodeNotFound
+	}
+	delete(m.codes, code)
+	return &data, nil
+}
+
+type memoryBlacklist struct {
+	mu     sync.Mutex
+	tokens map[string]struct{}
+}
+
+func (m *memoryBlacklist) Revoke(_ context.Context, token string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[token] = struct{}{}
+	return nil
+}
+
+func (m *memoryBlacklist) IsBlacklisted(_ context.Context, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.tokens[token]
+	return ok, nil
+}
+
+func generateKeyPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+	return privPEM, pubPEM
+}
@@ -0,0 +1,191 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+// TokenRequest carries the form parameters of a token endpoint call.
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientID     string
+	ClientSecret string
+	Client       users.ClientInfo
+}
+
+// TokenResponse is the RFC 6749 section 5.1 token response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Exchange redeems an authorization code or refresh token for a new token set. Client
+// authentication failures return ErrInvalidClient; grant problems are reported as *Error.
+func (s *Service) Exchange(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	client, err := s.tokenClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		return s.exchangeCode(ctx, client, req)
+	case GrantRefreshToken:
+		return s.exchangeRefreshToken(ctx, client, req)
+	case GrantClientCredentials:
+		return s.exchangeClientCredentials(client, req)
+	default:
+		return nil, protocolError(ErrorUnsupportedGrantType, "grant type %q is not supported", req.GrantType)
+	}
+}
+
+// tokenClient authenticates confidential clients and identifies public ones. Clients holding
+// a secret must always present it.
+func (s *Service) tokenClient(ctx context.Context, id, secret string) (*Client, error) {
+	if secret != "" {
+		return s.AuthenticateClient(ctx, id, secret)
+	}
+
+	client, err := s.clients.FindClient(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if !client.Public() {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+func (s *Service) exchangeCode(ctx context.Context, client *Client, req TokenRequest) (*TokenResponse, error) {
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		return nil, protocolError(ErrorUnauthorizedClient, "client may not use the authorization code grant")
+	}
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, protocolError(ErrorInvalidRequest, "code and code_verifier are required")
+	}
+
+	code, err := s.codes.ConsumeCode(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, ErrCodeNotFound) {
+			return nil, protocolError(ErrorInvalidGrant, "authorization code is invalid or expired")
+		}
+		return nil, err
+	}
+	if code.ClientID != client.ID || code.RedirectURI != req.RedirectURI {
+		return nil, protocolError(ErrorInvalidGrant, "authorization code was issued to another client or redirect uri")
+	}
+	if !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		return nil, protocolError(ErrorInvalidGrant, "code_verifier does not match code_challenge")
+	}
+
+	tokens, err := s.users.IssueTokensForUser(ctx, code.UserID, users.OAuthGrant{ClientID: client.ID, Scope: code.Scope}, req.Client)
+	if err != nil {
+		if errors.Is(err, users.ErrUserDisabled) {
+			return nil, protocolError(ErrorInvalidGrant, "user is disabled")
+		}
+		if errors.Is(err, users.ErrEmailNotVerified) {
+			return nil, protocolError(ErrorInvalidGrant, "user email is not verified")
+		}
+		return nil, err
+	}
+
+	res := tokenResponse(tokens, code.Scope)
+	if hasScope(code.Scope, ScopeOpenID) {
+		res.IDToken, err = s.idToken(ctx, client.ID, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (s *Service) exchangeRefreshToken(ctx context.Context, client *Client, req TokenRequest) (*TokenResponse, error) {
+	if !client.AllowsGrant(GrantRefreshToken) {
+		return nil, protocolError(ErrorUnauthorizedClient, "client may not use the refresh token grant")
+	}
+	if req.RefreshToken == "" {
+		return nil, protocolError(ErrorInvalidRequest, "refresh_token is required")
+	}
+
+	result, err := s.users.Refresh(ctx, users.RefreshRequest{RefreshToken: req.RefreshToken, Client: req.Client, ClientID: client.ID})
+	if err != nil {
+		if errors.Is(err, users.ErrTokenInvalid) || errors.Is(err, users.ErrTokenRevoked) || errors.Is(err, users.ErrUserDisabled) || errors.Is(err, users.ErrEmailNotVerified) {
+			return nil, protocolError(ErrorInvalidGrant, "refresh token is invalid or revoked")
+		}
+		return nil, err
+	}
+	return tokenResponse(&result.Tokens, ""), nil
+}
+
+// exchangeClientCredentials issues a service access token whose subject is the client. Only
+// confidential clients may use it; the granted scopes default to everything registered.
+func (s *Service) exchangeClientCredentials(client *Client, req TokenRequest) (*TokenResponse, error) {
+	if client.Public() || !client.AllowsGrant(GrantClientCredentials) {
+		return nil, protocolError(ErrorUnauthorizedClient, "client may not use the client credentials grant")
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		for _, scope := range scopes {
+			if !contains(client.Scopes, scope) {
+				return nil, protocolError(ErrorInvalidScope, "scope %q is not allowed", scope)
+			}
+		}
+	}
+
+	token, err := s.issuer.GenerateServiceToken(client.ID, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.issuer.AccessTokenTTL().Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// idToken mints the ID token for a redeemed code, including the claims granted by its scopes.
+func (s *Service) idToken(ctx context.Context, clientID string, code *AuthorizationCode) (string, error) {
+	profile, err := s.users.GetProfile(ctx, code.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	claims := profileClaims(profile, code.Scope)
+	claims["auth_time"] = code.AuthTime.Unix()
+	if code.Nonce != "" {
+		claims["nonce"] = code.Nonce
+	}
+	return s.issuer.GenerateIDToken(code.UserID, clientID, claims)
+}
+
+func tokenResponse(tokens *users.TokenPair, scope string) *TokenResponse {
+	return &TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.AccessExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		Scope:        scope,
+	}
+}
+
+func hasScope(scope, want string) bool {
+	return contains(strings.Fields(scope), want)
+}
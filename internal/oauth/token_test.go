@@ -0,0 +1,73 @@
+package oauth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/oauth"
+)
+
+func TestClientCredentialsGrant(t *testing.T) {
+	svc, issuer, _ := newTestService(t)
+	ctx := context.Background()
+
+	res, err := svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantClientCredentials,
+		Scope:        "roles:view",
+		ClientID:     "orders",
+		ClientSecret: "orders-secret",
+	})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if res.RefreshToken != "" || res.Scope != "roles:view" {
+		t.Fatalf("unexpected token response: %+v", res)
+	}
+
+	principal, err := issuer.PrincipalFromToken(res.AccessToken, auth.WithTokenType(auth.TokenTypeAccess))
+	if err != nil {
+		t.Fatalf("principal from token: %v", err)
+	}
+	if !principal.IsService() || principal.Subject != "orders" || !principal.HasScope("roles:view") || principal.HasScope("users:read") {
+		t.Fatalf("unexpected principal: %+v", principal)
+	}
+
+	introspection, err := svc.Introspect(ctx, res.AccessToken)
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	if !introspection.Active || introspection.ClientID != "orders" {
+		t.Fatalf("unexpected introspection: %+v", introspection)
+	}
+
+	all, err := svc.Exchange(ctx, oauth.TokenRequest{GrantType: oauth.GrantClientCredentials, ClientID: "orders", ClientSecret: "orders-secret"})
+	if err != nil {
+		t.Fatalf("exchange without scope: %v", err)
+	}
+	if all.Scope != "roles:view users:read" {
+		t.Fatalf("expected all registered scopes, got %q", all.Scope)
+	}
+}
+
+func TestClientCredentialsGrantRejections(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	_, err := svc.Exchange(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantClientCredentials,
+		Scope:        "roles:assign",
+		ClientID:     "orders",
+		ClientSecret: "orders-secret",
+	})
+	assertProtocolError(t, err, oauth.ErrorInvalidScope)
+
+	_, err = svc.Exchange(ctx, oauth.TokenRequest{GrantType: oauth.GrantClientCredentials, ClientID: "storefront"})
+	assertProtocolError(t, err, oauth.ErrorUnauthorizedClient)
+
+	_, err = svc.Exchange(ctx, oauth.TokenRequest{GrantType: oauth.GrantClientCredentials, ClientID: "orders", ClientSecret: "wrong"})
+	if !errors.Is(err, oauth.ErrInvalidClient) {
+		t.Fatalf("expected invalid client, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// APIKeyPrefix starts every API key so they can be told apart from JWTs and spotted by secret scanners.
+const APIKeyPrefix = "sk_"
+
+// apiKeyTouchInterval limits how often last_used_at is written for a busy key.
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is a long-lived credential owned by a user. Only the hash of the secret is stored;
+// Prefix is the public part shown in listings to identify the key.
+type APIKey struct {
+	ID         string
+	UserID     string
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+	CreatedAt  time.Time
+}
+
+// APIKeyRepository stores API keys.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	FindAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// CreateAPIKeyRequest describes a new API key. Empty scopes grant the owner's full access.
+type CreateAPIKeyRequest struct {
+	Name      string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// CreatedAPIKey carries the plaintext key, which is only available at creation time.
+type CreatedAPIKey struct {
+	APIKey
+	Secret string
+}
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInvalid  = errors.New("invalid api key")
+)
+
+// SQLAPIKeyRepository persists API keys in the api_keys table.
+type SQLAPIKeyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLAPIKeyRepository creates an API key repository instance.
+func NewSQLAPIKeyRepository(db *sql.DB) *SQLAPIKeyRepository {
+	return &SQLAPIKeyRepository{db: db}
+}
+
+// CreateAPIKey inserts a new API key record.
+func (r *SQLAPIKeyRepository) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	query := `INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING created_at`
+	return r.db.QueryRowContext(ctx, query, k.ID, k.UserID, k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, " "), k.ExpiresAt).
+		Scan(&k.CreatedAt)
+}
+
+// FindAPIKey returns an API key by the hash of its value.
+func (r *SQLAPIKeyRepository) FindAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	query := `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE key_hash=$1`
+	k, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return k, err
+}
+
+// ListAPIKeys returns a user's keys, newest first, including revoked ones.
+func (r *SQLAPIKeyRepository) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	query := `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE user_id=$1 ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of the user's active keys.
+func (r *SQLAPIKeyRepository) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records when the key was last used.
+func (r *SQLAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at=$1 WHERE id=$2`, usedAt, id)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	k := &APIKey{}
+	var scopes string
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	return k, nil
+}
+
+// CreateAPIKey mints a new key for the user. The returned secret is never retrievable again.
+func (s *Service) CreateAPIKey(ctx context.Context, userID string, req CreateAPIKeyRequest) (*CreatedAPIKey, error) {
+	if s.apiKeys == nil {
+		return nil, errors.New("api keys not configured")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	prefix, secret, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	key := APIKey{
+		ID:      uuid.NewString(),
+		UserID:  userID,
+		Name:    name,
+		Prefix:  prefix,
+		KeyHash: auth.HashToken(secret),
+		Scopes:  req.Scopes,
+	}
+	if req.ExpiresAt != nil {
+		key.ExpiresAt = sql.NullTime{Time: req.ExpiresAt.UTC(), Valid: true}
+	}
+	if err := s.apiKeys.CreateAPIKey(ctx, &key); err != nil {
+		return nil, err
+	}
+	return &CreatedAPIKey{APIKey: key, Secret: secret}, nil
+}
+
+// ListAPIKeys returns the user's keys without their secrets.
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	if s.apiKeys == nil {
+		return nil, nil
+	}
+	return s.apiKeys.ListAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey revokes one of the user's keys.
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	if s.apiKeys == nil {
+		return ErrAPIKeyNotFound
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrAPIKeyNotFound
+	}
+	return s.apiKeys.RevokeAPIKey(ctx, userID, id)
+}
+
+// AuthenticateAPIKey resolves a presented key to the principal of its owner. Revoked, expired
+// and unknown keys, and keys of disabled users, all yield ErrAPIKeyInvalid.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, secret string) (*auth.Principal, error) {
+	if s.apiKeys == nil || !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	key, err := s.apiKeys.FindAPIKey(ctx, auth.HashToken(secret))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, ErrAPIKeyInvalid
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if key.RevokedAt.Valid || (key.ExpiresAt.Valid && !now.Before(key.ExpiresAt.Time)) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	user, err := s.repo.FindByID(ctx, key.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrAPIKeyInvalid
+		}
+		return nil, err
+	}
+	if user.Status == "disabled" {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if !key.LastUsedAt.Valid || now.Sub(key.LastUsedAt.Time) >= apiKeyTouchInterval {
+		// Usage tracking must not fail the request.
+		_ = s.apiKeys.TouchAPIKey(ctx, key.ID, now)
+	}
+
+	return &auth.Principal{
+		Subject:  key.UserID,
+		Type:     auth.PrincipalUser,
+		Scopes:   key.Scopes,
+		APIKeyID: key.ID,
+	}, nil
+}
+
+// generateAPIKey returns the public prefix and the full key "sk_<prefix>_<secret>".
+func generateAPIKey() (string, string, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix := APIKeyPrefix + hex.EncodeToString(id)
+	return prefix, prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,101 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	repo := newMemoryRepo()
+	ctx := context.Background()
+	svc := users.NewService(repo, nil, nil, nil, users.WithAPIKeys(repo))
+
+	user := &users.User{Email: "merchant@example.com", Status: "active"}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	created, err := svc.CreateAPIKey(ctx, user.ID, users.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"roles:view"}})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if !strings.HasPrefix(created.Secret, created.Prefix+"_") || !strings.HasPrefix(created.Prefix, users.APIKeyPrefix) {
+		t.Fatalf("unexpected key format: prefix %q secret %q", created.Prefix, created.Secret)
+	}
+	if created.KeyHash == "" || strings.Contains(created.KeyHash, created.Secret) {
+		t.Fatal("expected only the key hash to be stored")
+	}
+
+	principal, err := svc.AuthenticateAPIKey(ctx, created.Secret)
+	if err != nil {
+		t.Fatalf("authenticate api key: %v", err)
+	}
+	if principal.Subject != user.ID || principal.APIKeyID != created.ID || principal.IsService() || !principal.HasScope("roles:view") {
+		t.Fatalf("unexpected principal: %+v", principal)
+	}
+
+	keys, err := svc.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("list api keys: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].LastUsedAt.Valid {
+		t.Fatalf("expected one key with last used time, got %+v", keys)
+	}
+
+	if err := svc.RevokeAPIKey(ctx, "someone-else", created.ID); !errors.Is(err, users.ErrAPIKeyNotFound) {
+		t.Fatalf("expected other users to be unable to revoke the key, got %v", err)
+	}
+	if err := svc.RevokeAPIKey(ctx, user.ID, created.ID); err != nil {
+		t.Fatalf("revoke api key: %v", err)
+	}
+	if _, err := svc.AuthenticateAPIKey(ctx, created.Secret); !errors.Is(err, users.ErrAPIKeyInvalid) {
+		t.Fatalf("expected revoked key to be rejected, got %v", err)
+	}
+}
+
+func TestAPIKeyExpiryAndDisabledOwner(t *testing.T) {
+	repo := newMemoryRepo()
+	ctx := context.Background()
+	svc := users.NewService(repo, nil, nil, nil, users.WithAPIKeys(repo))
+
+	user := &users.User{Email: "merchant@example.com", Status: "active"}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := svc.CreateAPIKey(ctx, user.ID, users.CreateAPIKeyRequest{Name: "old", ExpiresAt: &past}); err == nil {
+		t.Fatal("expected expiry in the past to be rejected")
+	}
+
+	soon := time.Now().Add(time.Hour)
+	created, err := svc.CreateAPIKey(ctx, user.ID, users.CreateAPIKeyRequest{Name: "short", ExpiresAt: &soon})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	stored := repo.apiKeys[created.ID]
+	stored.ExpiresAt.Time = time.Now().Add(-time.Second)
+	if _, err := svc.AuthenticateAPIKey(ctx, created.Secret); !errors.Is(err, users.ErrAPIKeyInvalid) {
+		t.Fatalf("expected expired key to be rejected, got %v", err)
+	}
+
+	fresh, err := svc.CreateAPIKey(ctx, user.ID, users.CreateAPIKeyRequest{Name: "fresh"})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	user.Status = "disabled"
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	if _, err := svc.AuthenticateAPIKey(ctx, fresh.Secret); !errors.Is(err, users.ErrAPIKeyInvalid) {
+		t.Fatalf("expected keys of disabled users to be rejected, got %v", err)
+	}
+	if _, err := svc.AuthenticateAPIKey(ctx, "not-a-key"); !errors.Is(err, users.ErrAPIKeyInvalid) {
+		t.Fatalf("expected malformed key to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+const (
+	// emailChangeTTL is how long the confirmation and cancel links of an email change stay valid.
+	emailChangeTTL = 24 * time.Hour
+	// Email changes can be requested at most emailChangeLimit times per window per user.
+	emailChangeLimit  = 3
+	emailChangeWindow = time.Hour
+)
+
+// EmailChange is a pending change of a user's login email. The new address confirms it and the
+// old one can cancel it; only the hashes of both link tokens are stored.
+type EmailChange struct {
+	ID               string
+	UserID           string
+	OldEmail         string
+	NewEmail         string
+	ConfirmTokenHash string
+	CancelTokenHash  string
+	ExpiresAt        time.Time
+	ConfirmedAt      sql.NullTime
+	CancelledAt      sql.NullTime
+	CreatedAt        time.Time
+}
+
+// EmailChangeRepository stores email changes.
+type EmailChangeRepository interface {
+	// CreateEmailChange stores the change and cancels the user's other pending changes.
+	CreateEmailChange(ctx context.Context, c *EmailChange) error
+	// ConfirmEmailChange completes a pending change: it marks it confirmed and moves the user to
+	// the new address, verified, in one transaction. It returns ErrEmailTaken when the address
+	// was claimed by another account in the meantime.
+	ConfirmEmailChange(ctx context.Context, confirmTokenHash string) (*EmailChange, error)
+	// CancelEmailChange cancels a pending change.
+	CancelEmailChange(ctx context.Context, cancelTokenHash string) (*EmailChange, error)
+}
+
+var (
+	ErrEmailChangeNotFound     = errors.New("email change not found")
+	ErrEmailChangeTokenInvalid = errors.New("invalid or expired email change token")
+	ErrEmailUnchanged          = errors.New("new email matches the current one")
+)
+
+// SQLEmailChangeRepository persists email changes in the email_changes table.
+type SQLEmailChangeRepository struct {
+	db *sql.DB
+}
+
+// NewSQLEmailChangeRepository creates an email change repository instance.
+func NewSQLEmailChangeRepository(db *sql.DB) *SQLEmailChangeRepository {
+	return &SQLEmailChangeRepository{db: db}
+}
+
+// CreateEmailChange inserts the change after cancelling the user's pending ones.
+func (r *SQLEmailChangeRepository) CreateEmailChange(ctx context.Context, c *EmailChange) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE email_changes SET cancelled_at=now() WHERE user_id=$1 AND confirmed_at IS NULL AND cancelled_at IS NULL`, c.UserID); err != nil {
+		return err
+	}
+	query := `INSERT INTO email_changes (id, user_id, old_email, new_email, confirm_token_hash, cancel_token_hash, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING created_at`
+	if err := tx.QueryRowContext(ctx, query, c.ID, c.UserID, c.OldEmail, c.NewEmail, c.ConfirmTokenHash, c.CancelTokenHash, c.ExpiresAt).Scan(&c.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConfirmEmailChange marks the change confirmed and swaps the user's email in one transaction.
+// A pending account is activated, since the link proves control of the new mailbox.
+func (r *SQLEmailChangeRepository) ConfirmEmailChange(ctx context.Context, confirmTokenHash string) (*EmailChange, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE email_changes SET confirmed_at=now()
+WHERE confirm_token_hash=$1 AND confirmed_at IS NULL AND cancelled_at IS NULL AND expires_at > now()
+RETURNING id, user_id, old_email, new_email, confirm_token_hash, cancel_token_hash, expires_at, confirmed_at, cancelled_at, created_at`
+	c, err := scanEmailChange(tx.QueryRowContext(ctx, query, confirmTokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEmailChangeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The old_email guard keeps a change from applying over one confirmed in the meantime.
+	res, err := tx.ExecContext(ctx, `UPDATE users SET email=$1, email_verified_at=now(), status=CASE WHEN status='pending' THEN 'active' ELSE status END, updated_at=now() WHERE id=$2 AND email=$3`,
+		c.NewEmail, c.UserID, c.OldEmail)
+	if isUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrEmailChangeNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CancelEmailChange marks a pending change cancelled.
+func (r *SQLEmailChangeRepository) CancelEmailChange(ctx context.Context, cancelTokenHash string) (*EmailChange, error) {
+	query := `UPDATE email_changes SET cancelled_at=now()
+WHERE cancel_token_hash=$1 AND confirmed_at IS NULL AND cancelled_at IS NULL AND expires_at > now()
+RETURNING id, user_id, old_email, new_email, confirm_token_hash, cancel_token_hash, expires_at, confirmed_at, cancelled_at, created_at`
+	c, err := scanEmailChange(r.db.QueryRowContext(ctx, query, cancelTokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEmailChangeNotFound
+	}
+	return c, err
+}
+
+func scanEmailChange(row rowScanner) (*EmailChange, error) {
+	c := &EmailChange{}
+	if err := row.Scan(&c.ID, &c.UserID, &c.OldEmail, &c.NewEmail, &c.ConfirmTokenHash, &c.CancelTokenHash, &c.ExpiresAt, &c.ConfirmedAt, &c.CancelledAt, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RequestEmailChange starts moving the user to a new login email. The current password is
+// required and at most emailChangeLimit attempts are allowed per window, right or wrong. A
+// confirmation link goes to the new address and a notice with a cancel link to the old one;
+// nothing changes until the new address confirms.
+func (s *Service) RequestEmailChange(ctx context.Context, userID, newEmail, currentPassword string) error {
+	if s.emailChanges == nil {
+		return errors.New("email changes not configured")
+	}
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	if err := validateEmail(newEmail); err != nil {
+		return err
+	}
+
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// Every attempt is throttled and wrong passwords count as failed sign-ins, so a stolen token
+	// cannot be used to guess the password.
+	if err := s.throttle(ctx, "email-change:"+userID, emailChangeLimit, emailChangeWindow); err != nil {
+		return err
+	}
+	if err := s.loginBlocked(ctx, user.Email, ""); err != nil {
+		return err
+	}
+	match, err := auth.VerifyPassword(currentPassword, user.PasswordHash)
+	if err != nil || !match {
+		s.loginFailed(ctx, user.Email, "", user)
+		return ErrInvalidCredentials
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return ErrEmailUnchanged
+	}
+
+	if _, err := s.repo.FindByEmail(ctx, newEmail); err == nil {
+		return ErrEmailTaken
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	confirmSecret, err := newUserTokenSecret()
+	if err != nil {
+		return err
+	}
+	cancelSecret, err := newUserTokenSecret()
+	if err != nil {
+		return err
+	}
+	change := EmailChange{
+		ID:               uuid.NewString(),
+		UserID:           user.ID,
+		OldEmail:         user.Email,
+		NewEmail:         newEmail,
+		ConfirmTokenHash: auth.HashToken(confirmSecret),
+		CancelTokenHash:  auth.HashToken(cancelSecret),
+		ExpiresAt:        time.Now().Add(emailChangeTTL).UTC(),
+	}
+	if err := s.emailChanges.CreateEmailChange(ctx, &change); err != nil {
+		return err
+	}
+
+	if err := s.notify(ctx, Notification{
+		Kind:      NotificationEmailChangeConfirm,
+		UserID:    user.ID,
+		Email:     newEmail,
+		Name:      user.FirstName.String,
+		Token:     confirmSecret,
+		ExpiresAt: change.ExpiresAt,
+	}); err != nil {
+		return err
+	}
+	return s.notify(ctx, Notification{
+		Kind:      NotificationEmailChangeRequested,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Name:      user.FirstName.String,
+		Token:     cancelSecret,
+		ExpiresAt: change.ExpiresAt,
+		NewEmail:  newEmail,
+	})
+}
+
+// ConfirmEmailChange redeems the link sent to the new address and swaps the login email. Links
+// mailed to the old address for verification, password reset or sign-in stop working.
+func (s *Service) ConfirmEmailChange(ctx context.Context, token string) error {
+	if s.emailChanges == nil {
+		return errors.New("email changes not configured")
+	}
+	change, err := s.emailChanges.ConfirmEmailChange(ctx, auth.HashToken(strings.TrimSpace(token)))
+	if err != nil {
+		if errors.Is(err, ErrEmailChangeNotFound) {
+			return ErrEmailChangeTokenInvalid
+		}
+		return err
+	}
+
+	if s.userTokens != nil {
+		for _, purpose := range []string{TokenPurposeEmailVerification, TokenPurposePasswordReset, TokenPurposeMagicLink} {
+			if err := s.userTokens.InvalidateUserTokens(ctx, change.UserID, purpose); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.emit(ctx, EventEmailChanged, change.UserID, map[string]any{
+		"oldEmail": change.OldEmail,
+		"newEmail": change.NewEmail,
+	})
+	return nil
+}
+
+// CancelEmailChange redeems the cancel link sent to the old address.
+func (s *Service) CancelEmailChange(ctx context.Context, token string) error {
+	if s.emailChanges == nil {
+		return errors.New("email changes not configured")
+	}
+	if _, err := s.emailChanges.CancelEmailChange(ctx, auth.HashToken(strings.TrimSpace(token))); err != nil {
+		if errors.Is(err, ErrEmailChangeNotFound) {
+			return ErrEmailChangeTokenInvalid
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestEmailChangeConfirmedByNewAddress(t *testing.T) {
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	events := &memoryEvents{}
+	changes := &memoryEmailChanges{}
+	svc, repo, _, _ := newTestService(t,
+		users.WithUserTokens(tokens, newMemoryLimiter()),
+		users.WithNotifier(notifier),
+		users.WithEmailChanges(changes),
+		users.WithEventPublisher(events),
+		users.WithMagicLinks(true),
+	)
+	changes.repo = repo
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "old@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "other@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register other: %v", err)
+	}
+
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "Old@example.com", "Password!2"); !errors.Is(err, users.ErrEmailUnchanged) {
+		t.Fatalf("expected ErrEmailUnchanged, got %v", err)
+	}
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "other@example.com", "Password!2"); !errors.Is(err, users.ErrEmailTaken) {
+		t.Fatalf("expected ErrEmailTaken, got %v", err)
+	}
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "New@example.com", "Password!2"); err != nil {
+		t.Fatalf("request email change: %v", err)
+	}
+
+	confirm, ok := notifier.last(users.NotificationEmailChangeConfirm)
+	if !ok || confirm.Email != "new@example.com" || confirm.Token == "" {
+		t.Fatalf("expected a confirmation email to the new address, got %+v", confirm)
+	}
+	notice, ok := notifier.last(users.NotificationEmailChangeRequested)
+	if !ok || notice.Email != "old@example.com" || notice.NewEmail != "new@example.com" || notice.Token == "" {
+		t.Fatalf("expected a notice to the old address, got %+v", notice)
+	}
+	if _, err := repo.FindByEmail(ctx, "new@example.com"); !errors.Is(err, users.ErrNotFound) {
+		t.Fatalf("expected the email to stay unchanged until confirmed")
+	}
+
+	link, err := svc.RequestMagicLink(ctx, "old@example.com", users.ClientInfo{})
+	if err != nil {
+		t.Fatalf("request magic link: %v", err)
+	}
+	linkSent, _ := notifier.last(users.NotificationMagicLink)
+
+	if err := svc.ConfirmEmailChange(ctx, notice.Token); !errors.Is(err, users.ErrEmailChangeTokenInvalid) {
+		t.Fatalf("expected the cancel token not to confirm, got %v", err)
+	}
+	if err := svc.ConfirmEmailChange(ctx, confirm.Token); err != nil {
+		t.Fatalf("confirm email change: %v", err)
+	}
+	if _, err := svc.RedeemMagicLink(ctx, linkSent.Token, link.DeviceToken, users.ClientInfo{}); !errors.Is(err, users.ErrMagicLinkInvalid) {
+		t.Fatalf("expected the sign-in link mailed to the old address to stop working, got %v", err)
+	}
+	if err := svc.ConfirmEmailChange(ctx, confirm.Token); !errors.Is(err, users.ErrEmailChangeTokenInvalid) {
+		t.Fatalf("expected the confirmation to be single use, got %v", err)
+	}
+
+	user, err := repo.FindByID(ctx, reg.UserID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if user.Email != "new@example.com" || !user.EmailVerifiedAt.Valid {
+		t.Fatalf("expected the new address to be set and verified, got %+v", user)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "new@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("expected login with the new address: %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "old@example.com", Password: "Password!2"}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected the old address to stop working, got %v", err)
+	}
+	if err := svc.CancelEmailChange(ctx, notice.Token); !errors.Is(err, users.ErrEmailChangeTokenInvalid) {
+		t.Fatalf("expected a confirmed change not to be cancellable, got %v", err)
+	}
+
+	changed := events.byType(users.EventEmailChanged)
+	if len(changed) != 1 {
+		t.Fatalf("expected one email changed event, got %d", len(changed))
+	}
+}
+
+func TestEmailChangeCancelledByOldAddress(t *testing.T) {
+	notifier := &memoryNotifier{}
+	changes := &memoryEmailChanges{}
+	svc, repo, _, _ := newTestService(t,
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+		users.WithNotifier(notifier),
+		users.WithEmailChanges(changes),
+	)
+	changes.repo = repo
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "owner@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "attacker@example.com", "Password!2"); err != nil {
+		t.Fatalf("request email change: %v", err)
+	}
+	confirm, _ := notifier.last(users.NotificationEmailChangeConfirm)
+	notice, _ := notifier.last(users.NotificationEmailChangeRequested)
+
+	if err := svc.CancelEmailChange(ctx, notice.Token); err != nil {
+		t.Fatalf("cancel email change: %v", err)
+	}
+	if err := svc.ConfirmEmailChange(ctx, confirm.Token); !errors.Is(err, users.ErrEmailChangeTokenInvalid) {
+		t.Fatalf("expected a cancelled change not to confirm, got %v", err)
+	}
+	user, _ := repo.FindByID(ctx, reg.UserID)
+	if user.Email != "owner@example.com" {
+		t.Fatalf("expected the email to stay unchanged, got %q", user.Email)
+	}
+
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "next@example.com", "wrong-password"); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected the current password to be required, got %v", err)
+	}
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "next@example.com", "Password!2"); err != nil {
+		t.Fatalf("request email change: %v", err)
+	}
+	// Wrong passwords use up the allowance too, so it cannot be used to guess the password.
+	if err := svc.RequestEmailChange(ctx, reg.UserID, "next@example.com", "Password!2"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected email change requests to be throttled, got %v", err)
+	}
+}
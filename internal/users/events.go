@@ -0,0 +1,52 @@
+package users
+
+import (
+	"context"
+	"time"
+)
+
+// Event types emitted by the user service.
+const (
+	EventRefreshTokenReused      = "user.security.refresh_token_reused"
+	EventSessionsRevoked         = "user.security.sessions_revoked"
+	EventMFAEnabled              = "user.security.mfa_enabled"
+	EventMFADisabled             = "user.security.mfa_disabled"
+	EventMFARecoveryCodeUsed     = "user.security.mfa_recovery_code_used"
+	EventPasskeyRegistered       = "user.security.passkey_registered"
+	EventPasskeyCounterRegressed = "user.security.passkey_counter_regressed"
+	EventEmailVerified           = "user.email_verified"
+	EventPasswordReset           = "user.security.password_reset"
+	EventEmailChanged            = "user.email_changed"
+	EventPhoneChanged            = "user.phone_changed"
+	EventIdentityLinked          = "user.security.identity_linked"
+	EventIdentityUnlinked        = "user.security.identity_unlinked"
+	EventAccountLocked           = "user.security.account_locked"
+	EventAccountUnlocked         = "user.security.account_unlocked"
+	EventLoginAddressBlocked     = "user.security.login_address_blocked"
+)
+
+// EventPublisher emits domain events for downstream consumers.
+type EventPublisher interface {
+	Publish(ctx context.Context, key string, payload any) error
+}
+
+// Event is the envelope published for user domain events.
+type Event struct {
+	Type       string         `json:"type"`
+	UserID     string         `json:"userId"`
+	OccurredAt time.Time      `json:"occurredAt"`
+	Data       map[string]any `json:"data,omitempty"`
+}
+
+// emit publishes an event keyed by user ID. Publishing is best effort and never fails the caller.
+func (s *Service) emit(ctx context.Context, eventType, userID string, data map[string]any) {
+	if s.events == nil {
+		return
+	}
+	_ = s.events.Publish(ctx, userID, Event{
+		Type:       eventType,
+		UserID:     userID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+}
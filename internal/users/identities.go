@@ -0,0 +1,402 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// federatedLoginTTL is how long a user has to come back from the identity provider.
+const federatedLoginTTL = 10 * time.Minute
+
+// IdentityLink ties an account at an external identity provider to a local user. Email is the
+// address the provider reported when the link was last used and is informational only.
+type IdentityLink struct {
+	ID          string
+	UserID      string
+	Provider    string
+	Subject     string
+	Email       sql.NullString
+	CreatedAt   time.Time
+	LastLoginAt sql.NullTime
+}
+
+// IdentityLinkRepository stores identity links.
+type IdentityLinkRepository interface {
+	// CreateIdentityLink returns ErrIdentityAlreadyLinked when the external account is linked
+	// to any user already.
+	CreateIdentityLink(ctx context.Context, link *IdentityLink) error
+	// CreateUserWithIdentity inserts a new user together with its first link in one transaction.
+	CreateUserWithIdentity(ctx context.Context, u *User, link *IdentityLink) error
+	FindIdentityLink(ctx context.Context, provider, subject string) (*IdentityLink, error)
+	ListIdentityLinks(ctx context.Context, userID string) ([]IdentityLink, error)
+	TouchIdentityLink(ctx context.Context, id, email string, usedAt time.Time) error
+	DeleteIdentityLink(ctx context.Context, userID, id string) error
+}
+
+// FederatedLogin is the first half of a sign-in with an identity provider. The client sends the
+// user to AuthorizationURL, keeps State, and checks that the provider redirects back with the
+// same state before finishing.
+type FederatedLogin struct {
+	State            string
+	AuthorizationURL string
+}
+
+var (
+	ErrIdentityProviderNotFound = errors.New("identity provider not found")
+	ErrIdentityLinkNotFound     = errors.New("identity link not found")
+	ErrIdentityAlreadyLinked    = errors.New("external identity already linked to an account")
+	// ErrIdentityEmailConflict is returned when an unlinked external identity reports the email
+	// of an existing account. The accounts are never merged automatically: the owner has to sign
+	// in and link the identity from their account.
+	ErrIdentityEmailConflict   = errors.New("an account with this email already exists; sign in and link the identity instead")
+	ErrIdentityEmailUnverified = errors.New("identity provider did not verify the email address")
+	ErrFederatedLoginInvalid   = errors.New("invalid or expired federated sign-in")
+)
+
+// SQLIdentityLinkRepository persists identity links in the identity_links table.
+type SQLIdentityLinkRepository struct {
+	db *sql.DB
+}
+
+// NewSQLIdentityLinkRepository creates an identity link repository instance.
+func NewSQLIdentityLinkRepository(db *sql.DB) *SQLIdentityLinkRepository {
+	return &SQLIdentityLinkRepository{db: db}
+}
+
+// CreateIdentityLink inserts a new link.
+func (r *SQLIdentityLinkRepository) CreateIdentityLink(ctx context.Context, link *IdentityLink) error {
+	query := `INSERT INTO identity_links (id, user_id, provider, subject, email) VALUES ($1,$2,$3,$4,$5) RETURNING created_at`
+	err := r.db.QueryRowContext(ctx, query, link.ID, link.UserID, link.Provider, link.Subject, link.Email).Scan(&link.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrIdentityAlreadyLinked
+	}
+	return err
+}
+
+// CreateUserWithIdentity inserts the user, verified, and the link. It returns ErrEmailTaken or
+// ErrIdentityAlreadyLinked when either was claimed in the meantime.
+func (r *SQLIdentityLinkRepository) CreateUserWithIdentity(ctx context.Context, u *User, link *IdentityLink) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO users (email, password_hash, first_name, last_name, status, email_verified_at) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id, created_at, updated_at`
+	err = tx.QueryRowContext(ctx, query, u.Email, u.PasswordHash, u.FirstName, u.LastName, u.Status, u.EmailVerifiedAt).
+		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	link.UserID = u.ID
+	query = `INSERT INTO identity_links (id, user_id, provider, subject, email) VALUES ($1,$2,$3,$4,$5) RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, link.ID, link.UserID, link.Provider, link.Subject, link.Email).Scan(&link.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrIdentityAlreadyLinked
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FindIdentityLink returns the link of an external account.
+func (r *SQLIdentityLinkRepository) FindIdentityLink(ctx context.Context, provider, subject string) (*IdentityLink, error) {
+	query := `SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM identity_links WHERE provider=$1 AND subject=$2`
+	link, err := scanIdentityLink(r.db.QueryRowContext(ctx, query, provider, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityLinkNotFound
+	}
+	return link, err
+}
+
+// ListIdentityLinks returns the user's links, oldest first.
+func (r *SQLIdentityLinkRepository) ListIdentityLinks(ctx context.Context, userID string) ([]IdentityLink, error) {
+	query := `SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM identity_links WHERE user_id=$1 ORDER BY created_at`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []IdentityLink
+	for rows.Next() {
+		link, err := scanIdentityLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
+
+// TouchIdentityLink records a sign-in through the link.
+func (r *SQLIdentityLinkRepository) TouchIdentityLink(ctx context.Context, id, email string, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE identity_links SET email=COALESCE($1, email), last_login_at=$2 WHERE id=$3`, sqlString(email), usedAt, id)
+	return err
+}
+
+// DeleteIdentityLink removes one of the user's links.
+func (r *SQLIdentityLinkRepository) DeleteIdentityLink(ctx context.Context, userID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM identity_links WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrIdentityLinkNotFound
+	}
+	return nil
+}
+
+func scanIdentityLink(row rowScanner) (*IdentityLink, error) {
+	link := &IdentityLink{}
+	if err := row.Scan(&link.ID, &link.UserID, &link.Provider, &link.Subject, &link.Email, &link.CreatedAt, &link.LastLoginAt); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// BeginFederatedLogin starts a sign-in with the named identity provider.
+func (s *Service) BeginFederatedLogin(ctx context.Context, provider string) (*FederatedLogin, error) {
+	return s.beginFederation(ctx, provider, "")
+}
+
+// BeginIdentityLink starts linking an account at the named identity provider to the user.
+func (s *Service) BeginIdentityLink(ctx context.Context, userID, provider string) (*FederatedLogin, error) {
+	if _, err := s.repo.FindByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.beginFederation(ctx, provider, userID)
+}
+
+func (s *Service) beginFederation(ctx context.Context, name, userID string) (*FederatedLogin, error) {
+	if s.identityLinks == nil {
+		return nil, errors.New("identity providers not configured")
+	}
+	provider, ok := s.identityProviders[name]
+	if !ok {
+		return nil, ErrIdentityProviderNotFound
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := newUserTokenSecret()
+		if err != nil {
+			return nil, err
+		}
+		secrets[i] = secret
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+	login := auth.FederatedLoginState{Provider: name, Nonce: nonce, CodeVerifier: verifier, UserID: userID}
+	if err := s.federationStates.SaveState(ctx, state, login, federatedLoginTTL); err != nil {
+		return nil, err
+	}
+	return &FederatedLogin{State: state, AuthorizationURL: authURL}, nil
+}
+
+// FinishFederatedLogin completes a sign-in with the code and state the identity provider
+// redirected back with. A linked identity signs in to its user. An unlinked one creates a new
+// verified account, provided the provider verified the email and no account uses it yet; an
+// existing account is never taken over by email alone. Users with MFA get an MFA challenge
+// instead of tokens.
+func (s *Service) FinishFederatedLogin(ctx context.Context, provider, state, code string, client ClientInfo) (*AuthenticateResult, error) {
+	login, identity, err := s.finishFederation(ctx, provider, state, code)
+	if err != nil {
+		return nil, err
+	}
+	if login.UserID != "" {
+		return nil, ErrFederatedLoginInvalid
+	}
+
+	var user *User
+	link, err := s.identityLinks.FindIdentityLink(ctx, identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		user, err = s.repo.FindByID(ctx, link.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.signInAllowed(user); err != nil {
+			return nil, err
+		}
+		if err := s.identityLinks.TouchIdentityLink(ctx, link.ID, strings.ToLower(identity.Email), time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, ErrIdentityLinkNotFound):
+		user, err = s.createFederatedUser(ctx, identity)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	challenge, err := s.mfaChallenge(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &AuthenticateResult{UserID: user.ID, MFA: challenge}, nil
+	}
+
+	tokens, err := s.issueTokens(ctx, user, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthenticateResult{UserID: user.ID, Tokens: *tokens}, nil
+}
+
+// createFederatedUser registers the owner of an unlinked identity. The account gets a random
+// password nobody knows; a password can be set later through a password reset.
+func (s *Service) createFederatedUser(ctx context.Context, identity *auth.ExternalIdentity) (*User, error) {
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	if !identity.EmailVerified || validateEmail(email) != nil {
+		return nil, ErrIdentityEmailUnverified
+	}
+	if _, err := s.repo.FindByEmail(ctx, email); err == nil {
+		return nil, ErrIdentityEmailConflict
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	secret, err := newUserTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := s.passwords.Hash(secret)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{
+		Email:           email,
+		PasswordHash:    hash,
+		FirstName:       sqlString(identity.GivenName),
+		LastName:        sqlString(identity.FamilyName),
+		Status:          "active",
+		EmailVerifiedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	}
+	link := &IdentityLink{
+		ID:       uuid.NewString(),
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    sqlString(email),
+	}
+	if err := s.identityLinks.CreateUserWithIdentity(ctx, user, link); err != nil {
+		if errors.Is(err, ErrEmailTaken) {
+			return nil, ErrIdentityEmailConflict
+		}
+		return nil, err
+	}
+
+	if s.roleStore != nil {
+		_ = s.roleStore.AssignRole(ctx, user.ID, "customer")
+	}
+	s.emit(ctx, EventIdentityLinked, user.ID, map[string]any{
+		"provider": link.Provider,
+		"linkId":   link.ID,
+		"created":  true,
+	})
+	return user, nil
+}
+
+// FinishIdentityLink completes linking started by BeginIdentityLink for the same user.
+func (s *Service) FinishIdentityLink(ctx context.Context, userID, provider, state, code string) (*IdentityLink, error) {
+	login, identity, err := s.finishFederation(ctx, provider, state, code)
+	if err != nil {
+		return nil, err
+	}
+	if login.UserID == "" || login.UserID != userID {
+		return nil, ErrFederatedLoginInvalid
+	}
+
+	link := &IdentityLink{
+		ID:       uuid.NewString(),
+		UserID:   userID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    sqlString(strings.ToLower(identity.Email)),
+	}
+	if err := s.identityLinks.CreateIdentityLink(ctx, link); err != nil {
+		return nil, err
+	}
+	s.emit(ctx, EventIdentityLinked, userID, map[string]any{
+		"provider": link.Provider,
+		"linkId":   link.ID,
+		"created":  false,
+	})
+	return link, nil
+}
+
+// finishFederation takes the state, redeems the code and verifies the returned ID token. The
+// state is spent whatever the outcome.
+func (s *Service) finishFederation(ctx context.Context, name, state, code string) (*auth.FederatedLoginState, *auth.ExternalIdentity, error) {
+	if s.identityLinks == nil {
+		return nil, nil, errors.New("identity providers not configured")
+	}
+	provider, ok := s.identityProviders[name]
+	if !ok {
+		return nil, nil, ErrIdentityProviderNotFound
+	}
+	login, err := s.federationStates.TakeState(ctx, strings.TrimSpace(state))
+	if err != nil {
+		if errors.Is(err, auth.ErrFederatedStateNotFound) {
+			return nil, nil, ErrFederatedLoginInvalid
+		}
+		return nil, nil, err
+	}
+	if login.Provider != name {
+		return nil, nil, ErrFederatedLoginInvalid
+	}
+
+	identity, err := provider.Exchange(ctx, strings.TrimSpace(code), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		if errors.Is(err, auth.ErrAuthorizationRejected) || errors.Is(err, auth.ErrIdentityTokenInvalid) {
+			return nil, nil, ErrFederatedLoginInvalid
+		}
+		return nil, nil, err
+	}
+	return login, identity, nil
+}
+
+// ListIdentityLinks returns the external identities linked to the user.
+func (s *Service) ListIdentityLinks(ctx context.Context, userID string) ([]IdentityLink, error) {
+	if s.identityLinks == nil {
+		return nil, errors.New("identity providers not configured")
+	}
+	return s.identityLinks.ListIdentityLinks(ctx, userID)
+}
+
+// UnlinkIdentity removes one of the user's identity links.
+func (s *Service) UnlinkIdentity(ctx context.Context, userID, linkID string) error {
+	if s.identityLinks == nil {
+		return errors.New("identity providers not configured")
+	}
+	if _, err := uuid.Parse(linkID); err != nil {
+		return ErrIdentityLinkNotFound
+	}
+	if err := s.identityLinks.DeleteIdentityLink(ctx, userID, linkID); err != nil {
+		return err
+	}
+	s.emit(ctx, EventIdentityUnlinked, userID, map[string]any{"linkId": linkID})
+	return nil
+}
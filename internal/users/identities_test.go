@@ -0,0 +1,153 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth/oidctest"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func newFederatedTestService(t *testing.T) (*users.Service, *memoryRepo, *memoryRoles, *oidctest.Provider, *memoryEvents) {
+	t.Helper()
+	stand := oidctest.NewProvider(t, "shop", "secret")
+	provider, err := auth.NewIdentityProvider(stand.Config("example", "https://shop.example.com/oidc/callback"), nil)
+	if err != nil {
+		t.Fatalf("new identity provider: %v", err)
+	}
+	links := &memoryIdentityLinks{}
+	events := &memoryEvents{}
+	svc, repo, roles, _ := newTestService(t,
+		users.WithIdentityProviders([]*auth.IdentityProvider{provider}, links, newMemoryFederationStates()),
+		users.WithEventPublisher(events),
+	)
+	links.repo = repo
+	return svc, repo, roles, stand, events
+}
+
+// federate runs a federated login through the stand-in provider as identity.
+func federate(t *testing.T, svc *users.Service, stand *oidctest.Provider, identity oidctest.Identity) (*users.AuthenticateResult, error) {
+	t.Helper()
+	login, err := svc.BeginFederatedLogin(context.Background(), "example")
+	if err != nil {
+		t.Fatalf("begin federated login: %v", err)
+	}
+	code, state := stand.Authorize(t, login.AuthorizationURL, identity)
+	return svc.FinishFederatedLogin(context.Background(), "example", state, code, users.ClientInfo{})
+}
+
+func TestFederatedLoginCreatesAccount(t *testing.T) {
+	svc, repo, roles, stand, events := newFederatedTestService(t)
+	ctx := context.Background()
+	identity := oidctest.Identity{Subject: "sub-1", Email: "Ana@Example.com", EmailVerified: true, GivenName: "Ana", FamilyName: "Lim"}
+
+	first, err := federate(t, svc, stand, identity)
+	if err != nil {
+		t.Fatalf("finish federated login: %v", err)
+	}
+	if first.Tokens.AccessToken == "" {
+		t.Fatalf("expected tokens for a new account")
+	}
+	user, err := repo.FindByID(ctx, first.UserID)
+	if err != nil {
+		t.Fatalf("find user: %v", err)
+	}
+	if user.Email != "ana@example.com" || user.Status != "active" || !user.EmailVerifiedAt.Valid || user.FirstName.String != "Ana" {
+		t.Fatalf("expected an active verified account, got %+v", user)
+	}
+	if assigned, _ := roles.ListRoles(ctx, user.ID); len(assigned) != 1 || assigned[0] != "customer" {
+		t.Fatalf("expected the customer role, got %v", assigned)
+	}
+	if len(events.byType(users.EventIdentityLinked)) != 1 {
+		t.Fatalf("expected an identity linked event")
+	}
+
+	second, err := federate(t, svc, stand, identity)
+	if err != nil {
+		t.Fatalf("second federated login: %v", err)
+	}
+	if second.UserID != first.UserID {
+		t.Fatalf("expected the linked account, got %s and %s", first.UserID, second.UserID)
+	}
+	links, _ := svc.ListIdentityLinks(ctx, first.UserID)
+	if len(links) != 1 || !links[0].LastLoginAt.Valid {
+		t.Fatalf("expected one link with its last login recorded, got %+v", links)
+	}
+
+	if _, err := federate(t, svc, stand, oidctest.Identity{Subject: "sub-2", Email: "ben@example.com"}); !errors.Is(err, users.ErrIdentityEmailUnverified) {
+		t.Fatalf("expected an unverified email to be refused, got %v", err)
+	}
+}
+
+func TestFederatedLoginNeverTakesOverExistingEmail(t *testing.T) {
+	svc, _, _, stand, _ := newFederatedTestService(t)
+	ctx := context.Background()
+
+	owner, err := svc.Register(ctx, users.RegisterRequest{Email: "ana@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	identity := oidctest.Identity{Subject: "sub-1", Email: "ana@example.com", EmailVerified: true}
+	if _, err := federate(t, svc, stand, identity); !errors.Is(err, users.ErrIdentityEmailConflict) {
+		t.Fatalf("expected an email conflict, got %v", err)
+	}
+
+	link, err := svc.BeginIdentityLink(ctx, owner.UserID, "example")
+	if err != nil {
+		t.Fatalf("begin identity link: %v", err)
+	}
+	code, state := stand.Authorize(t, link.AuthorizationURL, identity)
+	if _, err := svc.FinishFederatedLogin(ctx, "example", state, code, users.ClientInfo{}); !errors.Is(err, users.ErrFederatedLoginInvalid) {
+		t.Fatalf("expected a link state to be refused for sign-in, got %v", err)
+	}
+
+	link, _ = svc.BeginIdentityLink(ctx, owner.UserID, "example")
+	code, state = stand.Authorize(t, link.AuthorizationURL, identity)
+	if _, err := svc.FinishIdentityLink(ctx, "someone-else", "example", state, code); !errors.Is(err, users.ErrFederatedLoginInvalid) {
+		t.Fatalf("expected a link started by another user to be refused, got %v", err)
+	}
+
+	link, _ = svc.BeginIdentityLink(ctx, owner.UserID, "example")
+	code, state = stand.Authorize(t, link.AuthorizationURL, identity)
+	linked, err := svc.FinishIdentityLink(ctx, owner.UserID, "example", state, code)
+	if err != nil {
+		t.Fatalf("finish identity link: %v", err)
+	}
+	if _, err := svc.FinishIdentityLink(ctx, owner.UserID, "example", state, code); !errors.Is(err, users.ErrFederatedLoginInvalid) {
+		t.Fatalf("expected a spent state to be refused, got %v", err)
+	}
+
+	res, err := federate(t, svc, stand, identity)
+	if err != nil {
+		t.Fatalf("federated login after linking: %v", err)
+	}
+	if res.UserID != owner.UserID {
+		t.Fatalf("expected the linked owner, got %s", res.UserID)
+	}
+
+	other, err := svc.Register(ctx, users.RegisterRequest{Email: "ben@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register other: %v", err)
+	}
+	link, _ = svc.BeginIdentityLink(ctx, other.UserID, "example")
+	code, state = stand.Authorize(t, link.AuthorizationURL, identity)
+	if _, err := svc.FinishIdentityLink(ctx, other.UserID, "example", state, code); !errors.Is(err, users.ErrIdentityAlreadyLinked) {
+		t.Fatalf("expected an identity linked elsewhere to be refused, got %v", err)
+	}
+
+	if err := svc.UnlinkIdentity(ctx, other.UserID, linked.ID); !errors.Is(err, users.ErrIdentityLinkNotFound) {
+		t.Fatalf("expected another user's link to be hidden, got %v", err)
+	}
+	if err := svc.UnlinkIdentity(ctx, owner.UserID, linked.ID); err != nil {
+		t.Fatalf("unlink identity: %v", err)
+	}
+	if _, err := federate(t, svc, stand, identity); !errors.Is(err, users.ErrIdentityEmailConflict) {
+		t.Fatalf("expected the unlinked identity to conflict again, got %v", err)
+	}
+
+	if _, err := svc.BeginFederatedLogin(ctx, "unknown"); !errors.Is(err, users.ErrIdentityProviderNotFound) {
+		t.Fatalf("expected ErrIdentityProviderNotFound, got %v", err)
+	}
+}
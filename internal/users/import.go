@@ -0,0 +1,104 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// maxImportBatch bounds the number of users in one import request.
+const maxImportBatch = 1000
+
+// Outcomes of importing a single user.
+const (
+	ImportCreated = "created"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportUser is an account carried over from another system with its password already hashed.
+// PasswordHash may be in any format auth.VerifyPassword accepts; it is replaced with an argon2id
+// hash on the user's first successful login.
+type ImportUser struct {
+	Email         string
+	PasswordHash  string
+	FirstName     string
+	LastName      string
+	EmailVerified bool
+}
+
+// ImportResult reports what happened to one ImportUser. Users whose email is already registered
+// are skipped, so an interrupted import can be run again.
+type ImportResult struct {
+	Email  string
+	UserID string
+	Status string
+	Error  string
+}
+
+var ErrImportBatchTooLarge = fmt.Errorf("at most %d users can be imported at once", maxImportBatch)
+
+// ImportUsers creates accounts from pre-hashed passwords, active if the email was verified and
+// pending otherwise, like a fresh registration. Each user is handled on its own: an invalid
+// entry is reported as failed and does not stop the rest of the batch.
+func (s *Service) ImportUsers(ctx context.Context, batch []ImportUser) ([]ImportResult, error) {
+	if len(batch) > maxImportBatch {
+		return nil, ErrImportBatchTooLarge
+	}
+
+	results := make([]ImportResult, 0, len(batch))
+	for _, entry := range batch {
+		result, err := s.importUser(ctx, entry)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *Service) importUser(ctx context.Context, entry ImportUser) (ImportResult, error) {
+	email := strings.ToLower(strings.TrimSpace(entry.Email))
+	result := ImportResult{Email: email}
+	if err := validateEmail(email); err != nil {
+		result.Status, result.Error = ImportFailed, err.Error()
+		return result, nil
+	}
+	if err := auth.ValidatePasswordHash(entry.PasswordHash); err != nil {
+		result.Status, result.Error = ImportFailed, err.Error()
+		return result, nil
+	}
+
+	user := &User{
+		Email:        email,
+		PasswordHash: entry.PasswordHash,
+		FirstName:    sqlString(entry.FirstName),
+		LastName:     sqlString(entry.LastName),
+		Status:       "pending",
+	}
+	if entry.EmailVerified {
+		user.Status = "active"
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	err := s.repo.Create(ctx, user)
+	if errors.Is(err, ErrEmailTaken) {
+		result.Status, result.Error = ImportSkipped, err.Error()
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if s.roleStore != nil {
+		_ = s.roleStore.AssignRole(ctx, user.ID, "customer")
+	}
+
+	result.UserID, result.Status = user.ID, ImportCreated
+	return result, nil
+}
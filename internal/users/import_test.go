@@ -0,0 +1,95 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestImportUsersMigratesLegacyHashOnLogin(t *testing.T) {
+	svc, repo, roles, _ := newTestService(t)
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "taken@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Legacy#Pass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	results, err := svc.ImportUsers(ctx, []users.ImportUser{
+		{Email: " Legacy@Example.com ", PasswordHash: string(legacy), FirstName: "Ana", EmailVerified: true},
+		{Email: "taken@example.com", PasswordHash: string(legacy)},
+		{Email: "md5@example.com", PasswordHash: "5f4dcc3b5aa765d61d8327deb882cf99"},
+		{Email: "not-an-email", PasswordHash: string(legacy)},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	statuses := make([]string, 0, len(results))
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	if got := strings.Join(statuses, ","); got != "created,skipped,failed,failed" {
+		t.Fatalf("unexpected import statuses %s: %+v", got, results)
+	}
+
+	user, err := repo.FindByID(ctx, results[0].UserID)
+	if err != nil {
+		t.Fatalf("find imported user: %v", err)
+	}
+	if user.Email != "legacy@example.com" || user.Status != "active" || !user.EmailVerifiedAt.Valid || user.PasswordHash != string(legacy) {
+		t.Fatalf("unexpected imported user %+v", user)
+	}
+	if assigned, _ := roles.ListRoles(ctx, user.ID); len(assigned) != 1 || assigned[0] != "customer" {
+		t.Fatalf("expected the customer role, got %v", assigned)
+	}
+
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "legacy@example.com", Password: "Legacy#Pass1"}); err != nil {
+		t.Fatalf("authenticate with the legacy hash: %v", err)
+	}
+	user, _ = repo.FindByID(ctx, user.ID)
+	if !strings.HasPrefix(user.PasswordHash, "argon2id$") {
+		t.Fatalf("expected the hash to be migrated to argon2id, got %q", user.PasswordHash)
+	}
+	if ok, _ := auth.VerifyPassword("Legacy#Pass1", user.PasswordHash); !ok {
+		t.Fatal("expected the migrated hash to verify")
+	}
+
+	if _, err := svc.ImportUsers(ctx, make([]users.ImportUser, 1001)); !errors.Is(err, users.ErrImportBatchTooLarge) {
+		t.Fatalf("expected ErrImportBatchTooLarge, got %v", err)
+	}
+}
+
+func TestImportUsersKeepsUnverifiedAccountsPending(t *testing.T) {
+	svc, repo, _, _ := newTestService(t, users.WithVerifiedEmailRequired(true))
+	ctx := context.Background()
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Legacy#Pass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	results, err := svc.ImportUsers(ctx, []users.ImportUser{{Email: "unverified@example.com", PasswordHash: string(legacy)}})
+	if err != nil || len(results) != 1 || results[0].Status != users.ImportCreated {
+		t.Fatalf("import: %+v, %v", results, err)
+	}
+
+	user, err := repo.FindByID(ctx, results[0].UserID)
+	if err != nil {
+		t.Fatalf("find imported user: %v", err)
+	}
+	if user.Status != "pending" || user.EmailVerifiedAt.Valid {
+		t.Fatalf("expected an unverified import to be pending, got %+v", user)
+	}
+	_, err = svc.Authenticate(ctx, users.AuthenticateRequest{Email: "unverified@example.com", Password: "Legacy#Pass1"})
+	if !errors.Is(err, users.ErrEmailNotVerified) {
+		t.Fatalf("expected sign-in to wait for verification, got %v", err)
+	}
+}
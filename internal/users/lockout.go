@@ -0,0 +1,148 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// After the free attempts, each failed sign-in blocks the account for loginBackoffBase, doubling
+// with every further failure up to loginBackoffMax.
+const (
+	loginBackoffBase = time.Second
+	loginBackoffMax  = time.Minute
+)
+
+// LoginLockoutPolicy sets how failed password sign-ins are slowed down and locked out.
+type LoginLockoutPolicy struct {
+	// FreeAttempts failures per account are allowed before the backoff starts.
+	FreeAttempts int
+	// Threshold failures per account lock it for Cooldown.
+	Threshold int
+	// IPThreshold failures from one client address, whatever the account, block the address
+	// for Cooldown.
+	IPThreshold int
+	// Cooldown is both how long a lockout lasts and how long failures are remembered after the
+	// last one, so an expired lockout starts over with free attempts.
+	Cooldown time.Duration
+}
+
+// DefaultLoginLockoutPolicy is used when WithLoginLockout is given a zero policy.
+var DefaultLoginLockoutPolicy = LoginLockoutPolicy{
+	FreeAttempts: 3,
+	Threshold:    10,
+	IPThreshold:  100,
+	Cooldown:     15 * time.Minute,
+}
+
+func accountLoginKey(email string) string {
+	return "account:" + email
+}
+
+func addressLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBlocked returns a RateLimitError while the account or the client address is locked out
+// or waiting out a backoff.
+func (s *Service) loginBlocked(ctx context.Context, email, ip string) error {
+	if s.loginAttempts == nil {
+		return nil
+	}
+	keys := []string{accountLoginKey(email)}
+	if ip != "" {
+		keys = append(keys, addressLoginKey(ip))
+	}
+	var wait time.Duration
+	for _, key := range keys {
+		blocked, err := s.loginAttempts.Blocked(ctx, key)
+		if err != nil {
+			return err
+		}
+		wait = max(wait, blocked)
+	}
+	if wait > 0 {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// loginFailed records a failed password sign-in. user is nil for an unknown email, which is
+// tracked all the same so that it cannot be told apart from a real account. It is best effort:
+// the sign-in has failed either way.
+func (s *Service) loginFailed(ctx context.Context, email, ip string, user *User) {
+	if s.loginAttempts == nil {
+		return
+	}
+	policy := s.lockoutPolicy
+
+	key := accountLoginKey(email)
+	if failures, err := s.loginAttempts.Fail(ctx, key, policy.Cooldown); err == nil {
+		switch {
+		case failures >= policy.Threshold:
+			if err := s.loginAttempts.Block(ctx, key, policy.Cooldown); err == nil && user != nil {
+				s.emit(ctx, EventAccountLocked, user.ID, map[string]any{
+					"failures":    failures,
+					"ip":          ip,
+					"lockedUntil": time.Now().Add(policy.Cooldown).UTC(),
+				})
+			}
+		case failures > policy.FreeAttempts:
+			_ = s.loginAttempts.Block(ctx, key, loginBackoff(failures-policy.FreeAttempts))
+		}
+	}
+
+	if ip == "" {
+		return
+	}
+	key = addressLoginKey(ip)
+	if failures, err := s.loginAttempts.Fail(ctx, key, policy.Cooldown); err == nil && failures >= policy.IPThreshold {
+		if err := s.loginAttempts.Block(ctx, key, policy.Cooldown); err == nil {
+			// No user: the failures from an address span accounts.
+			s.emit(ctx, EventLoginAddressBlocked, "", map[string]any{
+				"failures":     failures,
+				"ip":           ip,
+				"blockedUntil": time.Now().Add(policy.Cooldown).UTC(),
+			})
+		}
+	}
+}
+
+// loginSucceeded forgets the account's failures. Those of the client address are kept, so that
+// signing in to an account of one's own does not reset them.
+func (s *Service) loginSucceeded(ctx context.Context, email string) {
+	if s.loginAttempts == nil {
+		return
+	}
+	_ = s.loginAttempts.Clear(ctx, accountLoginKey(email))
+}
+
+// loginBackoff is the wait after the nth failure beyond the free attempts.
+func loginBackoff(n int) time.Duration {
+	if n > 16 {
+		return loginBackoffMax
+	}
+	return min(loginBackoffBase<<(n-1), loginBackoffMax)
+}
+
+// UnlockUser lifts a lockout or backoff of the user's account and forgets its failed sign-ins
+// and second-factor codes.
+func (s *Service) UnlockUser(ctx context.Context, userID string) error {
+	if s.loginAttempts == nil {
+		return errors.New("login lockout not configured")
+	}
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.loginAttempts.Clear(ctx, accountLoginKey(user.Email)); err != nil {
+		return err
+	}
+	if s.mfaAttempts != nil {
+		if err := s.mfaAttempts.Reset(ctx, mfaUserKey(user.ID)); err != nil {
+			return err
+		}
+	}
+	s.emit(ctx, EventAccountUnlocked, user.ID, map[string]any{"reason": "admin"})
+	return nil
+}
@@ -0,0 +1,212 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+// memoryLoginAttempts is an in-memory auth.LoginAttempts with a clock the test moves forward.
+type memoryLoginAttempts struct {
+	mu       sync.Mutex
+	now      time.Time
+	failures map[string]int
+	expires  map[string]time.Time
+	blocked  map[string]time.Time
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{
+		now:      time.Now(),
+		failures: make(map[string]int),
+		expires:  make(map[string]time.Time),
+		blocked:  make(map[string]time.Time),
+	}
+}
+
+func (m *memoryLoginAttempts) Fail(_ context.Context, key string, ttl time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.now.Before(m.expires[key]) {
+		m.failures[key] = 0
+	}
+	m.failures[key]++
+	m.expires[key] = m.now.Add(ttl)
+	return m.failures[key], nil
+}
+
+func (m *memoryLoginAttempts) Block(_ context.Context, key string, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocked[key] = m.now.Add(d)
+	return nil
+}
+
+func (m *memoryLoginAttempts) Blocked(_ context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if until, ok := m.blocked[key]; ok && until.After(m.now) {
+		return until.Sub(m.now), nil
+	}
+	return 0, nil
+}
+
+func (m *memoryLoginAttempts) Clear(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, key)
+	delete(m.expires, key)
+	delete(m.blocked, key)
+	return nil
+}
+
+func (m *memoryLoginAttempts) advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+var testLockoutPolicy = users.LoginLockoutPolicy{FreeAttempts: 2, Threshold: 5, IPThreshold: 8, Cooldown: 10 * time.Minute}
+
+func TestLoginBackoffAndLockout(t *testing.T) {
+	attempts := newMemoryLoginAttempts()
+	events := &memoryEvents{}
+	svc, _, _, _ := newTestService(t, users.WithLoginLockout(attempts, testLockoutPolicy), users.WithEventPublisher(events))
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "target@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	login := func(password string) error {
+		_, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "Target@example.com", Password: password, Client: users.ClientInfo{IP: "203.0.113.7"}})
+		return err
+	}
+	retryAfter := func(err error) time.Duration {
+		t.Helper()
+		var limited *users.RateLimitError
+		if !errors.As(err, &limited) {
+			t.Fatalf("expected a RateLimitError, got %v", err)
+		}
+		return limited.RetryAfter
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := login("wrong"); !errors.Is(err, users.ErrInvalidCredentials) {
+			t.Fatalf("free attempt %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+	if err := login("wrong"); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+	if wait := retryAfter(login("Password!2")); wait != time.Second {
+		t.Fatalf("expected a one second backoff, got %s", wait)
+	}
+	attempts.advance(time.Second)
+	_ = login("wrong")
+	if wait := retryAfter(login("wrong")); wait != 2*time.Second {
+		t.Fatalf("expected the backoff to double, got %s", wait)
+	}
+	attempts.advance(2 * time.Second)
+	_ = login("wrong")
+	if wait := retryAfter(login("Password!2")); wait != testLockoutPolicy.Cooldown {
+		t.Fatalf("expected a lockout for the cooldown, got %s", wait)
+	}
+	locked := events.byType(users.EventAccountLocked)
+	if len(locked) != 1 || locked[0].UserID != reg.UserID {
+		t.Fatalf("expected an account locked event, got %+v", locked)
+	}
+
+	if err := svc.UnlockUser(ctx, reg.UserID); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if err := login("Password!2"); err != nil {
+		t.Fatalf("expected the unlocked account to sign in: %v", err)
+	}
+	if len(events.byType(users.EventAccountUnlocked)) != 1 {
+		t.Fatalf("expected an account unlocked event")
+	}
+
+	for i := 0; i < 5; i++ {
+		attempts.advance(time.Minute)
+		_ = login("wrong")
+	}
+	retryAfter(login("Password!2"))
+	attempts.advance(testLockoutPolicy.Cooldown)
+	if err := login("Password!2"); err != nil {
+		t.Fatalf("expected the lockout to lift after the cooldown: %v", err)
+	}
+}
+
+func TestChangePasswordIsThrottledAndCountsFailedSignIns(t *testing.T) {
+	attempts := newMemoryLoginAttempts()
+	svc, _, _, _ := newTestService(t,
+		users.WithLoginLockout(attempts, testLockoutPolicy),
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "changer@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := svc.ChangePassword(ctx, reg.UserID, "wrong", "Password!3"); !errors.Is(err, users.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+	if err := svc.ChangePassword(ctx, reg.UserID, "Password!2", "Password!3"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected wrong passwords to trigger the login backoff, got %v", err)
+	}
+	_, err = svc.Authenticate(ctx, users.AuthenticateRequest{Email: "changer@example.com", Password: "Password!2"})
+	if !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected the backoff to apply to sign-ins too, got %v", err)
+	}
+
+	attempts.advance(time.Second)
+	if err := svc.ChangePassword(ctx, reg.UserID, "Password!2", "Password!3"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if err := svc.ChangePassword(ctx, reg.UserID, "Password!3", "Password!4"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected password changes to be throttled, got %v", err)
+	}
+}
+
+func TestLoginLockoutTracksUnknownEmailsAndAddresses(t *testing.T) {
+	attempts := newMemoryLoginAttempts()
+	events := &memoryEvents{}
+	svc, _, _, _ := newTestService(t, users.WithLoginLockout(attempts, testLockoutPolicy), users.WithEventPublisher(events))
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "bystander@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	login := func(email, password, ip string) error {
+		_, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: email, Password: password, Client: users.ClientInfo{IP: ip}})
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		_ = login("nobody@example.com", "guess", "198.51.100.1")
+	}
+	if err := login("nobody@example.com", "guess", "198.51.100.1"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected an unknown email to be throttled like an account, got %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_ = login("spray"+string(rune('a'+i))+"@example.com", "guess", "198.51.100.1")
+	}
+	if err := login("bystander@example.com", "Password!2", "198.51.100.1"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected the address to be blocked, got %v", err)
+	}
+	if len(events.byType(users.EventLoginAddressBlocked)) != 1 {
+		t.Fatalf("expected an address blocked event")
+	}
+	if err := login("bystander@example.com", "Password!2", "198.51.100.2"); err != nil {
+		t.Fatalf("expected other addresses to sign in: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package users
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+const (
+	// magicLinkTTL is how long a sign-in link stays valid.
+	magicLinkTTL = 15 * time.Minute
+	// Links can be requested at most magicLinkEmailLimit times per window for an address, and
+	// magicLinkIPLimit times per window from an IP address.
+	magicLinkEmailLimit = 3
+	magicLinkIPLimit    = 10
+	magicLinkWindow     = 15 * time.Minute
+)
+
+// MagicLink is handed to the device that asked for a sign-in link. The link only works together
+// with DeviceToken, so a link forwarded to or intercepted on another device is useless.
+type MagicLink struct {
+	DeviceToken string
+	ExpiresIn   int64
+}
+
+var ErrMagicLinkInvalid = errors.New("invalid or expired sign-in link")
+
+// RequestMagicLink mails a single-use sign-in link to the address and invalidates the user's
+// earlier links. Unknown and disabled addresses get the same answer without an email, so
+// callers cannot probe for accounts. Requests are throttled per address and per client IP.
+func (s *Service) RequestMagicLink(ctx context.Context, email string, client ClientInfo) (*MagicLink, error) {
+	if s.userTokens == nil || !s.magicLinks {
+		return nil, errors.New("magic links not configured")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if err := s.throttle(ctx, "magic-link:"+auth.HashToken(email), magicLinkEmailLimit, magicLinkWindow); err != nil {
+		return nil, err
+	}
+	if client.IP != "" {
+		if err := s.throttle(ctx, "magic-link-ip:"+client.IP, magicLinkIPLimit, magicLinkWindow); err != nil {
+			return nil, err
+		}
+	}
+
+	deviceToken, err := newUserTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	link := &MagicLink{DeviceToken: deviceToken, ExpiresIn: int64(magicLinkTTL.Seconds())}
+
+	user, err := s.repo.FindByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		return link, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == "disabled" {
+		return link, nil
+	}
+
+	if err := s.userTokens.InvalidateUserTokens(ctx, user.ID, TokenPurposeMagicLink); err != nil {
+		return nil, err
+	}
+	if err := s.sendBoundUserToken(ctx, user, TokenPurposeMagicLink, NotificationMagicLink, magicLinkTTL, auth.HashToken(deviceToken)); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// RedeemMagicLink signs in with a link from RequestMagicLink and the device token returned with
+// it. The link is spent even when the device token does not match. Since the link proves
+// control of the mailbox, a pending account is verified and activated. Users with MFA get an
+// MFA challenge instead of tokens.
+func (s *Service) RedeemMagicLink(ctx context.Context, token, deviceToken string, client ClientInfo) (*AuthenticateResult, error) {
+	if s.userTokens == nil || !s.magicLinks {
+		return nil, errors.New("magic links not configured")
+	}
+	record, err := s.userTokens.ConsumeUserToken(ctx, TokenPurposeMagicLink, auth.HashToken(strings.TrimSpace(token)))
+	if err != nil {
+		if errors.Is(err, ErrUserTokenNotFound) {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+	binding := auth.HashToken(strings.TrimSpace(deviceToken))
+	if !record.BindingHash.Valid || subtle.ConstantTimeCompare([]byte(binding), []byte(record.BindingHash.String)) != 1 {
+		return nil, ErrMagicLinkInvalid
+	}
+
+	user, err := s.repo.FindByID(ctx, record.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+	if user.Status == "disabled" {
+		return nil, ErrUserDisabled
+	}
+	if !user.EmailVerifiedAt.Valid {
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		if user.Status == "pending" {
+			user.Status = "active"
+		}
+		if err := s.repo.Update(ctx, user); err != nil {
+			return nil, err
+		}
+		if err := s.userTokens.InvalidateUserTokens(ctx, user.ID, TokenPurposeEmailVerification); err != nil {
+			return nil, err
+		}
+		s.emit(ctx, EventEmailVerified, user.ID, map[string]any{"email": user.Email})
+	}
+
+	challenge, err := s.mfaChallenge(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &AuthenticateResult{UserID: user.ID, MFA: challenge}, nil
+	}
+
+	tokens, err := s.issueTokens(ctx, user, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthenticateResult{UserID: user.ID, Tokens: *tokens}, nil
+}
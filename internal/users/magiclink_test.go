@@ -0,0 +1,103 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestMagicLinkLogin(t *testing.T) {
+	notifier := &memoryNotifier{}
+	svc, repo, _, _ := newTestService(t,
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+		users.WithNotifier(notifier),
+		users.WithMagicLinks(true),
+		users.WithVerifiedEmailRequired(true),
+	)
+	ctx := context.Background()
+	client := users.ClientInfo{IP: "203.0.113.7"}
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "linked@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	first, err := svc.RequestMagicLink(ctx, "Linked@example.com", client)
+	if err != nil {
+		t.Fatalf("request magic link: %v", err)
+	}
+	sent, ok := notifier.last(users.NotificationMagicLink)
+	if !ok || sent.UserID != reg.UserID || first.DeviceToken == "" {
+		t.Fatalf("expected a sign-in link and a device token, got %+v, %+v", sent, first)
+	}
+	if _, err := svc.RedeemMagicLink(ctx, sent.Token, "another-device", client); !errors.Is(err, users.ErrMagicLinkInvalid) {
+		t.Fatalf("expected the link to be bound to the requesting device, got %v", err)
+	}
+	if _, err := svc.RedeemMagicLink(ctx, sent.Token, first.DeviceToken, client); !errors.Is(err, users.ErrMagicLinkInvalid) {
+		t.Fatalf("expected a link opened on another device to be spent, got %v", err)
+	}
+
+	older, _ := svc.RequestMagicLink(ctx, "linked@example.com", client)
+	olderSent, _ := notifier.last(users.NotificationMagicLink)
+	newer, err := svc.RequestMagicLink(ctx, "linked@example.com", client)
+	if err != nil {
+		t.Fatalf("request magic link: %v", err)
+	}
+	newerSent, _ := notifier.last(users.NotificationMagicLink)
+	if _, err := svc.RedeemMagicLink(ctx, olderSent.Token, older.DeviceToken, client); !errors.Is(err, users.ErrMagicLinkInvalid) {
+		t.Fatalf("expected a newer link to invalidate the older one, got %v", err)
+	}
+
+	login, err := svc.RedeemMagicLink(ctx, newerSent.Token, newer.DeviceToken, client)
+	if err != nil {
+		t.Fatalf("redeem magic link: %v", err)
+	}
+	if login.UserID != reg.UserID || login.Tokens.AccessToken == "" || login.Tokens.RefreshToken == "" {
+		t.Fatalf("expected a token pair, got %+v", login)
+	}
+	if _, err := svc.RedeemMagicLink(ctx, newerSent.Token, newer.DeviceToken, client); !errors.Is(err, users.ErrMagicLinkInvalid) {
+		t.Fatalf("expected the link to be single use, got %v", err)
+	}
+	user, _ := repo.FindByID(ctx, reg.UserID)
+	if user.Status != "active" || !user.EmailVerifiedAt.Valid {
+		t.Fatalf("expected the link to verify the pending account, got %+v", user)
+	}
+
+	if _, err := svc.RequestMagicLink(ctx, "linked@example.com", client); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected links to the address to be throttled, got %v", err)
+	}
+}
+
+func TestMagicLinkUnknownAddressesAndIPLimit(t *testing.T) {
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t,
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+		users.WithNotifier(notifier),
+		users.WithMagicLinks(true),
+	)
+	ctx := context.Background()
+	client := users.ClientInfo{IP: "198.51.100.23"}
+
+	link, err := svc.RequestMagicLink(ctx, "ghost@example.com", client)
+	if err != nil || link.DeviceToken == "" {
+		t.Fatalf("expected unknown addresses to get the same answer, got %+v, %v", link, err)
+	}
+	if notifier.count(users.NotificationMagicLink) != 0 {
+		t.Fatalf("expected no email for an unknown address")
+	}
+
+	for i := 0; i < 9; i++ {
+		if _, err := svc.RequestMagicLink(ctx, fmt.Sprintf("ghost%d@example.com", i), client); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if _, err := svc.RequestMagicLink(ctx, "someone-else@example.com", client); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected requests from the IP to be throttled, got %v", err)
+	}
+	if _, err := svc.RequestMagicLink(ctx, "someone-else@example.com", users.ClientInfo{IP: "198.51.100.24"}); err != nil {
+		t.Fatalf("expected other IPs to be unaffected: %v", err)
+	}
+}
@@ -0,0 +1,611 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// recoveryCodeCount is the number of recovery codes handed out at enrollment.
+const recoveryCodeCount = 10
+
+// mfaEnrollClaim marks a challenge issued to a user who must enroll before signing in.
+const mfaEnrollClaim = "mfa_enroll"
+
+// Each user may try mfaCodeAttempts codes per mfaAttemptWindow, and each MFA challenge allows as
+// many, so that six-digit codes cannot be guessed. A correct code gives the user's back.
+const (
+	mfaCodeAttempts  = 5
+	mfaAttemptWindow = 15 * time.Minute
+)
+
+// MFA is a user's TOTP enrollment. It only protects logins once ConfirmedAt is set.
+// LastUsedStep is the time step of the last accepted code, so codes cannot be replayed.
+type MFA struct {
+	UserID       string
+	Secret       string
+	LastUsedStep int64
+	ConfirmedAt  sql.NullTime
+	CreatedAt    time.Time
+}
+
+// MFARepository stores TOTP enrollments and recovery codes.
+type MFARepository interface {
+	FindMFA(ctx context.Context, userID string) (*MFA, error)
+	SaveMFA(ctx context.Context, m *MFA) error
+	ConfirmMFA(ctx context.Context, userID string, at time.Time) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	DeleteMFA(ctx context.Context, userID string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+}
+
+// MFAChallenge is returned by Authenticate instead of tokens when a second factor is needed.
+// EnrollmentRequired is set when the user's roles demand MFA but none is configured yet.
+type MFAChallenge struct {
+	Token              string
+	ExpiresIn          int64
+	EnrollmentRequired bool
+}
+
+// MFAEnrollment carries the secret to load into an authenticator app.
+type MFAEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFALoginRequest completes a login with the code for an MFA challenge.
+type MFALoginRequest struct {
+	Token  string
+	Code   string
+	Client ClientInfo
+}
+
+var (
+	ErrMFANotEnrolled        = errors.New("mfa not enrolled")
+	ErrMFAAlreadyEnabled     = errors.New("mfa already enabled")
+	ErrMFARequired           = errors.New("second factor required")
+	ErrMFAInvalidCode        = errors.New("invalid verification code")
+	ErrMFAEnrollmentRequired = errors.New("mfa enrollment required")
+	ErrMFAMandatory          = errors.New("mfa is mandatory for the user's roles")
+)
+
+// SQLMFARepository persists enrollments in user_mfa and recovery codes in mfa_recovery_codes.
+// TOTP secrets are encrypted with the secret box, bound to the user ID.
+type SQLMFARepository struct {
+	db      *sql.DB
+	secrets *auth.SecretBox
+}
+
+// NewSQLMFARepository creates an MFA repository instance.
+func NewSQLMFARepository(db *sql.DB, secrets *auth.SecretBox) *SQLMFARepository {
+	return &SQLMFARepository{db: db, secrets: secrets}
+}
+
+// FindMFA returns the user's enrollment. Secrets stored before encryption was introduced are
+// read as they are until EncryptPlaintextSecrets has run.
+func (r *SQLMFARepository) FindMFA(ctx context.Context, userID string) (*MFA, error) {
+	query := `SELECT user_id, totp_secret, last_used_step, confirmed_at, created_at FROM user_mfa WHERE user_id=$1`
+	m := &MFA{}
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&m.UserID, &m.Secret, &m.LastUsedStep, &m.ConfirmedAt, &m.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth.IsSealed(m.Secret) {
+		if m.Secret, err = r.secrets.Open(m.Secret, m.UserID); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// SaveMFA stores a pending enrollment, replacing an unconfirmed one.
+func (r *SQLMFARepository) SaveMFA(ctx context.Context, m *MFA) error {
+	sealed, err := r.secrets.Seal(m.Secret, m.UserID)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO user_mfa (user_id, totp_secret) VALUES ($1,$2)
+ON CONFLICT (user_id) DO UPDATE SET totp_secret=EXCLUDED.totp_secret, last_used_step=0, confirmed_at=NULL, created_at=now()
+WHERE user_mfa.confirmed_at IS NULL
+RETURNING created_at`
+	err = r.db.QueryRowContext(ctx, query, m.UserID, sealed).Scan(&m.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMFAAlreadyEnabled
+	}
+	return err
+}
+
+// HasEnrollments reports whether any user has confirmed an MFA enrollment. It needs no secrets,
+// so it can tell whether MFA may be switched off.
+func (r *SQLMFARepository) HasEnrollments(ctx context.Context) (bool, error) {
+	var enrolled bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_mfa WHERE confirmed_at IS NOT NULL)`).Scan(&enrolled)
+	return enrolled, err
+}
+
+// EncryptPlaintextSecrets encrypts the TOTP secrets stored before encryption was introduced and
+// returns how many it changed. It is safe to run repeatedly and concurrently with enrollments.
+func (r *SQLMFARepository) EncryptPlaintextSecrets(ctx context.Context) (int, error) {
+	plain, err := r.plaintextSecrets(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	encrypted := 0
+	for userID, secret := range plain {
+		sealed, err := r.secrets.Seal(secret, userID)
+		if err != nil {
+			return encrypted, err
+		}
+		res, err := r.db.ExecContext(ctx, `UPDATE user_mfa SET totp_secret=$1 WHERE user_id=$2 AND totp_secret=$3`, sealed, userID, secret)
+		if err != nil {
+			return encrypted, err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+			encrypted++
+		}
+	}
+	return encrypted, nil
+}
+
+func (r *SQLMFARepository) plaintextSecrets(ctx context.Context) (map[string]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT user_id, totp_secret FROM user_mfa WHERE totp_secret NOT LIKE 'v1:%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plain := make(map[string]string)
+	for rows.Next() {
+		var userID, secret string
+		if err := rows.Scan(&userID, &secret); err != nil {
+			return nil, err
+		}
+		plain[userID] = secret
+	}
+	return plain, rows.Err()
+}
+
+// ConfirmMFA activates a pending enrollment.
+func (r *SQLMFARepository) ConfirmMFA(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE user_mfa SET confirmed_at=$1 WHERE user_id=$2`, at, userID)
+	return err
+}
+
+// UseTOTPStep records an accepted code. It reports false when the step, or a later one, was
+// already used, which happens when the same code is submitted twice concurrently.
+func (r *SQLMFARepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE user_mfa SET last_used_step=$1 WHERE user_id=$2 AND last_used_step < $1`, step, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// DeleteMFA removes the enrollment together with its recovery codes.
+func (r *SQLMFARepository) DeleteMFA(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes and stores the new hashes.
+func (r *SQLMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1,$2)`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode burns an unused recovery code and reports whether one matched.
+func (r *SQLMFARepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE mfa_recovery_codes SET used_at=now() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// EnrollMFA starts a TOTP enrollment. It only takes effect once confirmed with a first code.
+func (s *Service) EnrollMFA(ctx context.Context, userID string) (*MFAEnrollment, error) {
+	if s.mfa == nil {
+		return nil, errors.New("mfa store not configured")
+	}
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.SaveMFA(ctx, &MFA{UserID: user.ID, Secret: secret}); err != nil {
+		return nil, err
+	}
+	return &MFAEnrollment{Secret: secret, URI: auth.TOTPURI(s.mfaIssuer, user.Email, secret)}, nil
+}
+
+// EnrollMFAWithChallenge starts enrollment for a user whose login was held back because their
+// roles require MFA.
+func (s *Service) EnrollMFAWithChallenge(ctx context.Context, challenge string) (*MFAEnrollment, error) {
+	claims, err := s.issuer.ParseAndValidate(challenge, auth.WithTokenType(auth.TokenTypeMFA))
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if enroll, _ := claims[mfaEnrollClaim].(bool); !enroll {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	sub, _ := claims["sub"].(string)
+	return s.EnrollMFA(ctx, sub)
+}
+
+// ConfirmMFA activates a pending enrollment with a first code and returns the recovery codes,
+// which are shown to the user exactly once.
+func (s *Service) ConfirmMFA(ctx context.Context, userID, code string) ([]string, error) {
+	if s.mfa == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	m, err := s.mfa.FindMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if m.ConfirmedAt.Valid {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := s.verifyTOTP(ctx, m, code); err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ConfirmMFA(ctx, userID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.issueRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(ctx, EventMFAEnabled, userID, nil)
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a current code.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	m, err := s.confirmedMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSecondFactor(ctx, m, code); err != nil {
+		return nil, err
+	}
+	return s.issueRecoveryCodes(ctx, userID)
+}
+
+// DisableMFA removes the user's enrollment after checking a current code. Users whose roles
+// require MFA cannot disable it.
+func (s *Service) DisableMFA(ctx context.Context, userID, code string) error {
+	m, err := s.confirmedMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+	required, err := s.mfaRequired(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFAMandatory
+	}
+	if err := s.checkSecondFactor(ctx, m, code); err != nil {
+		return err
+	}
+	if err := s.mfa.DeleteMFA(ctx, userID); err != nil {
+		return err
+	}
+	s.emit(ctx, EventMFADisabled, userID, nil)
+	return nil
+}
+
+// VerifyMFA completes a login held back by Authenticate. A challenge issued for a mandatory
+// enrollment also confirms the enrollment, and the result then carries the recovery codes.
+// A challenge can be redeemed only once and tried mfaCodeAttempts times.
+func (s *Service) VerifyMFA(ctx context.Context, req MFALoginRequest) (*AuthenticateResult, error) {
+	if s.mfa == nil {
+		return nil, ErrTokenInvalid
+	}
+	claims, err := s.issuer.ParseAndValidate(req.Token, auth.WithTokenType(auth.TokenTypeMFA))
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	sub, _ := claims["sub"].(string)
+	challengeID, _ := claims["jti"].(string)
+	if challengeID == "" {
+		return nil, ErrTokenInvalid
+	}
+
+	user, err := s.repo.FindByID(ctx, sub)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if err := s.signInAllowed(user); err != nil {
+		return nil, err
+	}
+	if err := s.useMFAChallenge(ctx, challengeID); err != nil {
+		return nil, err
+	}
+
+	m, err := s.mfa.FindMFA(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, ErrMFANotEnrolled) {
+			return nil, ErrMFAEnrollmentRequired
+		}
+		return nil, err
+	}
+
+	result := &AuthenticateResult{UserID: user.ID}
+	if m.ConfirmedAt.Valid {
+		err = s.checkSecondFactor(ctx, m, req.Code)
+	} else if enroll, _ := claims[mfaEnrollClaim].(bool); enroll {
+		result.RecoveryCodes, err = s.ConfirmMFA(ctx, user.ID, req.Code)
+	} else {
+		return nil, ErrMFAEnrollmentRequired
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redeemMFAChallenge(ctx, challengeID); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issueTokens(ctx, user, req.Client, nil)
+	if err != nil {
+		return nil, err
+	}
+	result.Tokens = *tokens
+	return result, nil
+}
+
+// useMFAChallenge counts an attempt at the challenge with the given ID, refusing one that was
+// already redeemed or has used up its attempts.
+func (s *Service) useMFAChallenge(ctx context.Context, id string) error {
+	attempts, _, err := s.mfaAttempts.Attempt(ctx, mfaChallengeKey(id), s.issuer.MFATokenTTL())
+	if err != nil {
+		return err
+	}
+	if attempts > mfaCodeAttempts {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// redeemMFAChallenge keeps the challenge from being used again before it expires.
+func (s *Service) redeemMFAChallenge(ctx context.Context, id string) error {
+	return s.mfaAttempts.Exhaust(ctx, mfaChallengeKey(id), s.issuer.MFATokenTTL())
+}
+
+func mfaUserKey(userID string) string {
+	return "user:" + userID
+}
+
+func mfaChallengeKey(id string) string {
+	return "challenge:" + id
+}
+
+// mfaChallenge returns the challenge that replaces the token pair when the user has MFA
+// enabled or is required to enroll, and nil otherwise.
+func (s *Service) mfaChallenge(ctx context.Context, user *User) (*MFAChallenge, error) {
+	if s.mfa == nil {
+		return nil, nil
+	}
+	m, err := s.mfa.FindMFA(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		return nil, err
+	}
+
+	claims := map[string]any{"jti": uuid.NewString()}
+	if m == nil || !m.ConfirmedAt.Valid {
+		required, err := s.mfaRequired(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !required {
+			return nil, nil
+		}
+		claims[mfaEnrollClaim] = true
+	}
+
+	token, err := s.issuer.GenerateMFAToken(user.ID, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallenge{
+		Token:              token,
+		ExpiresIn:          int64(s.issuer.MFATokenTTL().Seconds()),
+		EnrollmentRequired: claims[mfaEnrollClaim] == true,
+	}, nil
+}
+
+// verifySecondFactor checks the code submitted alongside a password in single-step logins,
+// such as the OpenID Connect sign-in page.
+func (s *Service) verifySecondFactor(ctx context.Context, user *User, code string) error {
+	if s.mfa == nil {
+		return nil
+	}
+	m, err := s.mfa.FindMFA(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		return err
+	}
+	if m == nil || !m.ConfirmedAt.Valid {
+		required, err := s.mfaRequired(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if required {
+			return ErrMFAEnrollmentRequired
+		}
+		return nil
+	}
+	if strings.TrimSpace(code) == "" {
+		return ErrMFARequired
+	}
+	return s.checkSecondFactor(ctx, m, code)
+}
+
+// mfaRequired reports whether the user holds a role for which MFA is mandatory.
+func (s *Service) mfaRequired(ctx context.Context, userID string) (bool, error) {
+	if len(s.mfaRequiredRoles) == 0 {
+		return false, nil
+	}
+	roles, err := s.listRoles(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		for _, required := range s.mfaRequiredRoles {
+			if role == required {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (s *Service) confirmedMFA(ctx context.Context, userID string) (*MFA, error) {
+	if s.mfa == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	m, err := s.mfa.FindMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !m.ConfirmedAt.Valid {
+		return nil, ErrMFANotEnrolled
+	}
+	return m, nil
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery code. Once the user
+// has used up their attempts it returns a RateLimitError without looking at the code.
+func (s *Service) checkSecondFactor(ctx context.Context, m *MFA, code string) error {
+	key := mfaUserKey(m.UserID)
+	attempts, wait, err := s.mfaAttempts.Attempt(ctx, key, mfaAttemptWindow)
+	if err != nil {
+		return err
+	}
+	if attempts > mfaCodeAttempts {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	if err := s.matchSecondFactor(ctx, m, code); err != nil {
+		return err
+	}
+	return s.mfaAttempts.Reset(ctx, key)
+}
+
+func (s *Service) matchSecondFactor(ctx context.Context, m *MFA, code string) error {
+	err := s.verifyTOTP(ctx, m, code)
+	if !errors.Is(err, ErrMFAInvalidCode) {
+		return err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrMFAInvalidCode
+	}
+	used, err := s.mfa.UseRecoveryCode(ctx, m.UserID, auth.HashToken(normalized))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrMFAInvalidCode
+	}
+	s.emit(ctx, EventMFARecoveryCodeUsed, m.UserID, nil)
+	return nil
+}
+
+func (s *Service) verifyTOTP(ctx context.Context, m *MFA, code string) error {
+	step, ok := auth.VerifyTOTP(m.Secret, code, time.Now(), m.LastUsedStep)
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+	fresh, err := s.mfa.UseTOTPStep(ctx, m.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrMFAInvalidCode
+	}
+	return nil
+}
+
+func (s *Service) issueRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = auth.HashToken(normalizeRecoveryCode(code))
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns 80 random bits formatted as two groups of eight characters.
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	return encoded[:8] + "-" + encoded[8:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
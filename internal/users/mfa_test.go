@@ -0,0 +1,263 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestMFAEnrollmentAndTwoStepLogin(t *testing.T) {
+	events := &memoryEvents{}
+	svc, _, _, _ := newTestService(t, users.WithMFA(newMemoryMFA(), newMemoryMFAAttempts(), "Shop"), users.WithEventPublisher(events))
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "totp@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	enrollment, err := svc.EnrollMFA(ctx, res.UserID)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	uri, err := url.Parse(enrollment.URI)
+	if err != nil || uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrollment.Secret {
+		t.Fatalf("unexpected otpauth uri %q", enrollment.URI)
+	}
+
+	// An unconfirmed enrollment does not affect logins yet.
+	login, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2"})
+	if err != nil || login.MFA != nil {
+		t.Fatalf("expected a plain login before confirmation, got %+v %v", login, err)
+	}
+
+	if _, err := svc.ConfirmMFA(ctx, res.UserID, "000000"); !errors.Is(err, users.ErrMFAInvalidCode) {
+		t.Fatalf("expected wrong confirmation code to fail, got %v", err)
+	}
+	now := auth.TOTPStep(time.Now())
+	recoveryCodes, err := svc.ConfirmMFA(ctx, res.UserID, totpCode(t, enrollment.Secret, now))
+	if err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(recoveryCodes))
+	}
+	if enabled := events.byType(users.EventMFAEnabled); len(enabled) != 1 {
+		t.Fatalf("expected mfa enabled event, got %+v", enabled)
+	}
+
+	login, err = svc.Authenticate(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if login.MFA == nil || login.MFA.EnrollmentRequired || login.Tokens.AccessToken != "" {
+		t.Fatalf("expected an mfa challenge without tokens, got %+v", login)
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: "", Code: "123456"}); !errors.Is(err, users.ErrTokenInvalid) {
+		t.Fatalf("expected a missing challenge to be rejected, got %v", err)
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: login.MFA.Token, Code: totpCode(t, enrollment.Secret, now)}); !errors.Is(err, users.ErrMFAInvalidCode) {
+		t.Fatalf("expected a replayed code to be rejected, got %v", err)
+	}
+	verified, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: login.MFA.Token, Code: totpCode(t, enrollment.Secret, now+1)})
+	if err != nil {
+		t.Fatalf("verify mfa: %v", err)
+	}
+	if verified.Tokens.AccessToken == "" || verified.RecoveryCodes != nil {
+		t.Fatalf("expected tokens after the second factor, got %+v", verified)
+	}
+
+	// A challenge is redeemed once; the recovery code needs a new one.
+	login, err = svc.Authenticate(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2"})
+	if err != nil || login.MFA == nil {
+		t.Fatalf("expected another mfa challenge, got %+v %v", login, err)
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: login.MFA.Token, Code: recoveryCodes[0]}); err != nil {
+		t.Fatalf("expected recovery code to work: %v", err)
+	}
+	login, err = svc.Authenticate(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2"})
+	if err != nil || login.MFA == nil {
+		t.Fatalf("expected another mfa challenge, got %+v %v", login, err)
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: login.MFA.Token, Code: recoveryCodes[0]}); !errors.Is(err, users.ErrMFAInvalidCode) {
+		t.Fatalf("expected recovery code to be single-use, got %v", err)
+	}
+
+	if _, err := svc.VerifyCredentials(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2"}); !errors.Is(err, users.ErrMFARequired) {
+		t.Fatalf("expected single-step sign-in to ask for a code, got %v", err)
+	}
+	if _, err := svc.VerifyCredentials(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2", MFACode: recoveryCodes[1]}); err != nil {
+		t.Fatalf("expected single-step sign-in with a code to work: %v", err)
+	}
+
+	if err := svc.DisableMFA(ctx, res.UserID, recoveryCodes[2]); err != nil {
+		t.Fatalf("disable mfa: %v", err)
+	}
+	login, err = svc.Authenticate(ctx, users.AuthenticateRequest{Email: "totp@example.com", Password: "Password!2"})
+	if err != nil || login.MFA != nil {
+		t.Fatalf("expected a plain login after disabling mfa, got %+v %v", login, err)
+	}
+}
+
+func TestMFARequiredRoles(t *testing.T) {
+	svc, _, roles, _ := newTestService(t, users.WithMFA(newMemoryMFA(), newMemoryMFAAttempts(), "Shop"), users.WithMFARequiredRoles([]string{"admin"}))
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "root@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	roles.assign(res.UserID, "admin")
+
+	login, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "root@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if login.MFA == nil || !login.MFA.EnrollmentRequired {
+		t.Fatalf("expected an enrollment challenge, got %+v", login)
+	}
+	if _, err := svc.VerifyCredentials(ctx, users.AuthenticateRequest{Email: "root@example.com", Password: "Password!2"}); !errors.Is(err, users.ErrMFAEnrollmentRequired) {
+		t.Fatalf("expected single-step sign-in to require enrollment, got %v", err)
+	}
+
+	enrollment, err := svc.EnrollMFAWithChallenge(ctx, login.MFA.Token)
+	if err != nil {
+		t.Fatalf("enroll with challenge: %v", err)
+	}
+	verified, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: login.MFA.Token, Code: totpCode(t, enrollment.Secret, auth.TOTPStep(time.Now()))})
+	if err != nil {
+		t.Fatalf("verify mfa: %v", err)
+	}
+	if verified.Tokens.AccessToken == "" || len(verified.RecoveryCodes) != 10 {
+		t.Fatalf("expected tokens and recovery codes, got %+v", verified)
+	}
+
+	if err := svc.DisableMFA(ctx, res.UserID, verified.RecoveryCodes[0]); !errors.Is(err, users.ErrMFAMandatory) {
+		t.Fatalf("expected mandatory mfa to stay enabled, got %v", err)
+	}
+}
+
+func TestMFACodeAttemptsAreLimited(t *testing.T) {
+	attempts := newMemoryMFAAttempts()
+	svc, _, _, _ := newTestService(t, users.WithMFA(newMemoryMFA(), attempts, "Shop"))
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "guess@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	enrollment, err := svc.EnrollMFA(ctx, res.UserID)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	now := auth.TOTPStep(time.Now())
+	recoveryCodes, err := svc.ConfirmMFA(ctx, res.UserID, totpCode(t, enrollment.Secret, now))
+	if err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	password := users.AuthenticateRequest{Email: "guess@example.com", Password: "Password!2"}
+	challenge := func() string {
+		t.Helper()
+		login, err := svc.Authenticate(ctx, password)
+		if err != nil || login.MFA == nil {
+			t.Fatalf("expected an mfa challenge, got %+v %v", login, err)
+		}
+		return login.MFA.Token
+	}
+
+	token := challenge()
+	for i := 0; i < 4; i++ {
+		if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: token, Code: "000000"}); !errors.Is(err, users.ErrMFAInvalidCode) {
+			t.Fatalf("attempt %d: expected an invalid code, got %v", i+1, err)
+		}
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: token, Code: totpCode(t, enrollment.Secret, now+1)}); err != nil {
+		t.Fatalf("verify mfa: %v", err)
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: token, Code: recoveryCodes[0]}); !errors.Is(err, users.ErrTokenInvalid) {
+		t.Fatalf("expected a redeemed challenge to be rejected, got %v", err)
+	}
+
+	token = challenge()
+	for i := 0; i < 5; i++ {
+		if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: token, Code: "000000"}); !errors.Is(err, users.ErrMFAInvalidCode) {
+			t.Fatalf("attempt %d: expected an invalid code, got %v", i+1, err)
+		}
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: token, Code: recoveryCodes[0]}); !errors.Is(err, users.ErrTokenInvalid) {
+		t.Fatalf("expected a challenge without attempts left to be rejected, got %v", err)
+	}
+	if _, err := svc.VerifyMFA(ctx, users.MFALoginRequest{Token: challenge(), Code: recoveryCodes[0]}); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected a fresh challenge to be refused after five wrong codes, got %v", err)
+	}
+	withCode := password
+	withCode.MFACode = recoveryCodes[0]
+	if _, err := svc.VerifyCredentials(ctx, withCode); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected single-step sign-in to be refused too, got %v", err)
+	}
+
+	attempts.advance(15 * time.Minute)
+	if _, err := svc.VerifyCredentials(ctx, withCode); err != nil {
+		t.Fatalf("expected codes to be accepted again after the window: %v", err)
+	}
+}
+
+// memoryMFAAttempts is an in-memory auth.MFAAttempts with a clock the test moves forward.
+type memoryMFAAttempts struct {
+	mu     sync.Mutex
+	now    time.Time
+	counts map[string]int
+	ends   map[string]time.Time
+}
+
+func newMemoryMFAAttempts() *memoryMFAAttempts {
+	return &memoryMFAAttempts{now: time.Now(), counts: make(map[string]int), ends: make(map[string]time.Time)}
+}
+
+func (m *memoryMFAAttempts) Attempt(_ context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.now.Before(m.ends[key]) {
+		m.counts[key] = 0
+		m.ends[key] = m.now.Add(window)
+	}
+	m.counts[key]++
+	return m.counts[key], m.ends[key].Sub(m.now), nil
+}
+
+func (m *memoryMFAAttempts) Exhaust(_ context.Context, key string, window time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[key] = math.MaxInt32
+	m.ends[key] = m.now.Add(window)
+	return nil
+}
+
+func (m *memoryMFAAttempts) Reset(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.counts, key)
+	delete(m.ends, key)
+	return nil
+}
+
+func (m *memoryMFAAttempts) advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	return code
+}
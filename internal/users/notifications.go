@@ -0,0 +1,54 @@
+package users
+
+import (
+	"context"
+	"time"
+)
+
+// Kinds of notifications sent to users.
+const (
+	NotificationEmailVerification = "email_verification"
+	NotificationPasswordReset     = "password_reset"
+	NotificationPasswordChanged   = "password_changed"
+	// NotificationEmailChangeConfirm goes to the new address, NotificationEmailChangeRequested
+	// to the old one with a link to cancel the change.
+	NotificationEmailChangeConfirm   = "email_change_confirm"
+	NotificationEmailChangeRequested = "email_change_requested"
+	NotificationMagicLink            = "magic_link"
+)
+
+// Notification is a transactional message for a user. Token is the plaintext secret the message
+// carries, if any; it is never stored. NewEmail is only set for email change notices.
+type Notification struct {
+	Kind      string
+	UserID    string
+	Email     string
+	Name      string
+	Token     string
+	ExpiresAt time.Time
+	NewEmail  string
+}
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// notify hands a notification to the configured notifier.
+func (s *Service) notify(ctx context.Context, n Notification) error {
+	if s.notifier == nil {
+		return nil
+	}
+	return s.notifier.Notify(ctx, n)
+}
+
+// alert sends a security notification about the account. Alerts are best effort and never
+// fail the caller.
+func (s *Service) alert(ctx context.Context, user *User, kind string) {
+	_ = s.notify(ctx, Notification{
+		Kind:   kind,
+		UserID: user.ID,
+		Email:  user.Email,
+		Name:   user.FirstName.String,
+	})
+}
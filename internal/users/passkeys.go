@@ -0,0 +1,359 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// Passkey is a WebAuthn credential registered by a user. SignCount is the last signature
+// counter reported by the authenticator.
+type Passkey struct {
+	ID         []byte
+	UserID     string
+	Name       string
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+// PasskeyRepository stores passkeys.
+type PasskeyRepository interface {
+	CreatePasskey(ctx context.Context, p *Passkey) error
+	FindPasskey(ctx context.Context, id []byte) (*Passkey, error)
+	ListPasskeys(ctx context.Context, userID string) ([]Passkey, error)
+	UpdatePasskeyCounter(ctx context.Context, id []byte, signCount uint32, usedAt time.Time) error
+	DeletePasskey(ctx context.Context, userID string, id []byte) error
+}
+
+// PasskeyRegistration is the first half of a registration: the options for
+// navigator.credentials.create and the ceremony to finish.
+type PasskeyRegistration struct {
+	CeremonyID string
+	Options    auth.CredentialCreationOptions
+}
+
+// PasskeyLogin is the first half of a passkey login.
+type PasskeyLogin struct {
+	CeremonyID string
+	Options    auth.CredentialRequestOptions
+}
+
+var (
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	ErrPasskeyInvalid  = errors.New("passkey verification failed")
+)
+
+// SQLPasskeyRepository persists passkeys in the webauthn_credentials table.
+type SQLPasskeyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLPasskeyRepository creates a passkey repository instance.
+func NewSQLPasskeyRepository(db *sql.DB) *SQLPasskeyRepository {
+	return &SQLPasskeyRepository{db: db}
+}
+
+// CreatePasskey inserts a new passkey.
+func (r *SQLPasskeyRepository) CreatePasskey(ctx context.Context, p *Passkey) error {
+	query := `INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, aaguid) VALUES ($1,$2,$3,$4,$5,$6) RETURNING created_at`
+	return r.db.QueryRowContext(ctx, query, p.ID, p.UserID, p.Name, p.PublicKey, int64(p.SignCount), p.AAGUID).Scan(&p.CreatedAt)
+}
+
+// FindPasskey returns a passkey by credential ID.
+func (r *SQLPasskeyRepository) FindPasskey(ctx context.Context, id []byte) (*Passkey, error) {
+	query := `SELECT id, user_id, name, public_key, sign_count, aaguid, created_at, last_used_at FROM webauthn_credentials WHERE id=$1`
+	p, err := scanPasskey(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPasskeyNotFound
+	}
+	return p, err
+}
+
+// ListPasskeys returns the user's passkeys, oldest first.
+func (r *SQLPasskeyRepository) ListPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	query := `SELECT id, user_id, name, public_key, sign_count, aaguid, created_at, last_used_at FROM webauthn_credentials WHERE user_id=$1 ORDER BY created_at`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, *p)
+	}
+	return passkeys, rows.Err()
+}
+
+// UpdatePasskeyCounter records a successful login.
+func (r *SQLPasskeyRepository) UpdatePasskeyCounter(ctx context.Context, id []byte, signCount uint32, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE webauthn_credentials SET sign_count=$1, last_used_at=$2 WHERE id=$3`, int64(signCount), usedAt, id)
+	return err
+}
+
+// DeletePasskey removes one of the user's passkeys.
+func (r *SQLPasskeyRepository) DeletePasskey(ctx context.Context, userID string, id []byte) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func scanPasskey(row rowScanner) (*Passkey, error) {
+	p := &Passkey{}
+	var signCount int64
+	if err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.PublicKey, &signCount, &p.AAGUID, &p.CreatedAt, &p.LastUsedAt); err != nil {
+		return nil, err
+	}
+	p.SignCount = uint32(signCount)
+	return p, nil
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the user.
+func (s *Service) BeginPasskeyRegistration(ctx context.Context, userID string) (*PasskeyRegistration, error) {
+	if s.webauthn == nil {
+		return nil, errors.New("passkeys not configured")
+	}
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := userHandle(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.passkeys.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([][]byte, 0, len(existing))
+	for _, p := range existing {
+		exclude = append(exclude, p.ID)
+	}
+
+	ceremonyID, challenge, err := s.startCeremony(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	displayName := strings.TrimSpace(user.FirstName.String + " " + user.LastName.String)
+	if displayName == "" {
+		displayName = user.Email
+	}
+	return &PasskeyRegistration{
+		CeremonyID: ceremonyID,
+		Options:    s.webauthn.CreationOptions(challenge, handle, user.Email, displayName, exclude),
+	}, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores the passkey.
+func (s *Service) FinishPasskeyRegistration(ctx context.Context, userID, ceremonyID, name string, resp auth.RegistrationResponse) (*Passkey, error) {
+	if s.webauthn == nil {
+		return nil, errors.New("passkeys not configured")
+	}
+	ceremony, err := s.takeCeremony(ctx, ceremonyID)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID != userID {
+		return nil, ErrPasskeyInvalid
+	}
+
+	credential, err := s.webauthn.VerifyRegistration(ceremony.Challenge, resp)
+	if err != nil {
+		if errors.Is(err, auth.ErrWebAuthnInvalid) {
+			return nil, ErrPasskeyInvalid
+		}
+		return nil, err
+	}
+	if _, err := s.passkeys.FindPasskey(ctx, credential.ID); !errors.Is(err, ErrPasskeyNotFound) {
+		if err == nil {
+			return nil, ErrPasskeyInvalid
+		}
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := &Passkey{
+		ID:        credential.ID,
+		UserID:    userID,
+		Name:      name,
+		PublicKey: credential.PublicKey,
+		SignCount: credential.SignCount,
+		AAGUID:    credential.AAGUID,
+	}
+	if err := s.passkeys.CreatePasskey(ctx, passkey); err != nil {
+		return nil, err
+	}
+	s.emit(ctx, EventPasskeyRegistered, userID, map[string]any{"passkeyId": PasskeyID(passkey.ID)})
+	return passkey, nil
+}
+
+// BeginPasskeyLogin starts a passwordless login. Passkeys are discoverable, so the browser lets
+// the user pick one and no email is needed, which also avoids revealing which accounts exist.
+func (s *Service) BeginPasskeyLogin(ctx context.Context) (*PasskeyLogin, error) {
+	if s.webauthn == nil {
+		return nil, errors.New("passkeys not configured")
+	}
+	ceremonyID, challenge, err := s.startCeremony(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyLogin{CeremonyID: ceremonyID, Options: s.webauthn.RequestOptions(challenge, nil)}, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and starts a session like a password login does.
+// Passkeys require user verification and therefore already count as two factors, so no MFA
+// challenge follows.
+func (s *Service) FinishPasskeyLogin(ctx context.Context, ceremonyID string, resp auth.AssertionResponse, client ClientInfo) (*AuthenticateResult, error) {
+	if s.webauthn == nil {
+		return nil, errors.New("passkeys not configured")
+	}
+	ceremony, err := s.takeCeremony(ctx, ceremonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(resp.ID, "="))
+	if err != nil {
+		return nil, ErrPasskeyInvalid
+	}
+	passkey, err := s.passkeys.FindPasskey(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrPasskeyNotFound) {
+			return nil, ErrPasskeyInvalid
+		}
+		return nil, err
+	}
+	if resp.Response.UserHandle != "" {
+		handle, err := userHandle(passkey.UserID)
+		if err != nil {
+			return nil, err
+		}
+		presented, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(resp.Response.UserHandle, "="))
+		if err != nil || !bytes.Equal(presented, handle) {
+			return nil, ErrPasskeyInvalid
+		}
+	}
+
+	signCount, err := s.webauthn.VerifyAssertion(ceremony.Challenge, auth.WebAuthnCredential{
+		ID:        passkey.ID,
+		PublicKey: passkey.PublicKey,
+		SignCount: passkey.SignCount,
+	}, resp)
+	if err != nil {
+		if errors.Is(err, auth.ErrWebAuthnCounter) {
+			s.emit(ctx, EventPasskeyCounterRegressed, passkey.UserID, map[string]any{
+				"passkeyId": PasskeyID(passkey.ID),
+				"userAgent": client.UserAgent,
+				"ip":        client.IP,
+			})
+			return nil, ErrPasskeyInvalid
+		}
+		if errors.Is(err, auth.ErrWebAuthnInvalid) {
+			return nil, ErrPasskeyInvalid
+		}
+		return nil, err
+	}
+	if err := s.passkeys.UpdatePasskeyCounter(ctx, passkey.ID, signCount, time.Now()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.FindByID(ctx, passkey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.signInAllowed(user); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issueTokens(ctx, user, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthenticateResult{UserID: user.ID, Tokens: *tokens}, nil
+}
+
+// ListPasskeys returns the user's passkeys.
+func (s *Service) ListPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	if s.passkeys == nil {
+		return nil, nil
+	}
+	return s.passkeys.ListPasskeys(ctx, userID)
+}
+
+// DeletePasskey removes a passkey identified by its base64url credential ID.
+func (s *Service) DeletePasskey(ctx context.Context, userID, id string) error {
+	if s.passkeys == nil {
+		return ErrPasskeyNotFound
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(id, "="))
+	if err != nil || len(raw) == 0 {
+		return ErrPasskeyNotFound
+	}
+	return s.passkeys.DeletePasskey(ctx, userID, raw)
+}
+
+// PasskeyID renders a credential ID the way WebAuthn clients and the API refer to it.
+func PasskeyID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func (s *Service) startCeremony(ctx context.Context, userID string) (string, string, error) {
+	challenge, err := auth.NewWebAuthnChallenge()
+	if err != nil {
+		return "", "", err
+	}
+	ceremonyID := uuid.NewString()
+	ceremony := auth.WebAuthnCeremony{Challenge: challenge, UserID: userID}
+	if err := s.ceremonies.SaveCeremony(ctx, ceremonyID, ceremony, s.webauthn.Timeout()); err != nil {
+		return "", "", err
+	}
+	return ceremonyID, challenge, nil
+}
+
+func (s *Service) takeCeremony(ctx context.Context, ceremonyID string) (*auth.WebAuthnCeremony, error) {
+	ceremony, err := s.ceremonies.TakeCeremony(ctx, ceremonyID)
+	if err != nil {
+		if errors.Is(err, auth.ErrCeremonyNotFound) {
+			return nil, ErrPasskeyInvalid
+		}
+		return nil, err
+	}
+	return ceremony, nil
+}
+
+// userHandle is the WebAuthn user.id: the raw bytes of the user's UUID, which carry no
+// personal information.
+func userHandle(userID string) ([]byte, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	return id[:], nil
+}
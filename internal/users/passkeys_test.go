@@ -0,0 +1,191 @@
+package users_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+const passkeyOrigin = "https://shop.example.com"
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	relyingParty, err := auth.NewWebAuthn("shop.example.com", "Shop", []string{passkeyOrigin})
+	if err != nil {
+		t.Fatalf("new relying party: %v", err)
+	}
+	events := &memoryEvents{}
+	passkeys := newMemoryPasskeys()
+	svc, _, _, _ := newTestService(t,
+		users.WithPasskeys(relyingParty, passkeys, newMemoryCeremonies()),
+		users.WithMFA(newMemoryMFA(), newMemoryMFAAttempts(), "Shop"),
+		users.WithEventPublisher(events),
+	)
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "passkey@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	registration, err := svc.BeginPasskeyRegistration(ctx, res.UserID)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	device := newPasskeyDevice(t)
+	response := device.register(t, registration.Options)
+	if _, err := svc.FinishPasskeyRegistration(ctx, "someone-else", registration.CeremonyID, "Phone", response); !errors.Is(err, users.ErrPasskeyInvalid) {
+		t.Fatalf("expected another user's ceremony to be rejected, got %v", err)
+	}
+
+	registration, err = svc.BeginPasskeyRegistration(ctx, res.UserID)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	passkey, err := svc.FinishPasskeyRegistration(ctx, res.UserID, registration.CeremonyID, "Phone", device.register(t, registration.Options))
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	if passkey.Name != "Phone" || len(events.byType(users.EventPasskeyRegistered)) != 1 {
+		t.Fatalf("unexpected passkey %+v", passkey)
+	}
+	again, err := svc.BeginPasskeyRegistration(ctx, res.UserID)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	if len(again.Options.ExcludeCredentials) != 1 || again.Options.ExcludeCredentials[0].ID != users.PasskeyID(passkey.ID) {
+		t.Fatalf("expected registered passkey to be excluded, got %+v", again.Options.ExcludeCredentials)
+	}
+
+	// MFA does not apply to passkey logins, which already verify the user.
+	enrollment, _ := svc.EnrollMFA(ctx, res.UserID)
+	if _, err := svc.ConfirmMFA(ctx, res.UserID, totpCode(t, enrollment.Secret, auth.TOTPStep(time.Now()))); err != nil {
+		t.Fatalf("confirm mfa: %v", err)
+	}
+
+	login, err := svc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	device.counter = 1
+	result, err := svc.FinishPasskeyLogin(ctx, login.CeremonyID, device.assert(t, login.Options.Challenge, res.UserID), users.ClientInfo{})
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	if result.UserID != res.UserID || result.Tokens.AccessToken == "" || result.MFA != nil {
+		t.Fatalf("expected a token pair for the passkey owner, got %+v", result)
+	}
+	if _, err := svc.FinishPasskeyLogin(ctx, login.CeremonyID, device.assert(t, login.Options.Challenge, res.UserID), users.ClientInfo{}); !errors.Is(err, users.ErrPasskeyInvalid) {
+		t.Fatalf("expected a ceremony to be single-use, got %v", err)
+	}
+
+	// A cloned authenticator replays an old counter.
+	login, _ = svc.BeginPasskeyLogin(ctx)
+	if _, err := svc.FinishPasskeyLogin(ctx, login.CeremonyID, device.assert(t, login.Options.Challenge, res.UserID), users.ClientInfo{}); !errors.Is(err, users.ErrPasskeyInvalid) {
+		t.Fatalf("expected a stale counter to be rejected, got %v", err)
+	}
+	if regressed := events.byType(users.EventPasskeyCounterRegressed); len(regressed) != 1 {
+		t.Fatalf("expected counter regression event, got %+v", regressed)
+	}
+
+	if err := svc.DeletePasskey(ctx, res.UserID, users.PasskeyID(passkey.ID)); err != nil {
+		t.Fatalf("delete passkey: %v", err)
+	}
+	login, _ = svc.BeginPasskeyLogin(ctx)
+	device.counter = 2
+	if _, err := svc.FinishPasskeyLogin(ctx, login.CeremonyID, device.assert(t, login.Options.Challenge, res.UserID), users.ClientInfo{}); !errors.Is(err, users.ErrPasskeyInvalid) {
+		t.Fatalf("expected deleted passkey to be rejected, got %v", err)
+	}
+}
+
+// passkeyDevice is a software ES256 authenticator producing "none" attestations.
+type passkeyDevice struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	counter uint32
+}
+
+func newPasskeyDevice(t *testing.T) *passkeyDevice {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &passkeyDevice{key: key, id: id}
+}
+
+func (d *passkeyDevice) register(t *testing.T, opts auth.CredentialCreationOptions) auth.RegistrationResponse {
+	t.Helper()
+	x, y := make([]byte, 32), make([]byte, 32)
+	d.key.X.FillBytes(x)
+	d.key.Y.FillBytes(y)
+	// COSE_Key {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+	coseKey := append([]byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}, x...)
+	coseKey = append(append(coseKey, 0x22, 0x58, 0x20), y...)
+
+	authData := d.authData(opts.RP.ID, 0x45)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(d.id)))
+	authData = append(append(authData, d.id...), coseKey...)
+
+	// {"fmt": "none", "attStmt": {}, "authData": authData}
+	attestation := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x58, byte(len(authData))}
+	attestation = append(attestation, authData...)
+
+	var resp auth.RegistrationResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(d.id)
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientDataJSON(t, "webauthn.create", opts.Challenge)
+	resp.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(attestation)
+	return resp
+}
+
+func (d *passkeyDevice) assert(t *testing.T, challenge, userID string) auth.AssertionResponse {
+	t.Helper()
+	authData := d.authData("shop.example.com", 0x05)
+	clientData := clientDataJSON(t, "webauthn.get", challenge)
+	raw, _ := base64.RawURLEncoding.DecodeString(clientData)
+	clientHash := sha256.Sum256(raw)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, d.key, digest[:])
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+	handle := uuid.MustParse(userID)
+
+	var resp auth.AssertionResponse
+	resp.ID = base64.RawURLEncoding.EncodeToString(d.id)
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	resp.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	resp.Response.UserHandle = base64.RawURLEncoding.EncodeToString(handle[:])
+	return resp
+}
+
+func (d *passkeyDevice) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	return binary.BigEndian.AppendUint32(append(rpIDHash[:], flags), d.counter)
+}
+
+func clientDataJSON(t *testing.T, ceremony, challenge string) string {
+	t.Helper()
+	raw, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": passkeyOrigin})
+	if err != nil {
+		t.Fatalf("marshal client data: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,101 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// PasswordHistoryRepository keeps the hashes of each user's recent passwords, newest first.
+type PasswordHistoryRepository interface {
+	// AddPasswordHistory records a new password hash and prunes all but the newest keep entries.
+	AddPasswordHistory(ctx context.Context, userID, passwordHash string, keep int) error
+	ListPasswordHistory(ctx context.Context, userID string, limit int) ([]string, error)
+}
+
+// SQLPasswordHistoryRepository persists password hashes in the password_history table.
+type SQLPasswordHistoryRepository struct {
+	db *sql.DB
+}
+
+// NewSQLPasswordHistoryRepository creates a password history repository instance.
+func NewSQLPasswordHistoryRepository(db *sql.DB) *SQLPasswordHistoryRepository {
+	return &SQLPasswordHistoryRepository{db: db}
+}
+
+// AddPasswordHistory inserts the hash and prunes older entries in one transaction.
+func (r *SQLPasswordHistoryRepository) AddPasswordHistory(ctx context.Context, userID, passwordHash string, keep int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO password_history (user_id, password_hash) VALUES ($1,$2)`, userID, passwordHash); err != nil {
+		return err
+	}
+	query := `DELETE FROM password_history WHERE user_id=$1 AND id NOT IN (SELECT id FROM password_history WHERE user_id=$1 ORDER BY id DESC LIMIT $2)`
+	if _, err := tx.ExecContext(ctx, query, userID, keep); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListPasswordHistory returns up to limit of the user's newest password hashes.
+func (r *SQLPasswordHistoryRepository) ListPasswordHistory(ctx context.Context, userID string, limit int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT password_hash FROM password_history WHERE user_id=$1 ORDER BY id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// checkPasswordHistory refuses a new password that matches the user's current password or any
+// of their last passwordHistorySize passwords. The current hash is checked as well, since imported
+// users have no history yet.
+func (s *Service) checkPasswordHistory(ctx context.Context, user *User, password string) error {
+	if s.passwordHistory == nil {
+		return nil
+	}
+	recent, err := s.passwordHistory.ListPasswordHistory(ctx, user.ID, s.passwordHistorySize)
+	if err != nil {
+		return err
+	}
+
+	checked := make(map[string]bool, len(recent)+1)
+	for _, hash := range append([]string{user.PasswordHash}, recent...) {
+		if hash == "" || checked[hash] {
+			continue
+		}
+		checked[hash] = true
+		if match, _ := auth.VerifyPassword(password, hash); match {
+			message := "password must differ from your current password"
+			if s.passwordHistorySize > 1 {
+				message = fmt.Sprintf("password must differ from your last %d passwords", s.passwordHistorySize)
+			}
+			return &PasswordPolicyError{Violations: []auth.PasswordViolation{{Code: auth.ViolationReused, Message: message}}}
+		}
+	}
+	return nil
+}
+
+// recordPassword adds the user's new password hash to the history. It is best effort: the
+// password has been changed by then either way.
+func (s *Service) recordPassword(ctx context.Context, user *User) {
+	if s.passwordHistory == nil {
+		return
+	}
+	_ = s.passwordHistory.AddPasswordHistory(ctx, user.ID, user.PasswordHash, s.passwordHistorySize)
+}
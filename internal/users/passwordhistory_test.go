@@ -0,0 +1,94 @@
+package users_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+type memoryPasswordHistory struct {
+	mu     sync.Mutex
+	hashes map[string][]string
+}
+
+func (m *memoryPasswordHistory) AddPasswordHistory(_ context.Context, userID, passwordHash string, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hashes == nil {
+		m.hashes = make(map[string][]string)
+	}
+	hashes := append([]string{passwordHash}, m.hashes[userID]...)
+	m.hashes[userID] = hashes[:min(len(hashes), keep)]
+	return nil
+}
+
+func (m *memoryPasswordHistory) ListPasswordHistory(_ context.Context, userID string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hashes := m.hashes[userID]
+	return append([]string(nil), hashes[:min(len(hashes), limit)]...), nil
+}
+
+func (m *memoryPasswordHistory) size(userID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.hashes[userID])
+}
+
+func TestPasswordHistoryPreventsReuse(t *testing.T) {
+	fast, err := auth.NewPasswordHasher(auth.Argon2Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatalf("new hasher: %v", err)
+	}
+	history := &memoryPasswordHistory{}
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t,
+		users.WithPasswordHasher(fast),
+		users.WithPasswordHistory(history, 3),
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+		users.WithNotifier(notifier),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "pci@example.com", Password: "first-Secret-1"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	change := func(current, next string) error {
+		return svc.ChangePassword(ctx, reg.UserID, current, next)
+	}
+	if err := change("first-Secret-1", "first-Secret-1"); len(policyViolations(t, err)) != 1 {
+		t.Fatalf("expected the current password to be refused")
+	}
+	if err := change("first-Secret-1", "second-Secret-2"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if err := change("second-Secret-2", "third-Secret-3"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	err = change("third-Secret-3", "first-Secret-1")
+	if codes := policyViolations(t, err); len(codes) != 1 || codes[0] != auth.ViolationReused {
+		t.Fatalf("expected a reused violation, got %v", codes)
+	}
+
+	if err := svc.ForgotPassword(ctx, "pci@example.com"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	sent, _ := notifier.last(users.NotificationPasswordReset)
+	if err := svc.ResetPassword(ctx, sent.Token, "second-Secret-2"); len(policyViolations(t, err)) != 1 {
+		t.Fatalf("expected a recent password to be refused on reset")
+	}
+	if err := svc.ResetPassword(ctx, sent.Token, "fourth-Secret-4"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+
+	if history.size(reg.UserID) != 3 {
+		t.Fatalf("expected the history to be pruned to 3 entries, got %d", history.size(reg.UserID))
+	}
+	if err := change("fourth-Secret-4", "first-Secret-1"); err != nil {
+		t.Fatalf("expected a password older than the history to be accepted: %v", err)
+	}
+}
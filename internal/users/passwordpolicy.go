@@ -0,0 +1,31 @@
+package users
+
+import (
+	"strings"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// PasswordPolicyError lists every rule a new password breaks, for clients to show next to the
+// password field.
+type PasswordPolicyError struct {
+	Violations []auth.PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// checkPasswordPolicy checks a new password for the user, whose email and names it may not
+// contain. It returns a *PasswordPolicyError when the password is refused.
+func (s *Service) checkPasswordPolicy(password string, user *User) error {
+	violations := s.passwordPolicy.Check(password, user.Email, user.FirstName.String, user.LastName.String)
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func policyViolations(t *testing.T, err error) []string {
+	t.Helper()
+	var policyErr *users.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	codes := make([]string, 0, len(policyErr.Violations))
+	for _, v := range policyErr.Violations {
+		codes = append(codes, v.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyAppliesToEveryPasswordChange(t *testing.T) {
+	blocklist, err := auth.ReadPasswordBlocklist(strings.NewReader("letmein123\nsummer2024!\n"))
+	if err != nil {
+		t.Fatalf("read blocklist: %v", err)
+	}
+	policy, err := auth.NewPasswordPolicy(auth.PasswordRules{
+		MinLength:          10,
+		MaxLength:          64,
+		RequiredClasses:    []auth.CharacterClass{auth.ClassDigit},
+		RejectPersonalInfo: true,
+	}, blocklist)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t,
+		users.WithPasswordPolicy(policy),
+		users.WithUserTokens(tokens, newMemoryLimiter()),
+		users.WithNotifier(notifier),
+	)
+	ctx := context.Background()
+
+	_, err = svc.Register(ctx, users.RegisterRequest{Email: "ana@example.com", Password: "short", FirstName: "Ana"})
+	if got := strings.Join(policyViolations(t, err), ","); got != "too_short,missing_class" {
+		t.Fatalf("unexpected violations %s", got)
+	}
+	_, err = svc.Register(ctx, users.RegisterRequest{Email: "ana@example.com", Password: "Rosalind-Ana-7", FirstName: "Ana"})
+	if got := strings.Join(policyViolations(t, err), ","); got != auth.ViolationPersonalInfo {
+		t.Fatalf("unexpected violations %s", got)
+	}
+	_, err = svc.Register(ctx, users.RegisterRequest{Email: "ana@example.com", Password: "LetMeIn123"})
+	if got := strings.Join(policyViolations(t, err), ","); got != auth.ViolationBreached {
+		t.Fatalf("unexpected violations %s", got)
+	}
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "ana@example.com", Password: "quiet-harbor-41", FirstName: "Ana"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := svc.ChangePassword(ctx, reg.UserID, "wrong", "summer2024!"); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected the current password to be checked first, got %v", err)
+	}
+	if err := svc.ChangePassword(ctx, reg.UserID, "quiet-harbor-41", "summer2024!"); len(policyViolations(t, err)) != 1 {
+		t.Fatalf("expected a breached password to be refused")
+	}
+
+	if err := svc.ForgotPassword(ctx, "ana@example.com"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	sent, _ := notifier.last(users.NotificationPasswordReset)
+	if err := svc.ResetPassword(ctx, sent.Token, "ana-example-99"); len(policyViolations(t, err)) != 1 {
+		t.Fatalf("expected a password with the email to be refused")
+	}
+	if err := svc.ResetPassword(ctx, sent.Token, "granite-lantern-8"); err != nil {
+		t.Fatalf("expected the refused password to leave the token usable: %v", err)
+	}
+}
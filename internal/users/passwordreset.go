@@ -0,0 +1,120 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+const (
+	// passwordResetTTL is how long a reset link stays valid.
+	passwordResetTTL = time.Hour
+	// Reset emails are sent at most passwordResetLimit times per window per address.
+	passwordResetLimit  = 3
+	passwordResetWindow = time.Hour
+)
+
+var ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+
+// ForgotPassword mails a password reset link and invalidates earlier ones. Like
+// ResendEmailVerification it answers the same way whether or not the address has an account.
+func (s *Service) ForgotPassword(ctx context.Context, email string) error {
+	if s.userTokens == nil {
+		return errors.New("password reset not configured")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if err := s.throttle(ctx, "forgot-password:"+auth.HashToken(email), passwordResetLimit, passwordResetWindow); err != nil {
+		return err
+	}
+
+	user, err := s.repo.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Status == "disabled" {
+		return nil
+	}
+
+	if err := s.userTokens.InvalidateUserTokens(ctx, user.ID, TokenPurposePasswordReset); err != nil {
+		return err
+	}
+	return s.sendUserToken(ctx, user, TokenPurposePasswordReset, NotificationPasswordReset, passwordResetTTL)
+}
+
+// ResetPassword redeems a reset token, sets the new password and revokes every session. Since
+// the link proves control of the mailbox, a pending account's email is marked verified too.
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if s.userTokens == nil {
+		return errors.New("password reset not configured")
+	}
+	tokenHash := auth.HashToken(strings.TrimSpace(token))
+
+	// Check the password before redeeming the token, so that a rejected password does not burn
+	// it. The policy needs the user's email and name, hence the lookup.
+	pending, err := s.userTokens.FindUserToken(ctx, TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		if errors.Is(err, ErrUserTokenNotFound) {
+			return ErrResetTokenInvalid
+		}
+		return err
+	}
+	user, err := s.repo.FindByID(ctx, pending.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrResetTokenInvalid
+		}
+		return err
+	}
+	if user.Status == "disabled" {
+		return ErrUserDisabled
+	}
+	if err := s.checkPasswordPolicy(newPassword, user); err != nil {
+		return err
+	}
+	if err := s.checkPasswordHistory(ctx, user, newPassword); err != nil {
+		return err
+	}
+
+	if _, err := s.userTokens.ConsumeUserToken(ctx, TokenPurposePasswordReset, tokenHash); err != nil {
+		if errors.Is(err, ErrUserTokenNotFound) {
+			return ErrResetTokenInvalid
+		}
+		return err
+	}
+
+	hash, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	if !user.EmailVerifiedAt.Valid {
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	if user.Status == "pending" {
+		user.Status = "active"
+	}
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+	s.recordPassword(ctx, user)
+	// The link proves control of the mailbox, so a lockout need not be waited out.
+	s.loginSucceeded(ctx, user.Email)
+	if err := s.userTokens.InvalidateUserTokens(ctx, user.ID, TokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	s.emit(ctx, EventPasswordReset, user.ID, nil)
+	if err := s.revokeAllSessions(ctx, user.ID, "password_reset"); err != nil {
+		return err
+	}
+	s.alert(ctx, user, NotificationPasswordChanged)
+	return nil
+}
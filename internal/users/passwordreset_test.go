@@ -0,0 +1,102 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestResetPasswordRevokesSessions(t *testing.T) {
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	events := &memoryEvents{}
+	svc, _, _, _ := newTestService(t,
+		users.WithUserTokens(tokens, newMemoryLimiter()),
+		users.WithNotifier(notifier),
+		users.WithTokenGenerations(newMemoryGenerations()),
+		users.WithEventPublisher(events),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "forgetful@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := svc.ForgotPassword(ctx, "Forgetful@example.com"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	sent, ok := notifier.last(users.NotificationPasswordReset)
+	if !ok || sent.UserID != reg.UserID {
+		t.Fatalf("expected a reset email, got %+v", sent)
+	}
+
+	if err := svc.ResetPassword(ctx, sent.Token, "short"); err == nil {
+		t.Fatalf("expected a short password to be rejected")
+	}
+	if err := svc.ResetPassword(ctx, sent.Token, "Password!3"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if err := svc.ResetPassword(ctx, sent.Token, "Password!4"); !errors.Is(err, users.ErrResetTokenInvalid) {
+		t.Fatalf("expected the token to be single use, got %v", err)
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: reg.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected existing sessions to be revoked, got %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "forgetful@example.com", Password: "Password!2"}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected the old password to stop working, got %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "forgetful@example.com", Password: "Password!3"}); err != nil {
+		t.Fatalf("expected the new password to work: %v", err)
+	}
+	if len(events.byType(users.EventPasswordReset)) != 1 || len(events.byType(users.EventSessionsRevoked)) != 1 {
+		t.Fatalf("expected password reset and sessions revoked events")
+	}
+	if notifier.count(users.NotificationPasswordChanged) != 1 {
+		t.Fatalf("expected a password changed alert")
+	}
+}
+
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t, users.WithUserTokens(tokens, newMemoryLimiter()), users.WithNotifier(notifier))
+	ctx := context.Background()
+
+	if err := svc.ForgotPassword(ctx, "ghost@example.com"); err != nil {
+		t.Fatalf("expected unknown addresses to succeed silently, got %v", err)
+	}
+	if notifier.count(users.NotificationPasswordReset) != 0 {
+		t.Fatalf("expected no email for an unknown address")
+	}
+	for i := 0; i < 3; i++ {
+		_ = svc.ForgotPassword(ctx, "ghost@example.com")
+	}
+	if err := svc.ForgotPassword(ctx, "ghost@example.com"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected unknown addresses to be throttled like known ones, got %v", err)
+	}
+}
+
+func TestResetTokenExpires(t *testing.T) {
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t, users.WithUserTokens(tokens, nil), users.WithNotifier(notifier))
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "slow@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := svc.ForgotPassword(ctx, "slow@example.com"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	sent, _ := notifier.last(users.NotificationPasswordReset)
+	tokens.expire(auth.HashToken(sent.Token))
+
+	if err := svc.ResetPassword(ctx, sent.Token, "Password!3"); !errors.Is(err, users.ErrResetTokenInvalid) {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+}
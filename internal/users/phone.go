@@ -0,0 +1,218 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+const (
+	// phoneCodeTTL is how long an SMS code stays valid.
+	phoneCodeTTL    = 10 * time.Minute
+	phoneCodeDigits = 6
+	// A code is discarded after phoneCodeAttempts wrong guesses.
+	phoneCodeAttempts = 5
+	// At most phoneCodeLimit codes are sent per window to a phone number, whether or not it
+	// belongs to a user.
+	phoneCodeLimit  = 3
+	phoneCodeWindow = 15 * time.Minute
+)
+
+// SMSSender delivers text messages to E.164 phone numbers.
+type SMSSender interface {
+	SendSMS(ctx context.Context, to, body string) error
+}
+
+var (
+	ErrInvalidPhone     = errors.New("phone number must be in E.164 format, e.g. +628123456789")
+	ErrPhoneUnchanged   = errors.New("new phone number matches the current one")
+	ErrPhoneCodeInvalid = errors.New("invalid or expired verification code")
+)
+
+// NormalizePhone returns raw in E.164 form. Spaces, dashes, dots and parentheses are dropped;
+// the number must then be a plus sign followed by 8 to 15 digits, the first of them non-zero.
+func NormalizePhone(raw string) (string, error) {
+	phone := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+
+	digits := strings.TrimPrefix(phone, "+")
+	if digits == phone || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidPhone
+		}
+	}
+	return phone, nil
+}
+
+// RequestPhoneChange texts a verification code to the phone number the user wants to add or
+// switch to. The number is only saved once ConfirmPhoneChange receives the code.
+func (s *Service) RequestPhoneChange(ctx context.Context, userID, phone string) error {
+	if s.sms == nil {
+		return errors.New("phone numbers not configured")
+	}
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Phone.Valid && user.Phone.String == phone {
+		return ErrPhoneUnchanged
+	}
+	if _, err := s.repo.FindByPhone(ctx, phone); err == nil {
+		return ErrPhoneTaken
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if err := s.throttle(ctx, "phone-code:"+auth.HashToken(phone), phoneCodeLimit, phoneCodeWindow); err != nil {
+		return err
+	}
+	return s.sendPhoneCode(ctx, "phone-change:"+user.ID, phone, phone)
+}
+
+// ConfirmPhoneChange checks the code texted by RequestPhoneChange and saves the phone number.
+func (s *Service) ConfirmPhoneChange(ctx context.Context, userID, code string) error {
+	if s.sms == nil {
+		return errors.New("phone numbers not configured")
+	}
+	pending, err := s.checkPhoneCode(ctx, "phone-change:"+userID, code)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	previous := user.Phone.String
+	user.Phone = sqlString(pending.Target)
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	s.emit(ctx, EventPhoneChanged, user.ID, map[string]any{
+		"oldPhone": previous,
+		"newPhone": pending.Target,
+	})
+	return nil
+}
+
+// SendPhoneLoginCode texts a sign-in code to the phone number. It succeeds without sending
+// anything when no user has the number, so that callers cannot probe for accounts.
+func (s *Service) SendPhoneLoginCode(ctx context.Context, phone string) error {
+	if s.sms == nil || !s.phoneLogin {
+		return errors.New("phone login not configured")
+	}
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	if err := s.throttle(ctx, "phone-code:"+auth.HashToken(phone), phoneCodeLimit, phoneCodeWindow); err != nil {
+		return err
+	}
+	user, err := s.repo.FindByPhone(ctx, phone)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.signInAllowed(user) != nil {
+		return nil
+	}
+
+	return s.sendPhoneCode(ctx, "phone-login:"+phone, phone, user.ID)
+}
+
+// PhoneLogin signs in with a code from SendPhoneLoginCode. Like a password login it answers
+// with an MFA challenge for users with MFA.
+func (s *Service) PhoneLogin(ctx context.Context, phone, code string, client ClientInfo) (*AuthenticateResult, error) {
+	if s.sms == nil || !s.phoneLogin {
+		return nil, errors.New("phone login not configured")
+	}
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	pending, err := s.checkPhoneCode(ctx, "phone-login:"+phone, code)
+	if err != nil {
+		if errors.Is(err, ErrPhoneCodeInvalid) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	user, err := s.repo.FindByID(ctx, pending.Target)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	// The number may have moved to another account since the code was sent.
+	if user.Phone.String != phone {
+		return nil, ErrInvalidCredentials
+	}
+	if err := s.signInAllowed(user); err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.mfaChallenge(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &AuthenticateResult{UserID: user.ID, MFA: challenge}, nil
+	}
+
+	tokens, err := s.issueTokens(ctx, user, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthenticateResult{UserID: user.ID, Tokens: *tokens}, nil
+}
+
+// sendPhoneCode stores a new code for target under key, replacing any earlier one, and texts it.
+func (s *Service) sendPhoneCode(ctx context.Context, key, phone, target string) error {
+	code, err := auth.GenerateNumericCode(phoneCodeDigits)
+	if err != nil {
+		return err
+	}
+	if err := s.phoneCodes.SaveCode(ctx, key, auth.OneTimeCode{CodeHash: auth.HashToken(code), Target: target}, phoneCodeTTL); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Your verification code is %s. It expires in %d minutes. Do not share it with anyone.", code, int(phoneCodeTTL.Minutes()))
+	return s.sms.SendSMS(ctx, phone, body)
+}
+
+func (s *Service) checkPhoneCode(ctx context.Context, key, code string) (*auth.OneTimeCode, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrPhoneCodeInvalid
+	}
+	pending, err := s.phoneCodes.CheckCode(ctx, key, auth.HashToken(code), phoneCodeAttempts)
+	if err != nil {
+		if errors.Is(err, auth.ErrCodeNotFound) || errors.Is(err, auth.ErrCodeMismatch) {
+			return nil, ErrPhoneCodeInvalid
+		}
+		return nil, err
+	}
+	return pending, nil
+}
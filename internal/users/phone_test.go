@@ -0,0 +1,167 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	cases := map[string]string{
+		"+62 812-3456-7890":  "+6281234567890",
+		"+1 (415) 555.2671":  "+14155552671",
+		" +447911123456 ":    "+447911123456",
+		"081234567890":       "",
+		"+0812345678":        "",
+		"+62812abc7890":      "",
+		"+1234567":           "",
+		"+1234567890123456":  "",
+		"+62 812 3456 7890x": "",
+	}
+	for raw, want := range cases {
+		got, err := users.NormalizePhone(raw)
+		if want == "" {
+			if !errors.Is(err, users.ErrInvalidPhone) {
+				t.Fatalf("expected %q to be rejected, got %q", raw, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Fatalf("normalize %q: got %q, %v", raw, got, err)
+		}
+	}
+}
+
+func TestPhoneChangeAndLogin(t *testing.T) {
+	sms := &memorySMS{}
+	events := &memoryEvents{}
+	svc, repo, _, _ := newTestService(t,
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+		users.WithPhoneNumbers(sms, newMemoryOneTimeCodes()),
+		users.WithPhoneLogin(true),
+		users.WithEventPublisher(events),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "caller@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := svc.RequestPhoneChange(ctx, reg.UserID, "0812345678"); !errors.Is(err, users.ErrInvalidPhone) {
+		t.Fatalf("expected a local number to be rejected, got %v", err)
+	}
+	if err := svc.RequestPhoneChange(ctx, reg.UserID, "+62 812-3456-7890"); err != nil {
+		t.Fatalf("request phone change: %v", err)
+	}
+	code := sms.lastCode("+6281234567890")
+	if code == "" {
+		t.Fatalf("expected a code texted to the new number")
+	}
+	if user, _ := repo.FindByID(ctx, reg.UserID); user.Phone.Valid {
+		t.Fatalf("expected the number to be saved only once confirmed")
+	}
+
+	if err := svc.ConfirmPhoneChange(ctx, reg.UserID, wrongCode(code)); !errors.Is(err, users.ErrPhoneCodeInvalid) {
+		t.Fatalf("expected a wrong code to be rejected, got %v", err)
+	}
+	if err := svc.ConfirmPhoneChange(ctx, reg.UserID, code); err != nil {
+		t.Fatalf("confirm phone change: %v", err)
+	}
+	if err := svc.ConfirmPhoneChange(ctx, reg.UserID, code); !errors.Is(err, users.ErrPhoneCodeInvalid) {
+		t.Fatalf("expected the code to be single use, got %v", err)
+	}
+	profile, err := svc.GetProfile(ctx, reg.UserID)
+	if err != nil || profile.Phone != "+6281234567890" {
+		t.Fatalf("expected the profile to show the phone number, got %+v, %v", profile, err)
+	}
+	if len(events.byType(users.EventPhoneChanged)) != 1 {
+		t.Fatalf("expected a phone changed event")
+	}
+	if err := svc.RequestPhoneChange(ctx, reg.UserID, "+6281234567890"); !errors.Is(err, users.ErrPhoneUnchanged) {
+		t.Fatalf("expected ErrPhoneUnchanged, got %v", err)
+	}
+
+	other, err := svc.Register(ctx, users.RegisterRequest{Email: "other@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register other: %v", err)
+	}
+	if err := svc.RequestPhoneChange(ctx, other.UserID, "+6281234567890"); !errors.Is(err, users.ErrPhoneTaken) {
+		t.Fatalf("expected ErrPhoneTaken, got %v", err)
+	}
+
+	if err := svc.SendPhoneLoginCode(ctx, "+62 812 3456 7890"); err != nil {
+		t.Fatalf("send phone login code: %v", err)
+	}
+	loginCode := sms.lastCode("+6281234567890")
+	if _, err := svc.PhoneLogin(ctx, "+6281234567890", wrongCode(loginCode), users.ClientInfo{}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected a wrong code to fail, got %v", err)
+	}
+	login, err := svc.PhoneLogin(ctx, "+6281234567890", loginCode, users.ClientInfo{})
+	if err != nil {
+		t.Fatalf("phone login: %v", err)
+	}
+	if login.UserID != reg.UserID || login.Tokens.AccessToken == "" {
+		t.Fatalf("expected tokens for the phone's owner, got %+v", login)
+	}
+}
+
+func TestPhoneLoginCodes(t *testing.T) {
+	sms := &memorySMS{}
+	svc, _, _, _ := newTestService(t,
+		users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()),
+		users.WithPhoneNumbers(sms, newMemoryOneTimeCodes()),
+		users.WithPhoneLogin(true),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "guesser@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := svc.RequestPhoneChange(ctx, reg.UserID, "+14155552671"); err != nil {
+		t.Fatalf("request phone change: %v", err)
+	}
+	if err := svc.ConfirmPhoneChange(ctx, reg.UserID, sms.lastCode("+14155552671")); err != nil {
+		t.Fatalf("confirm phone change: %v", err)
+	}
+
+	if err := svc.SendPhoneLoginCode(ctx, "+14155550000"); err != nil {
+		t.Fatalf("expected unknown numbers to succeed silently, got %v", err)
+	}
+	if sms.lastCode("+14155550000") != "" {
+		t.Fatalf("expected no code for an unknown number")
+	}
+
+	if err := svc.SendPhoneLoginCode(ctx, "+14155552671"); err != nil {
+		t.Fatalf("send phone login code: %v", err)
+	}
+	code := sms.lastCode("+14155552671")
+	for i := 0; i < 5; i++ {
+		if _, err := svc.PhoneLogin(ctx, "+14155552671", wrongCode(code), users.ClientInfo{}); !errors.Is(err, users.ErrInvalidCredentials) {
+			t.Fatalf("expected a wrong code to fail, got %v", err)
+		}
+	}
+	if _, err := svc.PhoneLogin(ctx, "+14155552671", code, users.ClientInfo{}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected the code to be discarded after too many guesses, got %v", err)
+	}
+
+	// The phone change and the first login code count towards the same limit.
+	if err := svc.SendPhoneLoginCode(ctx, "+14155552671"); err != nil {
+		t.Fatalf("send phone login code: %v", err)
+	}
+	if err := svc.SendPhoneLoginCode(ctx, "+14155552671"); !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected codes to the number to be throttled, got %v", err)
+	}
+}
+
+// wrongCode returns a code that differs from code in its last digit.
+func wrongCode(code string) string {
+	last := code[len(code)-1]
+	if last == '9' {
+		return code[:len(code)-1] + "0"
+	}
+	return code[:len(code)-1] + string(last+1)
+}
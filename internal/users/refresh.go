@@ -0,0 +1,113 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RefreshToken is the persisted record of an issued refresh token. Tokens derived from
+// one login share a FamilyID; ParentID links a rotated token to its predecessor. ClientID names
+// the OAuth client a family was issued to and Scope what that client was granted; both are empty
+// for first-party logins.
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string
+	ParentID  sql.NullString
+	ClientID  sql.NullString
+	Scope     sql.NullString
+	TokenHash string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+	UserAgent sql.NullString
+	IP        sql.NullString
+}
+
+// RefreshTokenRepository stores refresh tokens so they can be rotated and revoked.
+type RefreshTokenRepository interface {
+	CreateRefreshToken(ctx context.Context, t *RefreshToken) error
+	FindRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error)
+	RevokeUserRefreshTokens(ctx context.Context, userID string) (int64, error)
+}
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// SQLRefreshTokenRepository persists refresh tokens in the refresh_tokens table.
+type SQLRefreshTokenRepository struct {
+	db *sql.DB
+}
+
+// NewSQLRefreshTokenRepository creates a refresh token repository instance.
+func NewSQLRefreshTokenRepository(db *sql.DB) *SQLRefreshTokenRepository {
+	return &SQLRefreshTokenRepository{db: db}
+}
+
+// CreateRefreshToken inserts a new refresh token record.
+func (r *SQLRefreshTokenRepository) CreateRefreshToken(ctx context.Context, t *RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (id, user_id, family_id, parent_id, client_id, scope, token_hash, expires_at, user_agent, ip) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING issued_at`
+	return r.db.QueryRowContext(ctx, query, t.ID, t.UserID, t.FamilyID, t.ParentID, t.ClientID, t.Scope, t.TokenHash, t.ExpiresAt, t.UserAgent, t.IP).
+		Scan(&t.IssuedAt)
+}
+
+// FindRefreshToken returns a refresh token by the hash of its value.
+func (r *SQLRefreshTokenRepository) FindRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	query := `SELECT id, user_id, family_id, parent_id, client_id, scope, token_hash, issued_at, expires_at, revoked, user_agent, host(ip) FROM refresh_tokens WHERE token_hash=$1`
+	t := &RefreshToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.ParentID,
+		&t.ClientID,
+		&t.Scope,
+		&t.TokenHash,
+		&t.IssuedAt,
+		&t.ExpiresAt,
+		&t.Revoked,
+		&t.UserAgent,
+		&t.IP,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RevokeRefreshToken marks a token as revoked and reports whether this call performed the revocation.
+func (r *SQLRefreshTokenRepository) RevokeRefreshToken(ctx context.Context, id string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked=true WHERE id=$1 AND revoked=false`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// RevokeRefreshTokenFamily revokes every active token sharing the family and returns how many were revoked.
+func (r *SQLRefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked=true WHERE family_id=$1 AND revoked=false`, familyID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeUserRefreshTokens revokes every active token of the user and returns how many were revoked.
+func (r *SQLRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked=true WHERE user_id=$1 AND revoked=false`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
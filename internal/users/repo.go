@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // User represents the core user entity persisted in PostgreSQL.
@@ -26,10 +28,18 @@ type Repository interface {
 	Create(ctx context.Context, u *User) error
 	FindByEmail(ctx context.Context, email string) (*User, error)
 	FindByID(ctx context.Context, id string) (*User, error)
+	FindByPhone(ctx context.Context, phone string) (*User, error)
 	Update(ctx context.Context, u *User) error
+	// ReplacePasswordHash swaps the user's password hash for an upgraded one, unless the password
+	// changed since oldHash was read.
+	ReplacePasswordHash(ctx context.Context, userID, oldHash, newHash string) error
 }
 
-var ErrNotFound = errors.New("user not found")
+var (
+	ErrNotFound   = errors.New("user not found")
+	ErrEmailTaken = errors.New("email already in use")
+	ErrPhoneTaken = errors.New("phone number already in use")
+)
 
 // SQLRepository is a simple implementation backed by database/sql.
 type SQLRepository struct {
@@ -43,9 +53,13 @@ func NewSQLRepository(db *sql.DB) *SQLRepository {
 
 // Create inserts a new user record.
 func (r *SQLRepository) Create(ctx context.Context, u *User) error {
-	query := `INSERT INTO users (email, password_hash, first_name, last_name, status) VALUES ($1,$2,$3,$4,$5) RETURNING id, created_at, updated_at`
-	return r.db.QueryRowContext(ctx, query, u.Email, u.PasswordHash, u.FirstName, u.LastName, u.Status).
+	query := `INSERT INTO users (email, password_hash, first_name, last_name, status, email_verified_at) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id, created_at, updated_at`
+	err := r.db.QueryRowContext(ctx, query, u.Email, u.PasswordHash, u.FirstName, u.LastName, u.Status, u.EmailVerifiedAt).
 		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return err
 }
 
 // FindByEmail returns a user by email.
@@ -98,10 +112,39 @@ func (r *SQLRepository) FindByID(ctx context.Context, id string) (*User, error)
 	return u, nil
 }
 
-// Update persists modified fields of a user.
+// FindByPhone returns a user by E.164 phone number.
+func (r *SQLRepository) FindByPhone(ctx context.Context, phone string) (*User, error) {
+	query := `SELECT id, email, phone, password_hash, first_name, last_name, status, email_verified_at, created_at, updated_at FROM users WHERE phone=$1`
+	u := &User{}
+	err := r.db.QueryRowContext(ctx, query, phone).Scan(
+		&u.ID,
+		&u.Email,
+		&u.Phone,
+		&u.PasswordHash,
+		&u.FirstName,
+		&u.LastName,
+		&u.Status,
+		&u.EmailVerifiedAt,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Update persists modified fields of a user. The phone number is the only unique column it
+// writes, so a unique violation means the number belongs to another user.
 func (r *SQLRepository) Update(ctx context.Context, u *User) error {
-	query := `UPDATE users SET phone=$1, first_name=$2, last_name=$3, status=$4, email_verified_at=$5, updated_at=now() WHERE id=$6`
-	res, err := r.db.ExecContext(ctx, query, u.Phone, u.FirstName, u.LastName, u.Status, u.EmailVerifiedAt, u.ID)
+	query := `UPDATE users SET phone=$1, password_hash=$2, first_name=$3, last_name=$4, status=$5, email_verified_at=$6, updated_at=now() WHERE id=$7`
+	res, err := r.db.ExecContext(ctx, query, u.Phone, u.PasswordHash, u.FirstName, u.LastName, u.Status, u.EmailVerifiedAt, u.ID)
+	if isUniqueViolation(err) {
+		return ErrPhoneTaken
+	}
 	if err != nil {
 		return err
 	}
@@ -114,3 +157,17 @@ func (r *SQLRepository) Update(ctx context.Context, u *User) error {
 	}
 	return nil
 }
+
+// ReplacePasswordHash updates the hash only while it still equals oldHash, so an upgrade never
+// overwrites a password changed in the meantime. updated_at is left alone since the password
+// itself did not change.
+func (r *SQLRepository) ReplacePasswordHash(ctx context.Context, userID, oldHash, newHash string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE users SET password_hash=$1 WHERE id=$2 AND password_hash=$3`, newHash, userID, oldHash)
+	return err
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
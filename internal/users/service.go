@@ -9,20 +9,244 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
 )
 
 // Service orchestrates user business logic.
 type Service struct {
-	repo        Repository
-	issuer      *auth.TokenIssuer
-	roleStore   RoleStore
-	revocations auth.TokenBlacklist
+	repo          Repository
+	issuer        *auth.TokenIssuer
+	roleStore     RoleStore
+	revocations   auth.TokenBlacklist
+	refreshTokens RefreshTokenRepository
+	events        EventPublisher
+	apiKeys       APIKeyRepository
+	generations   auth.TokenGenerations
+	sessions      SessionRepository
+	// sessionRevocations rejects access tokens of individually revoked sessions.
+	sessionRevocations auth.SessionRevocations
+	mfa                MFARepository
+	mfaAttempts        auth.MFAAttempts
+	mfaIssuer          string
+	mfaRequiredRoles   []string
+	webauthn           *auth.WebAuthn
+	passkeys           PasskeyRepository
+	ceremonies         auth.WebAuthnCeremonies
+	userTokens         UserTokenRepository
+	limiter            auth.RateLimiter
+	notifier           Notifier
+	emailChanges       EmailChangeRepository
+	sms                SMSSender
+	phoneCodes         auth.OneTimeCodes
+	phoneLogin         bool
+	magicLinks         bool
+	identityProviders  map[string]*auth.IdentityProvider
+	identityLinks      IdentityLinkRepository
+	federationStates   auth.FederatedLoginStates
+	passwords          *auth.PasswordHasher
+	passwordPolicy     *auth.PasswordPolicy
+	passwordHistory    PasswordHistoryRepository
+	loginAttempts      auth.LoginAttempts
+	lockoutPolicy      LoginLockoutPolicy
+	// passwordHistorySize is how many recent passwords, the current one included, may not be reused.
+	passwordHistorySize int
+	// requireVerifiedEmail keeps pending users from signing in until they verify their email.
+	requireVerifiedEmail bool
+}
+
+// Option configures optional service dependencies.
+type Option func(*Service)
+
+// WithRefreshTokens enables persistent refresh-token rotation backed by the given repository.
+func WithRefreshTokens(store RefreshTokenRepository) Option {
+	return func(s *Service) {
+		s.refreshTokens = store
+	}
+}
+
+// WithEventPublisher emits domain and security events through the given publisher.
+func WithEventPublisher(publisher EventPublisher) Option {
+	return func(s *Service) {
+		s.events = publisher
+	}
+}
+
+// WithAPIKeys enables personal API keys backed by the given repository.
+func WithAPIKeys(store APIKeyRepository) Option {
+	return func(s *Service) {
+		s.apiKeys = store
+	}
+}
+
+// WithTokenGenerations stamps a per-user generation into issued tokens so that all of a user's
+// sessions can be revoked at once.
+func WithTokenGenerations(generations auth.TokenGenerations) Option {
+	return func(s *Service) {
+		s.generations = generations
+	}
+}
+
+// WithSessions records one session per login and lets users revoke them individually. It
+// requires WithRefreshTokens, since a session is a refresh token family.
+func WithSessions(store SessionRepository, revocations auth.SessionRevocations) Option {
+	return func(s *Service) {
+		s.sessions = store
+		s.sessionRevocations = revocations
+	}
+}
+
+// WithMFA enables TOTP second factors. attempts limits how often codes can be tried and
+// issuerName labels the account in authenticator apps.
+func WithMFA(store MFARepository, attempts auth.MFAAttempts, issuerName string) Option {
+	return func(s *Service) {
+		s.mfa = store
+		s.mfaAttempts = attempts
+		s.mfaIssuer = issuerName
+	}
+}
+
+// WithMFARequiredRoles makes MFA mandatory for users holding any of the roles. It requires WithMFA.
+func WithMFARequiredRoles(roles []string) Option {
+	return func(s *Service) {
+		s.mfaRequiredRoles = roles
+	}
+}
+
+// WithPasskeys enables WebAuthn passkey registration and passwordless login.
+func WithPasskeys(relyingParty *auth.WebAuthn, store PasskeyRepository, ceremonies auth.WebAuthnCeremonies) Option {
+	return func(s *Service) {
+		s.webauthn = relyingParty
+		s.passkeys = store
+		s.ceremonies = ceremonies
+	}
+}
+
+// WithUserTokens enables the single-use emailed tokens behind email verification and password
+// reset. The limiter throttles how often they are sent; it may be nil.
+func WithUserTokens(store UserTokenRepository, limiter auth.RateLimiter) Option {
+	return func(s *Service) {
+		s.userTokens = store
+		s.limiter = limiter
+	}
+}
+
+// WithNotifier delivers verification links and other transactional messages to users.
+func WithNotifier(notifier Notifier) Option {
+	return func(s *Service) {
+		s.notifier = notifier
+	}
+}
+
+// WithEmailChanges lets users change their login email after confirming the new address.
+func WithEmailChanges(store EmailChangeRepository) Option {
+	return func(s *Service) {
+		s.emailChanges = store
+	}
+}
+
+// WithPhoneNumbers lets users add a phone number after confirming a code texted to it. Sends
+// are throttled by the limiter given to WithUserTokens.
+func WithPhoneNumbers(sender SMSSender, codes auth.OneTimeCodes) Option {
+	return func(s *Service) {
+		s.sms = sender
+		s.phoneCodes = codes
+	}
+}
+
+// WithPhoneLogin enables passwordless sign-in with a code texted to a confirmed phone number. It
+// requires WithPhoneNumbers.
+func WithPhoneLogin(enabled bool) Option {
+	return func(s *Service) {
+		s.phoneLogin = enabled
+	}
+}
+
+// WithMagicLinks enables passwordless sign-in with single-use links mailed to the user. It
+// requires WithUserTokens and WithNotifier.
+func WithMagicLinks(enabled bool) Option {
+	return func(s *Service) {
+		s.magicLinks = enabled
+	}
+}
+
+// WithIdentityProviders enables sign-in with external OpenID Connect providers and linking their
+// accounts to existing users.
+func WithIdentityProviders(providers []*auth.IdentityProvider, links IdentityLinkRepository, states auth.FederatedLoginStates) Option {
+	return func(s *Service) {
+		s.identityProviders = make(map[string]*auth.IdentityProvider, len(providers))
+		for _, provider := range providers {
+			s.identityProviders[provider.Name()] = provider
+		}
+		s.identityLinks = links
+		s.federationStates = states
+	}
+}
+
+// WithPasswordHasher sets the parameters new password hashes are derived with. Hashes weaker
+// than them are upgraded when their owner next signs in with a password. The default is
+// auth.DefaultArgon2Params.
+func WithPasswordHasher(hasher *auth.PasswordHasher) Option {
+	return func(s *Service) {
+		s.passwords = hasher
+	}
+}
+
+// WithPasswordPolicy sets the rules new passwords are checked against on registration, password
+// change and reset. The default is auth.DefaultPasswordRules without a blocklist.
+func WithPasswordPolicy(policy *auth.PasswordPolicy) Option {
+	return func(s *Service) {
+		s.passwordPolicy = policy
+	}
+}
+
+// WithPasswordHistory keeps users from reusing any of their last size passwords when they
+// change or reset it. Older entries are pruned as new ones are recorded.
+func WithPasswordHistory(store PasswordHistoryRepository, size int) Option {
+	return func(s *Service) {
+		if size < 1 {
+			return
+		}
+		s.passwordHistory = store
+		s.passwordHistorySize = size
+	}
+}
+
+// WithLoginLockout tracks failed password sign-ins per account and client address, slowing
+// them down and then locking them out as set by the policy. A zero policy means
+// DefaultLoginLockoutPolicy.
+func WithLoginLockout(attempts auth.LoginAttempts, policy LoginLockoutPolicy) Option {
+	return func(s *Service) {
+		if policy == (LoginLockoutPolicy{}) {
+			policy = DefaultLoginLockoutPolicy
+		}
+		s.loginAttempts = attempts
+		s.lockoutPolicy = policy
+	}
+}
+
+// WithVerifiedEmailRequired keeps pending users from signing in until they verify their email.
+// Registration then returns no tokens.
+func WithVerifiedEmailRequired(required bool) Option {
+	return func(s *Service) {
+		s.requireVerifiedEmail = required
+	}
 }
 
 // NewService constructs the service dependencies.
-func NewService(repo Repository, issuer *auth.TokenIssuer, roles RoleStore, revocations auth.TokenBlacklist) *Service {
-	return &Service{repo: repo, issuer: issuer, roleStore: roles, revocations: revocations}
+func NewService(repo Repository, issuer *auth.TokenIssuer, roles RoleStore, revocations auth.TokenBlacklist, opts ...Option) *Service {
+	s := &Service{repo: repo, issuer: issuer, roleStore: roles, revocations: revocations}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.passwords == nil {
+		s.passwords, _ = auth.NewPasswordHasher(auth.DefaultArgon2Params)
+	}
+	if s.passwordPolicy == nil {
+		s.passwordPolicy, _ = auth.NewPasswordPolicy(auth.DefaultPasswordRules, nil)
+	}
+	return s
 }
 
 // RoleStore exposes RBAC operations required by the service.
@@ -39,12 +263,36 @@ type RegisterRequest struct {
 	Password  string
 	FirstName string
 	LastName  string
+	Client    ClientInfo
 }
 
 // AuthenticateRequest captures login information.
+// MFACode is only used by single-step sign-ins; Authenticate answers with an MFAChallenge instead.
 type AuthenticateRequest struct {
 	Email    string
 	Password string
+	MFACode  string
+	Client   ClientInfo
+}
+
+// RefreshRequest captures the refresh token presented for rotation.
+type RefreshRequest struct {
+	RefreshToken string
+	Client       ClientInfo
+	// ClientID is the OAuth client presenting the token, empty for first-party refreshes.
+	ClientID string
+}
+
+// OAuthGrant describes the OAuth client a token pair is issued to and the scope it was granted.
+type OAuthGrant struct {
+	ClientID string
+	Scope    string
+}
+
+// ClientInfo describes the device a token pair is issued to.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
 }
 
 // UpdateProfileRequest captures mutable profile fields.
@@ -53,16 +301,22 @@ type UpdateProfileRequest struct {
 	LastName  string
 }
 
-// RegisterResult describes the registration outcome.
+// RegisterResult describes the registration outcome. Tokens is empty when the account must be
+// verified before signing in.
 type RegisterResult struct {
-	UserID string
-	Tokens TokenPair
+	UserID                    string
+	Tokens                    TokenPair
+	EmailVerificationRequired bool
 }
 
-// AuthenticateResult contains the authenticated user profile and tokens.
+// AuthenticateResult contains the authenticated user profile and tokens. When MFA is set the
+// password step succeeded but no tokens were issued yet. RecoveryCodes is only filled when a
+// login also completed a mandatory MFA enrollment.
 type AuthenticateResult struct {
-	UserID string
-	Tokens TokenPair
+	UserID        string
+	Tokens        TokenPair
+	MFA           *MFAChallenge
+	RecoveryCodes []string
 }
 
 // TokenPair represents issued access and refresh tokens.
@@ -75,18 +329,21 @@ type TokenPair struct {
 
 // Profile describes the public user profile.
 type Profile struct {
-	ID        string
-	Email     string
-	FirstName string
-	LastName  string
-	Status    string
-	Roles     []string
+	ID            string
+	Email         string
+	FirstName     string
+	LastName      string
+	Phone         string
+	Status        string
+	EmailVerified bool
+	Roles         []string
 }
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserDisabled       = errors.New("user disabled")
 	ErrTokenInvalid       = errors.New("invalid token")
+	ErrTokenRevoked       = errors.New("token revoked")
 )
 
 // Register orchestrates the basic user registration flow.
@@ -96,45 +353,94 @@ func (s *Service) Register(ctx context.Context, req RegisterRequest) (*RegisterR
 		return nil, err
 	}
 
-	if len(req.Password) < 8 {
-		return nil, fmt.Errorf("password must be at least 8 characters")
+	user := &User{
+		Email:     strings.ToLower(email),
+		FirstName: sqlString(req.FirstName),
+		LastName:  sqlString(req.LastName),
+		Status:    "pending",
+	}
+	if err := s.checkPasswordPolicy(req.Password, user); err != nil {
+		return nil, err
 	}
 
-	hash, err := auth.HashPassword(req.Password)
+	hash, err := s.passwords.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
+	user.PasswordHash = hash
 
-	user := &User{
-		Email:        strings.ToLower(email),
-		PasswordHash: hash,
-		FirstName:    sqlString(req.FirstName),
-		LastName:     sqlString(req.LastName),
-		Status:       "pending",
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, err
 	}
+	s.recordPassword(ctx, user)
 
-	if err := s.repo.Create(ctx, user); err != nil {
+	if s.roleStore != nil {
+		_ = s.roleStore.AssignRole(ctx, user.ID, "customer")
+	}
+
+	if s.userTokens != nil {
+		// The account exists either way; a lost email can be requested again.
+		_ = s.sendEmailVerification(ctx, user)
+	}
+
+	if err := s.signInAllowed(user); err != nil {
+		return &RegisterResult{UserID: user.ID, EmailVerificationRequired: true}, nil
+	}
+
+	tokens, err := s.issueTokens(ctx, user, req.Client, nil)
+	if err != nil {
 		return nil, err
 	}
 
-	tokens, err := s.issueTokens(user)
+	return &RegisterResult{UserID: user.ID, Tokens: *tokens}, nil
+}
+
+// Authenticate validates the email/password credentials and issues tokens. Users with MFA get
+// an MFA challenge instead, to be completed with VerifyMFA.
+func (s *Service) Authenticate(ctx context.Context, req AuthenticateRequest) (*AuthenticateResult, error) {
+	user, err := s.checkPassword(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	if s.roleStore != nil {
-		_ = s.roleStore.AssignRole(ctx, user.ID, "customer")
+	challenge, err := s.mfaChallenge(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &AuthenticateResult{UserID: user.ID, MFA: challenge}, nil
 	}
 
-	return &RegisterResult{UserID: user.ID, Tokens: *tokens}, nil
+	tokens, err := s.issueTokens(ctx, user, req.Client, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthenticateResult{UserID: user.ID, Tokens: *tokens}, nil
 }
 
-// Authenticate validates the email/password credentials and issues tokens.
-func (s *Service) Authenticate(ctx context.Context, req AuthenticateRequest) (*AuthenticateResult, error) {
+// VerifyCredentials checks an email/password pair, and the MFA code when the user has MFA,
+// without issuing tokens.
+func (s *Service) VerifyCredentials(ctx context.Context, req AuthenticateRequest) (*User, error) {
+	user, err := s.checkPassword(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(ctx, user, req.MFACode); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) checkPassword(ctx context.Context, req AuthenticateRequest) (*User, error) {
 	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if err := s.loginBlocked(ctx, email, req.Client.IP); err != nil {
+		return nil, err
+	}
 	user, err := s.repo.FindByEmail(ctx, email)
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
+			s.loginFailed(ctx, email, req.Client.IP, nil)
 			return nil, ErrInvalidCredentials
 		}
 		return nil, err
@@ -142,14 +448,97 @@ func (s *Service) Authenticate(ctx context.Context, req AuthenticateRequest) (*A
 
 	match, err := auth.VerifyPassword(req.Password, user.PasswordHash)
 	if err != nil || !match {
+		s.loginFailed(ctx, email, req.Client.IP, user)
 		return nil, ErrInvalidCredentials
 	}
+	s.loginSucceeded(ctx, email)
 
-	if user.Status == "disabled" {
-		return nil, ErrUserDisabled
+	if err := s.signInAllowed(user); err != nil {
+		return nil, err
 	}
 
-	tokens, err := s.issueTokens(user)
+	s.upgradePasswordHash(ctx, user, req.Password)
+	return user, nil
+}
+
+// upgradePasswordHash re-hashes a verified password whose stored hash is weaker than the current
+// parameters. It is best effort: the old hash keeps working if the upgrade fails.
+func (s *Service) upgradePasswordHash(ctx context.Context, user *User, password string) {
+	if !s.passwords.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		return
+	}
+	if err := s.repo.ReplacePasswordHash(ctx, user.ID, user.PasswordHash, hash); err != nil {
+		return
+	}
+	user.PasswordHash = hash
+}
+
+// IssueTokensForUser starts a new session for an already authenticated user that completed an
+// OAuth authorization-code flow. The refresh token family is bound to the grant's client.
+func (s *Service) IssueTokensForUser(ctx context.Context, userID string, grant OAuthGrant, client ClientInfo) (*TokenPair, error) {
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.signInAllowed(user); err != nil {
+		return nil, err
+	}
+	return s.mintTokens(ctx, user, client, grant, nil)
+}
+
+// Refresh exchanges a valid refresh token for a new token pair and revokes the presented token.
+// Presenting a token that was already rotated is treated as theft: the whole token family is
+// revoked and a security event is emitted. A token is only accepted from the client it was
+// issued to: OAuth clients cannot use each other's tokens or first-party ones, and vice versa.
+func (s *Service) Refresh(ctx context.Context, req RefreshRequest) (*AuthenticateResult, error) {
+	record, err := s.findRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if record.ClientID.String != req.ClientID {
+		return nil, ErrTokenInvalid
+	}
+
+	if record.Revoked {
+		// A token of a session the user signed out is not a replay.
+		ended, err := s.sessionRevoked(ctx, record.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+		if ended {
+			return nil, ErrTokenRevoked
+		}
+		return nil, s.handleRefreshReuse(ctx, record, req.Client)
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, ErrTokenInvalid
+	}
+
+	revoked, err := s.refreshTokens.RevokeRefreshToken(ctx, record.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		// Another request rotated the token between the lookup and the update.
+		return nil, s.handleRefreshReuse(ctx, record, req.Client)
+	}
+
+	user, err := s.repo.FindByID(ctx, record.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if err := s.signInAllowed(user); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issueTokens(ctx, user, req.Client, record)
 	if err != nil {
 		return nil, err
 	}
@@ -157,6 +546,93 @@ func (s *Service) Authenticate(ctx context.Context, req AuthenticateRequest) (*A
 	return &AuthenticateResult{UserID: user.ID, Tokens: *tokens}, nil
 }
 
+// RevokeRefreshToken revokes the presented refresh token together with every token in its family.
+func (s *Service) RevokeRefreshToken(ctx context.Context, token string) error {
+	record, err := s.findRefreshToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrTokenRevoked) {
+			return nil
+		}
+		return err
+	}
+	if _, err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, record.FamilyID); err != nil {
+		return err
+	}
+	if s.sessions != nil {
+		if err := s.sessions.RevokeSession(ctx, record.UserID, record.FamilyID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefreshTokenActive reports whether a refresh token is known, unrevoked and unexpired.
+func (s *Service) RefreshTokenActive(ctx context.Context, token string) (bool, error) {
+	record, err := s.findRefreshToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrTokenInvalid) || errors.Is(err, ErrTokenRevoked) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !record.Revoked && time.Now().Before(record.ExpiresAt), nil
+}
+
+func (s *Service) findRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
+	if s.refreshTokens == nil {
+		return nil, errors.New("refresh token store not configured")
+	}
+
+	claims, err := s.issuer.ParseAndValidate(token, auth.WithTokenType(auth.TokenTypeRefresh))
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	// Tokens from before a "log out everywhere" were revoked deliberately and must not be
+	// mistaken for a replayed token.
+	if s.generations != nil {
+		if err := auth.CheckGeneration(ctx, s.generations, claims); err != nil {
+			if errors.Is(err, auth.ErrTokenStale) {
+				return nil, ErrTokenRevoked
+			}
+			return nil, err
+		}
+	}
+
+	record, err := s.refreshTokens.FindRefreshToken(ctx, auth.HashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *Service) handleRefreshReuse(ctx context.Context, record *RefreshToken, client ClientInfo) error {
+	revoked, err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, record.FamilyID)
+	if err != nil {
+		return err
+	}
+	if s.sessions != nil {
+		if err := s.sessions.RevokeSession(ctx, record.UserID, record.FamilyID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+		if err := s.revokeSessionAccess(ctx, record.FamilyID); err != nil {
+			return err
+		}
+	}
+
+	s.emit(ctx, EventRefreshTokenReused, record.UserID, map[string]any{
+		"familyId":      record.FamilyID,
+		"tokenId":       record.ID,
+		"revokedTokens": revoked,
+		"userAgent":     client.UserAgent,
+		"ip":            client.IP,
+	})
+
+	return ErrTokenRevoked
+}
+
 // GetProfile returns the profile for a user ID.
 func (s *Service) GetProfile(ctx context.Context, userID string) (*Profile, error) {
 	user, err := s.repo.FindByID(ctx, userID)
@@ -170,12 +646,14 @@ func (s *Service) GetProfile(ctx context.Context, userID string) (*Profile, erro
 	}
 
 	return &Profile{
-		ID:        user.ID,
-		Email:     user.Email,
-		FirstName: user.FirstName.String,
-		LastName:  user.LastName.String,
-		Status:    user.Status,
-		Roles:     roles,
+		ID:            user.ID,
+		Email:         user.Email,
+		FirstName:     user.FirstName.String,
+		LastName:      user.LastName.String,
+		Phone:         user.Phone.String,
+		Status:        user.Status,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Roles:         roles,
 	}, nil
 }
 
@@ -196,29 +674,101 @@ func (s *Service) UpdateProfile(ctx context.Context, userID string, req UpdatePr
 	return s.GetProfile(ctx, userID)
 }
 
-// ChangePassword verifies the current password and updates the stored hash.
-func (s *Service) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error {
-	if len(newPassword) < 8 {
-		return fmt.Errorf("password must be at least 8 characters")
-	}
+// Password changes can be attempted at most passwordChangeLimit times per window per user.
+const (
+	passwordChangeLimit  = 5
+	passwordChangeWindow = time.Hour
+)
 
+// ChangePassword verifies the current password, updates the stored hash and revokes every
+// session issued with the old password.
+func (s *Service) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error {
 	user, err := s.repo.FindByID(ctx, userID)
 	if err != nil {
 		return err
 	}
+	// As with email changes, every attempt is throttled and wrong passwords count as failed
+	// sign-ins, so a stolen token cannot be used to guess the password.
+	if err := s.throttle(ctx, "password-change:"+userID, passwordChangeLimit, passwordChangeWindow); err != nil {
+		return err
+	}
+	if err := s.loginBlocked(ctx, user.Email, ""); err != nil {
+		return err
+	}
 
 	match, err := auth.VerifyPassword(currentPassword, user.PasswordHash)
 	if err != nil || !match {
+		s.loginFailed(ctx, user.Email, "", user)
 		return ErrInvalidCredentials
 	}
+	if err := s.checkPasswordPolicy(newPassword, user); err != nil {
+		return err
+	}
+	if err := s.checkPasswordHistory(ctx, user, newPassword); err != nil {
+		return err
+	}
 
-	hash, err := auth.HashPassword(newPassword)
+	hash, err := s.passwords.Hash(newPassword)
 	if err != nil {
 		return err
 	}
 
 	user.PasswordHash = hash
-	return s.repo.Update(ctx, user)
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+	s.recordPassword(ctx, user)
+	if err := s.revokeAllSessions(ctx, userID, "password_changed"); err != nil {
+		return err
+	}
+	s.alert(ctx, user, NotificationPasswordChanged)
+	return nil
+}
+
+// LogoutAll revokes every access and refresh token issued to the user so far.
+func (s *Service) LogoutAll(ctx context.Context, userID string) error {
+	if s.generations == nil {
+		return errors.New("token generations not configured")
+	}
+	return s.revokeAllSessions(ctx, userID, "logout_all")
+}
+
+// revokeAllSessions bumps the user's token generation, which invalidates outstanding access
+// tokens, and revokes the stored refresh tokens.
+func (s *Service) revokeAllSessions(ctx context.Context, userID, reason string) error {
+	if s.generations != nil {
+		if _, err := s.generations.Bump(ctx, userID); err != nil {
+			return err
+		}
+	}
+	var revoked int64
+	if s.refreshTokens != nil {
+		var err error
+		revoked, err = s.refreshTokens.RevokeUserRefreshTokens(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+	if s.sessions != nil {
+		sessionIDs, err := s.sessions.RevokeUserSessions(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// Without generations the access tokens of each session have to be revoked one by one.
+		if s.generations == nil {
+			for _, id := range sessionIDs {
+				if err := s.revokeSessionAccess(ctx, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	s.emit(ctx, EventSessionsRevoked, userID, map[string]any{
+		"reason":        reason,
+		"revokedTokens": revoked,
+	})
+	return nil
 }
 
 // AssignRole assigns a role to a user.
@@ -251,7 +801,7 @@ func (s *Service) Logout(ctx context.Context, token string) error {
 		return errors.New("token blacklist not configured")
 	}
 
-	claims, err := s.issuer.ParseAndValidate(token)
+	claims, err := s.issuer.ParseAndValidate(token, auth.WithTokenType(auth.TokenTypeAccess))
 	if err != nil {
 		return ErrTokenInvalid
 	}
@@ -276,22 +826,85 @@ func (s *Service) Logout(ctx context.Context, token string) error {
 
 // ParseSubject extracts the user ID from a JWT.
 func (s *Service) ParseSubject(token string) (string, error) {
-	return s.issuer.SubjectFromToken(token)
+	return s.issuer.SubjectFromToken(token, auth.WithTokenType(auth.TokenTypeAccess))
 }
 
-func (s *Service) issueTokens(user *User) (*TokenPair, error) {
-	access, err := s.issuer.GenerateAccessToken(user.ID, map[string]any{
-		"email": user.Email,
-	})
+// issueTokens mints a token pair. A nil parent starts a new first-party refresh token family;
+// otherwise the refresh token continues the parent's family and its client binding.
+func (s *Service) issueTokens(ctx context.Context, user *User, client ClientInfo, parent *RefreshToken) (*TokenPair, error) {
+	var grant OAuthGrant
+	if parent != nil {
+		grant.ClientID = parent.ClientID.String
+		grant.Scope = parent.Scope.String
+	}
+	return s.mintTokens(ctx, user, client, grant, parent)
+}
+
+func (s *Service) mintTokens(ctx context.Context, user *User, client ClientInfo, grant OAuthGrant, parent *RefreshToken) (*TokenPair, error) {
+	accessClaims := map[string]any{"email": user.Email}
+	tokenID := uuid.NewString()
+	refreshClaims := map[string]any{"jti": tokenID}
+	if grant.ClientID != "" {
+		accessClaims["client_id"] = grant.ClientID
+		refreshClaims["client_id"] = grant.ClientID
+	}
+	if grant.Scope != "" {
+		accessClaims["scope"] = grant.Scope
+	}
+	if s.generations != nil {
+		generation, err := s.generations.Current(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		accessClaims[auth.GenerationClaim] = generation
+		refreshClaims[auth.GenerationClaim] = generation
+	}
+
+	familyID := tokenID
+	if parent != nil {
+		familyID = parent.FamilyID
+	}
+	if s.sessions != nil && s.refreshTokens != nil {
+		accessClaims[auth.SessionClaim] = familyID
+	}
+
+	access, err := s.issuer.GenerateAccessToken(user.ID, accessClaims)
 	if err != nil {
 		return nil, err
 	}
 
-	refresh, err := s.issuer.GenerateRefreshToken(user.ID, map[string]any{})
+	refresh, err := s.issuer.GenerateRefreshToken(user.ID, refreshClaims)
 	if err != nil {
 		return nil, err
 	}
 
+	if s.refreshTokens != nil {
+		record := &RefreshToken{
+			ID:        tokenID,
+			UserID:    user.ID,
+			FamilyID:  familyID,
+			ClientID:  sqlString(grant.ClientID),
+			Scope:     sqlString(grant.Scope),
+			TokenHash: auth.HashToken(refresh),
+			ExpiresAt: time.Now().Add(s.issuer.RefreshTokenTTL()),
+			UserAgent: sqlString(client.UserAgent),
+			IP:        sqlString(client.IP),
+		}
+		if parent != nil {
+			record.ParentID = sqlString(parent.ID)
+			if s.sessions != nil {
+				if err := s.sessions.TouchSession(ctx, familyID, time.Now(), record.ExpiresAt); err != nil {
+					return nil, fmt.Errorf("touch session: %w", err)
+				}
+			}
+		} else if err := s.startSession(ctx, record); err != nil {
+			return nil, fmt.Errorf("start session: %w", err)
+		}
+		if err := s.refreshTokens.CreateRefreshToken(ctx, record); err != nil {
+			return nil, fmt.Errorf("store refresh token: %w", err)
+		}
+	}
+
 	return &TokenPair{
 		AccessToken:      access,
 		RefreshToken:     refresh,
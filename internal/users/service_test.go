@@ -5,11 +5,15 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"database/sql"
 	"encoding/pem"
+	"errors"
+	"regexp"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 
 	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
@@ -108,6 +112,45 @@ func TestAuthenticateInvalidCredentials(t *testing.T) {
 	}
 }
 
+func TestAuthenticateUpgradesWeakPasswordHash(t *testing.T) {
+	svc, repo, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "rehash@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	weak, err := auth.NewPasswordHasher(auth.Argon2Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatalf("new weak hasher: %v", err)
+	}
+	weakHash, _ := weak.Hash("Password!2")
+	user, _ := repo.FindByID(ctx, reg.UserID)
+	user.PasswordHash = weakHash
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "rehash@example.com", Password: "wrong"}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+	if user, _ = repo.FindByID(ctx, reg.UserID); user.PasswordHash != weakHash {
+		t.Fatal("expected a failed login to leave the hash alone")
+	}
+
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "rehash@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	user, _ = repo.FindByID(ctx, reg.UserID)
+	current, _ := auth.NewPasswordHasher(auth.DefaultArgon2Params)
+	if user.PasswordHash == weakHash || current.NeedsRehash(user.PasswordHash) {
+		t.Fatalf("expected the hash to be upgraded, got %q", user.PasswordHash)
+	}
+	if ok, _ := auth.VerifyPassword("Password!2", user.PasswordHash); !ok {
+		t.Fatal("expected the upgraded hash to verify")
+	}
+}
+
 func TestLogoutBlacklistsToken(t *testing.T) {
 	svc, _, _, blacklist := newTestService(t)
 	ctx := context.Background()
@@ -126,7 +169,259 @@ func TestLogoutBlacklistsToken(t *testing.T) {
 	}
 }
 
-func newTestService(t *testing.T) (*users.Service, *memoryRepo, *memoryRoles, *memoryBlacklist) {
+func TestRefreshRotatesToken(t *testing.T) {
+	svc, repo, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{
+		Email:    "refresh@example.com",
+		Password: "Password!2",
+		Client:   users.ClientInfo{UserAgent: "test-agent", IP: "203.0.113.7"},
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	stored, ok := repo.refreshByHash(auth.HashToken(res.Tokens.RefreshToken))
+	if !ok {
+		t.Fatal("expected refresh token to be persisted")
+	}
+	if stored.UserAgent.String != "test-agent" || stored.IP.String != "203.0.113.7" {
+		t.Fatalf("unexpected client info: %+v", stored)
+	}
+
+	rotated, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: res.Tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if rotated.Tokens.RefreshToken == res.Tokens.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: res.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected revoked error for rotated token, got %v", err)
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: res.Tokens.AccessToken}); !errors.Is(err, users.ErrTokenInvalid) {
+		t.Fatalf("expected invalid error for access token, got %v", err)
+	}
+}
+
+func TestRefreshTokensAreBoundToTheirClient(t *testing.T) {
+	svc, _, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	login, err := svc.Register(ctx, users.RegisterRequest{Email: "bound@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: login.Tokens.RefreshToken, ClientID: "storefront"}); !errors.Is(err, users.ErrTokenInvalid) {
+		t.Fatalf("expected a client to be refused a first-party token, got %v", err)
+	}
+
+	granted, err := svc.IssueTokensForUser(ctx, login.UserID, users.OAuthGrant{ClientID: "storefront"}, users.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+	for _, clientID := range []string{"kiosk", ""} {
+		if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: granted.RefreshToken, ClientID: clientID}); !errors.Is(err, users.ErrTokenInvalid) {
+			t.Fatalf("expected client %q to be refused the storefront token, got %v", clientID, err)
+		}
+	}
+
+	rotated, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: granted.RefreshToken, ClientID: "storefront"})
+	if err != nil {
+		t.Fatalf("expected the issuing client to refresh: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: rotated.Tokens.RefreshToken, ClientID: "kiosk"}); !errors.Is(err, users.ErrTokenInvalid) {
+		t.Fatalf("expected the rotated token to stay bound, got %v", err)
+	}
+}
+
+func TestOAuthAccessTokensCarryTheGrantedScope(t *testing.T) {
+	svc, _, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	login, err := svc.Register(ctx, users.RegisterRequest{Email: "scoped@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if scope := accessScope(t, login.Tokens.AccessToken); scope != "" {
+		t.Fatalf("expected first-party tokens to carry no scope, got %q", scope)
+	}
+
+	granted, err := svc.IssueTokensForUser(ctx, login.UserID, users.OAuthGrant{ClientID: "storefront", Scope: "openid email"}, users.ClientInfo{})
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+	if scope := accessScope(t, granted.AccessToken); scope != "openid email" {
+		t.Fatalf("expected the granted scope, got %q", scope)
+	}
+
+	rotated, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: granted.RefreshToken, ClientID: "storefront"})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if scope := accessScope(t, rotated.Tokens.AccessToken); scope != "openid email" {
+		t.Fatalf("expected refreshed tokens to keep the granted scope, got %q", scope)
+	}
+}
+
+func accessScope(t *testing.T, accessToken string) string {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	scope, _ := claims["scope"].(string)
+	return scope
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	events := &memoryEvents{}
+	svc, repo, _, _ := newTestService(t, users.WithEventPublisher(events))
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "replay@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	first := res.Tokens.RefreshToken
+
+	second, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: first})
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+	third, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: second.Tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("second rotation: %v", err)
+	}
+
+	latest, _ := repo.refreshByHash(auth.HashToken(third.Tokens.RefreshToken))
+	origin, _ := repo.refreshByHash(auth.HashToken(first))
+	if latest.FamilyID != origin.FamilyID {
+		t.Fatalf("expected rotated tokens to share family, got %s and %s", latest.FamilyID, origin.FamilyID)
+	}
+	if !latest.ParentID.Valid || latest.ParentID.String == origin.ID {
+		t.Fatalf("expected latest token to link to its direct parent: %+v", latest)
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{
+		RefreshToken: first,
+		Client:       users.ClientInfo{IP: "198.51.100.1"},
+	}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: third.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected family to be revoked after replay, got %v", err)
+	}
+
+	published := events.byType(users.EventRefreshTokenReused)
+	if len(published) == 0 {
+		t.Fatal("expected security event for refresh token reuse")
+	}
+	if published[0].UserID != res.UserID || published[0].Data["familyId"] != origin.FamilyID {
+		t.Fatalf("unexpected security event: %+v", published[0])
+	}
+}
+
+func TestRefreshFamiliesAreIndependent(t *testing.T) {
+	svc, _, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "devices@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	laptop, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "devices@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate laptop: %v", err)
+	}
+	phone, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "devices@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate phone: %v", err)
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: laptop.Tokens.RefreshToken}); err != nil {
+		t.Fatalf("rotate laptop: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: laptop.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected laptop replay to be rejected, got %v", err)
+	}
+
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: phone.Tokens.RefreshToken}); err != nil {
+		t.Fatalf("expected other family to remain valid: %v", err)
+	}
+}
+
+func TestLogoutAllRevokesEverySession(t *testing.T) {
+	events := &memoryEvents{}
+	generations := newMemoryGenerations()
+	svc, _, _, _ := newTestService(t, users.WithTokenGenerations(generations), users.WithEventPublisher(events))
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "everywhere@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	other, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "everywhere@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+
+	if err := svc.LogoutAll(ctx, res.UserID); err != nil {
+		t.Fatalf("logout all: %v", err)
+	}
+	if current, _ := generations.Current(ctx, res.UserID); current != 1 {
+		t.Fatalf("expected generation to be bumped, got %d", current)
+	}
+
+	for _, token := range []string{res.Tokens.RefreshToken, other.Tokens.RefreshToken} {
+		if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: token}); !errors.Is(err, users.ErrTokenRevoked) {
+			t.Fatalf("expected refresh token to be revoked, got %v", err)
+		}
+	}
+	if reused := events.byType(users.EventRefreshTokenReused); len(reused) != 0 {
+		t.Fatalf("revoked sessions must not be reported as token reuse: %+v", reused)
+	}
+	if revoked := events.byType(users.EventSessionsRevoked); len(revoked) != 1 || revoked[0].Data["reason"] != "logout_all" {
+		t.Fatalf("expected sessions revoked event, got %+v", revoked)
+	}
+
+	fresh, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "everywhere@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate after logout all: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: fresh.Tokens.RefreshToken}); err != nil {
+		t.Fatalf("expected new session to work: %v", err)
+	}
+}
+
+func TestChangePasswordRevokesSessions(t *testing.T) {
+	generations := newMemoryGenerations()
+	svc, _, _, _ := newTestService(t, users.WithTokenGenerations(generations))
+	ctx := context.Background()
+
+	res, err := svc.Register(ctx, users.RegisterRequest{Email: "rotate@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := svc.ChangePassword(ctx, res.UserID, "Password!2", "Password!3"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: res.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected refresh token to be revoked after password change, got %v", err)
+	}
+	if active, err := svc.RefreshTokenActive(ctx, res.Tokens.RefreshToken); err != nil || active {
+		t.Fatalf("expected refresh token to be inactive, got %v %v", active, err)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "rotate@example.com", Password: "Password!3"}); err != nil {
+		t.Fatalf("expected new password to work: %v", err)
+	}
+}
+
+func newTestService(t *testing.T, opts ...users.Option) (*users.Service, *memoryRepo, *memoryRoles, *memoryBlacklist) {
 	t.Helper()
 
 	repo := newMemoryRepo()
@@ -139,25 +434,37 @@ func newTestService(t *testing.T) (*users.Service, *memoryRepo, *memoryRoles, *m
 		t.Fatalf("new token issuer: %v", err)
 	}
 
-	svc := users.NewService(repo, issuer, roles, blacklist)
+	opts = append([]users.Option{users.WithRefreshTokens(repo)}, opts...)
+	svc := users.NewService(repo, issuer, roles, blacklist, opts...)
 	return svc, repo, roles, blacklist
 }
 
+var codePattern = regexp.MustCompile(`\d{6}`)
+
 type memoryRepo struct {
-	mu      sync.RWMutex
-	byID    map[string]*users.User
-	byEmail map[string]*users.User
+	mu       sync.RWMutex
+	byID     map[string]*users.User
+	byEmail  map[string]*users.User
+	refresh  map[string]*users.RefreshToken
+	apiKeys  map[string]*users.APIKey
+	sessions map[string]*users.Session
 }
 
 func newMemoryRepo() *memoryRepo {
-	return &memoryRepo{byID: make(map[string]*users.User), byEmail: make(map[string]*users.User)}
+	return &memoryRepo{
+		byID:     make(map[string]*users.User),
+		byEmail:  make(map[string]*users.User),
+		refresh:  make(map[string]*users.RefreshToken),
+		apiKeys:  make(map[string]*users.APIKey),
+		sessions: make(map[string]*users.Session),
+	}
 }
 
 func (r *memoryRepo) Create(_ context.Context, u *users.User) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if _, exists := r.byEmail[u.Email]; exists {
-		return users.ErrInvalidCredentials
+		return users.ErrEmailTaken
 	}
 	if u.ID == "" {
 		u.ID = uuid.NewString()
@@ -188,110 +495,340 @@ func (r *memoryRepo) FindByID(_ context.Context, id string) (*users.User, error)
 	return nil, users.ErrNotFound
 }
 
+func (r *memoryRepo) FindByPhone(_ context.Context, phone string) (*users.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, u := range r.byID {
+		if u.Phone.Valid && u.Phone.String == phone {
+			clone := *u
+			return &clone, nil
+		}
+	}
+	return nil, users.ErrNotFound
+}
+
 func (r *memoryRepo) Update(_ context.Context, u *users.User) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if _, ok := r.byID[u.ID]; !ok {
 		return users.ErrNotFound
 	}
+	for id, other := range r.byID {
+		if id != u.ID && u.Phone.Valid && other.Phone.Valid && other.Phone.String == u.Phone.String {
+			return users.ErrPhoneTaken
+		}
+	}
 	clone := *u
 	r.byID[u.ID] = &clone
 	r.byEmail[u.Email] = &clone
 	return nil
 }
 
-type memoryRoles struct {
-	mu          sync.RWMutex
-	roles       map[string]map[string]struct{}
-	permissions map[string]map[string]struct{}
+func (r *memoryRepo) ReplacePasswordHash(_ context.Context, userID, oldHash, newHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u, ok := r.byID[userID]; ok && u.PasswordHash == oldHash {
+		u.PasswordHash = newHash
+	}
+	return nil
 }
 
-func newMemoryRoles() *memoryRoles {
-	return &memoryRoles{
-		roles:       make(map[string]map[string]struct{}),
-		permissions: make(map[string]map[string]struct{}),
+func (r *memoryRepo) changeEmail(id, oldEmail, newEmail string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok || u.Email != oldEmail {
+		return users.ErrEmailChangeNotFound
 	}
+	if _, taken := r.byEmail[newEmail]; taken {
+		return users.ErrEmailTaken
+	}
+	delete(r.byEmail, oldEmail)
+	u.Email = newEmail
+	u.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if u.Status == "pending" {
+		u.Status = "active"
+	}
+	r.byEmail[newEmail] = u
+	return nil
 }
 
-func (m *memoryRoles) AssignRole(_ context.Context, userID, role string) error {
-	m.assign(userID, role)
+func (r *memoryRepo) CreateRefreshToken(_ context.Context, t *users.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t.IssuedAt = time.Now()
+	clone := *t
+	r.refresh[t.ID] = &clone
 	return nil
 }
 
-func (m *memoryRoles) assign(userID, role string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if _, ok := m.roles[userID]; !ok {
-		m.roles[userID] = make(map[string]struct{})
+func (r *memoryRepo) FindRefreshToken(_ context.Context, tokenHash string) (*users.RefreshToken, error) {
+	if t, ok := r.refreshByHash(tokenHash); ok {
+		return t, nil
 	}
-	m.roles[userID][role] = struct{}{}
+	return nil, users.ErrRefreshTokenNotFound
 }
 
-func (m *memoryRoles) ListRoles(_ context.Context, userID string) ([]string, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	roles := m.roles[userID]
-	out := make([]string, 0, len(roles))
-	for role := range roles {
-		out = append(out, role)
+func (r *memoryRepo) RevokeRefreshToken(_ context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.refresh[id]
+	if !ok || t.Revoked {
+		return false, nil
 	}
-	return out, nil
+	t.Revoked = true
+	return true, nil
 }
 
-func (m *memoryRoles) ResolvePermissions(_ context.Context, userID string) ([]string, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	roles := m.roles[userID]
-	var perms []string
-	for role := range roles {
-		for perm := range m.permissions[role] {
-			perms = append(perms, perm)
+func (r *memoryRepo) RevokeRefreshTokenFamily(_ context.Context, familyID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var revoked int64
+	for _, t := range r.refresh {
+		if t.FamilyID == familyID && !t.Revoked {
+			t.Revoked = true
+			revoked++
 		}
 	}
-	return perms, nil
+	return revoked, nil
 }
 
-func (m *memoryRoles) HasPermission(ctx context.Context, userID, permission string) (bool, error) {
-	perms, _ := m.ResolvePermissions(ctx, userID)
-	for _, perm := range perms {
-		if perm == permission {
-			return true, nil
+func (r *memoryRepo) RevokeUserRefreshTokens(_ context.Context, userID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var revoked int64
+	for _, t := range r.refresh {
+		if t.UserID == userID && !t.Revoked {
+			t.Revoked = true
+			revoked++
 		}
 	}
-	return false, nil
+	return revoked, nil
 }
 
-func (m *memoryRoles) addPermission(role, permission string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if _, ok := m.permissions[role]; !ok {
-		m.permissions[role] = make(map[string]struct{})
+func (r *memoryRepo) refreshByHash(tokenHash string) (*users.RefreshToken, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.refresh {
+		if t.TokenHash == tokenHash {
+			clone := *t
+			return &clone, true
+		}
 	}
-	m.permissions[role][permission] = struct{}{}
+	return nil, false
 }
 
-func (m *memoryRoles) hasRole(userID, role string) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	roles := m.roles[userID]
-	_, ok := roles[role]
-	return ok
+func (r *memoryRepo) CreateAPIKey(_ context.Context, k *users.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k.CreatedAt = time.Now()
+	clone := *k
+	r.apiKeys[k.ID] = &clone
+	return nil
 }
 
-type memoryBlacklist struct {
-	mu     sync.RWMutex
-	tokens map[string]time.Time
+func (r *memoryRepo) FindAPIKey(_ context.Context, keyHash string) (*users.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.apiKeys {
+		if k.KeyHash == keyHash {
+			clone := *k
+			return &clone, nil
+		}
+	}
+	return nil, users.ErrAPIKeyNotFound
 }
 
-func newMemoryBlacklist() *memoryBlacklist {
-	return &memoryBlacklist{tokens: make(map[string]time.Time)}
+func (r *memoryRepo) ListAPIKeys(_ context.Context, userID string) ([]users.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var keys []users.APIKey
+	for _, k := range r.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, *k)
+		}
+	}
+	return keys, nil
 }
 
-func (m *memoryBlacklist) Revoke(_ context.Context, token string, ttl time.Duration) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if ttl <= 0 {
-		ttl = time.Second
+func (r *memoryRepo) RevokeAPIKey(_ context.Context, userID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.apiKeys[id]
+	if !ok || k.UserID != userID || k.RevokedAt.Valid {
+		return users.ErrAPIKeyNotFound
+	}
+	k.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (r *memoryRepo) TouchAPIKey(_ context.Context, id string, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k, ok := r.apiKeys[id]; ok {
+		k.LastUsedAt = sql.NullTime{Time: usedAt, Valid: true}
+	}
+	return nil
+}
+
+func (r *memoryRepo) CreateSession(_ context.Context, s *users.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.CreatedAt = time.Now()
+	s.LastRefreshedAt = s.CreatedAt
+	copied := *s
+	r.sessions[s.ID] = &copied
+	return nil
+}
+
+func (r *memoryRepo) FindSession(_ context.Context, id string) (*users.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if s, ok := r.sessions[id]; ok {
+		copied := *s
+		return &copied, nil
+	}
+	return nil, users.ErrSessionNotFound
+}
+
+func (r *memoryRepo) TouchSession(_ context.Context, id string, refreshedAt, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.sessions[id]; ok {
+		s.LastRefreshedAt = refreshedAt
+		s.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (r *memoryRepo) ListSessions(_ context.Context, userID string) ([]users.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var sessions []users.Session
+	for _, s := range r.sessions {
+		if s.UserID == userID && !s.RevokedAt.Valid && time.Now().Before(s.ExpiresAt) {
+			sessions = append(sessions, *s)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *memoryRepo) RevokeSession(_ context.Context, userID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	if !ok || s.UserID != userID || s.RevokedAt.Valid {
+		return users.ErrSessionNotFound
+	}
+	s.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (r *memoryRepo) RevokeUserSessions(_ context.Context, userID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for _, s := range r.sessions {
+		if s.UserID == userID && !s.RevokedAt.Valid {
+			s.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids, nil
+}
+
+type memoryRoles struct {
+	mu          sync.RWMutex
+	roles       map[string]map[string]struct{}
+	permissions map[string]map[string]struct{}
+}
+
+func newMemoryRoles() *memoryRoles {
+	return &memoryRoles{
+		roles:       make(map[string]map[string]struct{}),
+		permissions: make(map[string]map[string]struct{}),
+	}
+}
+
+func (m *memoryRoles) AssignRole(_ context.Context, userID, role string) error {
+	m.assign(userID, role)
+	return nil
+}
+
+func (m *memoryRoles) assign(userID, role string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.roles[userID]; !ok {
+		m.roles[userID] = make(map[string]struct{})
+	}
+	m.roles[userID][role] = struct{}{}
+}
+
+func (m *memoryRoles) ListRoles(_ context.Context, userID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	roles := m.roles[userID]
+	out := make([]string, 0, len(roles))
+	for role := range roles {
+		out = append(out, role)
+	}
+	return out, nil
+}
+
+func (m *memoryRoles) ResolvePermissions(_ context.Context, userID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	roles := m.roles[userID]
+	var perms []string
+	for role := range roles {
+		for perm := range m.permissions[role] {
+			perms = append(perms, perm)
+		}
+	}
+	return perms, nil
+}
+
+func (m *memoryRoles) HasPermission(ctx context.Context, userID, permission string) (bool, error) {
+	perms, _ := m.ResolvePermissions(ctx, userID)
+	for _, perm := range perms {
+		if perm == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryRoles) addPermission(role, permission string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.permissions[role]; !ok {
+		m.permissions[role] = make(map[string]struct{})
+	}
+	m.permissions[role][permission] = struct{}{}
+}
+
+func (m *memoryRoles) hasRole(userID, role string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	roles := m.roles[userID]
+	_, ok := roles[role]
+	return ok
+}
+
+type memoryBlacklist struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+}
+
+func newMemoryBlacklist() *memoryBlacklist {
+	return &memoryBlacklist{tokens: make(map[string]time.Time)}
+}
+
+func (m *memoryBlacklist) Revoke(_ context.Context, token string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ttl <= 0 {
+		ttl = time.Second
 	}
 	m.tokens[token] = time.Now().Add(ttl)
 	return nil
@@ -317,6 +854,596 @@ func (m *memoryBlacklist) has(token string) bool {
 	return ok
 }
 
+type memoryGenerations struct {
+	mu      sync.Mutex
+	current map[string]int64
+}
+
+func newMemoryGenerations() *memoryGenerations {
+	return &memoryGenerations{current: make(map[string]int64)}
+}
+
+func (m *memoryGenerations) Current(_ context.Context, userID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current[userID], nil
+}
+
+func (m *memoryGenerations) Bump(_ context.Context, userID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current[userID]++
+	return m.current[userID], nil
+}
+
+type memorySessionRevocations struct {
+	mu      sync.Mutex
+	revoked map[string]bool
+}
+
+func newMemorySessionRevocations() *memorySessionRevocations {
+	return &memorySessionRevocations{revoked: make(map[string]bool)}
+}
+
+func (m *memorySessionRevocations) RevokeSession(_ context.Context, sessionID string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[sessionID] = true
+	return nil
+}
+
+func (m *memorySessionRevocations) IsSessionRevoked(_ context.Context, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revoked[sessionID], nil
+}
+
+type memoryMFA struct {
+	mu       sync.Mutex
+	byUser   map[string]*users.MFA
+	recovery map[string]map[string]bool
+}
+
+func newMemoryMFA() *memoryMFA {
+	return &memoryMFA{byUser: make(map[string]*users.MFA), recovery: make(map[string]map[string]bool)}
+}
+
+func (m *memoryMFA) FindMFA(_ context.Context, userID string) (*users.MFA, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enrollment, ok := m.byUser[userID]; ok {
+		copied := *enrollment
+		return &copied, nil
+	}
+	return nil, users.ErrMFANotEnrolled
+}
+
+func (m *memoryMFA) SaveMFA(_ context.Context, enrollment *users.MFA) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.byUser[enrollment.UserID]; ok && existing.ConfirmedAt.Valid {
+		return users.ErrMFAAlreadyEnabled
+	}
+	enrollment.CreatedAt = time.Now()
+	copied := *enrollment
+	m.byUser[enrollment.UserID] = &copied
+	return nil
+}
+
+func (m *memoryMFA) ConfirmMFA(_ context.Context, userID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enrollment, ok := m.byUser[userID]; ok {
+		enrollment.ConfirmedAt = sql.NullTime{Time: at, Valid: true}
+	}
+	return nil
+}
+
+func (m *memoryMFA) UseTOTPStep(_ context.Context, userID string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	enrollment, ok := m.byUser[userID]
+	if !ok || enrollment.LastUsedStep >= step {
+		return false, nil
+	}
+	enrollment.LastUsedStep = step
+	return true, nil
+}
+
+func (m *memoryMFA) DeleteMFA(_ context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.byUser, userID)
+	delete(m.recovery, userID)
+	return nil
+}
+
+func (m *memoryMFA) ReplaceRecoveryCodes(_ context.Context, userID string, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recovery[userID] = make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		m.recovery[userID][hash] = false
+	}
+	return nil
+}
+
+func (m *memoryMFA) UseRecoveryCode(_ context.Context, userID, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used, ok := m.recovery[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	m.recovery[userID][codeHash] = true
+	return true, nil
+}
+
+type memoryPasskeys struct {
+	mu       sync.Mutex
+	passkeys map[string]*users.Passkey
+}
+
+func newMemoryPasskeys() *memoryPasskeys {
+	return &memoryPasskeys{passkeys: make(map[string]*users.Passkey)}
+}
+
+func (m *memoryPasskeys) CreatePasskey(_ context.Context, p *users.Passkey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.CreatedAt = time.Now()
+	copied := *p
+	m.passkeys[string(p.ID)] = &copied
+	return nil
+}
+
+func (m *memoryPasskeys) FindPasskey(_ context.Context, id []byte) (*users.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.passkeys[string(id)]; ok {
+		copied := *p
+		return &copied, nil
+	}
+	return nil, users.ErrPasskeyNotFound
+}
+
+func (m *memoryPasskeys) ListPasskeys(_ context.Context, userID string) ([]users.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var passkeys []users.Passkey
+	for _, p := range m.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, *p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (m *memoryPasskeys) UpdatePasskeyCounter(_ context.Context, id []byte, signCount uint32, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.passkeys[string(id)]; ok {
+		p.SignCount = signCount
+		p.LastUsedAt = sql.NullTime{Time: usedAt, Valid: true}
+	}
+	return nil
+}
+
+func (m *memoryPasskeys) DeletePasskey(_ context.Context, userID string, id []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.passkeys[string(id)]
+	if !ok || p.UserID != userID {
+		return users.ErrPasskeyNotFound
+	}
+	delete(m.passkeys, string(id))
+	return nil
+}
+
+type memoryCeremonies struct {
+	mu         sync.Mutex
+	ceremonies map[string]auth.WebAuthnCeremony
+}
+
+func newMemoryCeremonies() *memoryCeremonies {
+	return &memoryCeremonies{ceremonies: make(map[string]auth.WebAuthnCeremony)}
+}
+
+func (m *memoryCeremonies) SaveCeremony(_ context.Context, id string, ceremony auth.WebAuthnCeremony, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ceremonies[id] = ceremony
+	return nil
+}
+
+func (m *memoryCeremonies) TakeCeremony(_ context.Context, id string) (*auth.WebAuthnCeremony, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ceremony, ok := m.ceremonies[id]
+	if !ok {
+		return nil, auth.ErrCeremonyNotFound
+	}
+	delete(m.ceremonies, id)
+	return &ceremony, nil
+}
+
+type memoryUserTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*users.UserToken
+}
+
+func newMemoryUserTokens() *memoryUserTokens {
+	return &memoryUserTokens{tokens: make(map[string]*users.UserToken)}
+}
+
+func (m *memoryUserTokens) CreateUserToken(_ context.Context, t *users.UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.CreatedAt = time.Now()
+	copied := *t
+	m.tokens[t.TokenHash] = &copied
+	return nil
+}
+
+func (m *memoryUserTokens) FindUserToken(_ context.Context, purpose, tokenHash string) (*users.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[tokenHash]
+	if !ok || t.Purpose != purpose || t.UsedAt.Valid || !time.Now().Before(t.ExpiresAt) {
+		return nil, users.ErrUserTokenNotFound
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (m *memoryUserTokens) ConsumeUserToken(_ context.Context, purpose, tokenHash string) (*users.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[tokenHash]
+	if !ok || t.Purpose != purpose || t.UsedAt.Valid || !time.Now().Before(t.ExpiresAt) {
+		return nil, users.ErrUserTokenNotFound
+	}
+	t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	copied := *t
+	return &copied, nil
+}
+
+func (m *memoryUserTokens) InvalidateUserTokens(_ context.Context, userID, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if t.UserID == userID && t.Purpose == purpose && !t.UsedAt.Valid {
+			t.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *memoryUserTokens) expire(tokenHash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[tokenHash].ExpiresAt = time.Now().Add(-time.Minute)
+}
+
+type memoryLimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{counts: make(map[string]int)}
+}
+
+func (m *memoryLimiter) Allow(_ context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[key]++
+	if m.counts[key] > limit {
+		return false, window, nil
+	}
+	return true, 0, nil
+}
+
+type memoryNotifier struct {
+	mu   sync.Mutex
+	sent []users.Notification
+}
+
+func (m *memoryNotifier) Notify(_ context.Context, n users.Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, n)
+	return nil
+}
+
+func (m *memoryNotifier) last(kind string) (users.Notification, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].Kind == kind {
+			return m.sent[i], true
+		}
+	}
+	return users.Notification{}, false
+}
+
+func (m *memoryNotifier) count(kind string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, sent := range m.sent {
+		if sent.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// memoryEmailChanges applies confirmed changes to repo, which tests set once the service exists.
+type memoryEmailChanges struct {
+	mu      sync.Mutex
+	repo    *memoryRepo
+	changes []*users.EmailChange
+}
+
+func (m *memoryEmailChanges) CreateEmailChange(_ context.Context, c *users.EmailChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.changes {
+		if existing.UserID == c.UserID && !existing.ConfirmedAt.Valid && !existing.CancelledAt.Valid {
+			existing.CancelledAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	c.CreatedAt = time.Now()
+	clone := *c
+	m.changes = append(m.changes, &clone)
+	return nil
+}
+
+func (m *memoryEmailChanges) ConfirmEmailChange(_ context.Context, confirmTokenHash string) (*users.EmailChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.pending(func(c *users.EmailChange) bool { return c.ConfirmTokenHash == confirmTokenHash })
+	if c == nil {
+		return nil, users.ErrEmailChangeNotFound
+	}
+	if err := m.repo.changeEmail(c.UserID, c.OldEmail, c.NewEmail); err != nil {
+		return nil, err
+	}
+	c.ConfirmedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	clone := *c
+	return &clone, nil
+}
+
+func (m *memoryEmailChanges) CancelEmailChange(_ context.Context, cancelTokenHash string) (*users.EmailChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.pending(func(c *users.EmailChange) bool { return c.CancelTokenHash == cancelTokenHash })
+	if c == nil {
+		return nil, users.ErrEmailChangeNotFound
+	}
+	c.CancelledAt = sql.NullTime{Time: time.Now(), Valid: true}
+	clone := *c
+	return &clone, nil
+}
+
+func (m *memoryEmailChanges) pending(match func(*users.EmailChange) bool) *users.EmailChange {
+	for _, c := range m.changes {
+		if match(c) && !c.ConfirmedAt.Valid && !c.CancelledAt.Valid && time.Now().Before(c.ExpiresAt) {
+			return c
+		}
+	}
+	return nil
+}
+
+type memoryOneTimeCodes struct {
+	mu       sync.Mutex
+	codes    map[string]auth.OneTimeCode
+	attempts map[string]int
+}
+
+func newMemoryOneTimeCodes() *memoryOneTimeCodes {
+	return &memoryOneTimeCodes{codes: make(map[string]auth.OneTimeCode), attempts: make(map[string]int)}
+}
+
+func (m *memoryOneTimeCodes) SaveCode(_ context.Context, key string, code auth.OneTimeCode, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[key] = code
+	m.attempts[key] = 0
+	return nil
+}
+
+func (m *memoryOneTimeCodes) CheckCode(_ context.Context, key, codeHash string, maxAttempts int) (*auth.OneTimeCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[key]
+	if !ok {
+		return nil, auth.ErrCodeNotFound
+	}
+	if code.CodeHash != codeHash {
+		m.attempts[key]++
+		if m.attempts[key] >= maxAttempts {
+			delete(m.codes, key)
+		}
+		return nil, auth.ErrCodeMismatch
+	}
+	delete(m.codes, key)
+	return &code, nil
+}
+
+type sentSMS struct {
+	to   string
+	body string
+}
+
+type memorySMS struct {
+	mu   sync.Mutex
+	sent []sentSMS
+}
+
+func (m *memorySMS) SendSMS(_ context.Context, to, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, sentSMS{to: to, body: body})
+	return nil
+}
+
+// lastCode returns the code in the last message sent to the number.
+func (m *memorySMS) lastCode(to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].to == to {
+			return codePattern.FindString(m.sent[i].body)
+		}
+	}
+	return ""
+}
+
+// memoryIdentityLinks creates federated users in repo, which tests set once the service exists.
+type memoryIdentityLinks struct {
+	mu    sync.Mutex
+	repo  *memoryRepo
+	links []*users.IdentityLink
+}
+
+func (m *memoryIdentityLinks) CreateIdentityLink(_ context.Context, link *users.IdentityLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create(link)
+}
+
+func (m *memoryIdentityLinks) CreateUserWithIdentity(ctx context.Context, u *users.User, link *users.IdentityLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.links {
+		if existing.Provider == link.Provider && existing.Subject == link.Subject {
+			return users.ErrIdentityAlreadyLinked
+		}
+	}
+	if err := m.repo.Create(ctx, u); err != nil {
+		return err
+	}
+	link.UserID = u.ID
+	return m.create(link)
+}
+
+func (m *memoryIdentityLinks) create(link *users.IdentityLink) error {
+	for _, existing := range m.links {
+		if existing.Provider == link.Provider && existing.Subject == link.Subject {
+			return users.ErrIdentityAlreadyLinked
+		}
+	}
+	link.CreatedAt = time.Now()
+	clone := *link
+	m.links = append(m.links, &clone)
+	return nil
+}
+
+func (m *memoryIdentityLinks) FindIdentityLink(_ context.Context, provider, subject string) (*users.IdentityLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, link := range m.links {
+		if link.Provider == provider && link.Subject == subject {
+			clone := *link
+			return &clone, nil
+		}
+	}
+	return nil, users.ErrIdentityLinkNotFound
+}
+
+func (m *memoryIdentityLinks) ListIdentityLinks(_ context.Context, userID string) ([]users.IdentityLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []users.IdentityLink
+	for _, link := range m.links {
+		if link.UserID == userID {
+			out = append(out, *link)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryIdentityLinks) TouchIdentityLink(_ context.Context, id, email string, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, link := range m.links {
+		if link.ID == id {
+			if email != "" {
+				link.Email = sql.NullString{String: email, Valid: true}
+			}
+			link.LastLoginAt = sql.NullTime{Time: usedAt, Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *memoryIdentityLinks) DeleteIdentityLink(_ context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, link := range m.links {
+		if link.ID == id && link.UserID == userID {
+			m.links = append(m.links[:i], m.links[i+1:]...)
+			return nil
+		}
+	}
+	return users.ErrIdentityLinkNotFound
+}
+
+type memoryFederationStates struct {
+	mu     sync.Mutex
+	states map[string]auth.FederatedLoginState
+}
+
+func newMemoryFederationStates() *memoryFederationStates {
+	return &memoryFederationStates{states: make(map[string]auth.FederatedLoginState)}
+}
+
+func (m *memoryFederationStates) SaveState(_ context.Context, state string, login auth.FederatedLoginState, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[state] = login
+	return nil
+}
+
+func (m *memoryFederationStates) TakeState(_ context.Context, state string) (*auth.FederatedLoginState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	login, ok := m.states[state]
+	if !ok {
+		return nil, auth.ErrFederatedStateNotFound
+	}
+	delete(m.states, state)
+	return &login, nil
+}
+
+type memoryEvents struct {
+	mu     sync.Mutex
+	events []users.Event
+}
+
+func (m *memoryEvents) Publish(_ context.Context, _ string, payload any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if evt, ok := payload.(users.Event); ok {
+		m.events = append(m.events, evt)
+	}
+	return nil
+}
+
+func (m *memoryEvents) byType(eventType string) []users.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []users.Event
+	for _, evt := range m.events {
+		if evt.Type == eventType {
+			out = append(out, evt)
+		}
+	}
+	return out
+}
+
 func generateKeyPair(t *testing.T) ([]byte, []byte) {
 	t.Helper()
 
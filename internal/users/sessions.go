@@ -0,0 +1,199 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one login on one device. Its ID is the refresh token family ID, so every token
+// rotated from the login belongs to the same session.
+type Session struct {
+	ID              string
+	UserID          string
+	UserAgent       sql.NullString
+	IP              sql.NullString
+	CreatedAt       time.Time
+	LastRefreshedAt time.Time
+	ExpiresAt       time.Time
+	RevokedAt       sql.NullTime
+}
+
+// SessionRepository stores login sessions.
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *Session) error
+	FindSession(ctx context.Context, id string) (*Session, error)
+	TouchSession(ctx context.Context, id string, refreshedAt, expiresAt time.Time) error
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+	RevokeSession(ctx context.Context, userID, id string) error
+	RevokeUserSessions(ctx context.Context, userID string) ([]string, error)
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SQLSessionRepository persists sessions in the sessions table.
+type SQLSessionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLSessionRepository creates a session repository instance.
+func NewSQLSessionRepository(db *sql.DB) *SQLSessionRepository {
+	return &SQLSessionRepository{db: db}
+}
+
+// CreateSession inserts a new session record.
+func (r *SQLSessionRepository) CreateSession(ctx context.Context, s *Session) error {
+	query := `INSERT INTO sessions (id, user_id, user_agent, ip, expires_at) VALUES ($1,$2,$3,$4,$5) RETURNING created_at, last_refreshed_at`
+	return r.db.QueryRowContext(ctx, query, s.ID, s.UserID, s.UserAgent, s.IP, s.ExpiresAt).
+		Scan(&s.CreatedAt, &s.LastRefreshedAt)
+}
+
+// FindSession returns a session by ID.
+func (r *SQLSessionRepository) FindSession(ctx context.Context, id string) (*Session, error) {
+	query := `SELECT id, user_id, user_agent, host(ip), created_at, last_refreshed_at, expires_at, revoked_at FROM sessions WHERE id=$1`
+	s, err := scanSession(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	return s, err
+}
+
+// TouchSession records a refresh and extends the session to the new refresh token's expiry.
+func (r *SQLSessionRepository) TouchSession(ctx context.Context, id string, refreshedAt, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE sessions SET last_refreshed_at=$1, expires_at=$2 WHERE id=$3`, refreshedAt, expiresAt, id)
+	return err
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+func (r *SQLSessionRepository) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	query := `SELECT id, user_id, user_agent, host(ip), created_at, last_refreshed_at, expires_at, revoked_at FROM sessions
+WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > now()
+ORDER BY last_refreshed_at DESC`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of the user's active sessions.
+func (r *SQLSessionRepository) RevokeSession(ctx context.Context, userID, id string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of the user and returns their IDs.
+func (r *SQLSessionRepository) RevokeUserSessions(ctx context.Context, userID string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL RETURNING id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	s := &Session{}
+	if err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastRefreshedAt, &s.ExpiresAt, &s.RevokedAt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListSessions returns the user's active sessions.
+func (s *Service) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if s.sessions == nil {
+		return nil, nil
+	}
+	return s.sessions.ListSessions(ctx, userID)
+}
+
+// RevokeSession signs one of the user's devices out: the session's refresh token family is
+// revoked and its outstanding access tokens stop being accepted.
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if s.sessions == nil {
+		return ErrSessionNotFound
+	}
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+	if err := s.sessions.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+	if s.refreshTokens != nil {
+		if _, err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, sessionID); err != nil {
+			return err
+		}
+	}
+	return s.revokeSessionAccess(ctx, sessionID)
+}
+
+// revokeSessionAccess rejects the access tokens already issued for the session.
+func (s *Service) revokeSessionAccess(ctx context.Context, sessionID string) error {
+	if s.sessionRevocations == nil {
+		return nil
+	}
+	return s.sessionRevocations.RevokeSession(ctx, sessionID, s.issuer.AccessTokenTTL())
+}
+
+// startSession records a new login. The session shares its ID with the refresh token family.
+func (s *Service) startSession(ctx context.Context, record *RefreshToken) error {
+	if s.sessions == nil {
+		return nil
+	}
+	return s.sessions.CreateSession(ctx, &Session{
+		ID:        record.FamilyID,
+		UserID:    record.UserID,
+		UserAgent: record.UserAgent,
+		IP:        record.IP,
+		ExpiresAt: record.ExpiresAt,
+	})
+}
+
+// sessionRevoked reports whether the refresh token's session was ended deliberately, as
+// opposed to the token having been rotated already.
+func (s *Service) sessionRevoked(ctx context.Context, familyID string) (bool, error) {
+	if s.sessions == nil {
+		return false, nil
+	}
+	session, err := s.sessions.FindSession(ctx, familyID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return session.RevokedAt.Valid, nil
+}
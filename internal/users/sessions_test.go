@@ -0,0 +1,108 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestSessionInventory(t *testing.T) {
+	revocations := newMemorySessionRevocations()
+	events := &memoryEvents{}
+	repo := newMemoryRepo()
+	priv, pub := generateKeyPair(t)
+	issuer, err := auth.NewTokenIssuer(priv, pub, "test", []string{"api"})
+	if err != nil {
+		t.Fatalf("new token issuer: %v", err)
+	}
+	svc := users.NewService(repo, issuer, newMemoryRoles(), newMemoryBlacklist(),
+		users.WithRefreshTokens(repo),
+		users.WithSessions(repo, revocations),
+		users.WithEventPublisher(events),
+	)
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "devices@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	laptop, err := svc.Authenticate(ctx, users.AuthenticateRequest{
+		Email: "devices@example.com", Password: "Password!2",
+		Client: users.ClientInfo{UserAgent: "Firefox", IP: "203.0.113.7"},
+	})
+	if err != nil {
+		t.Fatalf("authenticate laptop: %v", err)
+	}
+	phone, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "devices@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("authenticate phone: %v", err)
+	}
+
+	sessions, err := svc.ListSessions(ctx, laptop.UserID)
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("expected a session per login, got %d", len(sessions))
+	}
+
+	laptopSession := sessionOf(t, issuer, laptop.Tokens.AccessToken)
+	rotated, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: laptop.Tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := sessionOf(t, issuer, rotated.Tokens.AccessToken); got != laptopSession {
+		t.Fatalf("expected refresh to stay in session %s, got %s", laptopSession, got)
+	}
+	session, err := repo.FindSession(ctx, laptopSession)
+	if err != nil {
+		t.Fatalf("find session: %v", err)
+	}
+	if session.UserAgent.String != "Firefox" || session.IP.String != "203.0.113.7" || session.LastRefreshedAt.Before(session.CreatedAt) {
+		t.Fatalf("unexpected session record: %+v", session)
+	}
+
+	if err := svc.RevokeSession(ctx, phone.UserID, laptopSession); err != nil {
+		t.Fatalf("revoke session: %v", err)
+	}
+	if !revocations.revoked[laptopSession] {
+		t.Fatal("expected access tokens of the session to be revoked")
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: rotated.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected refresh family to be revoked, got %v", err)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: laptop.Tokens.RefreshToken}); !errors.Is(err, users.ErrTokenRevoked) {
+		t.Fatalf("expected rotated token of revoked session to be rejected, got %v", err)
+	}
+	if reused := events.byType(users.EventRefreshTokenReused); len(reused) != 0 {
+		t.Fatalf("revoked sessions must not be reported as token reuse: %+v", reused)
+	}
+	if _, err := svc.Refresh(ctx, users.RefreshRequest{RefreshToken: phone.Tokens.RefreshToken}); err != nil {
+		t.Fatalf("expected other session to remain valid: %v", err)
+	}
+
+	if err := svc.RevokeSession(ctx, phone.UserID, laptopSession); !errors.Is(err, users.ErrSessionNotFound) {
+		t.Fatalf("expected revoked session to be gone, got %v", err)
+	}
+	if err := svc.RevokeSession(ctx, "someone-else", sessionOf(t, issuer, phone.Tokens.AccessToken)); !errors.Is(err, users.ErrSessionNotFound) {
+		t.Fatalf("expected other users' sessions to be hidden, got %v", err)
+	}
+	if sessions, _ := svc.ListSessions(ctx, phone.UserID); len(sessions) != 2 {
+		t.Fatalf("expected revoked session to be omitted, got %d", len(sessions))
+	}
+}
+
+func sessionOf(t *testing.T, issuer *auth.TokenIssuer, accessToken string) string {
+	t.Helper()
+	claims, err := issuer.ParseAndValidate(accessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	sid, _ := claims[auth.SessionClaim].(string)
+	if sid == "" {
+		t.Fatal("access token has no session claim")
+	}
+	return sid
+}
@@ -0,0 +1,142 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+// Purposes of single-use user tokens.
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeMagicLink         = "magic_link"
+)
+
+// UserToken is a single-use secret mailed to a user, such as an email verification link. Only
+// the hash of the secret is stored. BindingHash, when set, is the hash of a second secret held
+// by the device that asked for the token, which must be presented together with it.
+type UserToken struct {
+	ID          string
+	UserID      string
+	Purpose     string
+	TokenHash   string
+	BindingHash sql.NullString
+	ExpiresAt   time.Time
+	UsedAt      sql.NullTime
+	CreatedAt   time.Time
+}
+
+// UserTokenRepository stores single-use user tokens.
+type UserTokenRepository interface {
+	CreateUserToken(ctx context.Context, t *UserToken) error
+	// FindUserToken returns an unused, unexpired token without using it up.
+	FindUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error)
+	// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+	ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error)
+	// InvalidateUserTokens marks every unused token of the user with the purpose as used.
+	InvalidateUserTokens(ctx context.Context, userID, purpose string) error
+}
+
+var ErrUserTokenNotFound = errors.New("user token not found")
+
+// SQLUserTokenRepository persists user tokens in the user_tokens table.
+type SQLUserTokenRepository struct {
+	db *sql.DB
+}
+
+// NewSQLUserTokenRepository creates a user token repository instance.
+func NewSQLUserTokenRepository(db *sql.DB) *SQLUserTokenRepository {
+	return &SQLUserTokenRepository{db: db}
+}
+
+// CreateUserToken inserts a new token record.
+func (r *SQLUserTokenRepository) CreateUserToken(ctx context.Context, t *UserToken) error {
+	query := `INSERT INTO user_tokens (id, user_id, purpose, token_hash, binding_hash, expires_at) VALUES ($1,$2,$3,$4,$5,$6) RETURNING created_at`
+	return r.db.QueryRowContext(ctx, query, t.ID, t.UserID, t.Purpose, t.TokenHash, t.BindingHash, t.ExpiresAt).Scan(&t.CreatedAt)
+}
+
+// FindUserToken looks up a redeemable token.
+func (r *SQLUserTokenRepository) FindUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error) {
+	query := `SELECT id, user_id, purpose, token_hash, binding_hash, expires_at, used_at, created_at FROM user_tokens WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now()`
+	t := &UserToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).
+		Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.BindingHash, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ConsumeUserToken marks the token as used in a single statement so it can be redeemed only once.
+func (r *SQLUserTokenRepository) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error) {
+	query := `UPDATE user_tokens SET used_at=now() WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now() RETURNING id, user_id, purpose, token_hash, binding_hash, expires_at, used_at, created_at`
+	t := &UserToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).
+		Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.BindingHash, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// InvalidateUserTokens marks the user's outstanding tokens with the purpose as used.
+func (r *SQLUserTokenRepository) InvalidateUserTokens(ctx context.Context, userID, purpose string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL`, userID, purpose)
+	return err
+}
+
+// sendUserToken stores a new token for the user and hands its secret to the notifier.
+func (s *Service) sendUserToken(ctx context.Context, user *User, purpose, kind string, ttl time.Duration) error {
+	return s.sendBoundUserToken(ctx, user, purpose, kind, ttl, "")
+}
+
+// sendBoundUserToken is sendUserToken for a token bound to the device holding the secret whose
+// hash is bindingHash. An empty bindingHash leaves the token unbound.
+func (s *Service) sendBoundUserToken(ctx context.Context, user *User, purpose, kind string, ttl time.Duration, bindingHash string) error {
+	secret, err := newUserTokenSecret()
+	if err != nil {
+		return err
+	}
+	record := UserToken{
+		ID:          uuid.NewString(),
+		UserID:      user.ID,
+		Purpose:     purpose,
+		TokenHash:   auth.HashToken(secret),
+		BindingHash: sqlString(bindingHash),
+		ExpiresAt:   time.Now().Add(ttl).UTC(),
+	}
+	if err := s.userTokens.CreateUserToken(ctx, &record); err != nil {
+		return err
+	}
+	return s.notify(ctx, Notification{
+		Kind:      kind,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Name:      user.FirstName.String,
+		Token:     secret,
+		ExpiresAt: record.ExpiresAt,
+	})
+}
+
+// newUserTokenSecret returns 256 random bits encoded for use in links.
+func newUserTokenSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,138 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+)
+
+const (
+	// emailVerificationTTL is how long a verification link stays valid.
+	emailVerificationTTL = 24 * time.Hour
+	// Verification emails are resent at most verificationResendLimit times per window per address.
+	verificationResendLimit  = 3
+	verificationResendWindow = time.Hour
+)
+
+var (
+	ErrVerificationTokenInvalid = errors.New("invalid or expired verification token")
+	ErrEmailNotVerified         = errors.New("email not verified")
+	ErrRateLimited              = errors.New("too many requests")
+)
+
+// RateLimitError reports a throttled request and when it may be retried. It matches ErrRateLimited.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+// Is reports whether target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// throttle records an attempt under key and returns a RateLimitError once there were more than
+// limit attempts in the window. Without a limiter nothing is throttled.
+func (s *Service) throttle(ctx context.Context, key string, limit int, window time.Duration) error {
+	if s.limiter == nil {
+		return nil
+	}
+	allowed, retryAfter, err := s.limiter.Allow(ctx, key, limit, window)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &RateLimitError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// VerifyEmail redeems a verification token, marks the email as verified and activates a
+// pending account.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	if s.userTokens == nil {
+		return errors.New("email verification not configured")
+	}
+	record, err := s.userTokens.ConsumeUserToken(ctx, TokenPurposeEmailVerification, auth.HashToken(strings.TrimSpace(token)))
+	if err != nil {
+		if errors.Is(err, ErrUserTokenNotFound) {
+			return ErrVerificationTokenInvalid
+		}
+		return err
+	}
+
+	user, err := s.repo.FindByID(ctx, record.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrVerificationTokenInvalid
+		}
+		return err
+	}
+	if !user.EmailVerifiedAt.Valid {
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	if user.Status == "pending" {
+		user.Status = "active"
+	}
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+	if err := s.userTokens.InvalidateUserTokens(ctx, user.ID, TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+
+	s.emit(ctx, EventEmailVerified, user.ID, map[string]any{"email": user.Email})
+	return nil
+}
+
+// ResendEmailVerification sends a fresh verification link and invalidates earlier ones. It
+// succeeds silently for unknown and already verified addresses so that it cannot be used to
+// probe for accounts; the throttle counts every address alike for the same reason.
+func (s *Service) ResendEmailVerification(ctx context.Context, email string) error {
+	if s.userTokens == nil {
+		return errors.New("email verification not configured")
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if err := s.throttle(ctx, "verify-email:"+auth.HashToken(email), verificationResendLimit, verificationResendWindow); err != nil {
+		return err
+	}
+
+	user, err := s.repo.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.EmailVerifiedAt.Valid || user.Status == "disabled" {
+		return nil
+	}
+
+	if err := s.userTokens.InvalidateUserTokens(ctx, user.ID, TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+	return s.sendEmailVerification(ctx, user)
+}
+
+func (s *Service) sendEmailVerification(ctx context.Context, user *User) error {
+	return s.sendUserToken(ctx, user, TokenPurposeEmailVerification, NotificationEmailVerification, emailVerificationTTL)
+}
+
+// signInAllowed rejects disabled users, and pending users when a verified email is required.
+func (s *Service) signInAllowed(user *User) error {
+	if user.Status == "disabled" {
+		return ErrUserDisabled
+	}
+	if user.Status == "pending" && s.requireVerifiedEmail {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
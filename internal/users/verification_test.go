@@ -0,0 +1,145 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/auth"
+	"github.com/tasiuskenways/scalable-ecommerce/svc-user/internal/users"
+)
+
+func TestEmailVerificationActivatesAccount(t *testing.T) {
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	events := &memoryEvents{}
+	svc, repo, _, _ := newTestService(t,
+		users.WithUserTokens(tokens, newMemoryLimiter()),
+		users.WithNotifier(notifier),
+		users.WithEventPublisher(events),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "verify@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if reg.EmailVerificationRequired || reg.Tokens.AccessToken == "" {
+		t.Fatalf("expected pending users to get tokens by default, got %+v", reg)
+	}
+	sent, ok := notifier.last(users.NotificationEmailVerification)
+	if !ok || sent.Email != "verify@example.com" || sent.Token == "" {
+		t.Fatalf("expected a verification email, got %+v", sent)
+	}
+	if tokens.tokens[auth.HashToken(sent.Token)] == nil {
+		t.Fatalf("expected only the token hash to be stored")
+	}
+
+	if err := svc.VerifyEmail(ctx, "not-a-token"); !errors.Is(err, users.ErrVerificationTokenInvalid) {
+		t.Fatalf("expected ErrVerificationTokenInvalid, got %v", err)
+	}
+	if err := svc.VerifyEmail(ctx, sent.Token); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	user, _ := repo.FindByID(ctx, reg.UserID)
+	if user.Status != "active" || !user.EmailVerifiedAt.Valid {
+		t.Fatalf("expected an active verified account, got status %q verified %v", user.Status, user.EmailVerifiedAt.Valid)
+	}
+	if len(events.byType(users.EventEmailVerified)) != 1 {
+		t.Fatalf("expected an email verified event")
+	}
+
+	if err := svc.VerifyEmail(ctx, sent.Token); !errors.Is(err, users.ErrVerificationTokenInvalid) {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+}
+
+func TestEmailVerificationTokenExpires(t *testing.T) {
+	tokens := newMemoryUserTokens()
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t, users.WithUserTokens(tokens, nil), users.WithNotifier(notifier))
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "late@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	sent, _ := notifier.last(users.NotificationEmailVerification)
+	tokens.expire(auth.HashToken(sent.Token))
+
+	if err := svc.VerifyEmail(ctx, sent.Token); !errors.Is(err, users.ErrVerificationTokenInvalid) {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+}
+
+func TestResendEmailVerification(t *testing.T) {
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t, users.WithUserTokens(newMemoryUserTokens(), newMemoryLimiter()), users.WithNotifier(notifier))
+	ctx := context.Background()
+
+	if _, err := svc.Register(ctx, users.RegisterRequest{Email: "resend@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	first, _ := notifier.last(users.NotificationEmailVerification)
+
+	if err := svc.ResendEmailVerification(ctx, " Resend@Example.com "); err != nil {
+		t.Fatalf("resend: %v", err)
+	}
+	second, _ := notifier.last(users.NotificationEmailVerification)
+	if second.Token == first.Token {
+		t.Fatalf("expected a fresh token")
+	}
+	if err := svc.VerifyEmail(ctx, first.Token); !errors.Is(err, users.ErrVerificationTokenInvalid) {
+		t.Fatalf("expected the earlier token to be invalidated, got %v", err)
+	}
+
+	if err := svc.ResendEmailVerification(ctx, "nobody@example.com"); err != nil {
+		t.Fatalf("expected unknown addresses to succeed silently, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := svc.ResendEmailVerification(ctx, "resend@example.com"); err != nil {
+			t.Fatalf("resend %d: %v", i, err)
+		}
+	}
+	err := svc.ResendEmailVerification(ctx, "resend@example.com")
+	var limited *users.RateLimitError
+	if !errors.As(err, &limited) || limited.RetryAfter <= 0 || !errors.Is(err, users.ErrRateLimited) {
+		t.Fatalf("expected the resend to be throttled, got %v", err)
+	}
+	if got := notifier.count(users.NotificationEmailVerification); got != 4 {
+		t.Fatalf("expected 4 verification emails, got %d", got)
+	}
+}
+
+func TestVerifiedEmailRequiredBlocksPendingLogins(t *testing.T) {
+	notifier := &memoryNotifier{}
+	svc, _, _, _ := newTestService(t,
+		users.WithUserTokens(newMemoryUserTokens(), nil),
+		users.WithNotifier(notifier),
+		users.WithVerifiedEmailRequired(true),
+	)
+	ctx := context.Background()
+
+	reg, err := svc.Register(ctx, users.RegisterRequest{Email: "strict@example.com", Password: "Password!2"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if !reg.EmailVerificationRequired || reg.Tokens.AccessToken != "" {
+		t.Fatalf("expected no tokens before verification, got %+v", reg)
+	}
+
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "strict@example.com", Password: "wrong-password"}); !errors.Is(err, users.ErrInvalidCredentials) {
+		t.Fatalf("expected a wrong password to stay ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "strict@example.com", Password: "Password!2"}); !errors.Is(err, users.ErrEmailNotVerified) {
+		t.Fatalf("expected ErrEmailNotVerified, got %v", err)
+	}
+
+	sent, _ := notifier.last(users.NotificationEmailVerification)
+	if err := svc.VerifyEmail(ctx, sent.Token); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, users.AuthenticateRequest{Email: "strict@example.com", Password: "Password!2"}); err != nil {
+		t.Fatalf("expected login after verification, got %v", err)
+	}
+}